@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/godbus/dbus/v5"
+)
+
+// UPower's summary of the batteries, and how low it considers them.
+const (
+	upowerName          = "org.freedesktop.UPower"
+	upowerDisplayDevice = "/org/freedesktop/UPower/devices/DisplayDevice"
+	upowerDevice        = "org.freedesktop.UPower.Device"
+	upowerWarningLevel  = "WarningLevel"
+	propertiesChanged   = "org.freedesktop.DBus.Properties.PropertiesChanged"
+	upowerLevelCritical = 4 // UP_DEVICE_LEVEL_CRITICAL; 5, UP_DEVICE_LEVEL_ACTION, is lower still
+)
+
+const (
+	// criticalBatteryWhy is the reason given for locks released on a critical battery.
+	criticalBatteryWhy = "battery critical"
+	// criticalBatteryShown is the most released locks a notification names.
+	criticalBatteryShown = 5
+)
+
+// watchBattery releases every lock that blocks sleep or shutdown once UPower reports the battery critical, and keeps
+// releasing new ones until it recovers, so that inhibits never hold up the low-battery action. conn is a system bus
+// connection, which it closes if UPower can't be watched.
+func (i *inhibitor) watchBattery(conn *dbus.Conn) {
+	signals := make(chan *dbus.Signal, 8)
+	conn.Signal(signals)
+	err := conn.AddMatchSignal(dbus.WithMatchSender(upowerName), dbus.WithMatchObjectPath(upowerDisplayDevice), dbus.WithMatchMember("PropertiesChanged"))
+	var level uint32
+	if err == nil {
+		err = conn.Object(upowerName, upowerDisplayDevice).StoreProperty(upowerDevice+"."+upowerWarningLevel, &level)
+	}
+	if err != nil {
+		maybeLog("Not watching the battery: %v\n", err)
+		conn.Close()
+		return
+	}
+	i.batteryLevel(level)
+
+	for sig := range signals {
+		if sig.Name != propertiesChanged || sig.Path != upowerDisplayDevice || len(sig.Body) < 2 {
+			continue
+		}
+		if iface, _ := sig.Body[0].(string); iface != upowerDevice {
+			continue
+		}
+		changed, _ := sig.Body[1].(map[string]dbus.Variant)
+		if v, ok := changed[upowerWarningLevel]; ok {
+			if level, ok := v.Value().(uint32); ok {
+				i.batteryLevel(level)
+			}
+		}
+	}
+}
+
+// batteryLevel acts on UPower's warning level.
+func (i *inhibitor) batteryLevel(level uint32) {
+	critical := level >= upowerLevelCritical
+	if i.batteryCritical.Swap(critical) == critical {
+		return
+	}
+	if critical {
+		reallyLog("The battery is critical; releasing every lock that blocks sleep or shutdown until it recovers.\n")
+		i.releaseForBattery()
+	} else {
+		reallyLog("The battery is no longer critical.\n")
+	}
+}
+
+// releaseForBattery releases the locks that block sleep or shutdown while the battery is critical, telling the user
+// which.
+func (i *inhibitor) releaseForBattery() {
+	if !i.batteryCritical.Load() {
+		return
+	}
+	released, err := i.bridge.ReleaseBlocking(criticalBatteryWhy)
+	if err != nil {
+		reallyLog("Error releasing locks on critical battery: %v\n", err)
+	}
+	if len(released) == 0 {
+		return
+	}
+	var names []string
+	for n, l := range released {
+		if n == criticalBatteryShown {
+			names = append(names, fmt.Sprintf("and %d more", len(released)-n))
+			break
+		}
+		names = append(names, fmt.Sprintf("%s (%s)", l.Who, l.Why))
+	}
+	i.notifyInhibitChange(tr("Battery critical: released %d inhibit(s) that would have kept the system from suspending: %s.", len(released), strings.Join(names, ", ")), nil)
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/coltwillcox/inhibitor/pkg/bridge"
+	"github.com/godbus/dbus/v5"
+)
+
+const (
+	// benchWho is who the bench's locks are taken as, to tell them apart from everyone else's.
+	benchWho = "inhibitor-bench"
+
+	// benchSettle is how long the bench waits for the daemon to catch up, e.g. to reap the locks of the clients that
+	// left or to run its heartbeat.
+	benchSettle = 2 * time.Minute
+)
+
+// benchPhase is the outcome of the calls of one phase of the bench.
+type benchPhase struct {
+	name      string
+	took      time.Duration
+	latencies []time.Duration // of the calls that succeeded
+	failed    map[string]int  // by D-Bus error name
+}
+
+// String returns a summary of the phase, e.g. "uninhibit: 1930 calls in 1.709s (1129/s), p50 41.576ms, p99 177.521ms,
+// max 178.82ms".
+func (p *benchPhase) String() string {
+	ok := len(p.latencies)
+	s := fmt.Sprintf("%s: %d calls in %s", p.name, ok, p.took.Round(time.Millisecond))
+	if ok > 0 {
+		sort.Slice(p.latencies, func(i, j int) bool { return p.latencies[i] < p.latencies[j] })
+		s += fmt.Sprintf(" (%.0f/s), p50 %s, p99 %s, max %s", float64(ok)/p.took.Seconds(), p.percentile(50), p.percentile(99), p.latencies[ok-1].Round(time.Microsecond))
+	}
+	names := make([]string, 0, len(p.failed))
+	for name := range p.failed {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		s += fmt.Sprintf(", %d failed with %s", p.failed[name], name)
+	}
+	return s
+}
+
+// percentile returns the pth percentile of the sorted latencies.
+func (p *benchPhase) percentile(pth int) time.Duration {
+	return p.latencies[(len(p.latencies)-1)*pth/100].Round(time.Microsecond)
+}
+
+// benchClient is a synthetic peer: a bus connection of its own and the cookies of the locks it holds.
+type benchClient struct {
+	conn    *dbus.Conn
+	cookies []uint32
+}
+
+// bench puts the running daemon under load and reports how it copes: clients peers, each on a connection of its own,
+// take locks locks each, all at once, and then release them; they take them again and the heartbeat is timed with
+// every lock held; then they leave the bus without releasing them and the time until the daemon has reaped them all
+// is taken. The locks are real, so it is meant for a test instance, such as one run with --test_mode under
+// dbus-run-session against cmd/mock-logind.
+func bench(system bool, clients, locks int) error {
+	conn, err := connectBus(system)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	ctl := conn.Object(bridge.ServiceName, bridge.ControlPath)
+	if held, err := benchHeld(ctl); err != nil {
+		return err
+	} else if held > 0 {
+		return fmt.Errorf("%d locks of %s are held already; is another bench running?", held, benchWho)
+	}
+
+	peers := make([]*benchClient, 0, clients)
+	defer func() {
+		for _, c := range peers {
+			c.conn.Close()
+		}
+	}()
+	for i := 0; i < clients; i++ {
+		c, err := connectBus(system)
+		if err != nil {
+			return err
+		}
+		peers = append(peers, &benchClient{conn: c})
+	}
+	fmt.Printf("%d clients with %d locks each.\n", clients, locks)
+
+	take := func(name string) *benchPhase {
+		return benchRun(name, peers, func(c *benchClient, i int) error {
+			var cookie uint32
+			err := c.conn.Object(bridge.ServiceName, "/org/freedesktop/ScreenSaver").Call(bridge.ServiceName+".Inhibit", 0, benchWho, fmt.Sprintf("bench lock %d", i)).Store(&cookie)
+			if err == nil {
+				c.cookies = append(c.cookies, cookie)
+			}
+			return err
+		}, func(*benchClient) int { return locks })
+	}
+	fmt.Println(take("inhibit"))
+	fmt.Println(benchRun("uninhibit", peers, func(c *benchClient, i int) error {
+		return c.conn.Object(bridge.ServiceName, "/org/freedesktop/ScreenSaver").Call(bridge.ServiceName+".UnInhibit", 0, c.cookies[i]).Err
+	}, func(c *benchClient) int { return len(c.cookies) }))
+	for _, c := range peers {
+		c.cookies = nil
+	}
+	fmt.Println(take("inhibit again"))
+
+	held, err := benchHeld(ctl)
+	if err != nil {
+		return err
+	}
+	took, err := benchHeartbeat(ctl)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("heartbeat: %d locks checked in %s\n", held, took)
+
+	start := time.Now()
+	for _, c := range peers {
+		c.conn.Close()
+	}
+	peers = nil
+	deadline := start.Add(benchSettle)
+	for held > 0 {
+		if time.Now().After(deadline) {
+			return fmt.Errorf("%d locks still held %s after their clients left", held, benchSettle)
+		}
+		time.Sleep(10 * time.Millisecond)
+		if held, err = benchHeld(ctl); err != nil {
+			return err
+		}
+	}
+	fmt.Printf("reap: every lock released %s after its client left\n", time.Since(start).Round(time.Millisecond))
+	return nil
+}
+
+// benchRun has every client call f for each of its n(client) locks, the clients concurrently and each client's calls
+// in turn, as an application would.
+func benchRun(name string, clients []*benchClient, f func(c *benchClient, i int) error, n func(c *benchClient) int) *benchPhase {
+	p := &benchPhase{name: name, failed: make(map[string]int)}
+	var mtx sync.Mutex
+	var wg sync.WaitGroup
+	start := time.Now()
+	for _, c := range clients {
+		wg.Add(1)
+		go func(c *benchClient) {
+			defer wg.Done()
+			for i, calls := 0, n(c); i < calls; i++ {
+				at := time.Now()
+				err := f(c, i)
+				took := time.Since(at)
+
+				mtx.Lock()
+				var derr dbus.Error
+				switch {
+				case err == nil:
+					p.latencies = append(p.latencies, took)
+				case errors.As(err, &derr):
+					p.failed[derr.Name]++
+				default:
+					p.failed[err.Error()]++
+				}
+				mtx.Unlock()
+			}
+		}(c)
+	}
+	wg.Wait()
+	p.took = time.Since(start)
+	return p
+}
+
+// benchHeld returns how many of the bench's locks the daemon holds.
+func benchHeld(ctl dbus.BusObject) (int, error) {
+	var locks []statusLock
+	if err := ctl.Call(bridge.ControlInterface+".ListInhibits", 0).Store(&locks); err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, l := range locks {
+		if l.Who == benchWho {
+			n++
+		}
+	}
+	return n, nil
+}
+
+// benchHeartbeat waits for a heartbeat pass that started after it was called and returns how long it took. The pass
+// under way when it is called may have checked fewer locks, so it waits for the next but one.
+func benchHeartbeat(ctl dbus.BusObject) (time.Duration, error) {
+	metrics := func() (map[string]uint64, error) {
+		var counters map[string]uint64
+		err := ctl.Call(bridge.ControlInterface+".Metrics", 0).Store(&counters)
+		return counters, err
+	}
+	before, err := metrics()
+	if err != nil {
+		return 0, err
+	}
+	deadline := time.Now().Add(benchSettle)
+	for {
+		after, err := metrics()
+		if err != nil {
+			return 0, err
+		}
+		if after["heartbeats"] >= before["heartbeats"]+2 {
+			return time.Duration(after["heartbeat_microseconds"]) * time.Microsecond, nil
+		}
+		if time.Now().After(deadline) {
+			return 0, fmt.Errorf("no heartbeat within %s; is --heartbeat longer?", benchSettle)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/godbus/dbus/v5"
+)
+
+// caffeineWho is the who of the caffeine lock.
+const caffeineWho = "caffeine"
+
+// sigRTMin is SIGRTMIN as glibc and musl present it to programs, after the signals they keep for themselves.
+const sigRTMin = 34
+
+// parseSignal parses --caffeine_signal: USR2, or RTMIN+N for keybinding daemons that use the user signals for
+// something else. An optional SIG prefix is allowed, and empty disables the signal (0). SIGUSR1 already toggles the
+// manual inhibit.
+func parseSignal(s string) (syscall.Signal, error) {
+	name := strings.TrimPrefix(strings.ToUpper(s), "SIG")
+	switch {
+	case name == "":
+		return 0, nil
+	case name == "USR2":
+		return syscall.SIGUSR2, nil
+	case name == "RTMIN":
+		return sigRTMin, nil
+	case strings.HasPrefix(name, "RTMIN+"):
+		n, err := strconv.Atoi(strings.TrimPrefix(name, "RTMIN+"))
+		if err != nil || n < 0 || sigRTMin+n > 64 {
+			break
+		}
+		return syscall.Signal(sigRTMin + n), nil
+	}
+	return 0, fmt.Errorf("invalid signal %q: want USR2 or RTMIN+N", s)
+}
+
+// caffeineToggle takes the caffeine lock, held until toggled off again, or releases it.
+func (i *inhibitor) caffeineToggle() {
+	i.mtx.Lock()
+	defer i.mtx.Unlock()
+
+	if i.caffeineCookie != 0 {
+		if err := i.bridge.UnInhibit(i.bridge.Name(), i.caffeineCookie); err != nil {
+			maybeLog("Error releasing caffeine: %v\n", err)
+			return
+		}
+		i.caffeineCookie = 0
+		if i.caffeineItem != nil {
+			i.caffeineItem.Uncheck()
+		}
+		i.notifyInhibitChange(tr("Caffeine off."), &i.caffeineNotification)
+	} else {
+		cookie, err := i.bridge.Inhibit(i.bridge.Name(), caffeineWho, "toggled on")
+		if err != nil {
+			maybeLog("Error taking caffeine: %v\n", err)
+			return
+		}
+		i.caffeineCookie = cookie
+		if i.caffeineItem != nil {
+			i.caffeineItem.Check()
+		}
+		i.notifyInhibitChange(tr("Caffeine on until toggled off."), &i.caffeineNotification)
+	}
+	i.setStatus()
+}
+
+// adoptCaffeine picks up a caffeine lock handed over by a hot upgrade.
+func (i *inhibitor) adoptCaffeine() {
+	for _, l := range i.bridge.Locks() {
+		if dbus.Sender(l.Peer) == i.bridge.Name() && l.Who == caffeineWho {
+			i.caffeineCookie = l.Cookie
+		}
+	}
+}
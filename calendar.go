@@ -0,0 +1,257 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+)
+
+const (
+	// calendarWho is the who of the lock taken during calendar events.
+	calendarWho = "calendar"
+	// calendarPoll is how often the --calendar file is re-read, which bounds how late an event's lock is taken.
+	calendarPoll = time.Minute
+)
+
+// calendarEvent is the part of an iCalendar VEVENT we care about.
+type calendarEvent struct {
+	summary    string
+	categories string
+	start, end time.Time
+}
+
+// templateData is what the calendar's lock template (see lockTemplate) is filled in with, e.g. {{.Summary}} or
+// {{.End.Format "15:04"}}.
+func (e calendarEvent) templateData() interface{} {
+	return struct {
+		Summary, Categories string
+		Start, End          time.Time
+	}{e.summary, e.categories, e.start, e.end}
+}
+
+// matches reports whether e is tagged with keyword, in its summary or categories.
+func (e calendarEvent) matches(keyword string) bool {
+	return containsFold(e.summary, keyword) || containsFold(e.categories, keyword)
+}
+
+func containsFold(s, substr string) bool {
+	return strings.Contains(strings.ToLower(s), strings.ToLower(substr))
+}
+
+// watchCalendar holds a lock for as long as an event of the --calendar file tagged with keyword is under way. The
+// lock is named by the calendar's lock template, by default with the event's summary as why, so --config rules can
+// pick the lock's what-classes, e.g. handle-lid-switch for presentations.
+func (i *inhibitor) watchCalendar(path, keyword string) {
+	var (
+		cookie  uint32
+		current string // the summary of the event the lock is held for
+		lastErr string
+	)
+	// After a hot upgrade, a calendar lock may be among the locks handed over. It is told apart by its name, as the
+	// template gives it to one of the events.
+	recovered := false
+
+	for t := time.NewTicker(calendarPoll); ; <-t.C {
+		events, err := readCalendar(path)
+		if err != nil {
+			// Keep the current state rather than drop a lock mid-event because the file is being rewritten.
+			if err.Error() != lastErr {
+				reallyLog("Error reading calendar %q: %v\n", path, err)
+				lastErr = err.Error()
+			}
+			continue
+		}
+		lastErr = ""
+		if !recovered {
+			cookie, current = i.recoverCalendarLock(events, keyword)
+			recovered = true
+		}
+
+		var active *calendarEvent
+		now := time.Now()
+		for n := range events {
+			e := &events[n]
+			if e.matches(keyword) && !now.Before(e.start) && now.Before(e.end) && (active == nil || e.end.After(active.end)) {
+				active = e
+			}
+		}
+
+		if cookie != 0 && (active == nil || active.summary != current) {
+			if err := i.bridge.UnInhibit(i.bridge.Name(), cookie); err != nil {
+				maybeLog("Error releasing the calendar inhibit: %v\n", err)
+			}
+			i.notifyInhibitChange(tr("%q is over; released its inhibit.", current), nil)
+			cookie, current = 0, ""
+		}
+		if cookie == 0 && active != nil {
+			who, why := i.templates.render("calendar", active.templateData())
+			c, err := i.bridge.Inhibit(i.bridge.Name(), who, why)
+			if err != nil {
+				maybeLog("Error inhibiting for %q: %v\n", active.summary, err)
+				continue
+			}
+			cookie, current = c, active.summary
+			i.notifyInhibitChange(tr("Inhibiting for %q until %s.", why, active.end.Format("15:04")), nil)
+		}
+	}
+}
+
+// recoverCalendarLock returns the cookie of a lock handed over by a previous instance for one of the events tagged
+// with keyword, and that event's summary, or 0 if there is none.
+func (i *inhibitor) recoverCalendarLock(events []calendarEvent, keyword string) (uint32, string) {
+	held := i.bridge.Locks()
+	for _, e := range events {
+		if !e.matches(keyword) {
+			continue
+		}
+		who, why := i.templates.render("calendar", e.templateData())
+		for _, l := range held {
+			if dbus.Sender(l.Peer) == i.bridge.Name() && l.Who == who && l.Why == why {
+				return l.Cookie, e.summary
+			}
+		}
+	}
+	return 0, ""
+}
+
+// readCalendar returns the events of an iCalendar file. Recurring events only count for their first occurrence.
+func readCalendar(path string) ([]calendarEvent, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return parseCalendar(f)
+}
+
+// parseCalendar parses the VEVENTs of an iCalendar (RFC 5545) stream.
+func parseCalendar(r io.Reader) ([]calendarEvent, error) {
+	// Unfold continuation lines first: a line starting with a space or tab continues the previous one.
+	var lines []string
+	s := bufio.NewScanner(r)
+	s.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for s.Scan() {
+		line := strings.TrimRight(s.Text(), "\r")
+		if (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) && len(lines) > 0 {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		lines = append(lines, line)
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+
+	var (
+		events   []calendarEvent
+		ev       *calendarEvent
+		duration time.Duration
+		dateOnly bool
+	)
+	for n, line := range lines {
+		nameParams, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		name, params, _ := strings.Cut(nameParams, ";")
+		switch strings.ToUpper(name) {
+		case "BEGIN":
+			if strings.EqualFold(value, "VEVENT") {
+				ev, duration, dateOnly = &calendarEvent{}, 0, false
+			}
+		case "END":
+			if !strings.EqualFold(value, "VEVENT") || ev == nil {
+				continue
+			}
+			if ev.start.IsZero() {
+				return nil, fmt.Errorf("line %d: event %q has no DTSTART", n+1, ev.summary)
+			}
+			if ev.end.IsZero() {
+				switch {
+				case duration > 0:
+					ev.end = ev.start.Add(duration)
+				case dateOnly:
+					ev.end = ev.start.AddDate(0, 0, 1)
+				default:
+					ev.end = ev.start
+				}
+			}
+			events = append(events, *ev)
+			ev = nil
+		}
+		if ev == nil {
+			continue
+		}
+
+		var err error
+		switch strings.ToUpper(name) {
+		case "SUMMARY":
+			ev.summary = unescapeText(value)
+		case "CATEGORIES":
+			ev.categories = unescapeText(value)
+		case "DTSTART":
+			ev.start, dateOnly, err = parseCalendarTime(value, params)
+		case "DTEND":
+			ev.end, _, err = parseCalendarTime(value, params)
+		case "DURATION":
+			duration, err = parseCalendarDuration(value)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s: %v", n+1, name, err)
+		}
+	}
+	return events, nil
+}
+
+// parseCalendarTime parses a DATE or DATE-TIME value. Times are UTC with a Z suffix, in the TZID parameter's zone if
+// it names one we know, and local otherwise.
+func parseCalendarTime(value, params string) (t time.Time, dateOnly bool, err error) {
+	loc := time.Local
+	for _, p := range strings.Split(params, ";") {
+		if k, v, _ := strings.Cut(p, "="); strings.EqualFold(k, "TZID") {
+			if l, err := time.LoadLocation(strings.Trim(v, `"`)); err == nil {
+				loc = l
+			}
+		}
+	}
+	switch {
+	case len(value) == 8:
+		t, err = time.ParseInLocation("20060102", value, loc)
+		return t, true, err
+	case strings.HasSuffix(value, "Z"):
+		t, err = time.Parse("20060102T150405Z", value)
+	default:
+		t, err = time.ParseInLocation("20060102T150405", value, loc)
+	}
+	return t, false, err
+}
+
+var calendarDurationRE = regexp.MustCompile(`^\+?P(?:(\d+)W)?(?:(\d+)D)?(?:T(?:(\d+)H)?(?:(\d+)M)?(?:(\d+)S)?)?$`)
+
+// parseCalendarDuration parses a (non-negative) DURATION value such as "PT1H30M" or "P1D".
+func parseCalendarDuration(value string) (time.Duration, error) {
+	m := calendarDurationRE.FindStringSubmatch(value)
+	if m == nil {
+		return 0, fmt.Errorf("invalid duration %q", value)
+	}
+	var d time.Duration
+	for n, unit := range []time.Duration{7 * 24 * time.Hour, 24 * time.Hour, time.Hour, time.Minute, time.Second} {
+		if m[n+1] != "" {
+			v, _ := strconv.Atoi(m[n+1])
+			d += time.Duration(v) * unit
+		}
+	}
+	return d, nil
+}
+
+// unescapeText undoes the escaping of an iCalendar TEXT value.
+func unescapeText(s string) string {
+	return strings.NewReplacer(`\n`, "\n", `\N`, "\n", `\,`, ",", `\;`, ";", `\\`, `\`).Replace(s)
+}
@@ -0,0 +1,639 @@
+// Command e2e runs inhibitor end to end, for CI: it starts a private session bus with dbus-run-session, a
+// cmd/mock-logind and inhibitor --test_mode on it, and then checks the exported interfaces with real client calls, the
+// way applications make them, including the legacy /ScreenSaver path Firefox uses. Each check prints "ok" or "FAIL";
+// the command exits with 1 if any failed, after printing the daemons' logs.
+//
+// Run it from the module, which it builds both binaries from unless --inhibitor and --mock_logind point at them:
+//
+//	go run ./cmd/e2e
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/coltwillcox/inhibitor/pkg/bridge"
+	"github.com/godbus/dbus/v5"
+)
+
+const (
+	// busEnv is set once e2e runs under dbus-run-session, so that it doesn't start another.
+	busEnv = "INHIBITOR_E2E_BUS"
+
+	login1Name     = "org.freedesktop.login1"
+	login1Path     = "/org/freedesktop/login1"
+	listInhibitors = "org.freedesktop.login1.Manager.ListInhibitors"
+
+	screenSaverPath = "/org/freedesktop/ScreenSaver"
+	// legacyPath is where Firefox, and other older clients, call org.freedesktop.ScreenSaver.
+	legacyPath = "/ScreenSaver"
+
+	// settle is how long a change may take to show up, e.g. mock-logind noticing a released lock or the heartbeat
+	// reaping one.
+	settle = 5 * time.Second
+)
+
+var (
+	// CLI Flags
+	inhibitorBin  = flag.String("inhibitor", "", "The inhibitor binary to test. Built from the module if not set.")
+	mockLogindBin = flag.String("mock_logind", "", "The cmd/mock-logind binary to test against. Built from the module if not set.")
+	verbose       = flag.Bool("verbose", false, "If true, print the daemons' logs even if every check passes.")
+
+	// mockLogind is the running cmd/mock-logind, for checks that signal it.
+	mockLogind *daemon
+)
+
+// check is a single end-to-end check. conn is a session bus connection of its own.
+type check struct {
+	name string
+	run  func(conn *dbus.Conn) error
+}
+
+var checks = []check{
+	{"introspection", checkIntrospection},
+	{"inhibit", func(conn *dbus.Conn) error { return checkInhibit(conn, screenSaverPath) }},
+	{"firefox legacy path", func(conn *dbus.Conn) error { return checkInhibit(conn, legacyPath) }},
+	{"firefox tabs share a lock", func(conn *dbus.Conn) error { return checkTabs(conn, legacyPath, "Firefox", 1) }},
+	{"flatpak chromium tabs share a lock", func(conn *dbus.Conn) error {
+		return checkTabs(conn, screenSaverPath, "com.google.Chrome", 1)
+	}},
+	{"other applications don't share locks", func(conn *dbus.Conn) error {
+		return checkTabs(conn, screenSaverPath, "e2e", 2)
+	}},
+	{"invalid cookie", checkInvalidCookie},
+	{"idle time estimate", checkIdleTime},
+	{"peer exit reaps", checkReap},
+	{"control list and release", checkRelease},
+	{"capabilities", checkCapabilities},
+	{"object manager", checkObjectManager},
+	{"org.freedesktop.PowerManagement", checkPowerManagement},
+	{"org.gnome.SessionManager", func(conn *dbus.Conn) error {
+		return checkSessionManager(conn, "org.gnome.SessionManager", "/org/gnome/SessionManager")
+	}},
+	{"org.gnome.SessionManager suspend flag", checkSessionManagerSuspend},
+	{"suspend veto", checkSuspendVeto},
+	{"org.mate.SessionManager", func(conn *dbus.Conn) error {
+		return checkSessionManager(conn, "org.mate.SessionManager", "/org/mate/SessionManager")
+	}},
+	{"org.kde.Solid.PowerManagement.PolicyAgent", checkKDEPolicyAgent},
+}
+
+func main() {
+	flag.Parse()
+	if os.Getenv(busEnv) == "" {
+		os.Exit(underBus())
+	}
+	os.Exit(run())
+}
+
+// underBus runs e2e again under dbus-run-session and returns its exit code.
+func underBus() int {
+	exe, err := os.Executable()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "e2e: %v\n", err)
+		return 1
+	}
+	cmd := exec.Command("dbus-run-session", append([]string{"--", exe}, os.Args[1:]...)...)
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	cmd.Env = append(os.Environ(), busEnv+"=1")
+	err = cmd.Run()
+	var ee *exec.ExitError
+	if errors.As(err, &ee) {
+		return ee.ExitCode()
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "e2e: running dbus-run-session: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// run starts the daemons on the private bus, runs every check and returns the exit code.
+func run() int {
+	dir, err := os.MkdirTemp("", "inhibitor-e2e")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "e2e: %v\n", err)
+		return 1
+	}
+	defer os.RemoveAll(dir)
+	// Keep the daemon's runtime files, such as a --fifo, away from the user's.
+	os.Setenv("XDG_RUNTIME_DIR", dir)
+
+	failed := 0
+	fail := func(name string, err error) {
+		fmt.Printf("FAIL %s: %v\n", name, err)
+		failed++
+	}
+	var logs []*daemon
+	defer func() {
+		if failed > 0 || *verbose {
+			for _, d := range logs {
+				fmt.Printf("\n--- %s log:\n%s", d.name, d.log.String())
+			}
+		}
+	}()
+
+	if *inhibitorBin == "" {
+		if *inhibitorBin, err = build(dir, "github.com/coltwillcox/inhibitor"); err != nil {
+			fail("build inhibitor", err)
+			return 1
+		}
+	}
+	if *mockLogindBin == "" {
+		if *mockLogindBin, err = build(dir, "github.com/coltwillcox/inhibitor/cmd/mock-logind"); err != nil {
+			fail("build mock-logind", err)
+			return 1
+		}
+	}
+
+	conn, err := dbus.ConnectSessionBus()
+	if err != nil {
+		fail("connect", err)
+		return 1
+	}
+	defer conn.Close()
+
+	logind, err := start(conn, "mock-logind", login1Name, *mockLogindBin)
+	if logind != nil {
+		logs = append(logs, logind)
+		defer logind.stop()
+	}
+	mockLogind = logind
+	if err != nil {
+		fail("start mock-logind", err)
+		return 1
+	}
+	inh, err := start(conn, "inhibitor", bridge.ServiceName, *inhibitorBin, "--test_mode")
+	if inh != nil {
+		logs = append(logs, inh)
+	}
+	if err != nil {
+		fail("start inhibitor", err)
+		return 1
+	}
+
+	for _, c := range checks {
+		cconn, err := dbus.ConnectSessionBus()
+		if err != nil {
+			fail(c.name, err)
+			continue
+		}
+		err = c.run(cconn)
+		cconn.Close()
+		if err != nil {
+			fail(c.name, err)
+			continue
+		}
+		fmt.Printf("ok   %s\n", c.name)
+	}
+
+	// Every lock the checks left behind is given back on a clean shutdown.
+	if err := inh.stop(); err != nil {
+		fail("shutdown", err)
+	} else if err := waitInhibitors(conn, 0); err != nil {
+		fail("shutdown", err)
+	} else {
+		fmt.Printf("ok   shutdown\n")
+	}
+
+	if failed > 0 {
+		fmt.Printf("%d of %d checks failed\n", failed, len(checks)+1)
+		return 1
+	}
+	return 0
+}
+
+// build builds pkg into dir and returns the binary's path.
+func build(dir, pkg string) (string, error) {
+	out := filepath.Join(dir, filepath.Base(pkg))
+	cmd := exec.Command("go", "build", "-o", out, pkg)
+	if b, err := cmd.CombinedOutput(); err != nil {
+		return "", fmt.Errorf("go build %s: %v: %s", pkg, err, bytes.TrimSpace(b))
+	}
+	return out, nil
+}
+
+// daemon is a process the checks run against, with its output.
+type daemon struct {
+	name string
+	cmd  *exec.Cmd
+	log  bytes.Buffer
+}
+
+// start runs bin with args and waits for it to own name.
+func start(conn *dbus.Conn, name, owns, bin string, args ...string) (*daemon, error) {
+	d := &daemon{name: name, cmd: exec.Command(bin, args...)}
+	d.cmd.Stdout, d.cmd.Stderr = &d.log, &d.log
+	if err := d.cmd.Start(); err != nil {
+		return nil, err
+	}
+	err := poll(func() error {
+		var owned bool
+		if err := conn.BusObject().Call("org.freedesktop.DBus.NameHasOwner", 0, owns).Store(&owned); err != nil {
+			return err
+		}
+		if !owned {
+			return fmt.Errorf("%s never claimed %s", name, owns)
+		}
+		return nil
+	})
+	return d, err
+}
+
+// stop asks the daemon to exit, as its session ending would, and waits for it.
+func (d *daemon) stop() error {
+	if d.cmd.ProcessState != nil {
+		return nil
+	}
+	d.cmd.Process.Signal(syscall.SIGTERM)
+	done := make(chan error, 1)
+	go func() { done <- d.cmd.Wait() }()
+	select {
+	case err := <-done:
+		if err != nil {
+			return fmt.Errorf("%s exited with %v", d.name, err)
+		}
+		return nil
+	case <-time.After(settle):
+		d.cmd.Process.Kill()
+		<-done
+		return fmt.Errorf("%s didn't exit within %s of SIGTERM", d.name, settle)
+	}
+}
+
+// poll calls f until it succeeds or settle has passed, returning its last error.
+func poll(f func() error) error {
+	deadline := time.Now().Add(settle)
+	for {
+		err := f()
+		if err == nil || time.Now().After(deadline) {
+			return err
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// inhibitors returns the whys of the locks mock-logind holds.
+func inhibitors(conn *dbus.Conn) ([]string, error) {
+	var list []struct {
+		What, Who, Why, Mode string
+		UID, PID             uint32
+	}
+	if err := conn.Object(login1Name, login1Path).Call(listInhibitors, 0).Store(&list); err != nil {
+		return nil, err
+	}
+	whys := make([]string, 0, len(list))
+	for _, l := range list {
+		whys = append(whys, l.Why)
+	}
+	return whys, nil
+}
+
+// waitInhibitors waits for logind to hold want locks.
+func waitInhibitors(conn *dbus.Conn, want int) error {
+	return poll(func() error {
+		whys, err := inhibitors(conn)
+		if err != nil {
+			return err
+		}
+		if len(whys) != want {
+			return fmt.Errorf("logind holds %d locks (%q), want %d", len(whys), whys, want)
+		}
+		return nil
+	})
+}
+
+// screenSaver calls method of org.freedesktop.ScreenSaver on path.
+func screenSaver(conn *dbus.Conn, path dbus.ObjectPath, method string, args ...interface{}) *dbus.Call {
+	return conn.Object(bridge.ServiceName, path).Call(bridge.ServiceName+"."+method, 0, args...)
+}
+
+func checkIntrospection(conn *dbus.Conn) error {
+	var xml string
+	if err := conn.Object(bridge.ServiceName, screenSaverPath).Call("org.freedesktop.DBus.Introspectable.Introspect", 0).Store(&xml); err != nil {
+		return err
+	}
+	for _, want := range []string{`<interface name="` + bridge.ServiceName + `">`, `<method name="Inhibit">`, `<method name="UnInhibit">`} {
+		if !strings.Contains(xml, want) {
+			return fmt.Errorf("introspection of %s lacks %s", screenSaverPath, want)
+		}
+	}
+	return nil
+}
+
+// checkInhibit takes a lock on path and releases it, following it through to logind.
+func checkInhibit(conn *dbus.Conn, path dbus.ObjectPath) error {
+	var cookie uint32
+	if err := screenSaver(conn, path, "Inhibit", "e2e", "inhibit on "+string(path)).Store(&cookie); err != nil {
+		return err
+	}
+	if cookie == 0 {
+		return errors.New("Inhibit returned cookie 0")
+	}
+	if err := waitInhibitors(conn, 1); err != nil {
+		return err
+	}
+	if err := screenSaver(conn, path, "UnInhibit", cookie).Err; err != nil {
+		return err
+	}
+	return waitInhibitors(conn, 0)
+}
+
+// checkTabs takes two locks as who with the same why, like two tabs playing a video, and checks that logind holds
+// want inhibits for them until both are released.
+func checkTabs(conn *dbus.Conn, path dbus.ObjectPath, who string, want int) error {
+	var cookies [2]uint32
+	for i := range cookies {
+		if err := screenSaver(conn, path, "Inhibit", who, "video-playing").Store(&cookies[i]); err != nil {
+			return err
+		}
+	}
+	if cookies[0] == cookies[1] {
+		return fmt.Errorf("both locks got cookie %d", cookies[0])
+	}
+	if err := waitInhibitors(conn, want); err != nil {
+		return err
+	}
+	if err := screenSaver(conn, path, "UnInhibit", cookies[0]).Err; err != nil {
+		return err
+	}
+	// Either the other lock's inhibit is left, or the one they share, which outlives the first release.
+	if err := waitInhibitors(conn, 1); err != nil {
+		return err
+	}
+	if err := screenSaver(conn, path, "UnInhibit", cookies[1]).Err; err != nil {
+		return err
+	}
+	return waitInhibitors(conn, 0)
+}
+
+func checkInvalidCookie(conn *dbus.Conn) error {
+	err := screenSaver(conn, screenSaverPath, "UnInhibit", uint32(12345)).Err
+	var de dbus.Error
+	if !errors.As(err, &de) || de.Name != bridge.ErrorInvalidCookie {
+		return fmt.Errorf("UnInhibit of an unknown cookie returned %v, want %s", err, bridge.ErrorInvalidCookie)
+	}
+	return nil
+}
+
+// checkIdleTime reports user activity and reads the idle time back. The private bus has no Mutter, so it is the
+// daemon's own estimate.
+func checkIdleTime(conn *dbus.Conn) error {
+	if err := screenSaver(conn, screenSaverPath, "SimulateUserActivity").Err; err != nil {
+		return err
+	}
+	var idle uint32
+	if err := screenSaver(conn, screenSaverPath, "GetSessionIdleTime").Store(&idle); err != nil {
+		return err
+	}
+	if time.Duration(idle)*time.Second > settle {
+		return fmt.Errorf("GetSessionIdleTime is %ds right after SimulateUserActivity", idle)
+	}
+	return nil
+}
+
+// checkReap takes a lock on a connection of its own and closes it without releasing the lock, like a crashing
+// application.
+func checkReap(conn *dbus.Conn) error {
+	peer, err := dbus.ConnectSessionBus()
+	if err != nil {
+		return err
+	}
+	if err := screenSaver(peer, screenSaverPath, "Inhibit", "e2e", "reaped").Err; err != nil {
+		peer.Close()
+		return err
+	}
+	if err := waitInhibitors(conn, 1); err != nil {
+		peer.Close()
+		return err
+	}
+	peer.Close()
+	return waitInhibitors(conn, 0)
+}
+
+// checkRelease lists a lock through the control interface and releases it there.
+func checkRelease(conn *dbus.Conn) error {
+	holder, err := dbus.ConnectSessionBus()
+	if err != nil {
+		return err
+	}
+	defer holder.Close()
+	var cookie uint32
+	if err := screenSaver(holder, screenSaverPath, "Inhibit", "e2e", "released").Store(&cookie); err != nil {
+		return err
+	}
+
+	ctl := conn.Object(bridge.ServiceName, bridge.ControlPath)
+	var locks [][]interface{}
+	if err := ctl.Call(bridge.ControlInterface+".ListInhibits", 0).Store(&locks); err != nil {
+		return err
+	}
+	found := false
+	for _, l := range locks {
+		if len(l) > 1 && l[0] == cookie && l[1] == holder.Names()[0] {
+			found = true
+		}
+	}
+	if !found {
+		return fmt.Errorf("ListInhibits doesn't list cookie %d of %s: %v", cookie, holder.Names()[0], locks)
+	}
+	if err := ctl.Call(bridge.ControlInterface+".Release", 0, holder.Names()[0], cookie).Err; err != nil {
+		return err
+	}
+	return waitInhibitors(conn, 0)
+}
+
+func checkCapabilities(conn *dbus.Conn) error {
+	var caps map[string][]string
+	if err := conn.Object(bridge.ServiceName, bridge.ControlPath).Call(bridge.ControlInterface+".GetCapabilities", 0).Store(&caps); err != nil {
+		return err
+	}
+	for _, want := range []string{bridge.ServiceName, bridge.ControlInterface, "org.freedesktop.PowerManagement", "org.gnome.SessionManager", "org.kde.Solid.PowerManagement.PolicyAgent", "org.mate.SessionManager"} {
+		found := false
+		for _, iface := range caps[bridge.CapInterfaces] {
+			found = found || iface == want
+		}
+		if !found {
+			return fmt.Errorf("%s doesn't list %s: %v", bridge.CapInterfaces, want, caps[bridge.CapInterfaces])
+		}
+	}
+	return nil
+}
+
+// checkObjectManager takes a lock, finds it among the objects /org/freedesktop/ScreenSaver manages and releases it
+// through its object.
+func checkObjectManager(conn *dbus.Conn) error {
+	var cookie uint32
+	if err := screenSaver(conn, screenSaverPath, "Inhibit", "e2e", "managed").Store(&cookie); err != nil {
+		return err
+	}
+	var objects map[dbus.ObjectPath]map[string]map[string]dbus.Variant
+	om := conn.Object(bridge.ServiceName, screenSaverPath)
+	if err := om.Call("org.freedesktop.DBus.ObjectManager.GetManagedObjects", 0).Store(&objects); err != nil {
+		return err
+	}
+	path := dbus.ObjectPath(fmt.Sprintf("%s%d", bridge.LockPathPrefix, cookie))
+	if why := objects[path][bridge.LockInterface]["Why"]; why.Value() != "managed" {
+		return fmt.Errorf("GetManagedObjects has no lock at %s: %v", path, objects)
+	}
+	if err := conn.Object(bridge.ServiceName, path).Call(bridge.LockInterface+".Release", 0).Err; err != nil {
+		return err
+	}
+	return waitInhibitors(conn, 0)
+}
+
+func checkPowerManagement(conn *dbus.Conn) error {
+	const iface = "org.freedesktop.PowerManagement.Inhibit"
+	pm := conn.Object("org.freedesktop.PowerManagement", "/org/freedesktop/PowerManagement/Inhibit")
+	var cookie uint32
+	if err := pm.Call(iface+".Inhibit", 0, "e2e", "power management").Store(&cookie); err != nil {
+		return err
+	}
+	var has bool
+	if err := pm.Call(iface+".HasInhibit", 0).Store(&has); err != nil {
+		return err
+	}
+	if !has {
+		return errors.New("HasInhibit is false while a lock is held")
+	}
+	if err := waitInhibitors(conn, 1); err != nil {
+		return err
+	}
+	if err := pm.Call(iface+".UnInhibit", 0, cookie).Err; err != nil {
+		return err
+	}
+	return waitInhibitors(conn, 0)
+}
+
+// checkSessionManager checks org.gnome.SessionManager, or MATE's fork of it, iface, served on path.
+func checkSessionManager(conn *dbus.Conn, iface string, path dbus.ObjectPath) error {
+	const idle = uint32(8)
+	gs := conn.Object(iface, path)
+	var cookie uint32
+	if err := gs.Call(iface+".Inhibit", 0, "e2e", uint32(0), "session manager", idle).Store(&cookie); err != nil {
+		return err
+	}
+	var inhibited bool
+	if err := gs.Call(iface+".IsInhibited", 0, idle).Store(&inhibited); err != nil {
+		return err
+	}
+	if !inhibited {
+		return errors.New("IsInhibited is false while a lock is held")
+	}
+	if err := waitInhibitors(conn, 1); err != nil {
+		return err
+	}
+	if err := gs.Call(iface+".Uninhibit", 0, cookie).Err; err != nil {
+		return err
+	}
+	return waitInhibitors(conn, 0)
+}
+
+// checkSessionManagerSuspend takes an org.gnome.SessionManager lock with the suspend flag alone, as Electron's
+// prevent-app-suspension does, and checks that logind holds sleep for it, not idle.
+func checkSessionManagerSuspend(conn *dbus.Conn) error {
+	const (
+		iface   = "org.gnome.SessionManager"
+		suspend = uint32(4)
+		idle    = uint32(8)
+	)
+	gs := conn.Object(iface, "/org/gnome/SessionManager")
+	var cookie uint32
+	if err := gs.Call(iface+".Inhibit", 0, "electron", uint32(0), "suspend flag", suspend).Store(&cookie); err != nil {
+		return err
+	}
+	if err := waitInhibitors(conn, 1); err != nil {
+		return err
+	}
+	var list []struct {
+		What, Who, Why, Mode string
+		UID, PID             uint32
+	}
+	if err := conn.Object(login1Name, login1Path).Call(listInhibitors, 0).Store(&list); err != nil {
+		return err
+	}
+	if len(list) != 1 || list[0].What != "sleep" {
+		return fmt.Errorf("logind holds %v for the suspend flag, want a single sleep inhibit", list)
+	}
+	var inhibited bool
+	if err := gs.Call(iface+".IsInhibited", 0, idle).Store(&inhibited); err != nil {
+		return err
+	}
+	if inhibited {
+		return errors.New("IsInhibited(idle) is true while only sleep is inhibited")
+	}
+	if err := gs.Call(iface+".Uninhibit", 0, cookie).Err; err != nil {
+		return err
+	}
+	return waitInhibitors(conn, 0)
+}
+
+// checkSuspendVeto closes mock-logind's lid while a sleep lock blocks the suspend and waits for the bridge to count
+// the veto.
+func checkSuspendVeto(conn *dbus.Conn) error {
+	const iface = "org.gnome.SessionManager"
+	gs := conn.Object(iface, "/org/gnome/SessionManager")
+	var cookie uint32
+	if err := gs.Call(iface+".Inhibit", 0, "e2e", uint32(0), "veto", uint32(4)).Store(&cookie); err != nil {
+		return err
+	}
+	if err := waitInhibitors(conn, 1); err != nil {
+		return err
+	}
+	ctl := conn.Object(bridge.ServiceName, bridge.ControlPath)
+	mockLogind.cmd.Process.Signal(syscall.SIGUSR2)
+	err := poll(func() error {
+		var counters map[string]uint64
+		if err := ctl.Call(bridge.ControlInterface+".Metrics", 0).Store(&counters); err != nil {
+			return err
+		}
+		if counters["suspends_vetoed"] == 0 {
+			return errors.New("no suspend vetoed after closing the lid")
+		}
+		return nil
+	})
+	// Open the lid again for the checks after this one.
+	mockLogind.cmd.Process.Signal(syscall.SIGUSR2)
+	if err != nil {
+		return err
+	}
+	if err := gs.Call(iface+".Uninhibit", 0, cookie).Err; err != nil {
+		return err
+	}
+	return waitInhibitors(conn, 0)
+}
+
+func checkKDEPolicyAgent(conn *dbus.Conn) error {
+	const (
+		iface = "org.kde.Solid.PowerManagement.PolicyAgent"
+		// ChangeScreenSettings, KDE's idle inhibit.
+		screen = uint32(4)
+	)
+	pa := conn.Object(iface, "/org/kde/Solid/PowerManagement/PolicyAgent")
+	var cookie uint32
+	if err := pa.Call(iface+".AddInhibition", 0, screen, "e2e", "kde policy agent").Store(&cookie); err != nil {
+		return err
+	}
+	var has bool
+	if err := pa.Call(iface+".HasInhibition", 0, screen).Store(&has); err != nil {
+		return err
+	}
+	if !has {
+		return errors.New("HasInhibition is false while a lock is held")
+	}
+	if err := waitInhibitors(conn, 1); err != nil {
+		return err
+	}
+	if err := pa.Call(iface+".ReleaseInhibition", 0, cookie).Err; err != nil {
+		return err
+	}
+	return waitInhibitors(conn, 0)
+}
@@ -0,0 +1,299 @@
+// Command mock-logind serves a minimal org.freedesktop.login1 Manager, enough for inhibitor to develop and demo
+// against on systems without systemd. Each Inhibit hands out the write end of a pipe and logs the lock until the
+// caller closes it. Every process belongs to a single session, whose idle hint is logged and whose kind --session
+// picks. SIGUSR2 closes and opens the lid: a closed lid suspends, announced with PrepareForSleep, unless a sleep or
+// handle-lid-switch lock blocks it.
+//
+// By default it claims org.freedesktop.login1 on the session bus, so run inhibitor with --logind_bus=session to use
+// it.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/godbus/dbus/v5/introspect"
+	"github.com/godbus/dbus/v5/prop"
+)
+
+const (
+	login1Name   = "org.freedesktop.login1"
+	login1Path   = "/org/freedesktop/login1"
+	login1Iface  = "org.freedesktop.login1.Manager"
+	sessionPath  = "/org/freedesktop/login1/session/mock"
+	sessionIface = "org.freedesktop.login1.Session"
+	getConnPID   = "org.freedesktop.DBus.GetConnectionUnixProcessID"
+	getConnUID   = "org.freedesktop.DBus.GetConnectionUnixUser"
+
+	// godbus has no hook for after a reply has been sent, so our copy of a handed-out fd is closed after this long
+	// instead. Until then a released lock is not noticed.
+	handoffDelay = time.Second
+)
+
+var (
+	// CLI Flags
+	deny      = flag.String("deny", "", "Comma-separated what-classes to refuse as polkit would, each optionally limited to a mode, e.g. sleep/block,shutdown.")
+	fail      = flag.Bool("fail", false, "If true, refuse every Inhibit, for exercising the caller's error handling. SIGUSR1 toggles this at runtime.")
+	session   = flag.String("session", "local", "The kind of session every process is in: \"local\" (on seat0), \"remote\" (over ssh) or \"headless\" (no seat).")
+	systemBus = flag.Bool("system", false, "If true, claim org.freedesktop.login1 on the system bus instead of the session bus. Needs a policy allowing it.")
+)
+
+// inhibitor is one lock handed out, in the shape of logind's ListInhibitors entries.
+type inhibitor struct {
+	What, Who, Why, Mode string
+	UID, PID             uint32
+}
+
+type manager struct {
+	conn   *dbus.Conn
+	props  *prop.Properties
+	mtx    sync.Mutex
+	locks  map[*os.File]inhibitor
+	failed bool
+	lid    bool
+}
+
+// Inhibit implements org.freedesktop.login1.Manager.Inhibit.
+func (m *manager) Inhibit(from dbus.Sender, what, who, why, mode string) (dbus.UnixFD, *dbus.Error) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	if m.failed {
+		log.Printf("Refusing %q / %q (%s, %s) from %s\n", who, why, what, mode, from)
+		return -1, dbus.MakeFailedError(fmt.Errorf("mock-logind is refusing inhibits"))
+	}
+	if mode != "block" && mode != "delay" {
+		return -1, dbus.NewError("org.freedesktop.DBus.Error.InvalidArgs", []interface{}{fmt.Sprintf("invalid mode %q", mode)})
+	}
+	if w := denied(what, mode); w != "" {
+		log.Printf("Denying %q / %q (%s, %s) from %s\n", who, why, what, mode, from)
+		return -1, dbus.NewError("org.freedesktop.DBus.Error.AccessDenied", []interface{}{fmt.Sprintf("Permission denied to inhibit %s in %s mode", w, mode)})
+	}
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		return -1, dbus.MakeFailedError(err)
+	}
+	inh := inhibitor{What: what, Who: who, Why: why, Mode: mode}
+	m.conn.BusObject().Call(getConnUID, 0, string(from)).Store(&inh.UID)
+	m.conn.BusObject().Call(getConnPID, 0, string(from)).Store(&inh.PID)
+	m.locks[r] = inh
+	log.Printf("Inhibit %q / %q (%s, %s) from %s (uid %d, pid %d)\n", who, why, what, mode, from, inh.UID, inh.PID)
+
+	time.AfterFunc(handoffDelay, func() { w.Close() })
+	go m.watch(r)
+
+	return dbus.UnixFD(w.Fd()), nil
+}
+
+// denied returns the first of the colon-separated what-classes --deny refuses in mode, or "" if none.
+func denied(what, mode string) string {
+	for _, w := range strings.Split(what, ":") {
+		for _, d := range strings.Split(*deny, ",") {
+			if d == w || d == w+"/"+mode {
+				return w
+			}
+		}
+	}
+	return ""
+}
+
+// ListInhibitors implements org.freedesktop.login1.Manager.ListInhibitors.
+func (m *manager) ListInhibitors() ([]inhibitor, *dbus.Error) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	l := make([]inhibitor, 0, len(m.locks))
+	for _, inh := range m.locks {
+		l = append(l, inh)
+	}
+	return l, nil
+}
+
+// GetSessionByPID implements org.freedesktop.login1.Manager.GetSessionByPID. Every process is in the one session.
+func (m *manager) GetSessionByPID(pid uint32) (dbus.ObjectPath, *dbus.Error) {
+	return sessionPath, nil
+}
+
+// SetIdleHint implements org.freedesktop.login1.Session.SetIdleHint.
+func (m *manager) SetIdleHint(from dbus.Sender, idle bool) *dbus.Error {
+	log.Printf("Idle hint set to %t by %s\n", idle, from)
+	return nil
+}
+
+// sessionProps returns the org.freedesktop.login1.Session properties of a session of the given kind.
+func sessionProps(kind string) (map[string]*prop.Prop, error) {
+	type seat struct {
+		ID   string
+		Path dbus.ObjectPath
+	}
+	remote, service, st := false, "login", seat{"seat0", "/org/freedesktop/login1/seat/seat0"}
+	switch kind {
+	case "local":
+	case "remote":
+		remote, service, st = true, "sshd", seat{"", "/"}
+	case "headless":
+		st = seat{"", "/"}
+	default:
+		return nil, fmt.Errorf("invalid session kind %q", kind)
+	}
+	return map[string]*prop.Prop{
+		"Remote":  {Value: remote},
+		"Service": {Value: service},
+		"Seat":    {Value: st},
+	}, nil
+}
+
+// watch waits for every copy of the lock's write end to be closed and then drops it.
+func (m *manager) watch(r *os.File) {
+	io.Copy(io.Discard, r)
+	r.Close()
+
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	inh := m.locks[r]
+	delete(m.locks, r)
+	log.Printf("Released %q / %q (%s, %s)\n", inh.Who, inh.Why, inh.What, inh.Mode)
+}
+
+func (m *manager) toggleFail() {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	m.failed = !m.failed
+	log.Printf("Refusing inhibits: %t\n", m.failed)
+}
+
+// toggleLid closes the lid, or opens it again, and suspends for handoffDelay on closing it unless a lock blocks that.
+func (m *manager) toggleLid() {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	m.lid = !m.lid
+	m.props.SetMust(login1Iface, "LidClosed", m.lid)
+	log.Printf("Lid closed: %t\n", m.lid)
+	if !m.lid {
+		return
+	}
+	for _, inh := range m.locks {
+		if inh.Mode != "block" {
+			continue
+		}
+		for _, w := range strings.Split(inh.What, ":") {
+			if w == "sleep" || w == "handle-lid-switch" {
+				log.Printf("Not suspending, blocked by %q / %q (%s)\n", inh.Who, inh.Why, inh.What)
+				return
+			}
+		}
+	}
+	log.Printf("Suspending\n")
+	m.conn.Emit(login1Path, login1Iface+".PrepareForSleep", true)
+	time.AfterFunc(handoffDelay, func() {
+		log.Printf("Resuming\n")
+		m.conn.Emit(login1Path, login1Iface+".PrepareForSleep", false)
+	})
+}
+
+func main() {
+	flag.Parse()
+	log.SetPrefix("mock-logind: ")
+
+	connect, bus := dbus.ConnectSessionBus, "session"
+	if *systemBus {
+		connect, bus = dbus.ConnectSystemBus, "system"
+	}
+	props, err := sessionProps(*session)
+	if err != nil {
+		log.Fatalf("--session: %v\n", err)
+	}
+	conn, err := connect()
+	if err != nil {
+		log.Fatalf("%s bus connect failed: %v\n", bus, err)
+	}
+	defer conn.Close()
+
+	m := &manager{conn: conn, locks: make(map[*os.File]inhibitor), failed: *fail}
+	if err := conn.ExportMethodTable(map[string]interface{}{
+		"Inhibit":         m.Inhibit,
+		"ListInhibitors":  m.ListInhibitors,
+		"GetSessionByPID": m.GetSessionByPID,
+	}, login1Path, login1Iface); err != nil {
+		log.Fatalf("Couldn't export %s: %v\n", login1Iface, err)
+	}
+	if err := conn.ExportMethodTable(map[string]interface{}{
+		"SetIdleHint": m.SetIdleHint,
+	}, sessionPath, sessionIface); err != nil {
+		log.Fatalf("Couldn't export %s: %v\n", sessionIface, err)
+	}
+	if _, err := prop.Export(conn, sessionPath, prop.Map{sessionIface: props}); err != nil {
+		log.Fatalf("Couldn't export the properties of %s: %v\n", sessionIface, err)
+	}
+	if m.props, err = prop.Export(conn, login1Path, prop.Map{login1Iface: {
+		"LidClosed": {Value: false, Emit: prop.EmitTrue},
+	}}); err != nil {
+		log.Fatalf("Couldn't export the properties of %s: %v\n", login1Iface, err)
+	}
+	node := &introspect.Node{
+		Name: login1Path,
+		Interfaces: []introspect.Interface{
+			introspect.IntrospectData,
+			{
+				Name: login1Iface,
+				Methods: []introspect.Method{
+					{Name: "Inhibit", Args: []introspect.Arg{
+						{Name: "what", Type: "s", Direction: "in"},
+						{Name: "who", Type: "s", Direction: "in"},
+						{Name: "why", Type: "s", Direction: "in"},
+						{Name: "mode", Type: "s", Direction: "in"},
+						{Name: "pipe_fd", Type: "h", Direction: "out"},
+					}},
+					{Name: "ListInhibitors", Args: []introspect.Arg{
+						{Name: "inhibitors", Type: "a(ssssuu)", Direction: "out"},
+					}},
+					{Name: "GetSessionByPID", Args: []introspect.Arg{
+						{Name: "pid", Type: "u", Direction: "in"},
+						{Name: "object_path", Type: "o", Direction: "out"},
+					}},
+				},
+			},
+		},
+	}
+	if err := conn.Export(introspect.NewIntrospectable(node), login1Path, "org.freedesktop.DBus.Introspectable"); err != nil {
+		log.Fatalf("Couldn't export introspection: %v\n", err)
+	}
+
+	r, err := conn.RequestName(login1Name, dbus.NameFlagDoNotQueue)
+	if err != nil {
+		log.Fatalf("conn.RequestName(%q, 0): %v\n", login1Name, err)
+	}
+	if r != dbus.RequestNameReplyPrimaryOwner {
+		log.Fatalf("conn.RequestName(%q, 0): not the primary owner; is logind running?\n", login1Name)
+	}
+	log.Printf("Serving %s on the %s bus.\n", login1Name, bus)
+
+	quitCh := make(chan os.Signal, 1)
+	signal.Notify(quitCh, syscall.SIGINT, syscall.SIGTERM)
+	sigToggle := make(chan os.Signal, 1)
+	signal.Notify(sigToggle, syscall.SIGUSR1)
+	sigLid := make(chan os.Signal, 1)
+	signal.Notify(sigLid, syscall.SIGUSR2)
+
+	for {
+		select {
+		case s := <-quitCh:
+			log.Printf("Received signal %q. Exiting.\n", s)
+			return
+		case <-sigToggle:
+			m.toggleFail()
+		case <-sigLid:
+			m.toggleLid()
+		}
+	}
+}
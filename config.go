@@ -0,0 +1,333 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/coltwillcox/inhibitor/pkg/bridge"
+	"github.com/coltwillcox/inhibitor/pkg/policy"
+	"github.com/coltwillcox/inhibitor/pkg/telemetry"
+	"github.com/godbus/dbus/v5"
+)
+
+// configVersion is the version of the config schema this build reads and writes.
+const configVersion = 1
+
+// config is the contents of the --config file. Unlike flags, it is re-read on SIGHUP.
+type config struct {
+	// Version is the schema version the file was written for. Files from before versioning have none.
+	Version int `json:"version"`
+	// Paths are extra object paths to serve org.freedesktop.ScreenSaver on.
+	Paths []string `json:"paths,omitempty"`
+	// Rules add logind what-classes to the locks of matching requests.
+	Rules []policy.Rule `json:"rules,omitempty"`
+	// Rewrites tidy up the who and why of requests before they are logged or matched against Rules.
+	Rewrites []policy.Rewrite `json:"rewrites,omitempty"`
+	// DefaultReasons give requests that came without a why one, by application.
+	DefaultReasons []policy.DefaultReason `json:"default_reasons,omitempty"`
+	// Profiles are named sets of inhibits the daemon holds itself while activated.
+	Profiles []policy.Profile `json:"profiles,omitempty"`
+	// Quirks are the workarounds for each browser family's locks, by family (see policy.Quirks).
+	Quirks map[string]policy.Quirks `json:"quirks,omitempty"`
+	// Compat turns the names and paths served besides org.freedesktop.ScreenSaver on or off one at a time, by
+	// toggle (see bridge.CompatToggles), overriding --compat.
+	Compat map[string]bool `json:"compat,omitempty"`
+	// Templates name the locks of the auto-inhibit sources, such as --calendar, by source.
+	Templates map[string]lockTemplate `json:"templates,omitempty"`
+	// Faults fail or hold up matching lock requests on purpose, with --developer only (see policy.Fault).
+	Faults []policy.Fault `json:"faults,omitempty"`
+	// Metrics names where the metrics go besides the control interface (see telemetry.ParseSpec). Unlike the rest, it
+	// is only read on startup.
+	Metrics string `json:"metrics,omitempty"`
+}
+
+// configMigrations bring a config file up to date, one schema version at a time: configMigrations[n] turns the
+// top-level fields of a version n file into those of version n+1, and returns a warning describing what changed.
+var configMigrations = []func(fields map[string]json.RawMessage) (string, error){
+	// Version 1 only added the version itself.
+	func(map[string]json.RawMessage) (string, error) {
+		return "it has no \"version\"; reading it as version 1", nil
+	},
+}
+
+// loadConfig reads and validates the config file at path, migrating it from older schema versions. An empty path
+// yields the empty config.
+func loadConfig(path string) (*config, error) {
+	if path == "" {
+		return &config{Version: configVersion}, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	c, warnings, err := parseConfig(data)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't parse %q: %v", path, err)
+	}
+	for _, w := range warnings {
+		reallyLog("Config %[1]q: %[2]s. \"inhibitor config --config=%[1]s import %[1]s\" updates it.\n", path, w)
+	}
+	if err := c.validate(); err != nil {
+		return nil, fmt.Errorf("%q: %v", path, err)
+	}
+	return c, nil
+}
+
+// parseConfig decodes a config file, migrating it to configVersion. The warnings say what the migration changed.
+func parseConfig(data []byte) (*config, []string, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, nil, err
+	}
+	if fields == nil {
+		fields = make(map[string]json.RawMessage)
+	}
+	version := 0
+	if v, ok := fields["version"]; ok {
+		if err := json.Unmarshal(v, &version); err != nil {
+			return nil, nil, fmt.Errorf("invalid version: %v", err)
+		}
+	}
+	if version < 0 || version > configVersion {
+		return nil, nil, fmt.Errorf("schema version %d is unknown to this build, which reads up to version %d", version, configVersion)
+	}
+
+	var warnings []string
+	for ; version < configVersion; version++ {
+		w, err := configMigrations[version](fields)
+		if err != nil {
+			return nil, nil, fmt.Errorf("migrating from version %d: %v", version, err)
+		}
+		warnings = append(warnings, w)
+	}
+	fields["version"] = json.RawMessage(fmt.Sprint(configVersion))
+
+	migrated, err := json.Marshal(fields)
+	if err != nil {
+		return nil, nil, err
+	}
+	c := &config{}
+	if err := json.Unmarshal(migrated, c); err != nil {
+		return nil, nil, err
+	}
+	return c, warnings, nil
+}
+
+// validate checks the settings of c.
+func (c *config) validate() error {
+	for _, p := range c.Paths {
+		if !dbus.ObjectPath(p).IsValid() {
+			return fmt.Errorf("invalid object path %q", p)
+		}
+	}
+	for _, r := range c.Rules {
+		if err := r.Validate(); err != nil {
+			return err
+		}
+	}
+	for _, r := range c.Rewrites {
+		if err := r.Validate(); err != nil {
+			return err
+		}
+	}
+	for _, r := range c.DefaultReasons {
+		if err := r.Validate(); err != nil {
+			return err
+		}
+	}
+	if err := policy.ValidateProfiles(c.Profiles); err != nil {
+		return err
+	}
+	if err := policy.ValidateQuirks(c.Quirks); err != nil {
+		return err
+	}
+	for _, f := range c.Faults {
+		if err := f.Validate(); err != nil {
+			return err
+		}
+	}
+	toggles := bridge.CompatToggles()
+	for toggle := range c.Compat {
+		known := false
+		for _, t := range toggles {
+			known = known || t == toggle
+		}
+		if !known {
+			return fmt.Errorf("unknown compat toggle %q; want one of %s", toggle, strings.Join(toggles, ", "))
+		}
+	}
+	for source, t := range c.Templates {
+		if err := t.validate(source); err != nil {
+			return err
+		}
+	}
+	if c.Metrics != "" {
+		if _, _, err := telemetry.ParseSpec(c.Metrics); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// compatToggles returns every compat toggle, on if all is set (see --compat) unless c turns it off, or off unless c
+// turns it on. The legacy path is on unless c turns it off.
+func (c *config) compatToggles(all bool) map[string]bool {
+	toggles := make(map[string]bool)
+	for _, t := range bridge.CompatToggles() {
+		toggles[t] = all || t == bridge.CompatLegacyPath
+	}
+	for t, on := range c.Compat {
+		toggles[t] = on
+	}
+	return toggles
+}
+
+// faults returns c.Faults if developer mode is on (see --developer), and otherwise none, warning that they are
+// ignored.
+func (c *config) faults(developer bool) []policy.Fault {
+	if developer {
+		if len(c.Faults) > 0 {
+			reallyLog("WARNING: developer mode: %d fault(s) will fail or hold up matching lock requests on purpose.\n", len(c.Faults))
+		}
+		return c.Faults
+	}
+	if len(c.Faults) > 0 {
+		reallyLog("Ignoring the config's %d fault(s) without --developer.\n", len(c.Faults))
+	}
+	return nil
+}
+
+// objectPaths returns c.Paths as object paths.
+func (c *config) objectPaths() []dbus.ObjectPath {
+	paths := make([]dbus.ObjectPath, 0, len(c.Paths))
+	for _, p := range c.Paths {
+		paths = append(paths, dbus.ObjectPath(p))
+	}
+	return paths
+}
+
+// checkConfig is the startup check for the config file.
+func checkConfig(path string) (*config, checkResult) {
+	c, err := loadConfig(path)
+	return c, checkResult{name: "config", code: exitConfig, err: err}
+}
+
+// reloadConfig re-reads the config file and applies it. A broken file is logged and leaves the running config alone.
+func (i *inhibitor) reloadConfig(path string) {
+	c, err := loadConfig(path)
+	if err != nil {
+		reallyLog("Error reloading config: %v\n", err)
+		return
+	}
+	if err := i.bridge.SetExtraPaths(c.objectPaths()); err != nil {
+		reallyLog("Error applying config: %v\n", err)
+		return
+	}
+	if err := i.bridge.SetCompat(c.compatToggles(*compat)); err != nil {
+		reallyLog("Error applying config: %v\n", err)
+		return
+	}
+	if err := i.bridge.SetRules(c.Rules); err != nil {
+		reallyLog("Error applying config: %v\n", err)
+		return
+	}
+	if err := i.bridge.SetRewrites(c.Rewrites); err != nil {
+		reallyLog("Error applying config: %v\n", err)
+		return
+	}
+	if err := i.bridge.SetDefaultReasons(c.DefaultReasons); err != nil {
+		reallyLog("Error applying config: %v\n", err)
+		return
+	}
+	if err := i.bridge.SetProfiles(c.Profiles); err != nil {
+		reallyLog("Error applying config: %v\n", err)
+		return
+	}
+	if err := i.bridge.SetQuirks(c.Quirks); err != nil {
+		reallyLog("Error applying config: %v\n", err)
+		return
+	}
+	if err := i.bridge.SetFaults(c.faults(*developer)); err != nil {
+		reallyLog("Error applying config: %v\n", err)
+		return
+	}
+	if err := i.templates.set(c.Templates); err != nil {
+		reallyLog("Error applying config: %v\n", err)
+		return
+	}
+	if c.Metrics != metricsSpec {
+		reallyLog("The config's metrics changed from %q to %q; restart to apply that.\n", metricsSpec, c.Metrics)
+	}
+	warnLidSwitch(nil, c.Rules)
+	maybeLog("Reloaded config from %q.\n", path)
+}
+
+// exportConfig writes the config file at path, migrated to configVersion, to out, or stdout if out is empty. The
+// result holds every setting of the file and nothing specific to the machine, for another machine to import.
+func exportConfig(path, out string) error {
+	c, err := loadConfig(path)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	if out == "" {
+		_, err = os.Stdout.Write(data)
+		return err
+	}
+	return os.WriteFile(out, data, 0644)
+}
+
+// importConfig validates the exported config at in, migrates it to configVersion and makes it the config file at
+// path. A config file already there is kept as path.bak. The new file replaces the old one in a single step, so that
+// a daemon reloading it meanwhile reads one or the other.
+func importConfig(in, path string) error {
+	data, err := os.ReadFile(in)
+	if err != nil {
+		return err
+	}
+	c, warnings, err := parseConfig(data)
+	if err != nil {
+		return fmt.Errorf("couldn't parse %q: %v", in, err)
+	}
+	if err := c.validate(); err != nil {
+		return fmt.Errorf("%q: %v", in, err)
+	}
+	for _, w := range warnings {
+		reallyLog("Migrated %q: %s.\n", in, w)
+	}
+	if data, err = json.MarshalIndent(c, "", "  "); err != nil {
+		return err
+	}
+
+	if old, err := os.ReadFile(path); err == nil {
+		if err := os.WriteFile(path+".bak", old, 0644); err != nil {
+			return fmt.Errorf("backing up %q: %v", path, err)
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	// daemonEnv marks the stages of --daemonize: "1" for the intermediate process, which only starts the daemon, and
+	// the readiness pipe's fd for the daemon itself.
+	daemonEnv = "INHIBITOR_DAEMON"
+	// pidfileWait is how long a taking-over instance waits for its predecessor to exit and unlock the --pidfile.
+	pidfileWait = 5 * time.Second
+)
+
+// daemonize detaches from the terminal with the classic double fork, done here as two re-execs since Go can't fork:
+// the first child becomes a session leader and starts the daemon, which, not being a session leader, can never
+// reacquire a controlling terminal. The original process relays the daemon's startup errors and exits once it is up,
+// with 0 or, if it failed, exitFailure. In the daemon itself daemonize returns the readiness pipe to pass to
+// daemonReady.
+func daemonize() *os.File {
+	stage, ok := os.LookupEnv(daemonEnv)
+	os.Unsetenv(daemonEnv)
+	switch {
+	case !ok:
+		os.Exit(startDaemon())
+	case stage == "1":
+		os.Exit(startDaemonChild())
+	}
+	fd, err := strconv.Atoi(stage)
+	if err != nil {
+		fatalf(exitFailure, "Invalid %s %q\n", daemonEnv, stage)
+	}
+	syscall.CloseOnExec(fd)
+	return os.NewFile(uintptr(fd), "ready")
+}
+
+// startDaemon is the original process's half of daemonize.
+func startDaemon() int {
+	ready, readyW, err := os.Pipe()
+	if err != nil {
+		reallyLog("Can't daemonize: %v\n", err)
+		return exitFailure
+	}
+	errs, errsW, err := os.Pipe()
+	if err != nil {
+		reallyLog("Can't daemonize: %v\n", err)
+		return exitFailure
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		reallyLog("Can't daemonize: %v\n", err)
+		return exitFailure
+	}
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Env = append(os.Environ(), daemonEnv+"=1")
+	// The readiness pipe becomes fd 3; stderr carries startup errors until the daemon is ready.
+	cmd.ExtraFiles = []*os.File{readyW}
+	cmd.Stderr = errsW
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	if err := cmd.Start(); err != nil {
+		reallyLog("Can't daemonize: %v\n", err)
+		return exitFailure
+	}
+	readyW.Close()
+	errsW.Close()
+	cmd.Wait()
+
+	// The daemon writes "ok" once it is up, and closes stderr at the same time. Either pipe hits EOF if it dies first.
+	copied := make(chan struct{})
+	go func() {
+		io.Copy(os.Stderr, errs)
+		close(copied)
+	}()
+	line, _ := bufio.NewReader(ready).ReadString('\n')
+	<-copied
+	if strings.TrimSpace(line) != "ok" {
+		return exitFailure
+	}
+	return 0
+}
+
+// startDaemonChild is the session leader's half of daemonize: it starts the daemon and exits.
+func startDaemonChild() int {
+	exe, err := os.Executable()
+	if err != nil {
+		reallyLog("Can't daemonize: %v\n", err)
+		return exitFailure
+	}
+	cmd := exec.Command(exe, os.Args[1:]...)
+	// The readiness pipe, inherited as fd 3, is fd 3 again in the daemon.
+	cmd.Env = append(os.Environ(), daemonEnv+"=3")
+	cmd.ExtraFiles = []*os.File{os.NewFile(3, "ready")}
+	cmd.Stderr = os.Stderr
+	if err := cmd.Start(); err != nil {
+		reallyLog("Can't daemonize: %v\n", err)
+		return exitFailure
+	}
+	return 0
+}
+
+// daemonReady tells the process that ran --daemonize that the daemon is up, and detaches stdin, stdout and stderr
+// from the terminal; without a --logfile, logs go nowhere from then on.
+func daemonReady(ready *os.File) {
+	null, err := os.OpenFile(os.DevNull, os.O_RDWR, 0)
+	if err != nil {
+		reallyLog("Can't open %s: %v\n", os.DevNull, err)
+	} else {
+		for fd := 0; fd <= 2; fd++ {
+			dupFd(int(null.Fd()), fd)
+		}
+		null.Close()
+	}
+	fmt.Fprintln(ready, "ok")
+	ready.Close()
+}
+
+// pidfile is a --pidfile held by this process. The lock on it tells a live instance from a stale file.
+type pidfile struct {
+	path string
+	f    *os.File
+}
+
+// lockPidfile writes our pid to path, unless another running instance holds it. A pidfile whose instance is gone is
+// stale and overwritten. With wait, a held pidfile is retried for a while, for a predecessor that is handing over.
+func lockPidfile(path string, wait bool) (*pidfile, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	deadline := time.Now().Add(pidfileWait)
+	for {
+		err = syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+		if !errors.Is(err, syscall.EWOULDBLOCK) || !wait || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	if errors.Is(err, syscall.EWOULDBLOCK) {
+		data, _ := io.ReadAll(f)
+		f.Close()
+		return nil, fmt.Errorf("held by a running instance (pid %s)", strings.TrimSpace(string(data)))
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	if data, _ := io.ReadAll(f); len(data) > 0 {
+		maybeLog("Replacing stale pidfile %q (pid %s).\n", path, strings.TrimSpace(string(data)))
+	}
+	if err := f.Truncate(0); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if _, err := f.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &pidfile{path: path, f: f}, nil
+}
+
+// remove deletes the pidfile on shutdown. Within the sandbox it can't be, but it is stale as soon as we exit.
+func (p *pidfile) remove() {
+	if p == nil {
+		return
+	}
+	if err := os.Remove(p.path); err != nil {
+		maybeLog("Leaving pidfile %q behind: %v\n", p.path, err)
+	}
+	p.f.Close()
+}
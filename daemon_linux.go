@@ -0,0 +1,8 @@
+package main
+
+import "syscall"
+
+// dupFd makes newfd a copy of oldfd. linux/arm64 lacks dup2, so dup3 it is.
+func dupFd(oldfd, newfd int) error {
+	return syscall.Dup3(oldfd, newfd, 0)
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package main
+
+import "syscall"
+
+// dupFd makes newfd a copy of oldfd.
+func dupFd(oldfd, newfd int) error {
+	return syscall.Dup2(oldfd, newfd)
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+)
+
+// The GNOME setting that dims the screen some time before it blanks. Idle inhibits don't stop it.
+const (
+	dimSchema = "org.gnome.settings-daemon.plugins.power"
+	dimKey    = "idle-dim"
+)
+
+// dimmer turns idle dimming off while any lock is held. The setting is changed by a helper process started before
+// the sandbox is applied, since gsettings needs far more of the system than the daemon does. The helper restores the
+// user's setting when told to or when the daemon goes away, however that happens.
+type dimmer struct {
+	mtx  sync.Mutex
+	cmd  *exec.Cmd
+	w    io.WriteCloser
+	held bool
+}
+
+// startDimmer starts the dim-helper verb of exe.
+func startDimmer(exe string) (*dimmer, error) {
+	if _, err := exec.LookPath("gsettings"); err != nil {
+		return nil, err
+	}
+	cmd := exec.Command(exe, "dim-helper", fmt.Sprintf("--verbose=%t", *verbose || *debug))
+	cmd.Stdout, cmd.Stderr = log.Writer(), log.Writer()
+	w, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("starting the dim helper: %v", err)
+	}
+	return &dimmer{cmd: cmd, w: w}, nil
+}
+
+// update tells the helper to suppress dimming if held and to restore it otherwise.
+func (d *dimmer) update(held bool) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	if held == d.held {
+		return
+	}
+	cmd := "restore\n"
+	if held {
+		cmd = "suppress\n"
+	}
+	if _, err := io.WriteString(d.w, cmd); err != nil {
+		reallyLog("Couldn't reach the dim helper: %v\n", err)
+		return
+	}
+	d.held = held
+}
+
+// stop restores dimming and waits for the helper to exit.
+func (d *dimmer) stop() {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	d.w.Close()
+	if err := d.cmd.Wait(); err != nil {
+		reallyLog("Dim helper failed: %v\n", err)
+	}
+}
+
+// dimHelper is the dim-helper verb: it follows the suppress and restore commands the daemon sends on stdin, and
+// restores dimming once stdin is closed.
+func dimHelper() {
+	log.SetPrefix("dim-helper: ")
+	saved := "" // the value to restore, or "" while dimming isn't suppressed
+	restore := func() {
+		if saved == "" {
+			return
+		}
+		if err := gsettingsSet(saved); err != nil {
+			reallyLog("Couldn't restore dimming: %v\n", err)
+			return
+		}
+		maybeLog("Restored %s to %s.\n", dimKey, saved)
+		saved = ""
+	}
+
+	s := bufio.NewScanner(os.Stdin)
+	for s.Scan() {
+		switch s.Text() {
+		case "suppress":
+			if saved != "" {
+				continue
+			}
+			out, err := exec.Command("gsettings", "get", dimSchema, dimKey).Output()
+			if err != nil {
+				var stderr []byte
+				if ee, ok := err.(*exec.ExitError); ok {
+					stderr = ee.Stderr
+				}
+				reallyLog("Couldn't read %s %s: %v: %s\n", dimSchema, dimKey, err, strings.TrimSpace(string(stderr)))
+				continue
+			}
+			value := strings.TrimSpace(string(out))
+			if err := gsettingsSet("false"); err != nil {
+				reallyLog("Couldn't suppress dimming: %v\n", err)
+				continue
+			}
+			saved = value
+			maybeLog("Suppressed dimming (%s was %s).\n", dimKey, saved)
+		case "restore":
+			restore()
+		}
+	}
+	restore()
+}
+
+func gsettingsSet(value string) error {
+	if out, err := exec.Command("gsettings", "set", dimSchema, dimKey, value).CombinedOutput(); err != nil {
+		return fmt.Errorf("gsettings set %s %s %s: %v: %s", dimSchema, dimKey, value, err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
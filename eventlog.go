@@ -0,0 +1,52 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"time"
+
+	"github.com/coltwillcox/inhibitor/pkg/bridge"
+)
+
+// eventLog is where --events writes lock events, or nil.
+var eventLog *ndjsonLog
+
+// ndjsonLog writes lock events as newline-delimited JSON, one object per event, for jq, log shippers and scripts
+// that would rather not speak D-Bus.
+type ndjsonLog struct {
+	enc *json.Encoder
+}
+
+// ndjsonEvent is a line of --events=ndjson output.
+type ndjsonEvent struct {
+	Time    time.Time    `json:"time"`
+	Type    string       `json:"type"`
+	Message string       `json:"message,omitempty"`
+	Lock    *bridge.Lock `json:"lock,omitempty"` // absent for events about the bridge itself, such as name-lost
+}
+
+// openEventLog opens path for appending, or uses stdout if path is empty.
+func openEventLog(path string) (*ndjsonLog, error) {
+	var w io.Writer = os.Stdout
+	if path != "" {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+		if err != nil {
+			return nil, err
+		}
+		w = f
+	}
+	return &ndjsonLog{enc: json.NewEncoder(w)}, nil
+}
+
+// write writes ev as a line. It is fed from the bridge's Options.EventSink (see sinkEvent), so that no event is lost: a
+// reader that doesn't keep up only holds up the lines after it, which queue up meanwhile.
+func (l *ndjsonLog) write(ev bridge.Event) {
+	line := ndjsonEvent{Time: time.Now(), Type: ev.Type.String(), Message: ev.Message}
+	if ev.Type != bridge.NameLost && ev.Type != bridge.NameAcquired {
+		line.Lock = &ev.Lock
+	}
+	if err := l.enc.Encode(line); err != nil {
+		maybeLog("Error writing event: %v\n", err)
+	}
+}
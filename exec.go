@@ -0,0 +1,63 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/coltwillcox/inhibitor/pkg/bridge"
+)
+
+// execInhibited runs args, like systemd-inhibit, with a lock of the given what-classes and mode held through the
+// running daemon, and returns the command's exit code. who defaults to the command line.
+func execInhibited(system bool, what, who, why, mode string, args []string) (int, error) {
+	conn, err := connectBus(system)
+	if err != nil {
+		return exitCode(err), err
+	}
+	defer conn.Close()
+
+	if who == "" {
+		who = strings.Join(args, " ")
+	}
+	var cookie uint32
+	if err := conn.Object(bridge.ServiceName, bridge.ControlPath).Call(bridge.ControlInterface+".InhibitWhat", 0, who, why, what, mode).Store(&cookie); err != nil {
+		return exitCode(err), err
+	}
+	// The daemon drops the lock along with our connection should we die first.
+	defer conn.Object(bridge.ServiceName, "/org/freedesktop/ScreenSaver").Call(bridge.ServiceName+".UnInhibit", 0, cookie)
+
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := cmd.Start(); err != nil {
+		return exitFailure, err
+	}
+	// The terminal already sends SIGINT to the command; pass SIGTERM on and keep both from killing us before it exits.
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(sigCh)
+	go func() {
+		for s := range sigCh {
+			if s == syscall.SIGTERM {
+				cmd.Process.Signal(s)
+			}
+		}
+	}()
+
+	err = cmd.Wait()
+	var eerr *exec.ExitError
+	if errors.As(err, &eerr) {
+		if ws, ok := eerr.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+			// As a shell reports it.
+			return 128 + int(ws.Signal()), nil
+		}
+		return eerr.ExitCode(), nil
+	}
+	if err != nil {
+		return exitFailure, err
+	}
+	return 0, nil
+}
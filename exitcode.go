@@ -0,0 +1,56 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/coltwillcox/inhibitor/pkg/bridge"
+	"github.com/godbus/dbus/v5"
+)
+
+// exitError is an error that calls for a specific exit code.
+type exitError struct {
+	code int
+	err  error
+}
+
+func (e *exitError) Error() string {
+	return e.err.Error()
+}
+
+func (e *exitError) Unwrap() error {
+	return e.err
+}
+
+// exitCode returns the exit code a command that failed with err exits with, so that wrapper scripts and units can
+// tell the kinds of failure apart.
+func exitCode(err error) int {
+	var ee *exitError
+	if errors.As(err, &ee) {
+		return ee.code
+	}
+	var de dbus.Error
+	if errors.As(err, &de) {
+		switch de.Name {
+		case "org.freedesktop.DBus.Error.ServiceUnknown", "org.freedesktop.DBus.Error.NameHasNoOwner":
+			return exitNotRunning
+		case bridge.ErrorUnavailable, bridge.ErrorBackendDown:
+			// The daemon is up, but logind couldn't take the lock.
+			return exitNoLogind
+		}
+	}
+	return exitFailure
+}
+
+// connectBus connects the command to the system bus, or else the session bus, which the daemon it talks to serves.
+func connectBus(system bool) (*dbus.Conn, error) {
+	connect, bus := dbus.ConnectSessionBus, "session"
+	if system {
+		connect, bus = dbus.ConnectSystemBus, "system"
+	}
+	conn, err := connect()
+	if err != nil {
+		return nil, &exitError{exitBusUnavailable, fmt.Errorf("%s bus connect failed: %v", bus, err)}
+	}
+	return conn, nil
+}
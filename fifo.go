@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+)
+
+const (
+	// fifoWho is the who of every lock taken through the --fifo.
+	fifoWho = "fifo"
+	// fifoName is the --fifo's name in $XDG_RUNTIME_DIR.
+	fifoName = "inhibitor.fifo"
+	// fifoMaxLine is the longest --fifo request, newline included. Longer lines are skipped.
+	fifoMaxLine = 4096
+)
+
+// openFIFO creates the --fifo, unless a previous instance left it behind, and opens it. It is opened for writing
+// too, so that reads block rather than hit EOF while no script has it open.
+func openFIFO() (*os.File, error) {
+	dir := os.Getenv("XDG_RUNTIME_DIR")
+	if dir == "" {
+		return nil, errors.New("$XDG_RUNTIME_DIR isn't set")
+	}
+	path := filepath.Join(dir, fifoName)
+
+	if err := syscall.Mkfifo(path, 0600); err != nil && !errors.Is(err, os.ErrExist) {
+		return nil, fmt.Errorf("creating %q: %v", path, err)
+	}
+	if fi, err := os.Lstat(path); err != nil {
+		return nil, err
+	} else if fi.Mode()&os.ModeNamedPipe == 0 {
+		return nil, fmt.Errorf("%q exists and isn't a FIFO", path)
+	}
+	return os.OpenFile(path, os.O_RDWR, 0)
+}
+
+// serveFIFO reads requests from the --fifo, one per line, for as long as the daemon runs. A line over fifoMaxLine is
+// skipped rather than ending the reading, so that no writer can stop the --fifo serving everyone else.
+func (i *inhibitor) serveFIFO(f *os.File) {
+	r := bufio.NewReaderSize(f, fifoMaxLine)
+	for {
+		data, err := r.ReadSlice('\n')
+		if errors.Is(err, bufio.ErrBufferFull) {
+			reallyLog("Skipping a --fifo request over %d bytes.\n", fifoMaxLine)
+			for errors.Is(err, bufio.ErrBufferFull) {
+				_, err = r.ReadSlice('\n')
+			}
+			if err == nil {
+				continue
+			}
+		}
+		if err != nil {
+			reallyLog("Error reading --fifo: %v\n", err)
+			return
+		}
+		line := strings.TrimSpace(string(data))
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if err := i.fifoRequest(line); err != nil {
+			reallyLog("Invalid --fifo request %q: %v\n", line, err)
+		}
+	}
+}
+
+// fifoRequest carries out a single --fifo request:
+//
+//	inhibit WHAT DURATION REASON...
+//	keepalive WHAT INTERVAL REASON...
+//	uninhibit REASON...
+//
+// inhibit takes a lock of the colon-separated what-classes WHAT, such as "sleep" or "idle:sleep", for at most
+// DURATION, such as "30m". Inhibiting again for the same reason replaces the lock, so a job can keep renewing it.
+// keepalive takes a lock that is released once INTERVAL passes without the same request again, which renews it
+// without taking it afresh, so that a job that dies without uninhibiting holds nothing for long. uninhibit releases
+// either early.
+func (i *inhibitor) fifoRequest(line string) error {
+	f := strings.Fields(line)
+	switch {
+	case f[0] == "inhibit" && len(f) >= 4:
+		ttl, err := time.ParseDuration(f[2])
+		if err != nil {
+			return err
+		}
+		if ttl < time.Second {
+			return fmt.Errorf("duration %s is under a second", ttl)
+		}
+		why := strings.Join(f[3:], " ")
+		i.fifoRelease(why)
+		if _, err := i.bridge.InhibitWhat(i.bridge.Name(), fifoWho, why, f[1], ttl); err != nil {
+			return err
+		}
+		maybeLog("Inhibited %s for %s through --fifo: %q\n", f[1], ttl, why)
+	case f[0] == "keepalive" && len(f) >= 4:
+		interval, err := time.ParseDuration(f[2])
+		if err != nil {
+			return err
+		}
+		why := strings.Join(f[3:], " ")
+		if i.fifoKeepalive(why) {
+			return nil
+		}
+		i.fifoRelease(why)
+		if _, err := i.bridge.InhibitKeepalive(i.bridge.Name(), fifoWho, why, f[1], interval); err != nil {
+			return err
+		}
+		maybeLog("Inhibited %s through --fifo, kept alive every %s: %q\n", f[1], interval, why)
+	case f[0] == "uninhibit" && len(f) >= 2:
+		why := strings.Join(f[1:], " ")
+		if !i.fifoRelease(why) {
+			return errors.New("no such lock")
+		}
+		maybeLog("Released through --fifo: %q\n", why)
+	default:
+		return errors.New(`want "inhibit WHAT DURATION REASON...", "keepalive WHAT INTERVAL REASON..." or "uninhibit REASON..."`)
+	}
+	return nil
+}
+
+// fifoRelease releases the --fifo locks taken for why and reports whether there were any.
+func (i *inhibitor) fifoRelease(why string) bool {
+	found := false
+	for _, l := range i.bridge.Locks() {
+		if dbus.Sender(l.Peer) == i.bridge.Name() && l.Who == fifoWho && l.Why == why {
+			if err := i.bridge.UnInhibit(i.bridge.Name(), l.Cookie); err != nil {
+				maybeLog("Error releasing %s: %v\n", l, err)
+				continue
+			}
+			found = true
+		}
+	}
+	return found
+}
+
+// fifoKeepalive renews the --fifo keepalive lock taken for why and reports whether there was one.
+func (i *inhibitor) fifoKeepalive(why string) bool {
+	for _, l := range i.bridge.Locks() {
+		if dbus.Sender(l.Peer) != i.bridge.Name() || l.Who != fifoWho || l.Why != why || l.Keepalive == 0 {
+			continue
+		}
+		if err := i.bridge.Keepalive(i.bridge.Name(), l.Cookie); err != nil {
+			maybeLog("Error renewing %s: %v\n", l, err)
+			continue
+		}
+		return true
+	}
+	return false
+}
@@ -4,13 +4,11 @@ go 1.19
 
 require (
 	fyne.io/systray v1.10.1-0.20230710085509-436a931baccf
-	github.com/coreos/go-systemd v0.0.0-20191104093116-d3cd4ed1dbcf
-	github.com/esiqveland/notify v0.11.2
 	github.com/godbus/dbus/v5 v5.1.0
+	golang.org/x/sync v0.6.0
 )
 
 require (
-	github.com/godbus/dbus v4.1.0+incompatible // indirect
 	github.com/tevino/abool v1.2.0 // indirect
 	golang.org/x/sys v0.0.0-20200515095857-1151b9dac4a9 // indirect
 )
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/coltwillcox/inhibitor/pkg/bridge"
+)
+
+// lockGroup is the locks of one application in a listing. Browsers take many near-identical locks, one per tab
+// playing media, which would otherwise drown out everything else.
+type lockGroup struct {
+	name   string        // the application, as its oldest lock names it
+	locks  []int         // indices of its locks in the listing, oldest first
+	oldest time.Duration // how long the oldest has been held
+}
+
+// String returns a summary of the group, e.g. "Firefox: 7 locks, oldest 2h0m0s".
+func (g lockGroup) String() string {
+	noun := "locks"
+	if len(g.locks) == 1 {
+		noun = "lock"
+	}
+	return fmt.Sprintf("%s: %d %s, oldest %s", g.name, len(g.locks), noun, g.oldest)
+}
+
+// groupByApp groups the n locks of a listing by application (see bridge.AppKey), those with the most locks first.
+// lock returns the Flatpak application ID, who and time held of the i'th.
+func groupByApp(n int, lock func(i int) (app, who string, held time.Duration)) []lockGroup {
+	type entry struct {
+		i        int
+		app, who string
+		held     time.Duration
+	}
+	byApp := make(map[string][]entry)
+	for i := 0; i < n; i++ {
+		app, who, held := lock(i)
+		key := bridge.AppKey(app, who)
+		byApp[key] = append(byApp[key], entry{i, app, who, held})
+	}
+
+	groups := make([]lockGroup, 0, len(byApp))
+	for _, entries := range byApp {
+		sort.SliceStable(entries, func(i, j int) bool { return entries[i].held > entries[j].held })
+		g := lockGroup{name: entries[0].who, oldest: entries[0].held}
+		if entries[0].app != "" {
+			g.name = entries[0].app
+		}
+		for _, e := range entries {
+			g.locks = append(g.locks, e.i)
+		}
+		groups = append(groups, g)
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		if len(groups[i].locks) != len(groups[j].locks) {
+			return len(groups[i].locks) > len(groups[j].locks)
+		}
+		return groups[i].name < groups[j].name
+	})
+	return groups
+}
@@ -0,0 +1,331 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coltwillcox/inhibitor/pkg/bridge"
+	"github.com/coltwillcox/inhibitor/pkg/store"
+)
+
+const (
+	// historyDay is the layout of the days --history is kept by.
+	historyDay = "2006-01-02"
+	// historyKeep is how many days --history keeps.
+	historyKeep = 400
+	// historyRecord is the store record --history is kept in.
+	historyRecord = "history"
+	// legacyHistoryRecord is where the days are in a --history file written before it was a store: at the top level.
+	legacyHistoryRecord = "days"
+)
+
+// lockHistory is the daemon's --history, or nil.
+var lockHistory *history
+
+// appTotal is what an application inhibited on a single day.
+type appTotal struct {
+	Locks   int     `json:"locks"`   // taken that day
+	Seconds float64 `json:"seconds"` // held that day, of locks taken any day
+}
+
+// historyFile is the --history record: the totals of every application by day, in local time.
+type historyFile struct {
+	Days map[string]map[string]*appTotal `json:"days"`
+}
+
+// heldLock is a lock whose time so far is not yet in the totals.
+type heldLock struct {
+	app  string
+	from time.Time // when its time was last added to the totals
+}
+
+// history keeps --history up to date from the bridge's events. A lock's time is added when it is released, and
+// before the daemon exits or hands its locks over, so that a long-held lock is never counted twice. The totals are
+// saved by saveLoop until stop, rather than as events are recorded, which would hold up the ones after them.
+type history struct {
+	mtx     sync.Mutex
+	st      store.Store
+	started time.Time
+	data    historyFile
+	held    map[string]*heldLock // by peer and cookie
+	saveCh  chan struct{}        // wakes saveLoop; closed by stop
+	stopped bool                 // saveCh is closed
+	done    chan struct{}        // closed once saveLoop returns
+	saveMtx sync.Mutex           // held while saving, so that an older copy of the totals never overwrites a newer one
+}
+
+// loadHistory reads the history kept in st.
+func loadHistory(st store.Store) (*history, error) {
+	h := &history{st: st, started: time.Now(), held: make(map[string]*heldLock), saveCh: make(chan struct{}, 1),
+		done: make(chan struct{})}
+	data, err := readHistory(st)
+	if err != nil && !errors.Is(err, store.ErrNotFound) {
+		// Most likely cut short by a crash while writing it. The history is nice to have, not worth not starting.
+		reallyLog("Starting --history afresh: %v\n", err)
+	}
+	h.data = data
+	if err := h.save(); err != nil {
+		return nil, err
+	}
+	go h.saveLoop()
+	return h, nil
+}
+
+// readHistory returns the history kept in st, moving it from where a --history file written before it was a store
+// has it.
+func readHistory(st store.Store) (historyFile, error) {
+	var hf historyFile
+	err := store.GetJSON(st, historyRecord, &hf)
+	if !errors.Is(err, store.ErrNotFound) {
+		return hf, err
+	}
+	if lerr := store.GetJSON(st, legacyHistoryRecord, &hf.Days); lerr != nil {
+		return historyFile{}, err
+	}
+	if err := store.PutJSON(st, historyRecord, hf); err != nil {
+		return hf, err
+	}
+	return hf, st.Delete(legacyHistoryRecord)
+}
+
+// historyApp is what a lock is counted under: its Flatpak application ID if it has one, or else its who.
+func historyApp(l bridge.Lock) string {
+	if l.App != "" {
+		return l.App
+	}
+	return l.Who
+}
+
+// record accounts for a lock event.
+func (h *history) record(ev bridge.Event) {
+	if ev.Type != bridge.LockAdded && ev.Type != bridge.LockRemoved {
+		return
+	}
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+
+	id := fmt.Sprintf("%s/%d", ev.Lock.Peer, ev.Lock.Cookie)
+	now := time.Now()
+	// Locks adopted from a previous instance were counted, and their time so far added, by it.
+	from := ev.Lock.Since
+	if from.Before(h.started) {
+		from = h.started
+	}
+	switch ev.Type {
+	case bridge.LockAdded:
+		h.held[id] = &heldLock{app: historyApp(ev.Lock), from: from}
+		if !ev.Lock.Since.Before(h.started) {
+			h.total(ev.Lock.Since, historyApp(ev.Lock)).Locks++
+		}
+	case bridge.LockRemoved:
+		if l, ok := h.held[id]; ok {
+			from = l.from
+			delete(h.held, id)
+		}
+		h.add(historyApp(ev.Lock), from, now)
+		if h.stopped {
+			return
+		}
+		select {
+		case h.saveCh <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// saveLoop saves the totals each time record wakes it, until stop.
+func (h *history) saveLoop() {
+	defer close(h.done)
+	for range h.saveCh {
+		h.saveLogged()
+	}
+}
+
+// stop ends saveLoop once any save it is in the middle of is written. Whatever is recorded after it is only saved by
+// an explicit save or settle, which the daemon does last, before closing the store.
+func (h *history) stop() {
+	h.mtx.Lock()
+	if !h.stopped {
+		h.stopped = true
+		close(h.saveCh)
+	}
+	h.mtx.Unlock()
+	<-h.done
+}
+
+// settle adds the time of every held lock so far to the totals and saves them, before the daemon exits or hands its
+// locks over.
+func (h *history) settle() {
+	h.mtx.Lock()
+	now := time.Now()
+	for _, l := range h.held {
+		h.add(l.app, l.from, now)
+		l.from = now
+	}
+	h.mtx.Unlock()
+	h.saveLogged()
+}
+
+// total returns the totals of app on the day of t.
+func (h *history) total(t time.Time, app string) *appTotal {
+	day := t.Format(historyDay)
+	if h.data.Days == nil {
+		h.data.Days = make(map[string]map[string]*appTotal)
+	}
+	if h.data.Days[day] == nil {
+		h.data.Days[day] = make(map[string]*appTotal)
+	}
+	if h.data.Days[day][app] == nil {
+		h.data.Days[day][app] = &appTotal{}
+	}
+	return h.data.Days[day][app]
+}
+
+// add adds the time from from to to to app's totals, split across the days it spans.
+func (h *history) add(app string, from, to time.Time) {
+	for from.Before(to) {
+		y, m, d := from.Date()
+		end := time.Date(y, m, d+1, 0, 0, 0, 0, from.Location())
+		if end.After(to) {
+			end = to
+		}
+		h.total(from, app).Seconds += end.Sub(from).Seconds()
+		from = end
+	}
+}
+
+// save writes the totals, dropping days older than historyKeep. The store is written to without holding up record.
+func (h *history) save() error {
+	h.saveMtx.Lock()
+	defer h.saveMtx.Unlock()
+	cutoff := time.Now().AddDate(0, 0, -historyKeep).Format(historyDay)
+	h.mtx.Lock()
+	for day := range h.data.Days {
+		if day < cutoff {
+			delete(h.data.Days, day)
+		}
+	}
+	data, err := json.Marshal(h.data)
+	h.mtx.Unlock()
+	if err != nil {
+		return fmt.Errorf("encoding record %q: %v", historyRecord, err)
+	}
+	return h.st.Put(historyRecord, data)
+}
+
+func (h *history) saveLogged() {
+	if err := h.save(); err != nil {
+		reallyLog("Error writing --history: %v\n", err)
+	}
+}
+
+// parseSince parses --since: a number of days such as "7d", a Go duration such as "12h", or a date.
+func parseSince(s string, now time.Time) (time.Time, error) {
+	if n, err := strconv.Atoi(strings.TrimSuffix(s, "d")); err == nil && strings.HasSuffix(s, "d") && n >= 0 {
+		return now.AddDate(0, 0, -n), nil
+	}
+	if d, err := time.ParseDuration(s); err == nil && d >= 0 {
+		return now.Add(-d), nil
+	}
+	if t, err := time.ParseInLocation(historyDay, s, now.Location()); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("invalid --since %q: want days (\"7d\"), a duration (\"12h\") or a date (%q)", s, historyDay)
+}
+
+// reportRow is the totals of one application, on one day or over the whole report.
+type reportRow struct {
+	Day     string  `json:"day,omitempty"`
+	App     string  `json:"app"`
+	Locks   int     `json:"locks"`
+	Seconds float64 `json:"seconds"`
+}
+
+// report prints what the --history kept in st says applications inhibited since the day of since: in text, the
+// totals of each, most time first; as csv or json, the totals of each by day, for further analysis.
+func report(st store.Store, since time.Time, format string) error {
+	hf, err := readHistory(st)
+	if err != nil && !errors.Is(err, store.ErrNotFound) {
+		return err
+	}
+
+	first := since.Format(historyDay)
+	var rows []reportRow
+	for day, apps := range hf.Days {
+		if day < first {
+			continue
+		}
+		for app, t := range apps {
+			rows = append(rows, reportRow{Day: day, App: app, Locks: t.Locks, Seconds: t.Seconds})
+		}
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		if rows[i].Day != rows[j].Day {
+			return rows[i].Day < rows[j].Day
+		}
+		return rows[i].App < rows[j].App
+	})
+
+	switch format {
+	case "csv":
+		w := csv.NewWriter(os.Stdout)
+		w.Write([]string{"day", "app", "locks", "seconds"})
+		for _, r := range rows {
+			w.Write([]string{r.Day, r.App, strconv.Itoa(r.Locks), strconv.FormatFloat(r.Seconds, 'f', 0, 64)})
+		}
+		w.Flush()
+		return w.Error()
+	case "json":
+		if rows == nil {
+			rows = []reportRow{}
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(rows)
+	}
+
+	totals := make(map[string]*reportRow)
+	var all float64
+	for _, r := range rows {
+		t := totals[r.App]
+		if t == nil {
+			t = &reportRow{App: r.App}
+			totals[r.App] = t
+		}
+		t.Locks += r.Locks
+		t.Seconds += r.Seconds
+		all += r.Seconds
+	}
+	byTime := make([]*reportRow, 0, len(totals))
+	for _, t := range totals {
+		byTime = append(byTime, t)
+	}
+	sort.Slice(byTime, func(i, j int) bool {
+		if byTime[i].Seconds != byTime[j].Seconds {
+			return byTime[i].Seconds > byTime[j].Seconds
+		}
+		return byTime[i].App < byTime[j].App
+	})
+
+	fmt.Printf("Inhibited since %s:\n", first)
+	if len(byTime) == 0 {
+		fmt.Println("  nothing")
+	}
+	for _, t := range byTime {
+		share := 0.0
+		if all > 0 {
+			share = 100 * t.Seconds / all
+		}
+		held := time.Duration(t.Seconds * float64(time.Second)).Round(time.Second)
+		fmt.Printf("  %-30s %10s %5.1f%%  %d lock(s)\n", t.App, held, share, t.Locks)
+	}
+	return nil
+}
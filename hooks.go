@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/coltwillcox/inhibitor/pkg/bridge"
+)
+
+// The changes --hook is run for, as $INHIBITOR_EVENT.
+const (
+	hookActive = "active" // the first lock was taken
+	hookIdle   = "idle"   // the last lock was released
+	hookDenied = "denied" // a request for a lock was refused
+)
+
+// hookTimeout is how long a hook may run before it is killed, so that a hung one doesn't hold up those after it.
+const hookTimeout = 30 * time.Second
+
+// hookRunner runs --hook when the daemon goes from holding no lock to holding some and back, and when a request is
+// denied. Like the dimmer's, its commands are run by a helper process started before the sandbox is applied, which
+// takes each change as a line of JSON with the hook's environment on its stdin and runs the hooks one at a time, in
+// order.
+type hookRunner struct {
+	mtx    sync.Mutex
+	cmd    *exec.Cmd
+	w      io.WriteCloser
+	active bool
+}
+
+// startHooks starts the hook-helper verb of exe for command.
+func startHooks(exe, command string) (*hookRunner, error) {
+	cmd := exec.Command(exe, "hook-helper", "--hook="+command, fmt.Sprintf("--verbose=%t", *verbose || *debug))
+	cmd.Stdout, cmd.Stderr = log.Writer(), log.Writer()
+	w, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("starting the hook helper: %v", err)
+	}
+	return &hookRunner{cmd: cmd, w: w}, nil
+}
+
+// update runs the hook for ev if it changed whether any lock is held, now that held are, or if it was a denial.
+func (h *hookRunner) update(ev bridge.Event, held int) {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+
+	event := hookDenied
+	if ev.Type != bridge.LockDenied {
+		if active := held > 0; active == h.active {
+			return
+		} else if h.active = active; active {
+			event = hookActive
+		} else {
+			event = hookIdle
+		}
+	}
+	env := map[string]string{"INHIBITOR_EVENT": event, "INHIBITOR_LOCKS": strconv.Itoa(held)}
+	// The lock that caused the change, and why it was released or denied, where known.
+	for k, v := range map[string]string{
+		"INHIBITOR_WHO":    ev.Lock.Who,
+		"INHIBITOR_WHY":    ev.Lock.Why,
+		"INHIBITOR_WHAT":   ev.Lock.What,
+		"INHIBITOR_PEER":   ev.Lock.Peer,
+		"INHIBITOR_ID":     ev.Lock.ID,
+		"INHIBITOR_REASON": ev.Message,
+	} {
+		if v != "" {
+			env[k] = v
+		}
+	}
+	line, err := json.Marshal(env)
+	if err != nil {
+		reallyLog("Couldn't encode the %s hook: %v\n", event, err)
+		return
+	}
+	if _, err := h.w.Write(append(line, '\n')); err != nil {
+		reallyLog("Couldn't reach the hook helper: %v\n", err)
+	}
+}
+
+// stop waits for the helper to run the hooks it was given and exit.
+func (h *hookRunner) stop() {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+
+	h.w.Close()
+	if err := h.cmd.Wait(); err != nil {
+		reallyLog("Hook helper failed: %v\n", err)
+	}
+}
+
+// hookHelper is the hook-helper verb: it runs command through the shell for every change the daemon sends on stdin,
+// with the change's variables added to the environment, until stdin is closed.
+func hookHelper(command string) {
+	log.SetPrefix("hook-helper: ")
+
+	s := bufio.NewScanner(os.Stdin)
+	for s.Scan() {
+		var env map[string]string
+		if err := json.Unmarshal(s.Bytes(), &env); err != nil {
+			reallyLog("Unexpected input: %v\n", err)
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), hookTimeout)
+		cmd := exec.CommandContext(ctx, "/bin/sh", "-c", command)
+		cmd.Stdout, cmd.Stderr = os.Stderr, os.Stderr
+		cmd.Env = os.Environ()
+		for k, v := range env {
+			cmd.Env = append(cmd.Env, k+"="+v)
+		}
+		start := time.Now()
+		err := cmd.Run()
+		killed := ctx.Err() == context.DeadlineExceeded
+		cancel()
+		if killed {
+			reallyLog("The %s hook was killed after %s.\n", env["INHIBITOR_EVENT"], hookTimeout)
+		} else if err != nil {
+			reallyLog("The %s hook failed: %v\n", env["INHIBITOR_EVENT"], err)
+		} else {
+			maybeLog("Ran the %s hook in %s.\n", env["INHIBITOR_EVENT"], time.Since(start).Round(time.Millisecond))
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// locales holds a message catalog per language, such as locales/de.json: a JSON object mapping each English string
+// of the notifications and the tray, as passed to tr, to its translation.
+//
+//go:embed locales/*.json
+var locales embed.FS
+
+// catalog is the message catalog of the user's language, or nil for English.
+var catalog = loadCatalog(localeName())
+
+// localeName returns the locale messages are shown in: LC_ALL, LC_MESSAGES or LANG, the first one set, as gettext
+// picks it.
+func localeName() string {
+	for _, v := range []string{"LC_ALL", "LC_MESSAGES", "LANG"} {
+		if l := os.Getenv(v); l != "" {
+			return l
+		}
+	}
+	return ""
+}
+
+// loadCatalog returns the catalog for locale, e.g. "de_AT.UTF-8", looking for de_AT and then de. There is none for
+// English, the C locale or languages without a catalog; a broken one is logged and ignored.
+func loadCatalog(locale string) map[string]string {
+	lang := locale
+	if i := strings.IndexAny(lang, ".@"); i >= 0 {
+		lang = lang[:i]
+	}
+	names := []string{lang}
+	if i := strings.IndexByte(lang, '_'); i >= 0 {
+		names = append(names, lang[:i])
+	}
+	for _, name := range names {
+		if name == "" || name == "C" || name == "POSIX" || name == "en" {
+			return nil
+		}
+		data, err := locales.ReadFile("locales/" + name + ".json")
+		if err != nil {
+			continue
+		}
+		var c map[string]string
+		if err := json.Unmarshal(data, &c); err != nil {
+			reallyLog("Ignoring the %s message catalog: %v\n", name, err)
+			return nil
+		}
+		return c
+	}
+	return nil
+}
+
+// tr formats msg, a user-facing English string, with args like fmt.Sprintf, in the user's language if its catalog
+// translates it. Strings left untranslated stay English.
+func tr(msg string, args ...interface{}) string {
+	if t, ok := catalog[msg]; ok && t != "" {
+		msg = t
+	}
+	if len(args) == 0 {
+		return msg
+	}
+	return fmt.Sprintf(msg, args...)
+}
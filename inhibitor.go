@@ -1,56 +1,84 @@
 package main
 
 import (
+	"context"
 	_ "embed"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
-	"math/rand"
 	"os"
 	"os/signal"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
 	"fyne.io/systray"
-	"github.com/coreos/go-systemd/login1"
-	"github.com/esiqveland/notify"
+	"github.com/coltwillcox/inhibitor/pkg/backend"
+	"github.com/coltwillcox/inhibitor/pkg/bridge"
+	"github.com/coltwillcox/inhibitor/pkg/policy"
+	"github.com/coltwillcox/inhibitor/pkg/store"
 	"github.com/godbus/dbus/v5"
-	"github.com/godbus/dbus/v5/introspect"
 )
 
-// lockDetails represents all of the state for an individual inhibit lock that we've requested from systemd.
-type lockDetails struct {
-	cookie   uint
-	peer     dbus.Sender
-	who, why string
-	fd       *os.File
-}
+// Exit codes. Scripts and service managers may branch on these, so they never change meaning.
+const (
+	exitFailure        = 1 // anything not covered below
+	exitUsage          = 2 // invalid flags or command, as with the flag package
+	exitNameTaken      = 3 // org.freedesktop.ScreenSaver is owned by another instance or a desktop's screensaver
+	exitSandbox        = 4 // the sandbox couldn't be applied
+	exitBusUnavailable = 5 // the bus to serve on, or reach the daemon over, can't be reached
+	exitNoLogind       = 6 // logind can't be reached or fails to take a lock
+	exitConfig         = 7 // the --config file is missing or invalid
+	exitNotRunning     = 8 // a command needs the running daemon, and there is none
+)
 
-// inhibitor represents the state required to bridge dbus inhibit requests to systemd logind idle inhibits.
-type inhibitor struct {
-	prog            string
-	dbusConn        *dbus.Conn
-	loginConn       *login1.Conn
-	manualInhibit   *systray.MenuItem
-	quitInhibitor   *systray.MenuItem
-	localCookie     uint
-	locks           map[uint]*lockDetails
-	mtx             sync.Mutex
-	trayCh, doneCh  chan struct{}
-	manualTimeoutCh chan struct{}
-	quitCh          chan os.Signal
-}
+// manualWho is the who of the manual inhibit's lock.
+const manualWho = "systray"
 
+// Desktop notifications, sent through org.freedesktop.Notifications.
 const (
-	listNames       = "org.freedesktop.DBus.ListNames"
-	intro           = "org.freedesktop.DBus.Introspectable"
-	screensaver     = "org.freedesktop.ScreenSaver"
-	screensaverPath = "/org/freedesktop/ScreenSaver"
-	legacyPath      = "/ScreenSaver" // Firefox looks for this path, not /org/freedesktop/ScreenSaver
+	notifyName    = "org.freedesktop.Notifications"
+	notifyPath    = "/org/freedesktop/Notifications"
+	notifyCall    = notifyName + ".Notify"
+	notifyExpire  = 5 * time.Second // how long a notification is shown
+	notifyTimeout = 5 * time.Second // how long the notification daemon may take to answer
 )
 
+// inhibitor is the command's front-end to the bridge: the tray icon, notifications and the manual inhibit.
+type inhibitor struct {
+	prog          string
+	exe           string
+	system        bool
+	logFD         int
+	bridge        *bridge.Bridge
+	dim           *dimmer        // nil unless --suppress_dimming
+	windows       *windowWatcher // nil unless --window_locks
+	hooks         *hookRunner    // nil unless --hook
+	pidfile       *pidfile       // nil unless --pidfile
+	pool          *workerPool
+	conn          *dbus.Conn
+	manualInhibit *systray.MenuItem
+	quitInhibitor *systray.MenuItem
+	lockMenu      *lockMenu // nil until the tray is up
+	templates     lockTemplates
+	localCookie   uint32
+	// The caffeine lock, toggled by --caffeine_signal or the tray, is held until toggled off: unlike the manual
+	// inhibit it has no timeout.
+	caffeineItem         *systray.MenuItem
+	caffeineCookie       uint32
+	caffeineNotification atomic.Uint32
+	mtx                  sync.Mutex
+	trayCh               chan struct{}
+	manualTimeoutCh      chan struct{}
+	quitCh               chan os.Signal
+	batteryCritical      atomic.Bool // while UPower reports the battery critical (see --critical_battery)
+}
+
 var (
 	//go:embed icons/uninhibited.png
 	iconUninhibited []byte
@@ -59,49 +87,615 @@ var (
 	//go:embed icons/manually-inhibited.png
 	iconManuallyInhibited []byte
 
-	//go:embed org.freedesktop.ScreenSaver.xml
-	screensaverInterface string
-	ssXML                = "<node>" + screensaverInterface + introspect.IntrospectDataString + "</node>"
-
 	// CLI Flags
+	allowReplacement  = flag.Bool("allow_replacement", false, "If true, let another process (e.g. a desktop environment's screensaver) take org.freedesktop.ScreenSaver over, and exit when it does.")
+	allowTakeover     = flag.Bool("allow_takeover", true, "If true, let a new instance started with --takeover (as the same user) take every lock over, after which this one exits.")
+	allUsers          = flag.Bool("all_users", false, "If true, `inhibitor status` lists every user's locks. Needs --system and root or the manage-all polkit action.")
+	scheduleAt        = flag.String("at", "", "When inhibitor schedule takes its lock: a time of day (\"22:00\", the next one), a date and time (\"2006-01-02 15:04\") or RFC 3339.")
+	breakerThreshold  = flag.Int("breaker_threshold", 5, "How many logind Inhibit calls in a row, retries included, may fail before requests fail at once with org.freedesktop.ScreenSaver.Error.BackendDown, rather than keep calling it, until a probe finds logind back. 0 disables the circuit breaker.")
+	busAddress        = flag.String("bus_address", "", "If set, use the session bus at this D-Bus address (e.g. \"unix:path=/run/user/1000/bus\") instead of $DBUS_SESSION_BUS_ADDRESS. Applies to subcommands, the systray and notifications too.")
+	caffeineSignal    = flag.String("caffeine_signal", "USR2", "The signal that toggles the caffeine lock, held until toggled off again: \"USR2\", \"RTMIN+N\", or empty to disable it.")
+	calendarFile      = flag.String("calendar", "", "If set, an iCalendar (.ics) file, such as Evolution's local calendar.ics, to hold a lock during events tagged with --calendar_keyword.")
+	calendarKeyword   = flag.String("calendar_keyword", "presentation", "The word, in an event's summary or categories, that makes --calendar hold a lock during it.")
+	benchClients      = flag.Int("clients", 10, "How many bus clients inhibitor bench runs at once.")
+	compat            = flag.Bool("compat", false, "If true, also serve org.freedesktop.PowerManagement, org.gnome.SessionManager, org.kde.Solid.PowerManagement.PolicyAgent and org.mate.SessionManager inhibits, for applications that use those. The --config file's compat toggles turn them on or off one at a time.")
+	configFile        = flag.String("config", "", "If set, read extra settings (see README) from this JSON file. SIGHUP re-reads it.")
+	criticalBattery   = flag.Bool("critical_battery", true, "If true, release every lock that blocks sleep or shutdown while UPower reports the battery critical, so that the low-battery action isn't held up.")
+	detach            = flag.Bool("daemonize", false, "If true, detach from the terminal and run in the background, e.g. from .xinitrc. The command returns once the daemon is up, relaying its startup errors unless --logfile is set.")
+	debug             = flag.Bool("debug", false, "If true, log even per-lock detail, such as every lock each heartbeat checks. Implies --verbose.")
+	developer         = flag.Bool("developer", false, "If true, apply the --config file's faults, failing or holding up matching lock requests on purpose so that application developers can test their error handling. Never use it for real.")
+	dedupePortal      = flag.Bool("dedupe_portal", false, "If true, a request for the same application and reason as a held lock, where exactly one of the two came through xdg-desktop-portal, shares that lock's logind inhibit instead of taking a second one.")
+	events            = flag.String("events", "", "If \"ndjson\", write every lock event as a line of JSON to stdout, or --events_file, for scripts and log shippers.")
+	eventsFile        = flag.String("events_file", "", "If set with --events, append the events to this file instead of stdout.")
+	expand            = flag.Bool("expand", false, "If true, `inhibitor status` lists every lock of an application holding several, rather than just how many and how long the oldest has been held.")
+	fifo              = flag.Bool("fifo", false, "If true, take requests such as \"inhibit sleep 30m backup\" from scripts through the FIFO $XDG_RUNTIME_DIR/inhibitor.fifo (see README).")
+	scheduleFor       = flag.Duration("for", time.Hour, "How long inhibitor schedule holds its lock from --at.")
+	foreground        = flag.Bool("foreground", false, "If true, stay in the foreground even with --daemonize, e.g. to debug a session script that passes it.")
+	format            = flag.String("format", "text", "The format inhibitor report prints in: \"text\", or \"csv\" or \"json\" for totals by day.")
 	heartbeat         = flag.Duration("heartbeat", time.Duration(10*time.Second), "How long do we wait between active lock peer validations.")
+	heartbeatReport   = flag.Duration("heartbeat_report", 10*time.Minute, "How often to log, with --verbose, a summary of the heartbeat's work such as \"checked 14 locks, reaped 1\".")
+	historyPath       = flag.String("history", "", "If set, keep each application's daily inhibit counts and durations in this store, which inhibitor report reads: a JSON file's path or file:PATH. It may be the same as --store.")
+	hook              = flag.String("hook", "", "If set, a shell command to run when the first lock is taken, the last one is released or a request is denied, with $INHIBITOR_EVENT set to \"active\", \"idle\" or \"denied\" and the lock described by $INHIBITOR_WHO, $INHIBITOR_WHY and the like.")
+	hotUpgrade        = flag.Bool("hot_upgrade", false, "If true, `inhibitor upgrade` re-execs the daemon's binary, handing over every lock. Keeps execve available in the sandbox.")
+	idleHint          = flag.Bool("idle_hint", false, "If true, also mark the sessions of processes holding locks as not idle with logind's SetIdleHint, for idle policies that ignore inhibitors.")
+	inhibitRetries    = flag.Int("inhibit_retries", 3, "How many times to retry a failed logind Inhibit, with exponential backoff, before giving up.")
+	jit               = flag.Duration("jit", 0, "If set, the session's idle timeout. Locks on idle alone then take their logind inhibit only once GNOME's idle time nears it, and give it back when the user is active, keeping short-lived locks out of systemd-inhibit --list. 0s takes every lock right away.")
+	benchLocks        = flag.Int("locks", 10, "How many locks each of inhibitor bench's clients takes.")
 	logfile           = flag.String("logfile", "", "If set, log to this path instead of the default (os.Stderr) target")
+	logindBus         = flag.String("logind_bus", "system", "Which bus to find logind on: \"system\", or \"session\" to use a cmd/mock-logind instance for development.")
+	logindInhibitors  = flag.Bool("logind_inhibitors", false, "If true, `inhibitor status` lists every logind inhibitor instead, marking those taken by the daemon, to show everything that is blocking idle, sleep or shutdown.")
+	logRateLimit      = flag.Duration("log_ratelimit", time.Minute, "Repeated errors are logged at most once per this window and then summarized. 0s disables coalescing.")
 	manualTimeout     = flag.Duration("manual_inhibit_timeout", 60*time.Minute, "The maximum time to allow a manual inhibit to persist. 0m disables this feature.")
+	maxBlock          = flag.Duration("max_block", 0, "How long a lock may block sleep and shutdown before they are downgraded to delay mode, which logind only honours for InhibitDelayMaxSec. 0 never downgrades.")
+	maxLocksPerPeer   = flag.Int("max_locks_per_peer", 0, "The most locks a single peer may hold at once; further Inhibits fail with org.freedesktop.ScreenSaver.Error.Limit. 0 means no limit.")
+	mode              = flag.String("mode", "block", "The logind mode inhibitor exec and inhibitor schedule take their lock in: \"block\", or \"delay\" to only hold sleep and shutdown off for InhibitDelayMaxSec.")
 	sendNotifications = flag.Bool("notify", true, "If true, send notifications on interesting state changes.")
+	ownerChangePolicy = flag.String("owner_change_policy", string(policy.OwnerChangeKeep), "What to do with a lock when a well-known name its peer held moves to another connection: \"keep\" or \"release\". Either way the event is logged.")
+	pidPath           = flag.String("pidfile", "", "If set, write the daemon's pid to this file, refusing to start while another running instance holds it. A file left behind by an instance that is gone is replaced.")
+	profileSignals    = flag.String("profile_signals", "", "Comma-separated SIGNAL=PROFILE pairs, e.g. \"RTMIN+1=presentation\": each signal toggles the --config profile it names. Signals are given as for --caffeine_signal.")
+	provisional       = flag.Bool("provisional_locks", false, "If true, hand out a provisional cookie when logind can't be reached and acquire the lock once it is back.")
+	proxy             = flag.Bool("proxy", false, "If true and org.freedesktop.ScreenSaver is owned by another process, serve only the --compat names and forward them to that process.")
+	queue             = flag.Bool("queue", false, "If true and org.freedesktop.ScreenSaver is owned by another process, wait in line for it instead of exiting, and take over when the owner exits.")
+	remoteSleep       = flag.Bool("remote_sleep", false, "If true, let locks from remote (ssh, xrdp, VNC) and seatless logind sessions inhibit sleep too. Otherwise sleep is dropped from their what-classes.")
+	replace           = flag.Bool("replace", false, "If true, take org.freedesktop.ScreenSaver over from its current owner if that owner allows replacement.")
+	requestQueue      = flag.Int("request_queue", 64, "How many requests for locks may wait while the daemon works on others before new ones are refused with org.freedesktop.ScreenSaver.Error.Busy.")
+	requestTimeout    = flag.Duration("request_timeout", 5*time.Second, "How long a request for a lock may wait for the daemon to get to it before it is refused with org.freedesktop.ScreenSaver.Error.Busy.")
+	sandbox           = flag.Bool("sandbox", true, "If true, restrict the daemon with no_new_privs, Landlock and seccomp once it is running. Disable for debugging.")
+	shutdownTTL       = flag.Duration("shutdown_ttl", 4*time.Hour, "How long `inhibitor shutdown` holds off shutdowns and reboots unless released earlier.")
+	signalBatch       = flag.Duration("signal_batch", 250*time.Millisecond, "How long the Removed signal of a released lock waits for those of others, to announce locks released together, such as all of an exiting browser's, with one RemovedBatch signal, and how often changes of ActiveInhibitorCount are announced at most. 0 sends each right away.")
+	since             = flag.String("since", "7d", "How far back inhibitor report goes: days (\"7d\"), a duration (\"12h\") or a date (\"2006-01-02\").")
+	softFail          = flag.Bool("soft_fail", false, "If true, keep serving when logind can't be reached, handing out no-op locks that inhibit nothing rather than failing requests, with a warning. They are taken for real once logind is back.")
+	state             = flag.Bool("state", false, "If true, keep $XDG_RUNTIME_DIR/inhibitor/state.json up to date with the held locks and the manual and caffeine inhibits, for status bars and scripts that can't speak D-Bus.")
+	storeSpec         = flag.String("store", "", "If set, keep the lock table, notes included, in this store as locks change, and take back from it on startup the locks a crashed instance held, for the peers still around: memory, file:PATH or a JSON file's path.")
+	strict            = flag.Bool("strict", false, "If true, reject calls that don't follow the org.freedesktop.ScreenSaver spec to the letter. Useful for testing applications.")
+	summaryFile       = flag.String("summary_file", "", "If set, write a JSON summary of the run (locks held at shutdown, totals) to this path on exit.")
+	suspendVeto       = flag.Duration("suspend_veto", 10*time.Second, "How long after the lid is closed the machine must be asleep for the locks blocking it not to be reported as having vetoed the suspend. 0 doesn't report vetoes.")
+	suppressDimming   = flag.Bool("suppress_dimming", false, "If true, turn GNOME's idle dimming off while any lock is held, restoring it once the last one is released.")
+	systemBus         = flag.Bool("system", false, "If true, serve all users on the system bus instead of the session bus. Disables the systray.")
+	takeover          = flag.Bool("takeover", false, "If true and an instance is already running, take its locks over through its control interface and replace it.")
+	testMode          = flag.Bool("test_mode", false, "If true, run for end-to-end tests on a private bus, such as cmd/e2e's: logind is a cmd/mock-logind on the session bus, the compat names are served, nothing is sent outside the bus and the heartbeat runs every second. Flags given explicitly still win.")
+	top               = flag.Int("top", 10, "How many applications inhibitor top-apps lists. 0 lists every one.")
+	topWindow         = flag.Duration("top_window", time.Hour, "How far back inhibitor top-apps looks, up to 24h.")
+	usageCheck        = flag.Duration("usage_check", 5*time.Minute, "How often the daemon samples its own memory, goroutines and open fds for Metrics, logging a warning when any grows well past what it was at startup. 0s disables sampling.")
 	verbose           = flag.Bool("verbose", false, "If true, output logging status updates. Be quiet when false.")
+	watchdog          = flag.Duration("watchdog", time.Minute, "How often to check that the daemon still owns its names and that its objects answer over the bus, claiming and exporting them again if not. 0s disables the check.")
+	what              = flag.String("what", policy.WhatIdle, "The logind what-classes every lock takes, colon-separated. \"idle:sleep\" also keeps the machine from suspending. For inhibitor exec and inhibitor schedule, the classes their lock takes instead.")
+	who               = flag.String("who", "", "Who inhibitor exec takes its lock for, defaulting to the command line, or inhibitor schedule, defaulting to \"schedule\".")
+	why               = flag.String("why", "Unknown reason", "Why inhibitor exec or inhibitor schedule takes its lock.")
+	windowLocks       = flag.Bool("window_locks", false, "If true, serve InhibitWindow, whose locks last as long as an X11 or Wayland window rather than the caller, following windows with xprop and the compositor's xdg-foreign protocol.")
 )
 
 func main() {
+	if filepath.Base(os.Args[0]) == xdgWho {
+		// Installed as a symlink named xdg-screensaver, for scripts that call it.
+		os.Args = append([]string{os.Args[0], xdgWho}, os.Args[1:]...)
+	}
 	flag.Parse()
+	// Flags may also follow the command.
+	verb := flag.Arg(0)
+	if verb != "" {
+		flag.CommandLine.Parse(flag.Args()[1:])
+		// Only annotate (the lock and note), config (export or import), exec (the command), profile (what to do with
+		// which), schedule (which to cancel), shutdown (the reason) and the xdg-screensaver shim take arguments.
+		if flag.NArg() > 0 && verb != "annotate" && verb != "config" && verb != "exec" && verb != "profile" && verb != "schedule" && verb != "shutdown" && verb != xdgWho && verb != "xdg-screensaver-hold" {
+			fatalf(exitUsage, "Unexpected arguments after %q: %q\n", verb, flag.Args())
+		}
+	}
+
+	if *testMode {
+		if *systemBus {
+			fatalf(exitUsage, "--test_mode runs on a private session bus and can't be combined with --system\n")
+		}
+		applyTestMode()
+	}
+
+	ownerChange, err := policy.ParseOwnerChange(*ownerChangePolicy)
+	if err != nil {
+		fatalf(exitUsage, "Invalid --owner_change_policy: %v\n", err)
+	}
+	whatClasses, err := policy.ParseWhat(*what)
+	if err != nil {
+		fatalf(exitUsage, "Invalid --what: %v\n", err)
+	}
+	if *logindBus != "system" && *logindBus != "session" {
+		fatalf(exitUsage, "Invalid --logind_bus %q: want \"system\" or \"session\"\n", *logindBus)
+	}
+	if *busAddress != "" {
+		if *systemBus {
+			fatalf(exitUsage, "--bus_address sets the session bus and can't be combined with --system\n")
+		}
+		// Everything connects through the environment, including the systray, notifications and helper processes.
+		os.Setenv("DBUS_SESSION_BUS_ADDRESS", *busAddress)
+	}
+	if *events != "" && *events != "ndjson" {
+		fatalf(exitUsage, "Invalid --events %q: want \"ndjson\"\n", *events)
+	}
+	if *eventsFile != "" && *events == "" {
+		fatalf(exitUsage, "--events_file needs --events=ndjson\n")
+	}
+	caffeineSig, err := parseSignal(*caffeineSignal)
+	if err != nil {
+		fatalf(exitUsage, "Invalid --caffeine_signal: %v\n", err)
+	}
+	profileSigs, err := parseProfileSignals(*profileSignals, syscall.SIGUSR1, syscall.SIGHUP, caffeineSig)
+	if err != nil {
+		fatalf(exitUsage, "Invalid --profile_signals: %v\n", err)
+	}
+	if *suppressDimming && *systemBus {
+		fatalf(exitUsage, "--suppress_dimming is a per-user setting and can't be combined with --system\n")
+	}
+	if *fifo && *systemBus {
+		fatalf(exitUsage, "--fifo lives in the user's $XDG_RUNTIME_DIR and can't be combined with --system\n")
+	}
+	if *windowLocks && *systemBus {
+		fatalf(exitUsage, "--window_locks follows the user's own display and can't be combined with --system\n")
+	}
+	if *state && *systemBus {
+		fatalf(exitUsage, "--state lives in the user's $XDG_RUNTIME_DIR and can't be combined with --system\n")
+	}
+	if *calendarFile != "" {
+		if *systemBus {
+			fatalf(exitUsage, "--calendar is a per-user setting and can't be combined with --system\n")
+		}
+		if *calendarKeyword == "" {
+			fatalf(exitUsage, "--calendar_keyword can't be empty\n")
+		}
+		if _, err := readCalendar(*calendarFile); err != nil {
+			fatalf(exitUsage, "Couldn't read --calendar %q: %v\n", *calendarFile, err)
+		}
+	}
+
+	switch verb {
+	case "":
+	case "annotate":
+		cookie, err := strconv.ParseUint(flag.Arg(1), 10, 32)
+		if flag.NArg() < 2 || err != nil {
+			fatalf(exitUsage, "Usage: inhibitor annotate PEER COOKIE [NOTE...]\n")
+		}
+		if err := annotate(*systemBus, flag.Arg(0), uint32(cookie), strings.Join(flag.Args()[2:], " ")); err != nil {
+			fatalf(exitCode(err), "Annotate failed: %v\n", err)
+		}
+		return
+	case "bench":
+		if *benchClients < 1 || *benchLocks < 1 {
+			fatalf(exitUsage, "Usage: inhibitor bench [--clients=N] [--locks=N], with N at least 1\n")
+		}
+		if err := bench(*systemBus, *benchClients, *benchLocks); err != nil {
+			fatalf(exitCode(err), "Bench failed: %v\n", err)
+		}
+		return
+	case "capabilities":
+		if err := capabilities(*systemBus); err != nil {
+			fatalf(exitCode(err), "Capabilities failed: %v\n", err)
+		}
+		return
+	case "check":
+		_, cr := checkConfig(*configFile)
+		os.Exit(reportChecks(append([]checkResult{cr}, selfCheck(*systemBus, *logindBus, false, false)...), true))
+	case "config":
+		args := flag.Args()
+		if *configFile == "" || len(args) == 0 || args[0] == "export" && len(args) > 2 || args[0] == "import" && len(args) != 2 {
+			fatalf(exitUsage, "Usage: inhibitor config --config=FILE export [OUT] | import IN\n")
+		}
+		switch args[0] {
+		case "export":
+			out := ""
+			if len(args) == 2 {
+				out = args[1]
+			}
+			if err := exportConfig(*configFile, out); err != nil {
+				fatalf(exitConfig, "Export failed: %v\n", err)
+			}
+		case "import":
+			if err := importConfig(args[1], *configFile); err != nil {
+				fatalf(exitConfig, "Import failed: %v\n", err)
+			}
+			reallyLog("Imported %q into %q; SIGHUP a running daemon to apply it.\n", args[1], *configFile)
+		default:
+			fatalf(exitUsage, "Unknown config command %q: want \"export\" or \"import\"\n", args[0])
+		}
+		return
+	case "dim-helper":
+		dimHelper()
+		return
+	case "exec":
+		if flag.NArg() == 0 {
+			fatalf(exitUsage, "Usage: inhibitor exec [--what=CLASSES] [--who=WHO] [--why=WHY] [--mode=block|delay] -- COMMAND...\n")
+		}
+		if *mode != "block" && *mode != "delay" {
+			fatalf(exitUsage, "Invalid --mode %q: want \"block\" or \"delay\"\n", *mode)
+		}
+		code, err := execInhibited(*systemBus, *what, *who, *why, *mode, flag.Args())
+		if err != nil {
+			fatalf(code, "Exec failed: %v\n", err)
+		}
+		os.Exit(code)
+	case "health":
+		os.Exit(reportChecks(healthCheck(*systemBus), true))
+	case "hook-helper":
+		hookHelper(*hook)
+		return
+	case "monitor":
+		if err := monitor(*systemBus); err != nil {
+			fatalf(exitCode(err), "Monitor failed: %v\n", err)
+		}
+		return
+	case "name-stats":
+		if err := nameStats(*systemBus); err != nil {
+			fatalf(exitCode(err), "Name-stats failed: %v\n", err)
+		}
+		return
+	case "profile":
+		args := flag.Args()
+		switch {
+		case len(args) == 0:
+		case len(args) == 2 && (args[0] == "on" || args[0] == "off" || args[0] == "toggle"):
+		case len(args) == 3 && args[0] == "on":
+		default:
+			fatalf(exitUsage, "Usage: inhibitor profile [on NAME [DURATION] | off NAME | toggle NAME]\n")
+		}
+		if err := profileCommand(*systemBus, args); err != nil {
+			fatalf(exitCode(err), "Profile failed: %v\n", err)
+		}
+		return
+	case "report":
+		if *historyPath == "" {
+			fatalf(exitUsage, "Usage: inhibitor report --history=STORE [--since=7d] [--format=text|csv|json]\n")
+		}
+		if *format != "text" && *format != "csv" && *format != "json" {
+			fatalf(exitUsage, "Invalid --format %q: want \"text\", \"csv\" or \"json\"\n", *format)
+		}
+		from, err := parseSince(*since, time.Now())
+		if err != nil {
+			fatalf(exitUsage, "%v\n", err)
+		}
+		if _, _, err := parseStore(*historyPath); err != nil {
+			fatalf(exitUsage, "%v\n", err)
+		}
+		st, err := readStore(*historyPath)
+		if err != nil {
+			fatalf(exitFailure, "Report failed: %v\n", err)
+		}
+		defer st.Close()
+		if err := report(st, from, *format); err != nil {
+			fatalf(exitFailure, "Report failed: %v\n", err)
+		}
+		return
+	case "schedule":
+		args := flag.Args()
+		if len(args) != 0 && (len(args) != 2 || args[0] != "cancel" || *scheduleAt != "") {
+			fatalf(exitUsage, "Usage: inhibitor schedule [--at=TIME [--for=DURATION] [--what=CLASSES] [--who=WHO] [--why=WHY] [--mode=block|delay] | cancel ID]\n")
+		}
+		var at time.Time
+		if *scheduleAt != "" {
+			if at, err = parseAt(*scheduleAt, time.Now()); err != nil {
+				fatalf(exitUsage, "Invalid --at %q: %v\n", *scheduleAt, err)
+			}
+			if *scheduleFor < time.Second {
+				fatalf(exitUsage, "--for must be at least a second, got %s\n", *scheduleFor)
+			}
+		}
+		if err := scheduleCommand(*systemBus, args, at, *scheduleFor, *who, *why, *what, *mode); err != nil {
+			fatalf(exitCode(err), "Schedule failed: %v\n", err)
+		}
+		return
+	case "shutdown":
+		if flag.NArg() == 0 {
+			fatalf(exitUsage, "Usage: inhibitor shutdown [--shutdown_ttl=DURATION] REASON...\n")
+		}
+		if err := inhibitShutdown(*systemBus, strings.Join(flag.Args(), " "), *shutdownTTL); err != nil {
+			fatalf(exitCode(err), "Shutdown inhibit failed: %v\n", err)
+		}
+		return
+	case "status":
+		if *allUsers && !*systemBus {
+			fatalf(exitUsage, "--all_users needs --system: a session daemon only serves its own user\n")
+		}
+		lbus := ""
+		if *logindInhibitors {
+			lbus = *logindBus
+		}
+		if err := status(*systemBus, *allUsers, *expand, lbus); err != nil {
+			fatalf(exitCode(err), "Status failed: %v\n", err)
+		}
+		return
+	case "top-apps":
+		if *topWindow <= 0 || *topWindow > bridge.TopRetention {
+			fatalf(exitUsage, "Invalid --top_window %s: want more than 0s and at most %s\n", *topWindow, bridge.TopRetention)
+		}
+		if err := topApps(*systemBus, *top, *topWindow); err != nil {
+			fatalf(exitCode(err), "Top-apps failed: %v\n", err)
+		}
+		return
+	case xdgWho:
+		if flag.NArg() != 2 || (flag.Arg(0) != "suspend" && flag.Arg(0) != "resume") {
+			fatalf(exitUsage, "Usage: xdg-screensaver suspend|resume WINDOW\n")
+		}
+		if err := xdgScreensaver(flag.Arg(0), flag.Arg(1)); err != nil {
+			fatalf(exitFailure, "xdg-screensaver: %v\n", err)
+		}
+		return
+	case "xdg-screensaver-hold":
+		if flag.NArg() != 1 {
+			fatalf(exitUsage, "Usage: inhibitor xdg-screensaver-hold WINDOW\n")
+		}
+		holdWindow(flag.Arg(0))
+		return
+	case "upgrade":
+		if err := requestUpgrade(*systemBus); err != nil {
+			fatalf(exitCode(err), "Upgrade failed: %v\n", err)
+		}
+		return
+	case "window-helper":
+		windowHelper()
+		return
+	default:
+		fatalf(exitUsage, "Unknown command %q\n", verb)
+	}
 
-	if *logfile != "" {
+	var ready *os.File
+	// A hot upgrade replaces the daemon in place; it is detached already.
+	if *detach && !*foreground && os.Getenv(handoffEnv) == "" {
+		ready = daemonize()
+	}
+
+	handoff, err := readHandoff()
+	if err != nil {
+		fatalf(exitFailure, "Couldn't take over from the previous instance: %v\n", err)
+	}
+
+	if *takeover && handoff == nil {
+		h, err := takeOver(*systemBus)
+		if err != nil {
+			fatalf(exitFailure, "Couldn't take over from the running instance: %v\n", err)
+		}
+		if h != nil {
+			handoff = &handoffState{Bridge: h, LogFD: -1}
+		}
+	}
+
+	var be backend.Backend
+	if *logindBus == "session" {
+		be = backend.NewLogindWith(func() (*dbus.Conn, error) { return dbus.ConnectSessionBus() })
+	}
+
+	logFD := -1
+	if handoff != nil && handoff.LogFD >= 0 {
+		// Carry on in the log the previous instance was writing, rather than truncating it.
+		log.SetOutput(os.NewFile(uintptr(handoff.LogFD), *logfile))
+		logFD = handoff.LogFD
+	} else if *logfile != "" {
 		lf, err := os.OpenFile(*logfile, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
 		if err != nil {
-			log.Fatalf("Couldn't open logfile %q: %v\n", *logfile, err)
+			fatalf(exitFailure, "Couldn't open logfile %q: %v\n", *logfile, err)
 		}
 		log.SetOutput(lf)
+		logFD = int(lf.Fd())
+	}
+
+	if *summaryFile != "" {
+		if err := prepareSummaryFile(*summaryFile); err != nil {
+			fatalf(exitUsage, "Couldn't create summary file %q: %v\n", *summaryFile, err)
+		}
+	}
+
+	if *events != "" {
+		if eventLog, err = openEventLog(*eventsFile); err != nil {
+			fatalf(exitUsage, "Couldn't open --events_file %q: %v\n", *eventsFile, err)
+		}
+	}
+
+	var lockStore store.Store
+	if *storeSpec != "" {
+		if lockStore, err = openStore("store", *storeSpec); err != nil {
+			fatalf(exitUsage, "Couldn't open --store %q: %v\n", *storeSpec, err)
+		}
+	}
+
+	if *historyPath != "" {
+		st, err := openStore("history", *historyPath)
+		if err != nil {
+			fatalf(exitUsage, "Couldn't open --history %q: %v\n", *historyPath, err)
+		}
+		if lockHistory, err = loadHistory(st); err != nil {
+			fatalf(exitUsage, "Couldn't open --history %q: %v\n", *historyPath, err)
+		}
+	}
+
+	if *state {
+		if stateFile, err = openStateFile(); err != nil {
+			fatalf(exitFailure, "Can't keep --state: %v\n", err)
+		}
+	}
+
+	cfg, cr := checkConfig(*configFile)
+	if code := reportChecks(append([]checkResult{cr}, selfCheck(*systemBus, *logindBus, handoff != nil || *replace || *queue || *proxy, true)...), false); code != 0 {
+		os.Exit(code)
+	}
+
+	lidSwitchHonoured = readLidSwitchIgnoreInhibited()
+	warnLidSwitch(whatClasses, cfg.Rules)
+	if err := openMetrics(cfg.Metrics); err != nil {
+		fatalf(exitConfig, "Can't send metrics to %q: %v\n", cfg.Metrics, err)
 	}
 
 	prog, err := os.Executable()
 	if err != nil {
-		maybeLog("Error determining program executable: %v\n", err)
-		os.Exit(1)
+		fatalf(exitFailure, "Error determining program executable: %v\n", err)
 	}
 	base := filepath.Base(prog)
-	ib, err := NewInhibitor(base)
+	opts := bridge.Options{
+		Prog:             base,
+		System:           *systemBus,
+		Heartbeat:        *heartbeat,
+		HeartbeatReport:  *heartbeatReport,
+		Watchdog:         *watchdog,
+		Usage:            *usageCheck,
+		InhibitRetries:   *inhibitRetries,
+		BreakerThreshold: *breakerThreshold,
+		MaxLocksPerPeer:  *maxLocksPerPeer,
+		RequestQueue:     *requestQueue,
+		RequestTimeout:   *requestTimeout,
+		Provisional:      *provisional,
+		SoftFail:         *softFail,
+		SignalBatch:      *signalBatch,
+		MaxBlock:         *maxBlock,
+		SuspendVeto:      *suspendVeto,
+		JIT:              *jit,
+		IdleHint:         *idleHint,
+		RemoteSleep:      *remoteSleep,
+		Replace:          *replace,
+		AllowReplacement: *allowReplacement,
+		Queue:            *queue,
+		Compat:           cfg.compatToggles(*compat),
+		DedupePortal:     *dedupePortal,
+		LogRateLimit:     *logRateLimit,
+		ExtraPaths:       cfg.objectPaths(),
+		Policy:           &policy.Policy{Strict: *strict, OwnerChange: ownerChange, What: whatClasses, Rules: cfg.Rules, Rewrites: cfg.Rewrites, DefaultReasons: cfg.DefaultReasons, Profiles: cfg.Profiles, Quirks: cfg.Quirks, Faults: cfg.faults(*developer)},
+		Backend:          be,
+		Logger:           logger{},
+		Store:            lockStore,
+		MetricsSink:      metricsSink,
+	}
+	if eventLog != nil || lockHistory != nil {
+		opts.EventSink = sinkEvent
+	}
+	var windows *windowWatcher
+	if *windowLocks {
+		if windows, err = startWindowWatcher(prog); err != nil {
+			fatalf(exitFailure, "Can't serve window locks: %v\n", err)
+		}
+		opts.Windows = windows
+	}
+	var ib *inhibitor
+	if *hotUpgrade {
+		opts.Upgrade = func() error { return ib.upgrade() }
+	}
+	if *allowTakeover {
+		opts.HandedOff = func() {
+			// The bridge stays frozen, so no event comes after these.
+			if lockHistory != nil {
+				lockHistory.stop()
+				lockHistory.settle()
+			}
+			closeStores()
+			closeMetrics()
+			reallyLog("Handed every lock over to a new instance. Exiting.\n")
+			os.Exit(0)
+		}
+	}
+	if handoff != nil {
+		opts.Handoff = handoff.Bridge
+	}
+	ib, err = NewInhibitor(context.Background(), base, opts)
 	if err != nil {
-		maybeLog("Setup failure: %v\n", err)
-		os.Exit(1)
+		if errors.Is(err, bridge.ErrNameTaken) {
+			if *proxy {
+				maybeLog("%s is owned by another process; proxying to it.\n", bridge.ServiceName)
+				runProxy(base, *systemBus, cfg.Compat)
+			}
+			fatalf(exitNameTaken, "Setup failure: %v (%s)\n", err, nameConflict(*systemBus))
+		}
+		fatalf(exitFailure, "Setup failure: %v\n", err)
+	}
+	ib.exe, ib.logFD, ib.windows = prog, logFD, windows
+	if err := ib.templates.set(cfg.Templates); err != nil {
+		fatalf(exitConfig, "Can't use --config %q: %v\n", *configFile, err)
+	}
+	if *pidPath != "" {
+		// Taking over, the predecessor still holds it until it has handed its locks over and exited.
+		if ib.pidfile, err = lockPidfile(*pidPath, opts.Handoff != nil); err != nil {
+			fatalf(exitFailure, "Can't use --pidfile %q: %v\n", *pidPath, err)
+		}
+	}
+	if *suppressDimming {
+		if ib.dim, err = startDimmer(prog); err != nil {
+			fatalf(exitFailure, "Can't suppress dimming: %v\n", err)
+		}
+	}
+	if *hook != "" {
+		if ib.hooks, err = startHooks(prog, *hook); err != nil {
+			fatalf(exitFailure, "Can't run --hook: %v\n", err)
+		}
+	}
+	var fifoFile *os.File
+	if *fifo {
+		if fifoFile, err = openFIFO(); err != nil {
+			fatalf(exitFailure, "Can't serve --fifo: %v\n", err)
+		}
+	}
+	var upower *dbus.Conn
+	if *criticalBattery {
+		// UPower is on the system bus even for a session daemon. Machines without it, or a battery, just go unwatched.
+		if upower, err = dbus.ConnectSystemBus(); err != nil {
+			maybeLog("Not watching the battery: system bus connect failed: %v\n", err)
+		}
+	}
+	if opts.Handoff != nil {
+		maybeLog("Took over %d locks from the previous instance.\n", len(opts.Handoff.Locks))
 	}
 	log.SetPrefix(base + ": ")
+
+	// Everything the daemon needs from the filesystem and the buses is set up by now, so lock it down before
+	// handling requests from untrusted peers.
+	if *sandbox {
+		p := defaultSandboxPolicy()
+		if *hotUpgrade {
+			p.allowReexec(prog)
+		}
+		if *configFile != "" {
+			// The whole directory, since editors usually replace the file rather than rewrite it.
+			p.readPaths = append(p.readPaths, filepath.Dir(*configFile))
+		}
+		if *calendarFile != "" {
+			// Like the config file, calendars are usually replaced rather than rewritten.
+			p.readPaths = append(p.readPaths, filepath.Dir(*calendarFile))
+		}
+		if *summaryFile != "" {
+			p.writePaths = append(p.writePaths, *summaryFile)
+		}
+		for spec := range openStores {
+			p.writePaths = append(p.writePaths, store.WritePaths(spec)...)
+		}
+		if stateFile != nil {
+			p.writePaths = append(p.writePaths, stateFile.dir())
+		}
+		if err := applySandbox(p); err != nil {
+			fatalf(exitSandbox, "Sandbox failure: %v\n", err)
+		}
+	}
 	maybeLog("Running.\n")
+	if ready != nil {
+		daemonReady(ready)
+	}
+	if *calendarFile != "" {
+		go ib.watchCalendar(*calendarFile, *calendarKeyword)
+	}
+	if fifoFile != nil {
+		go ib.serveFIFO(fifoFile)
+	}
+	if upower != nil {
+		go ib.watchBattery(upower)
+	}
 
-	ib.quitCh = make(chan os.Signal, 1)
 	signal.Notify(ib.quitCh, syscall.SIGINT, syscall.SIGTERM)
 
 	sigToggle := make(chan os.Signal, 1)
 	signal.Notify(sigToggle, syscall.SIGUSR1)
 
+	sigReload := make(chan os.Signal, 1)
+	signal.Notify(sigReload, syscall.SIGHUP)
+
+	sigCaffeine := make(chan os.Signal, 1)
+	if caffeineSig != 0 {
+		signal.Notify(sigCaffeine, caffeineSig)
+	}
+
+	sigProfile := make(chan os.Signal, 1)
+	for sig := range profileSigs {
+		signal.Notify(sigProfile, sig)
+	}
+
 	for {
 		select {
 		case s := <-ib.quitCh:
@@ -112,12 +706,22 @@ func main() {
 		case <-sigToggle:
 			maybeLog("Received SIGUSR1. Toggling inhibit.\n")
 			ib.manualInhibitToggle()
+		case <-sigReload:
+			maybeLog("Received SIGHUP. Reloading config.\n")
+			ib.reloadConfig(*configFile)
+		case s := <-sigCaffeine:
+			maybeLog("Received signal %q. Toggling caffeine.\n", s)
+			ib.caffeineToggle()
+		case s := <-sigProfile:
+			name := profileSigs[s.(syscall.Signal)]
+			maybeLog("Received signal %q. Toggling profile %q.\n", s, name)
+			ib.profileToggle(name)
 		}
 	}
 }
 
 func maybeLog(fmt string, args ...interface{}) {
-	if *verbose {
+	if *verbose || *debug {
 		reallyLog(fmt, args...)
 	}
 }
@@ -126,77 +730,148 @@ func reallyLog(fmt string, args ...interface{}) {
 	log.Printf(fmt, args...)
 }
 
-// String returns a useful textual representation of a lock.
-func (ld *lockDetails) String() string {
-	return fmt.Sprintf("%q / %q (%q, %d)", ld.who, ld.why, ld.peer, ld.cookie)
+// fatalf logs regardless of --verbose and exits with code.
+func fatalf(code int, fmt string, args ...interface{}) {
+	reallyLog(fmt, args...)
+	os.Exit(code)
 }
 
-func NewInhibitor(prog string) (*inhibitor, error) {
-	conn, err := dbus.ConnectSessionBus()
-	if err != nil {
-		return nil, fmt.Errorf("session bus connect failed: %v", err)
-	}
+// logger routes the bridge's log output through maybeLog and reallyLog.
+type logger struct{}
 
-	r, err := conn.RequestName(screensaver, dbus.NameFlagDoNotQueue)
-	if err != nil {
-		return nil, fmt.Errorf("conn.RequestName(%q, 0): %v", screensaver, err)
-	}
-	if r != dbus.RequestNameReplyPrimaryOwner {
-		return nil, fmt.Errorf("conn.RequestName(%q, 0): not the primary owner", screensaver)
+func (logger) Debugf(format string, args ...interface{}) { maybeLog(format, args...) }
+func (logger) Printf(format string, args ...interface{}) { reallyLog(format, args...) }
+func (logger) Tracef(format string, args ...interface{}) {
+	if *debug {
+		reallyLog(format, args...)
 	}
+}
 
-	login, err := login1.New()
-	if err != nil {
-		return nil, fmt.Errorf("login1.New() failed: %v", err)
+func NewInhibitor(ctx context.Context, prog string, opts bridge.Options) (*inhibitor, error) {
+	// In session mode we share the bridge's connection for notifications, so open it here rather than letting the
+	// bridge do it.
+	var conn *dbus.Conn
+	if !opts.System && opts.Bus == nil {
+		c, err := dbus.ConnectSessionBus()
+		if err != nil {
+			return nil, fmt.Errorf("session bus connect failed: %v", err)
+		}
+		conn, opts.Bus = c, c
 	}
 
 	ib := &inhibitor{
 		prog:            prog,
-		dbusConn:        conn,
-		loginConn:       login,
-		locks:           make(map[uint]*lockDetails),
+		system:          opts.System,
+		conn:            conn,
+		pool:            newWorkerPool(),
 		trayCh:          make(chan struct{}),
-		doneCh:          make(chan struct{}),
 		manualTimeoutCh: make(chan struct{}),
+		quitCh:          make(chan os.Signal, 1),
 	}
-
-	for _, p := range []dbus.ObjectPath{screensaverPath, legacyPath} {
-		if err = ib.dbusConn.Export(ib, p, screensaver); err != nil {
-			return nil, fmt.Errorf("couldn't export %q on %q: %v", screensaver, p, err)
+	// A name moving from under a lock is worth telling the user about even when Events drops it, so it comes through
+	// the sink, which may run before NewBridge returns.
+	sink := opts.EventSink
+	opts.EventSink = func(ev bridge.Event) {
+		if sink != nil {
+			sink(ev)
 		}
-		if err = ib.dbusConn.Export(introspect.Introspectable(ssXML), p, intro); err != nil {
-			return nil, fmt.Errorf("couldn't export %q on %q: %v", intro, p, err)
+		if ev.Type == bridge.OwnerChanged {
+			ib.notifyInhibitChange(ev.Message, nil)
 		}
 	}
 
-	systray.SetTitle(prog)
-	systray.SetTemplateIcon(iconUninhibited, iconUninhibited)
+	b, err := bridge.NewBridge(ctx, opts)
+	if err != nil {
+		ib.pool.stop()
+		return nil, err
+	}
+	ib.bridge = b
+	ib.adoptCaffeine()
+
+	// A system-wide bridge has no session to show a tray icon in.
+	if !opts.System {
+		systray.SetTitle(prog)
+		systray.SetTemplateIcon(iconUninhibited, iconUninhibited)
 
-	ib.setStatus()
+		ib.setStatus()
 
-	// We don't need any cleanup when this gets shut down, so ignore the end func().
-	sysStart, _ := systray.RunWithExternalLoop(ib.systrayStart, func() {})
+		// We don't need any cleanup when this gets shut down, so ignore the end func().
+		sysStart, _ := systray.RunWithExternalLoop(ib.systrayStart, func() {})
 
-	go sysStart()
-	go ib.heartbeatCheck()
+		go sysStart()
+	}
+	go ib.watchEvents()
 
 	return ib, nil
 }
 
+// sinkEvent writes ev to --events and --history. It is the bridge's Options.EventSink, which misses no event, unlike
+// Events.
+func sinkEvent(ev bridge.Event) {
+	if eventLog != nil {
+		eventLog.write(ev)
+	}
+	if lockHistory != nil {
+		lockHistory.record(ev)
+	}
+}
+
+// watchEvents keeps the tray in sync with the bridge's lock set and passes security-relevant events on to the user.
+func (i *inhibitor) watchEvents() {
+	for ev := range i.bridge.Events() {
+		maybeLog("Event: %s\n", ev)
+		switch ev.Type {
+		case bridge.LockAdded, bridge.LockAcquired:
+			// Whatever was taken while the battery is critical is let go at once.
+			i.releaseForBattery()
+		case bridge.SuspendVetoed:
+			i.notifyInhibitChange(tr("The lid was closed, but the system didn't suspend: %s.", ev.Message), nil)
+		case bridge.NameLost:
+			// Yield to whoever replaced us, typically a desktop environment's own screensaver. With --queue the bridge
+			// is back in line instead, and carries on once the name is free again.
+			if !*queue {
+				reallyLog("No longer serving %s (%s). Exiting.\n", bridge.ServiceName, ev.Message)
+				i.quitCh <- syscall.SIGTERM
+			}
+		}
+		i.mtx.Lock()
+		i.setStatus()
+		i.mtx.Unlock()
+		if i.dim == nil && i.hooks == nil {
+			continue
+		}
+		held := len(i.bridge.Locks())
+		if i.dim != nil {
+			i.dim.update(held > 0)
+		}
+		if i.hooks != nil {
+			i.hooks.update(ev, held)
+		}
+	}
+}
+
 func (i *inhibitor) setStatus() {
-	if i.localCookie > 0 {
+	if i.system {
+		return
+	}
+
+	held := i.bridge.Locks()
+	locks := len(held)
+	if i.localCookie > 0 || i.caffeineCookie > 0 {
 		systray.SetIcon(iconManuallyInhibited)
-	} else if len(i.locks) > 0 {
+	} else if locks > 0 {
 		systray.SetIcon(iconAutoInhibited)
 	} else {
 		systray.SetIcon(iconUninhibited)
 	}
 
-	systray.SetTitle(fmt.Sprintf("%s: %d inhibits (manual: %t)", i.prog, len(i.locks), i.localCookie > 0))
-}
-
-func (i *inhibitor) dbusName() dbus.Sender {
-	return dbus.Sender(i.dbusConn.Names()[0])
+	systray.SetTitle(tr("%s: %d inhibits (manual: %t, caffeine: %t)", i.prog, locks, i.localCookie > 0, i.caffeineCookie > 0))
+	if i.lockMenu != nil {
+		i.lockMenu.update(held, time.Now())
+	}
+	if stateFile != nil {
+		stateFile.write(stateSnapshot{Running: true, Manual: i.localCookie > 0, Caffeine: i.caffeineCookie > 0, Locks: held})
+	}
 }
 
 func (i *inhibitor) manualInhibitToggle() {
@@ -218,7 +893,7 @@ func (i *inhibitor) manualInhibitTimeout(d time.Duration, cancelCh <-chan struct
 
 func (i *inhibitor) manualUninhibit() {
 	if i.localCookie != 0 {
-		if err := i.UnInhibit(i.dbusName(), uint32(i.localCookie)); err != nil {
+		if err := i.bridge.UnInhibit(i.bridge.Name(), i.localCookie); err != nil {
 			maybeLog("Error manually unihibiting after timeout: %v\n", err)
 			return
 		}
@@ -228,11 +903,27 @@ func (i *inhibitor) manualUninhibit() {
 	}
 }
 func (i *inhibitor) systrayStart() {
-	var notificationID uint32
+	var notificationID atomic.Uint32
 	cancelCh := make(chan struct{})
 
-	i.manualInhibit = systray.AddMenuItemCheckbox("Manually inhibit screen lock", "", false)
-	i.quitInhibitor = systray.AddMenuItem("Quit", "")
+	i.manualInhibit = systray.AddMenuItemCheckbox(tr("Manually inhibit screen lock"), "", false)
+	i.mtx.Lock()
+	i.caffeineItem = systray.AddMenuItemCheckbox(tr("Caffeine"), tr("Inhibit until toggled off"), i.caffeineCookie != 0)
+	i.lockMenu = newLockMenu()
+	i.setStatus()
+	i.mtx.Unlock()
+	i.quitInhibitor = systray.AddMenuItem(tr("Quit"), "")
+
+	// After a hot upgrade, a manual inhibit is among the locks handed over.
+	for _, l := range i.bridge.Locks() {
+		if dbus.Sender(l.Peer) == i.bridge.Name() && l.Who == manualWho {
+			i.localCookie = l.Cookie
+			i.manualInhibit.Check()
+			if *manualTimeout > 0 {
+				go i.manualInhibitTimeout(*manualTimeout, cancelCh)
+			}
+		}
+	}
 
 	for {
 		select {
@@ -243,19 +934,19 @@ func (i *inhibitor) systrayStart() {
 			return
 		case <-i.manualTimeoutCh:
 			i.manualUninhibit()
-			i.notifyInhibitChange("Released manual inhibit after timeout.", 0)
+			i.notifyInhibitChange(tr("Released manual inhibit after timeout."), nil)
 		case <-i.manualInhibit.ClickedCh:
 			if i.manualInhibit.Checked() {
 				i.manualUninhibit()
 
-				notificationID = i.notifyInhibitChange("Manual screen lock inhibit cleared", notificationID)
+				i.notifyInhibitChange(tr("Manual screen lock inhibit cleared"), &notificationID)
 				if *manualTimeout > 0 {
 					// Cancel the timeout on manual the inhibit
 					cancelCh <- struct{}{}
 				}
 
 			} else {
-				cookie, err := i.Inhibit(i.dbusName(), "systray", "clicked")
+				cookie, err := i.bridge.Inhibit(i.bridge.Name(), manualWho, "clicked")
 				if err != nil {
 					maybeLog("Error manually inhibiting: %v\n", err)
 					continue
@@ -264,15 +955,17 @@ func (i *inhibitor) systrayStart() {
 				i.localCookie = cookie
 				i.manualInhibit.Check()
 
-				m := "Manual screen lock inhibit placed."
+				m := tr("Manual screen lock inhibit placed.")
 				if *manualTimeout > 0 {
-					m += fmt.Sprintf(" It will expire in %s", *manualTimeout)
+					m += tr(" It will expire in %s", *manualTimeout)
 				}
-				notificationID = i.notifyInhibitChange(m, notificationID)
+				i.notifyInhibitChange(m, &notificationID)
 				if *manualTimeout > 0 {
 					go i.manualInhibitTimeout(*manualTimeout, cancelCh)
 				}
 			}
+		case <-i.caffeineItem.ClickedCh:
+			i.caffeineToggle()
 		case <-i.quitInhibitor.ClickedCh:
 			i.quitCh <- syscall.SIGINT
 		}
@@ -282,133 +975,67 @@ func (i *inhibitor) systrayStart() {
 	}
 }
 
-func (i *inhibitor) notifyInhibitChange(message string, replaces uint32) uint32 {
-	if !*sendNotifications {
-		return 0
-	}
-
-	n := notify.Notification{
-		AppName:       i.prog,
-		ReplacesID:    replaces,
-		Summary:       i.prog,
-		Body:          message,
-		ExpireTimeout: 5 * time.Second,
+// notifyInhibitChange tells the user about a change in the background. With replaces, the notification replaces the
+// one last sent with it rather than stacking up.
+func (i *inhibitor) notifyInhibitChange(message string, replaces *atomic.Uint32) {
+	// Notifications are a session service; a system-wide bridge has nowhere to send them.
+	if !*sendNotifications || i.system {
+		return
 	}
 
-	id, err := notify.SendNotification(i.dbusConn, n)
-	if err != nil {
-		maybeLog("Error sending notification: %v\n", err)
-	}
-
-	return id
-}
-
-func (i *inhibitor) heartbeatCheck() {
-	ticker := time.NewTicker(*heartbeat)
-
-	maybeLog("Heartbeat checker started.\n")
-
-	for {
-		select {
-		case <-ticker.C:
-			maybeLog("Heartbeck checker running.\n")
-			// Not every peer implements the org.freedesktop.DBus.Peer interface, so we'll simply lookup every active peer on the bus.
-			// Using that, we can determine if a peer that requested the inhibit is still alive.
-			var activeNames []dbus.Sender
-			if err := i.dbusConn.BusObject().Call(listNames, 0).Store(&activeNames); err != nil {
-				maybeLog("Error calling %q: %v\n", listNames, err)
-				continue
-			}
-
-			nameMap := make(map[dbus.Sender]struct{})
-			for _, n := range activeNames {
-				nameMap[n] = struct{}{}
-			}
-
-			i.mtx.Lock()
-			for _, ld := range i.locks {
-				maybeLog("Heartbeat checking: %s\n", ld)
-				if _, ok := nameMap[ld.peer]; !ok {
-					maybeLog("Missing peer %q; Dropping: %s\n", ld.peer, ld)
-					ld.fd.Close()
-					delete(i.locks, ld.cookie)
-				}
-			}
-			i.setStatus()
-			i.mtx.Unlock()
-		case <-i.doneCh:
-			maybeLog("Heartbeat checker stopping.\n")
-			close(i.doneCh)
-			return
+	i.pool.submit("notification", notifyTimeout, func(ctx context.Context) error {
+		var prev uint32
+		if replaces != nil {
+			prev = replaces.Load()
 		}
-	}
+		var id uint32
+		err := i.conn.Object(notifyName, notifyPath).CallWithContext(ctx, notifyCall, 0,
+			i.prog, prev, "", i.prog, message, []string{}, map[string]dbus.Variant{}, int32(notifyExpire.Milliseconds())).Store(&id)
+		if err != nil {
+			return fmt.Errorf("sending notification: %w", err)
+		}
+		if replaces != nil {
+			replaces.Store(id)
+		}
+		return nil
+	})
 }
 
 func (i *inhibitor) shutdown() {
-	// Stop programatic inhibits
-	i.dbusConn.Close()
 	// Stop manual inhibits
-	i.trayCh <- struct{}{}
-	<-i.trayCh
-	// With all inhibit sources stopped, we can shut down the heartbeat.
-	i.doneCh <- struct{}{}
-	<-i.doneCh
-	// Close any open files to release all inhibits.
-	i.mtx.Lock()
-	for _, ld := range i.locks {
-		if err := ld.fd.Close(); err != nil {
-			maybeLog("Error closing lock for %q: %v\n", ld, err)
-		}
+	if !i.system {
+		i.trayCh <- struct{}{}
+		<-i.trayCh
 	}
-	i.mtx.Unlock()
-	i.loginConn.Close()
-}
-
-func (i *inhibitor) Inhibit(from dbus.Sender, who, why string) (uint, *dbus.Error) {
-	fd, err := i.loginConn.Inhibit("idle", i.prog, who+" "+why, "block")
-	if err != nil {
-		return 0, dbus.MakeFailedError(err)
+	reportSummary(i.bridge.Summary(), *summaryFile)
+	if i.dim != nil {
+		i.dim.stop()
 	}
-
-	ld := &lockDetails{
-		cookie: uint(rand.Uint32()),
-		peer:   from,
-		who:    who,
-		why:    why,
-		fd:     fd,
+	// Stop programatic inhibits and release everything still held.
+	if err := i.bridge.Close(); err != nil {
+		maybeLog("Error shutting down bridge: %v\n", err)
 	}
-
-	i.mtx.Lock()
-	defer i.mtx.Unlock()
-	i.locks[ld.cookie] = ld
-
-	maybeLog("Inhibit: %s\n", ld)
-	i.setStatus()
-
-	return ld.cookie, nil
-}
-
-func (i *inhibitor) UnInhibit(from dbus.Sender, cookie uint32) *dbus.Error {
-	i.mtx.Lock()
-	defer i.mtx.Unlock()
-
-	ld, ok := i.locks[uint(cookie)]
-	if !ok {
-		return dbus.MakeFailedError(fmt.Errorf("%d is an invalid cookie", cookie))
+	// Close has passed every event to the sink by now, so this adds the time of the locks it closed and saves last.
+	if lockHistory != nil {
+		lockHistory.stop()
+		lockHistory.settle()
 	}
-
-	if from != ld.peer {
-		return dbus.MakeFailedError(fmt.Errorf("%q is not the originating peer for cookie %d", from, cookie))
+	if i.windows != nil {
+		i.windows.stop()
 	}
-
-	delete(i.locks, ld.cookie)
-
-	if err := ld.fd.Close(); err != nil {
-		return dbus.MakeFailedError(fmt.Errorf("failed to close clock for cookie %d -> %s", cookie, ld.fd.Name()))
+	if i.hooks != nil {
+		// Closing the bridge released whatever was still held.
+		i.hooks.update(bridge.Event{Type: bridge.LockRemoved, Message: "shutting down"}, 0)
+		i.hooks.stop()
 	}
-
-	maybeLog("UnInhibit: %s\n", ld)
-	i.setStatus()
-
-	return nil
+	i.pool.stop()
+	if stateFile != nil {
+		i.mtx.Lock()
+		stateFile.write(stateSnapshot{})
+		i.mtx.Unlock()
+	}
+	// Only now that the bridge has saved its empty lock table and the history is settled.
+	closeStores()
+	closeMetrics()
+	i.pidfile.remove()
 }
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/coltwillcox/inhibitor/pkg/policy"
+)
+
+const whatLidSwitch = "handle-lid-switch"
+
+// logindConfDirs are where logind's drop-ins live. Like logind, later files override earlier ones.
+var logindConfDirs = []string{"/usr/lib/systemd/logind.conf.d", "/usr/local/lib/systemd/logind.conf.d", "/etc/systemd/logind.conf.d", "/run/systemd/logind.conf.d"}
+
+// lidSwitchHonoured is whether logind acts on handle-lid-switch inhibits. Its config can't be read once sandboxed, so
+// it is looked up once at startup.
+var lidSwitchHonoured bool
+
+// readLidSwitchIgnoreInhibited reports whether logind is configured to honour handle-lid-switch inhibits. By default
+// it ignores them (LidSwitchIgnoreInhibited=yes), so that closing the lid always suspends.
+func readLidSwitchIgnoreInhibited() bool {
+	files := []string{"/etc/systemd/logind.conf"}
+	for _, dir := range logindConfDirs {
+		matches, _ := filepath.Glob(filepath.Join(dir, "*.conf"))
+		sort.Strings(matches)
+		files = append(files, matches...)
+	}
+
+	honoured := false
+	for _, f := range files {
+		fh, err := os.Open(f)
+		if err != nil {
+			continue
+		}
+		s := bufio.NewScanner(fh)
+		for s.Scan() {
+			k, v, ok := strings.Cut(strings.TrimSpace(s.Text()), "=")
+			if ok && strings.TrimSpace(k) == "LidSwitchIgnoreInhibited" {
+				v = strings.ToLower(strings.TrimSpace(v))
+				honoured = v == "no" || v == "false" || v == "0" || v == "off"
+			}
+		}
+		fh.Close()
+	}
+	return honoured
+}
+
+// warnLidSwitch warns if locks may ask for handle-lid-switch while logind is set to ignore that.
+func warnLidSwitch(base []string, rules []policy.Rule) {
+	if lidSwitchHonoured {
+		return
+	}
+	uses := false
+	for _, w := range base {
+		uses = uses || w == whatLidSwitch
+	}
+	for _, r := range rules {
+		for _, w := range r.What {
+			uses = uses || w == whatLidSwitch
+		}
+	}
+	if uses {
+		reallyLog("Warning: %s inhibits are configured, but logind ignores them unless LidSwitchIgnoreInhibited=no is set in logind.conf.\n", whatLidSwitch)
+	}
+}
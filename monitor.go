@@ -0,0 +1,153 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+)
+
+const (
+	becomeMonitor = "org.freedesktop.DBus.Monitoring.BecomeMonitor"
+
+	// monitorPending caps how many calls await their reply, since calls that expect none never get one.
+	monitorPending = 1024
+)
+
+// monitorInterfaces are the inhibit APIs whose traffic `inhibitor monitor` logs, with the methods of interest.
+var monitorInterfaces = map[string][]string{
+	"org.freedesktop.ScreenSaver":               {"Inhibit", "UnInhibit"},
+	"org.freedesktop.PowerManagement.Inhibit":   {"Inhibit", "UnInhibit"},
+	"org.gnome.SessionManager":                  {"Inhibit", "Uninhibit"},
+	"org.kde.Solid.PowerManagement.PolicyAgent": {"AddInhibition", "ReleaseInhibition"},
+	"org.mate.SessionManager":                   {"Inhibit", "Uninhibit"},
+}
+
+// monitorCall is an inhibit call waiting for its reply.
+type monitorCall struct {
+	desc string
+	at   time.Time
+}
+
+// monitor logs every inhibit call on the bus, and its outcome, until interrupted. It owns no name, so it works
+// alongside any desktop environment's screensaver, but the bus may only allow root to monitor the system bus.
+func monitor(system bool) error {
+	bus := "session"
+	if system {
+		bus = "system"
+	}
+	conn, err := connectBus(system)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// Replies are matched to calls, so every reply and error is needed too.
+	rules := []string{"type='method_return'", "type='error'"}
+	for iface, members := range monitorInterfaces {
+		for _, m := range members {
+			rules = append(rules, fmt.Sprintf("type='method_call',interface='%s',member='%s'", iface, m))
+		}
+	}
+
+	// Once a monitor, the connection must never send anything, not even the error godbus answers unknown calls
+	// with, so every message is diverted before asking. The BecomeMonitor reply arrives the same way.
+	ch := make(chan *dbus.Message, 256)
+	conn.Eavesdrop(ch)
+	conn.BusObject().Go(becomeMonitor, 0, nil, rules, uint32(0))
+
+	self := conn.Names()[0]
+	for msg := range ch {
+		if dest, _ := msg.Headers[dbus.FieldDestination].Value().(string); dest != self {
+			continue
+		}
+		if msg.Type == dbus.TypeError {
+			return fmt.Errorf("%s: %v", becomeMonitor, msg.Body)
+		}
+		break
+	}
+	reallyLog("Monitoring inhibit traffic on the %s bus.\n", bus)
+
+	quitCh := make(chan os.Signal, 1)
+	signal.Notify(quitCh, syscall.SIGINT, syscall.SIGTERM)
+
+	pending := make(map[string]monitorCall)
+	for {
+		select {
+		case <-quitCh:
+			return nil
+		case msg, ok := <-ch:
+			if !ok {
+				return fmt.Errorf("%s bus connection closed", bus)
+			}
+			logInhibitTraffic(msg, pending)
+		}
+	}
+}
+
+// logInhibitTraffic logs an inhibit call once its reply arrives, or a call that expects none right away.
+func logInhibitTraffic(msg *dbus.Message, pending map[string]monitorCall) {
+	sender, _ := msg.Headers[dbus.FieldSender].Value().(string)
+	dest, _ := msg.Headers[dbus.FieldDestination].Value().(string)
+
+	switch msg.Type {
+	case dbus.TypeMethodCall:
+		iface, _ := msg.Headers[dbus.FieldInterface].Value().(string)
+		member, _ := msg.Headers[dbus.FieldMember].Value().(string)
+		desc := fmt.Sprintf("%s %s.%s(%s) -> %s", sender, iface, member, formatArgs(msg.Body), dest)
+		if msg.Flags&dbus.FlagNoReplyExpected != 0 {
+			reallyLog("%s\n", desc)
+			return
+		}
+		if len(pending) >= monitorPending {
+			for k, c := range pending {
+				if time.Since(c.at) > time.Minute {
+					delete(pending, k)
+				}
+			}
+		}
+		// A burst of recent calls can still be over the cap, so the oldest go too.
+		for len(pending) >= monitorPending {
+			oldest := ""
+			for k, c := range pending {
+				if oldest == "" || c.at.Before(pending[oldest].at) {
+					oldest = k
+				}
+			}
+			delete(pending, oldest)
+		}
+		pending[fmt.Sprintf("%s/%d", sender, msg.Serial())] = monitorCall{desc: desc, at: time.Now()}
+
+	case dbus.TypeMethodReply, dbus.TypeError:
+		serial, _ := msg.Headers[dbus.FieldReplySerial].Value().(uint32)
+		key := fmt.Sprintf("%s/%d", dest, serial)
+		c, ok := pending[key]
+		if !ok {
+			return
+		}
+		delete(pending, key)
+		if msg.Type == dbus.TypeError {
+			name, _ := msg.Headers[dbus.FieldErrorName].Value().(string)
+			reallyLog("%s: error %s: %s\n", c.desc, name, formatArgs(msg.Body))
+			return
+		}
+		reallyLog("%s: %s\n", c.desc, formatArgs(msg.Body))
+	}
+}
+
+// formatArgs formats a message body for logging.
+func formatArgs(body []interface{}) string {
+	args := make([]string, len(body))
+	for i, v := range body {
+		if s, ok := v.(string); ok {
+			args[i] = fmt.Sprintf("%q", s)
+		} else {
+			args[i] = fmt.Sprint(v)
+		}
+	}
+	return strings.Join(args, ", ")
+}
@@ -0,0 +1,422 @@
+// Package backend provides the inhibit backends a bridge forwards its locks to.
+package backend
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+)
+
+const (
+	login1Name     = "org.freedesktop.login1"
+	login1Path     = "/org/freedesktop/login1"
+	login1Inhibit  = "org.freedesktop.login1.Manager.Inhibit"
+	login1Session  = "org.freedesktop.login1.Manager.GetSessionByPID"
+	login1IdleHint = "org.freedesktop.login1.Session.SetIdleHint"
+	login1SessIf   = "org.freedesktop.login1.Session"
+	login1MgrIf    = "org.freedesktop.login1.Manager"
+)
+
+// Session classes returned by SessionClasser.
+const (
+	// SessionLocal is a session on a seat, in front of the machine.
+	SessionLocal = "local"
+	// SessionRemote is a session logged into over the network, such as ssh, xrdp or VNC.
+	SessionRemote = "remote"
+	// SessionHeadless is a session without a seat that isn't known to be remote.
+	SessionHeadless = "headless"
+)
+
+// Reconnect backoff bounds: a lost logind connection is retried after minBackoff, doubling on every failure up to
+// maxBackoff.
+const (
+	minBackoff = time.Second
+	maxBackoff = time.Minute
+)
+
+// lidPoll is how often WatchSleep reads logind's LidClosed property.
+const lidPoll = 2 * time.Second
+
+// remoteServices are PAM services of remote desktop servers, whose sessions logind doesn't always mark as remote.
+var remoteServices = []string{"xrdp", "vnc"}
+
+// Backend takes inhibitor locks. The returned file holds the lock until it is closed.
+type Backend interface {
+	// Inhibit takes a lock of the given what-class(es) and mode ("block" or "delay") on behalf of who/why. It gives up
+	// when ctx is done.
+	Inhibit(ctx context.Context, what, who, why, mode string) (*os.File, error)
+	// Close releases the backend's own resources. Locks already handed out are unaffected.
+	Close()
+}
+
+// IdleHinter is implemented by backends that can also tell the session manager that sessions aren't idle, for
+// idle policies that ignore inhibitors.
+type IdleHinter interface {
+	// ClearIdleHints marks the sessions of the given processes as not idle.
+	ClearIdleHints(ctx context.Context, pids []uint32) error
+}
+
+// SessionClasser is implemented by backends that can tell what kind of session a process runs in.
+type SessionClasser interface {
+	// SessionClass returns SessionLocal, SessionRemote or SessionHeadless for the session of pid, or "" if pid isn't
+	// in a session.
+	SessionClass(ctx context.Context, pid uint32) (string, error)
+}
+
+// IdleSincer is implemented by backends that know when the user was last seen, from the session manager's idle hint.
+type IdleSincer interface {
+	// IdleSince returns when the user's sessions last went idle or became active again, by the wall clock, and
+	// whether they are idle now.
+	IdleSince(ctx context.Context) (since time.Time, idle bool, err error)
+}
+
+// SleepEvent is something a SleepWatcher saw happen to the machine.
+type SleepEvent int
+
+const (
+	// LidClosed is the laptop lid being closed, which logind suspends on unless told otherwise.
+	LidClosed SleepEvent = iota
+	// LidOpened is the lid being opened again.
+	LidOpened
+	// Sleeping is the machine about to suspend or hibernate.
+	Sleeping
+	// Resumed is the machine back from sleep.
+	Resumed
+)
+
+// SleepWatcher is implemented by backends that can tell when the machine is about to sleep, and when it may be asked
+// to: with those, a bridge reports the locks that kept it awake.
+type SleepWatcher interface {
+	// WatchSleep sends what happens on ch until ctx is done or the backend's connection drops.
+	WatchSleep(ctx context.Context, ch chan<- SleepEvent) error
+}
+
+// Prober is implemented by backends that can tell, ahead of any request, which what-classes and modes the user may
+// take locks of.
+type Prober interface {
+	// Permitted reports whether the user may take locks of the what-class what in mode. The error is only set if
+	// that couldn't be found out, e.g. because the backend is unavailable.
+	Permitted(ctx context.Context, what, mode string) (bool, error)
+}
+
+// ErrDenied is returned (wrapped) by Inhibit when the user isn't allowed the lock asked for, as opposed to the backend
+// failing to take it. Custom backends may wrap it as well.
+var ErrDenied = errors.New("not permitted")
+
+// deniedErrors are the D-Bus errors logind refuses inhibits with when polkit doesn't allow them.
+var deniedErrors = map[string]bool{
+	"org.freedesktop.DBus.Error.AccessDenied":                     true,
+	"org.freedesktop.DBus.Error.InteractiveAuthorizationRequired": true,
+}
+
+// PolkitAction returns the polkit action logind checks before taking a lock of the what-class what in mode, such as
+// org.freedesktop.login1.inhibit-block-sleep. The handle-* classes have one action for both modes.
+func PolkitAction(what, mode string) string {
+	if strings.HasPrefix(what, "handle-") {
+		return "org.freedesktop.login1.inhibit-" + what
+	}
+	return "org.freedesktop.login1.inhibit-" + mode + "-" + what
+}
+
+// Logind takes locks from systemd-logind over the system bus. It connects when first used rather than when created,
+// so that a daemon started early in the session doesn't depend on logind being up yet, and connects again whenever
+// the connection drops. Attempts that fail are spaced out with exponential backoff; calls made in between fail
+// right away.
+type Logind struct {
+	connect func() (*dbus.Conn, error) // nil for a fixed connection
+
+	mtx     sync.Mutex
+	conn    *dbus.Conn
+	backoff time.Duration
+	retryAt time.Time
+	lastErr error
+}
+
+// NewLogind returns a Logind on the system bus. It doesn't connect until first used, so the error is always nil.
+func NewLogind() (*Logind, error) {
+	return NewLogindWith(func() (*dbus.Conn, error) { return dbus.ConnectSystemBus() }), nil
+}
+
+// NewLogindWith returns a Logind that connects with connect when first used and whenever the connection drops. This
+// is mostly useful for talking to a mock logind (see cmd/mock-logind) on the session bus.
+func NewLogindWith(connect func() (*dbus.Conn, error)) *Logind {
+	return &Logind{connect: connect}
+}
+
+// NewLogindOn talks to logind over conn, which the returned Logind takes ownership of. It doesn't reconnect.
+func NewLogindOn(conn *dbus.Conn) *Logind {
+	return &Logind{conn: conn}
+}
+
+// connection returns the current connection, connecting first if there is none or it dropped.
+func (l *Logind) connection() (*dbus.Conn, error) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	if l.conn != nil && (l.connect == nil || l.conn.Connected()) {
+		return l.conn, nil
+	}
+	if l.conn != nil {
+		l.conn.Close()
+		l.conn = nil
+	}
+	if now := time.Now(); now.Before(l.retryAt) {
+		return nil, fmt.Errorf("logind unavailable, retrying in %s: %v", l.retryAt.Sub(now).Truncate(time.Second)+time.Second, l.lastErr)
+	}
+
+	conn, err := l.connect()
+	if err != nil {
+		if l.backoff = 2 * l.backoff; l.backoff < minBackoff {
+			l.backoff = minBackoff
+		} else if l.backoff > maxBackoff {
+			l.backoff = maxBackoff
+		}
+		l.retryAt, l.lastErr = time.Now().Add(l.backoff), err
+		return nil, fmt.Errorf("logind connect failed: %v", err)
+	}
+	l.conn, l.backoff = conn, 0
+	return conn, nil
+}
+
+// manager returns logind's Manager object on the current connection.
+func (l *Logind) manager() (dbus.BusObject, error) {
+	conn, err := l.connection()
+	if err != nil {
+		return nil, err
+	}
+	return conn.Object(login1Name, login1Path), nil
+}
+
+// Inhibit implements Backend.
+func (l *Logind) Inhibit(ctx context.Context, what, who, why, mode string) (*os.File, error) {
+	manager, err := l.manager()
+	if err != nil {
+		return nil, err
+	}
+	var fd dbus.UnixFD
+	if err := manager.CallWithContext(ctx, login1Inhibit, 0, what, who, why, mode).Store(&fd); err != nil {
+		if derr, ok := err.(dbus.Error); ok && deniedErrors[derr.Name] {
+			return nil, fmt.Errorf("calling %q: %v (%w)", login1Inhibit, err, ErrDenied)
+		}
+		return nil, fmt.Errorf("calling %q: %v", login1Inhibit, err)
+	}
+
+	return os.NewFile(uintptr(fd), "inhibit"), nil
+}
+
+// Permitted implements Prober. logind has no way to ask, so it takes the lock and lets go of it right away.
+func (l *Logind) Permitted(ctx context.Context, what, mode string) (bool, error) {
+	f, err := l.Inhibit(ctx, what, "inhibitor", "Checking what may be inhibited", mode)
+	switch {
+	case err == nil:
+		f.Close()
+		return true, nil
+	case errors.Is(err, ErrDenied):
+		return false, nil
+	}
+	return false, err
+}
+
+// ClearIdleHints implements IdleHinter. logind only lets a session's own user (or root) change its idle hint.
+func (l *Logind) ClearIdleHints(ctx context.Context, pids []uint32) error {
+	manager, err := l.manager()
+	if err != nil {
+		return err
+	}
+	sessions := make(map[dbus.ObjectPath]bool)
+	for _, pid := range pids {
+		var session dbus.ObjectPath
+		if err := manager.CallWithContext(ctx, login1Session, 0, pid).Store(&session); err != nil {
+			// Not every process is in a session, e.g. ones started by a user service manager.
+			continue
+		}
+		sessions[session] = true
+	}
+
+	for session := range sessions {
+		if err := l.object(session).CallWithContext(ctx, login1IdleHint, 0, false).Err; err != nil {
+			return fmt.Errorf("calling %q on %q: %v", login1IdleHint, session, err)
+		}
+	}
+	return nil
+}
+
+// SessionClass implements SessionClasser.
+func (l *Logind) SessionClass(ctx context.Context, pid uint32) (string, error) {
+	manager, err := l.manager()
+	if err != nil {
+		return "", err
+	}
+	var session dbus.ObjectPath
+	if err := manager.CallWithContext(ctx, login1Session, 0, pid).Store(&session); err != nil {
+		// Not every process is in a session, e.g. ones started by a user service manager.
+		return "", nil
+	}
+
+	obj := l.object(session)
+	var remote bool
+	if err := obj.StoreProperty(login1SessIf+".Remote", &remote); err != nil {
+		return "", fmt.Errorf("reading the Remote property of %q: %v", session, err)
+	}
+	var service string
+	if err := obj.StoreProperty(login1SessIf+".Service", &service); err != nil {
+		return "", fmt.Errorf("reading the Service property of %q: %v", session, err)
+	}
+	if remote {
+		return SessionRemote, nil
+	}
+	for _, s := range remoteServices {
+		if strings.Contains(service, s) {
+			return SessionRemote, nil
+		}
+	}
+
+	var seat struct {
+		ID   string
+		Path dbus.ObjectPath
+	}
+	if err := obj.StoreProperty(login1SessIf+".Seat", &seat); err != nil {
+		return "", fmt.Errorf("reading the Seat property of %q: %v", session, err)
+	}
+	if seat.ID == "" {
+		return SessionHeadless, nil
+	}
+	return SessionLocal, nil
+}
+
+// IdleSince implements IdleSincer with the Manager's IdleHint and IdleSinceHint, which logind sums up over every
+// session: they are idle once all of them are. Sessions whose compositor never sets the hint stay active since they
+// started.
+func (l *Logind) IdleSince(ctx context.Context) (time.Time, bool, error) {
+	manager, err := l.manager()
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	var idle bool
+	if err := manager.StoreProperty(login1MgrIf+".IdleHint", &idle); err != nil {
+		return time.Time{}, false, fmt.Errorf("reading the IdleHint property: %v", err)
+	}
+	var usec uint64
+	if err := manager.StoreProperty(login1MgrIf+".IdleSinceHint", &usec); err != nil {
+		return time.Time{}, false, fmt.Errorf("reading the IdleSinceHint property: %v", err)
+	}
+	return time.UnixMicro(int64(usec)), idle, nil
+}
+
+// WatchSleep implements SleepWatcher with the Manager's PrepareForSleep signal and LidClosed property, which is polled
+// every lidPoll as logind doesn't announce its changes. A Logind that connects again after its connection dropped
+// doesn't watch the new one.
+func (l *Logind) WatchSleep(ctx context.Context, ch chan<- SleepEvent) error {
+	conn, err := l.connection()
+	if err != nil {
+		return err
+	}
+	if err := conn.AddMatchSignal(dbus.WithMatchObjectPath(login1Path), dbus.WithMatchInterface(login1MgrIf), dbus.WithMatchMember("PrepareForSleep")); err != nil {
+		return fmt.Errorf("couldn't watch PrepareForSleep: %v", err)
+	}
+	if err := conn.AddMatchSignal(dbus.WithMatchObjectPath(login1Path), dbus.WithMatchInterface("org.freedesktop.DBus.Properties"), dbus.WithMatchMember("PropertiesChanged")); err != nil {
+		return fmt.Errorf("couldn't watch LidClosed: %v", err)
+	}
+	signals := make(chan *dbus.Signal, 8)
+	conn.Signal(signals)
+
+	manager := conn.Object(login1Name, login1Path)
+	lidClosed := func() bool {
+		var closed bool
+		manager.StoreProperty(login1MgrIf+".LidClosed", &closed)
+		return closed
+	}
+
+	go func() {
+		defer conn.RemoveSignal(signals)
+		ticker := time.NewTicker(lidPoll)
+		defer ticker.Stop()
+		closed := lidClosed()
+		for {
+			var ev SleepEvent
+			select {
+			case <-ctx.Done():
+				return
+			case sig, ok := <-signals:
+				if !ok {
+					return
+				}
+				if ev, ok = sleepEvent(sig); !ok {
+					continue
+				}
+			case <-ticker.C:
+				if lidClosed() == closed {
+					continue
+				}
+				ev = LidClosed
+				if closed {
+					ev = LidOpened
+				}
+			}
+			switch ev {
+			case LidClosed, LidOpened:
+				if (ev == LidClosed) == closed {
+					continue
+				}
+				closed = ev == LidClosed
+			}
+			select {
+			case ch <- ev:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+// sleepEvent translates a logind signal into a SleepEvent.
+func sleepEvent(sig *dbus.Signal) (SleepEvent, bool) {
+	if sig.Path != login1Path || len(sig.Body) < 1 {
+		return 0, false
+	}
+	switch sig.Name {
+	case login1MgrIf + ".PrepareForSleep":
+		if start, ok := sig.Body[0].(bool); ok && start {
+			return Sleeping, true
+		} else if ok {
+			return Resumed, true
+		}
+	case "org.freedesktop.DBus.Properties.PropertiesChanged":
+		if iface, _ := sig.Body[0].(string); iface != login1MgrIf || len(sig.Body) < 2 {
+			return 0, false
+		}
+		changed, _ := sig.Body[1].(map[string]dbus.Variant)
+		if closed, ok := changed["LidClosed"].Value().(bool); ok && closed {
+			return LidClosed, true
+		} else if ok {
+			return LidOpened, true
+		}
+	}
+	return 0, false
+}
+
+// object returns the logind object at path on the connection manager was last called on.
+func (l *Logind) object(path dbus.ObjectPath) dbus.BusObject {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	return l.conn.Object(login1Name, path)
+}
+
+// Close implements Backend.
+func (l *Logind) Close() {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	if l.conn != nil {
+		l.conn.Close()
+		l.conn = nil
+	}
+	// Nothing can use a closed Logind again.
+	l.retryAt = time.Now().Add(100 * 365 * 24 * time.Hour)
+}
@@ -0,0 +1,59 @@
+package bridge
+
+import (
+	"runtime/debug"
+
+	"github.com/godbus/dbus/v5"
+)
+
+// op is a unit of work on the bridge's state (the lock table, the name owner table and the event channel), run by
+// the actor goroutine. All of that state is only ever touched from inside an op, so ops never race with each other
+// and need no locking.
+type op struct {
+	name string
+	fn   func()
+	done chan bool // receives whether fn panicked
+}
+
+// run is the actor goroutine. It applies ops one at a time until Close stops it.
+func (b *Bridge) run() {
+	for {
+		select {
+		case o := <-b.ops:
+			o.done <- b.apply(o)
+		case <-b.quit:
+			return
+		}
+	}
+}
+
+// apply runs a single op. A panic is logged, counted and followed by a repair of the lock table, so that one bad
+// request can't take the whole bridge down.
+func (b *Bridge) apply(o op) (panicked bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			b.log.Printf("Recovered panic in %s: %v\n%s", o.name, r, debug.Stack())
+			b.metrics.add(metricPanics, 1)
+			b.repairLocks()
+			panicked = true
+		}
+	}()
+
+	o.fn()
+	return false
+}
+
+// do runs fn on the actor goroutine and waits for it to finish. It fails if fn panicked or the bridge is closed. It
+// must not be called from inside an op.
+func (b *Bridge) do(name string, fn func()) *dbus.Error {
+	o := op{name: name, fn: fn, done: make(chan bool, 1)}
+	select {
+	case b.ops <- o:
+	case <-b.quit:
+		return newError(ErrorInternal, "bridge is closed")
+	}
+	if <-o.done {
+		return newError(ErrorInternal, "internal error in %s", name)
+	}
+	return nil
+}
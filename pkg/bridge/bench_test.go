@@ -0,0 +1,75 @@
+package bridge_test
+
+import (
+	"fmt"
+	"os"
+	"sync/atomic"
+	"syscall"
+	"testing"
+
+	"github.com/coltwillcox/inhibitor/pkg/bridge"
+	"github.com/godbus/dbus/v5"
+)
+
+// benchLocks is how many locks BenchmarkHeartbeat holds, spread over benchPeers peers.
+const (
+	benchLocks = 10000
+	benchPeers = 100
+)
+
+func BenchmarkInhibitParallel(b *testing.B) {
+	f := newFakes(b, bridge.Options{})
+	var next int32
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		// Each goroutine is a client of its own, as contention between clients is what this measures.
+		peer := dbus.Sender(fmt.Sprintf(":2.%d", atomic.AddInt32(&next, 1)))
+		f.bus.AddPeer(peer, os.Getpid(), aliceUID)
+		for pb.Next() {
+			cookie, err := f.b.Inhibit(peer, "bench", "benchmarking")
+			if err != nil {
+				b.Errorf("Inhibit() failed: %v", err)
+				return
+			}
+			if err := f.b.UnInhibit(peer, cookie); err != nil {
+				b.Errorf("UnInhibit() failed: %v", err)
+				return
+			}
+		}
+	})
+}
+
+func BenchmarkHeartbeat(b *testing.B) {
+	// Every lock holds an fd.
+	locks := benchLocks
+	var lim syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &lim); err == nil {
+		lim.Cur = lim.Max
+		syscall.Setrlimit(syscall.RLIMIT_NOFILE, &lim)
+		if max := int(lim.Cur) - 100; max < locks {
+			b.Logf("Holding %d locks rather than %d, as only %d files may be open.", max, locks, lim.Cur)
+			locks = max
+		}
+	}
+
+	f := newFakes(b, bridge.Options{})
+	for i := 0; i < benchPeers; i++ {
+		f.bus.AddPeer(dbus.Sender(fmt.Sprintf(":2.%d", i)), os.Getpid(), aliceUID)
+	}
+	for i := 0; i < locks; i++ {
+		peer := dbus.Sender(fmt.Sprintf(":2.%d", i%benchPeers))
+		if _, err := f.b.Inhibit(peer, "bench", fmt.Sprintf("lock %d", i)); err != nil {
+			b.Fatalf("Inhibit() failed after %d locks: %v", i, err)
+		}
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		f.b.HeartbeatTick()
+	}
+	b.StopTimer()
+
+	if got := len(f.b.Locks()); got != locks {
+		b.Errorf("heartbeat dropped locks: %d left of %d", got, locks)
+	}
+}
@@ -0,0 +1,120 @@
+package bridge
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/coltwillcox/inhibitor/pkg/backend"
+	"github.com/coltwillcox/inhibitor/pkg/policy"
+)
+
+const (
+	// breakerProbe is how long the circuit breaker stays open before its first probe of the backend. It doubles
+	// with each failed probe, up to breakerProbeMax.
+	breakerProbe    = 5 * time.Second
+	breakerProbeMax = 5 * time.Minute
+)
+
+// errBreakerOpen is returned, wrapped, by backendInhibit while the circuit breaker is open.
+var errBreakerOpen = errors.New("the backend failed repeatedly and isn't called until it is back")
+
+// breaker is a circuit breaker for backend Inhibit calls (see Options.BreakerThreshold). Once threshold calls in a
+// row have failed it opens, and calls fail at once, without reaching the backend, until a probe (see probeBreaker)
+// finds the backend back. It is used off the actor, by whoever calls the backend.
+type breaker struct {
+	threshold int // 0 disables the breaker
+
+	mtx      sync.Mutex
+	failures int           // in a row, guarded by mtx
+	open     bool          // guarded by mtx
+	wait     time.Duration // before the next probe while open, guarded by mtx
+	lastErr  error         // the failure that opened the breaker, or of the latest probe, guarded by mtx
+}
+
+// allow returns an error wrapping errBreakerOpen if the breaker is open.
+func (br *breaker) allow() error {
+	br.mtx.Lock()
+	defer br.mtx.Unlock()
+	if br.open {
+		return fmt.Errorf("%w (last error: %v)", errBreakerOpen, br.lastErr)
+	}
+	return nil
+}
+
+// record counts the outcome of a backend call made while the breaker was closed, and reports whether it opened the
+// breaker. Refusals (see backend.ErrDenied) show the backend up and answering, so they count as successes.
+func (br *breaker) record(err error) (opened bool) {
+	br.mtx.Lock()
+	defer br.mtx.Unlock()
+	if err == nil || errors.Is(err, backend.ErrDenied) {
+		br.failures = 0
+		return false
+	}
+	br.failures++
+	if br.open || br.threshold == 0 || br.failures < br.threshold {
+		return false
+	}
+	br.open, br.wait, br.lastErr = true, breakerProbe, err
+	return true
+}
+
+// probed records the outcome of a probe of the open breaker: success closes it, failure doubles the wait for the
+// next probe, which it returns.
+func (br *breaker) probed(err error) time.Duration {
+	br.mtx.Lock()
+	defer br.mtx.Unlock()
+	if err == nil {
+		br.open, br.failures, br.lastErr = false, 0, nil
+		return 0
+	}
+	br.lastErr = err
+	if br.wait *= 2; br.wait > breakerProbeMax {
+		br.wait = breakerProbeMax
+	}
+	return br.wait
+}
+
+// backendCall makes a backend call through the breaker, opening it, and starting its probes, once threshold calls
+// in a row have failed.
+func (b *Bridge) backendCall(call func() error) error {
+	if err := b.breaker.allow(); err != nil {
+		b.metrics.add(metricBreakerRejected, 1)
+		return err
+	}
+	err := call()
+	if b.breaker.record(err) {
+		b.metrics.add(metricBreakerTrips, 1)
+		b.metrics.set(metricBreakerOpen, 1)
+		b.log.Printf("WARNING: the backend failed %d times in a row (%v); failing requests at once until a probe in %s finds it back.\n", b.breaker.threshold, err, breakerProbe)
+		go b.probeBreaker()
+	}
+	return err
+}
+
+// probeBreaker takes and releases an idle inhibit, with backoff, until the backend is back, then closes the breaker.
+func (b *Bridge) probeBreaker() {
+	wait := breakerProbe
+	for {
+		timer := b.opts.Clock.NewTimer(wait)
+		select {
+		case <-timer.C():
+		case <-b.ctx.Done():
+			timer.Stop()
+			return
+		}
+		fd, err := b.backend.Inhibit(b.ctx, policy.WhatIdle, b.opts.Prog, "Checking whether the backend is back", "block")
+		if err == nil {
+			fd.Close()
+		}
+		if wait = b.breaker.probed(err); wait == 0 {
+			b.metrics.set(metricBreakerOpen, 0)
+			b.log.Printf("The backend is back; requests reach it again.\n")
+			// Provisional and no-op locks are taken for real by the heartbeat.
+			b.wake()
+			return
+		}
+		b.log.Debugf("The backend is still down (%v); probing again in %s.\n", err, wait)
+	}
+}
@@ -0,0 +1,1210 @@
+// Package bridge bridges org.freedesktop.ScreenSaver Inhibit/UnInhibit requests on D-Bus to inhibitor locks taken
+// from a backend, normally systemd-logind.
+//
+// A Bridge owns org.freedesktop.ScreenSaver on the bus it connects to, tracks every lock it hands out and heartbeats
+// the requesting peers so that locks held by crashed programs are released. Front-ends (such as the inhibitor
+// command's tray icon) take their own locks through Bridge.Inhibit and follow state changes via Bridge.Events:
+//
+//	b, err := bridge.NewBridge(ctx, bridge.Options{Prog: "myapp"}, bridge.WithLogger(myLogger))
+//	if err != nil {
+//		return err
+//	}
+//	defer b.Close()
+//
+//	cookie, err := b.Inhibit(b.Name(), "myapp", "presenting")
+//	...
+//	for ev := range b.Events() {
+//		fmt.Println(ev)
+//	}
+package bridge
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/coltwillcox/inhibitor/pkg/backend"
+	"github.com/coltwillcox/inhibitor/pkg/policy"
+	"github.com/coltwillcox/inhibitor/pkg/store"
+	"github.com/coltwillcox/inhibitor/pkg/telemetry"
+	"github.com/godbus/dbus/v5"
+	"golang.org/x/sync/errgroup"
+)
+
+const (
+	listNames       = "org.freedesktop.DBus.ListNames"
+	nameHasOwner    = "org.freedesktop.DBus.NameHasOwner"
+	intro           = "org.freedesktop.DBus.Introspectable"
+	screensaver     = "org.freedesktop.ScreenSaver"
+	screensaverPath = "/org/freedesktop/ScreenSaver"
+	legacyPath      = "/ScreenSaver" // Firefox looks for this path, not /org/freedesktop/ScreenSaver
+)
+
+// Options configures a Bridge. The zero value is usable: it serves the session bus with logind as the backend.
+type Options struct {
+	// Prog is the name the bridge reports to the backend as the lock holder.
+	Prog string
+	// System serves every user on the system bus rather than a single user on the session bus.
+	System bool
+	// Heartbeat is how often peers holding locks are checked for liveness. Defaults to 10s.
+	Heartbeat time.Duration
+	// HeartbeatReport is how often the heartbeat sums up its passes in a single Debugf line, such as "checked 14
+	// locks, reaped 1". Each lock checked is only logged with a Tracer. Defaults to 10m.
+	HeartbeatReport time.Duration
+	// Watchdog is how often the bridge makes sure it still owns the names it claimed and that its objects answer a
+	// call made to them over the bus, claiming and exporting them again if not. 0 disables the check.
+	Watchdog time.Duration
+	// Usage is how often the bridge samples its own resident memory, goroutines and open fds, publishing them as the
+	// rss_bytes, goroutines and open_fds metrics and warning when any grows well past what it was at startup. 0
+	// disables sampling.
+	Usage time.Duration
+	// InhibitRetries is how many times a failed backend Inhibit is retried, with exponential backoff.
+	InhibitRetries int
+	// BreakerThreshold is how many backend Inhibits in a row may fail, retries included, before the circuit breaker
+	// opens: requests then fail at once with ErrorBackendDown, rather than each wait out InhibitRetries against a
+	// backend that is down, until a probe, backing off from 5s to 5m, finds it back. Provisional and no-op locks
+	// are still handed out meanwhile (see Provisional and SoftFail). 0 disables the breaker.
+	BreakerThreshold int
+	// MaxLocksPerPeer caps the locks a single peer may hold at once. 0 means no limit.
+	MaxLocksPerPeer int
+	// RequestQueue is how many requests for locks may wait for one of the few worked on at once before new ones are
+	// refused with ErrorBusy. Defaults to 64.
+	RequestQueue int
+	// RequestTimeout is how long a request for a lock may wait in the queue before it is refused with ErrorBusy.
+	// Defaults to 5s.
+	RequestTimeout time.Duration
+	// Provisional hands out a cookie even when the backend is unavailable, acquiring the lock once it is back.
+	Provisional bool
+	// SoftFail keeps the bridge serving when the backend is unavailable, e.g. while logind is being upgraded or is
+	// broken: requests get no-op locks, which inhibit nothing, rather than ErrorUnavailable, so that applications
+	// don't fail over a lock. Once the backend is known to be down, requests no longer wait out InhibitRetries
+	// either. No-op locks are taken for real as soon as the backend is back, like provisional ones (see
+	// Bridge.softFail).
+	SoftFail bool
+	// SignalBatch is how long the Removed signal of a released lock waits for those of others, so that locks released
+	// together, such as the 40 of a browser that exits, are announced with a single RemovedBatch signal rather than
+	// waking every subscriber 40 times. Changes of the ActiveInhibitorCount property are announced at most once per
+	// SignalBatch likewise. 0 sends each signal right away.
+	SignalBatch time.Duration
+	// SuspendVeto is how long after the lid is closed the machine has to go to sleep before the locks blocking sleep or
+	// the lid switch are reported, with the SuspendVetoed event, for vetoing the suspend. It needs a backend
+	// implementing backend.SleepWatcher. 0 reports nothing.
+	SuspendVeto time.Duration
+	// LogRateLimit coalesces repeated error log lines within this window. 0 disables coalescing.
+	LogRateLimit time.Duration
+	// Replace takes org.freedesktop.ScreenSaver over from its current owner, if that owner allows replacement.
+	Replace bool
+	// AllowReplacement lets another process take org.freedesktop.ScreenSaver over, e.g. a desktop environment's own
+	// screensaver once it starts. The bridge then emits NameLost and stops serving; the front-end should shut down.
+	AllowReplacement bool
+	// Queue waits in line for org.freedesktop.ScreenSaver when another process owns it, rather than failing. Until the
+	// name is acquired (signalled by NameAcquired) the bridge is passive and refuses Inhibit. With AllowReplacement,
+	// a replaced bridge goes back to waiting rather than stopping.
+	Queue bool
+	// Compat turns the compat toggles (see CompatToggles) on or off by name: org.freedesktop.ScreenSaver on the legacy
+	// path /ScreenSaver, and the inhibit methods of org.freedesktop.PowerManagement, org.gnome.SessionManager,
+	// org.kde.Solid.PowerManagement.PolicyAgent and org.mate.SessionManager, for applications that use those
+	// instead. Toggles left out are off, but for the legacy path. Names already owned by another process are
+	// skipped. SetCompat changes them while the bridge runs.
+	Compat map[string]bool
+	// MaxBlock is how long a lock may block sleep and shutdown. After that those classes are downgraded to delay mode,
+	// which only holds them off for logind's InhibitDelayMaxSec, while the rest (such as idle) stay blocked. 0 never
+	// downgrades.
+	MaxBlock time.Duration
+	// JIT is the session's idle timeout, after which the screen blanks. If set, locks that only inhibit idle take
+	// their backend inhibit just in time: once the session has been idle for nearly that long, as GNOME's Mutter
+	// counts it, and only until the user is active again. That keeps short-lived locks, such as a video that is
+	// paused before the screen would have blanked anyway, out of logind's list of inhibitors. Without Mutter the
+	// locks are taken right away. It has no effect with System.
+	JIT time.Duration
+	// IdleHint also marks the sessions of processes holding locks as not idle, for idle policies that ignore
+	// inhibitors. It needs a backend implementing backend.IdleHinter.
+	IdleHint bool
+	// RemoteSleep lets locks from remote and headless sessions inhibit sleep. Otherwise sleep is dropped from their
+	// what-classes. Sessions are only told apart with a backend implementing backend.SessionClasser.
+	RemoteSleep bool
+	// DedupePortal lets a request share the backend lock of a held one for the same application and reason when
+	// exactly one of them came through xdg-desktop-portal, so that a sandboxed application inhibiting both directly
+	// and via the portal only takes one backend lock.
+	DedupePortal bool
+	// ExtraPaths are object paths to serve org.freedesktop.ScreenSaver on besides /org/freedesktop/ScreenSaver and
+	// /ScreenSaver, for clients that use yet other legacy paths. SetExtraPaths changes them at runtime.
+	ExtraPaths []dbus.ObjectPath
+	// Windows follows the windows of locks taken with the control interface's InhibitWindow. nil disables it.
+	Windows WindowWatcher
+	// Policy decides which requests are accepted. Defaults to policy.Default().
+	Policy *policy.Policy
+	// Bus is the connection requests are served on. Defaults to connecting to the session (or, with System, the
+	// system) bus. Close closes it either way.
+	Bus Bus
+	// Backend takes the actual locks. Defaults to a logind connection. Close closes it either way.
+	Backend backend.Backend
+	// Logger receives log output. Defaults to discarding it.
+	Logger Logger
+	// Clock tells the time and runs the periodic work (see Clock). Defaults to the system clock.
+	Clock Clock
+	// Store keeps the lock table, notes included, as it changes, so that a bridge started on the same store after this
+	// one crashed takes back the locks of the peers still around. The bridge doesn't close it. nil keeps nothing.
+	Store store.Store
+	// MetricsSink receives every update of the metrics as it happens, for a monitoring system (see telemetry.Sink);
+	// Metrics keeps returning them all the same. The bridge doesn't close it. Defaults to telemetry.Nop.
+	MetricsSink telemetry.Sink
+	// EventSink, if set, is called with every event, in order, from a goroutine of its own. Unlike Events, which drops
+	// what a slow reader doesn't take in time, it misses none: events queue up for it for as long as it takes, so it
+	// must keep up on average. Close passes on the last ones before it returns.
+	EventSink func(Event)
+	// Handoff is a predecessor's lock table (see Freeze) to adopt on startup. Its lock fds must be open in this
+	// process under the recorded numbers.
+	Handoff *Handoff
+	// Upgrade is run when the control interface's Upgrade method is called. It should replace the process, handing
+	// over the lock table from Freeze, and only return on failure. nil disables upgrades.
+	Upgrade func() error
+	// HandedOff is run once a successor has taken the lock table over through the control interface (see
+	// RequestHandoff). The bridge stays frozen, so it should exit the process without calling Close. nil disables
+	// handoffs.
+	HandedOff func()
+}
+
+// nameFlags returns the flags to request org.freedesktop.ScreenSaver with.
+func (o *Options) nameFlags() dbus.RequestNameFlags {
+	var flags dbus.RequestNameFlags
+	if !o.Queue {
+		flags |= dbus.NameFlagDoNotQueue
+	}
+	if o.Replace {
+		flags |= dbus.NameFlagReplaceExisting
+	}
+	if o.AllowReplacement {
+		flags |= dbus.NameFlagAllowReplacement
+	}
+	return flags
+}
+
+// lockDetails represents all of the state for an individual inhibit lock that we've requested from the backend.
+type lockDetails struct {
+	id       string // correlation ID, in every log line, signal and metric exemplar about the lock (see newLockID)
+	cookie   uint
+	peer     dbus.Sender
+	who, why string
+	what     string       // the backend what-classes, e.g. "idle:sleep"
+	fd       *os.File     // nil while a provisional lock waits for the backend
+	delay    *os.File     // the delay-mode half of a downgraded lock that also blocks other classes, or nil
+	proc     *peerProcess // nil if the peer's process couldn't be identified
+	uid      uint32
+	names    []string  // well-known names the peer owned when the lock was requested
+	app      string    // the Flatpak application ID of the peer, if it is sandboxed
+	session  string    // the class of the peer's session (see backend.SessionClasser), if known
+	since    time.Time // when the lock was handed out, which its held time counts from
+	expires  time.Time // zero unless the lock outlives its peer, until then
+	noLock   bool      // keep lockers from locking the session while held (see policy.Rule.NoLock)
+	jit      bool      // take the backend inhibit only once idle is near (see Options.JIT)
+	note     string    // free-form annotation set through the control interface (see controlAPI.Annotate)
+	window   string    // the window the lock lasts as long as (see controlAPI.InhibitWindow), if any
+	unwatch  func()    // stops following window
+	// downgraded is set once the lock's sleep and shutdown classes are in delay mode, after Options.MaxBlock or from
+	// the start (see lockRequest.delay).
+	downgraded bool
+	// interval is how far each Keepalive pushes expires back, or 0 unless the lock is kept alive (see
+	// Bridge.InhibitKeepalive).
+	interval time.Duration
+}
+
+// held returns how long ld has been held at now, counting from when it was handed out, provisional or not. A clock
+// set back before then (see Clock) makes it 0.
+func (ld *lockDetails) held(now time.Time) time.Duration {
+	if d := now.Sub(ld.since); d > 0 {
+		return d
+	}
+	return 0
+}
+
+// lockKey identifies a lock within the cookie namespace of the peer that requested it. Cookies are only unique per
+// peer, so a cookie on its own is never enough to find (or release) a lock. Including the owning uid keeps each
+// user's locks strictly partitioned when serving the system bus.
+type lockKey struct {
+	uid    uint32
+	peer   dbus.Sender
+	cookie uint
+}
+
+// Bridge holds the state required to bridge D-Bus inhibit requests to backend locks. All methods are safe for
+// concurrent use: the lock table is owned by a single actor goroutine (see do), and everything else hands it work.
+//
+// The bridge's background work (heartbeat, name owner tracking) runs in an errgroup bound to a context derived from
+// the one passed to NewBridge. Cancelling that context or calling Close stops it; Close then releases every lock.
+type Bridge struct {
+	opts      Options
+	log       Logger
+	policy    *policy.Policy
+	dbusConn  Bus
+	exports   *exports // everything exported on dbusConn, with its introspection
+	queue     *requestQueue
+	backend   backend.Backend
+	locks     map[lockKey]*lockDetails
+	ops       chan op
+	quit      chan struct{}
+	wakeCh    chan struct{}               // see wake
+	persistCh chan struct{}               // see persistLater
+	objects   map[dbus.ObjectPath]lockKey // the published lock objects (see exportLock)
+	signals   *signalQueue
+	ctx       context.Context
+	cancel    context.CancelFunc
+	group     *errgroup.Group
+	events    chan Event
+	sink      *eventQueue // for Options.EventSink
+	errLog    *logLimiter
+	fds       *fdTracker
+	metrics   *counters
+	owners    nameOwners
+	paths     map[dbus.ObjectPath]bool // extra paths currently exported
+	compat    []string                 // the compat names claimed (see Options.Compat)
+	compatMtx sync.Mutex               // held by SetCompat
+	legacy    bool                     // whether org.freedesktop.ScreenSaver is served on legacyPath
+	rules     []policy.Rule
+	rewrites  []policy.Rewrite
+	reasons   []policy.DefaultReason
+	profiles  []policy.Profile
+	quirks    map[string]policy.Quirks // by browser family
+	faults    []policy.Fault           // injected into lock requests (see SetFaults)
+	schedules []Schedule               // pending, in the order they were made
+	started   time.Time
+	active    time.Time       // when the user was last seen (see SessionIdle)
+	hb        heartbeatStats  // only touched by heartbeatTick
+	usage     usageStats      // only touched by usageTick
+	apps      appUsage        // for TopInhibitors
+	serving   bool            // whether the bridge owns org.freedesktop.ScreenSaver
+	degraded  bool            // whether the backend is down and locks are no-ops (see Options.SoftFail)
+	removed   []removedSignal // Removed signals waiting for Options.SignalBatch to pass
+	countDue  bool            // whether a change of ActiveInhibitorCount is waiting for Options.SignalBatch to pass
+	countSent uint32          // the ActiveInhibitorCount last announced
+	denied    map[string]bool // class/mode pairs the backend refuses (see probeBackend)
+	breaker   *breaker
+	closed    bool
+}
+
+// NewBridge connects to the bus, claims org.freedesktop.ScreenSaver and starts serving requests until ctx is
+// cancelled or Close is called. It is configured by an Options value, With options, or both (see Option); with none,
+// it serves the session bus with logind as the backend.
+func NewBridge(ctx context.Context, options ...Option) (_ *Bridge, err error) {
+	var opts Options
+	for _, o := range options {
+		o.apply(&opts)
+	}
+	if opts.Heartbeat <= 0 {
+		opts.Heartbeat = 10 * time.Second
+	}
+	if opts.HeartbeatReport <= 0 {
+		opts.HeartbeatReport = 10 * time.Minute
+	}
+	if opts.RequestQueue <= 0 {
+		opts.RequestQueue = 64
+	}
+	if opts.RequestTimeout <= 0 {
+		opts.RequestTimeout = 5 * time.Second
+	}
+	if opts.Policy == nil {
+		opts.Policy = policy.Default()
+	}
+	if opts.Logger == nil {
+		opts.Logger = nopLogger{}
+	}
+	if opts.Clock == nil {
+		opts.Clock = systemClock{}
+	}
+	if opts.MetricsSink == nil {
+		opts.MetricsSink = telemetry.Nop{}
+	}
+
+	// Whatever was set up is undone if NewBridge fails, so that a caller can try again.
+	var (
+		b    *Bridge
+		conn Bus
+		be   backend.Backend
+	)
+	defer func() {
+		if err == nil {
+			return
+		}
+		if b != nil {
+			b.abandon()
+		}
+		if be != nil && opts.Backend == nil {
+			be.Close()
+		}
+		if conn != nil && opts.Bus == nil {
+			conn.Close()
+		}
+	}()
+
+	conn = opts.Bus
+	if conn == nil {
+		connect, bus := dbus.ConnectSessionBus, "session"
+		if opts.System {
+			connect, bus = dbus.ConnectSystemBus, "system"
+		}
+		c, err := connect()
+		if err != nil {
+			return nil, fmt.Errorf("%s bus connect failed: %v", bus, err)
+		}
+		conn = c
+	}
+
+	be = opts.Backend
+	if be == nil {
+		if be, err = backend.NewLogind(); err != nil {
+			return nil, err
+		}
+	}
+	if _, ok := be.(backend.IdleHinter); opts.IdleHint && !ok {
+		opts.Logger.Printf("The backend can't set idle hints; ignoring IdleHint.\n")
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	group, ctx := errgroup.WithContext(ctx)
+	b = &Bridge{
+		opts:      opts,
+		log:       opts.Logger,
+		policy:    opts.Policy,
+		dbusConn:  conn,
+		exports:   newExports(conn),
+		queue:     newRequestQueue(requestWorkers, opts.RequestQueue),
+		backend:   be,
+		locks:     make(map[lockKey]*lockDetails),
+		ops:       make(chan op),
+		quit:      make(chan struct{}),
+		wakeCh:    make(chan struct{}, 1),
+		persistCh: make(chan struct{}, 1),
+		objects:   make(map[dbus.ObjectPath]lockKey),
+		signals:   newSignalQueue(),
+		ctx:       ctx,
+		cancel:    cancel,
+		group:     group,
+		events:    make(chan Event, eventBuffer),
+		sink:      newEventQueue(),
+		fds:       newFdTracker(),
+		metrics:   newCounters(opts.MetricsSink),
+		owners:    make(nameOwners),
+		paths:     make(map[dbus.ObjectPath]bool),
+		rules:     opts.Policy.Rules,
+		rewrites:  opts.Policy.Rewrites,
+		reasons:   opts.Policy.DefaultReasons,
+		profiles:  opts.Policy.Profiles,
+		quirks:    opts.Policy.Quirks,
+		faults:    opts.Policy.Faults,
+		started:   opts.Clock.Now(),
+		active:    opts.Clock.Now(),
+		breaker:   &breaker{threshold: opts.BreakerThreshold},
+	}
+	b.errLog = newLogLimiter(opts.LogRateLimit, b.log)
+	b.errLog.wake = b.wake
+	go b.run()
+	if opts.Handoff != nil {
+		b.do("adopt", func() { b.adopt(opts.Handoff, "adopted from previous instance") })
+	} else if opts.Store != nil {
+		b.do("recoverLocks", b.recoverLocks)
+	}
+	b.probeBackend()
+
+	if screensaverIntrospectionErr != nil {
+		b.log.Printf("Introspection of %s uses generated data: %v\n", screensaver, screensaverIntrospectionErr)
+	}
+	if err := b.exportScreenSaver(); err != nil {
+		return nil, err
+	}
+	if err := b.exportObjectManager(); err != nil {
+		return nil, err
+	}
+	if err := b.SetExtraPaths(opts.ExtraPaths); err != nil {
+		return nil, err
+	}
+	if err := b.SetCompat(opts.Compat); err != nil {
+		return nil, err
+	}
+	if err := b.exportControl(); err != nil {
+		return nil, err
+	}
+	if err := b.watchNameOwners(); err != nil {
+		return nil, err
+	}
+	b.watchSleep()
+	// The name is claimed last, so that nobody finds it owned before its methods are exported: a client taking its
+	// locks again as soon as it sees the owner change would otherwise be refused.
+	if err := b.claimName(); err != nil {
+		return nil, err
+	}
+
+	jobs := []*periodic{{every: opts.Heartbeat, run: b.heartbeatTick}}
+	if opts.JIT > 0 && !opts.System {
+		jobs = append(jobs, &periodic{every: jitPoll, run: b.jitTick})
+	}
+	if opts.Usage > 0 {
+		// Sampled once right away for the baseline. A leak can grow while no lock is held, too.
+		b.usageTick()
+		jobs = append(jobs, &periodic{every: opts.Usage, idle: true, run: b.usageTick})
+	}
+	if opts.Watchdog > 0 {
+		// A broken export keeps clients from taking locks in the first place, so the watchdog can't wait for one.
+		jobs = append(jobs, &periodic{every: opts.Watchdog, idle: true, run: b.watchdogTick})
+	}
+	b.group.Go(func() error { return b.runWheel(jobs) })
+	b.group.Go(b.sendSignals)
+	if opts.EventSink != nil {
+		b.group.Go(b.sendEvents)
+	}
+	if opts.Store != nil {
+		// Whatever was adopted or recovered is saved right away, as the previous instance's record may be out of date.
+		b.do("persist", b.persistLater)
+		b.group.Go(b.persistLoop)
+	}
+
+	return b, nil
+}
+
+// claimName requests org.freedesktop.ScreenSaver, waiting in line for it with Options.Queue.
+func (b *Bridge) claimName() error {
+	if b.opts.Handoff != nil {
+		if err := requestNameAfterHandoff(b.dbusConn, b.opts.nameFlags()); err != nil {
+			return err
+		}
+	} else {
+		r, err := b.dbusConn.RequestName(screensaver, b.opts.nameFlags())
+		if err != nil {
+			return fmt.Errorf("conn.RequestName(%q, 0): %v", screensaver, err)
+		}
+		switch {
+		case r == dbus.RequestNameReplyInQueue && b.opts.Queue:
+			b.log.Printf("%s is owned by another process; waiting in line for it.\n", screensaver)
+			return nil
+		case r != dbus.RequestNameReplyPrimaryOwner:
+			return fmt.Errorf("conn.RequestName(%q, 0): %w", screensaver, ErrNameTaken)
+		}
+	}
+	b.do("claimName", func() { b.serving = true })
+	return nil
+}
+
+// String returns a useful textual representation of a lock.
+func (ld *lockDetails) String() string {
+	if ld.app != "" {
+		return fmt.Sprintf("[%s] %q / %q (%q, %d, %s, app %s)", ld.id, ld.who, ld.why, ld.peer, ld.cookie, ld.proc, ld.app)
+	}
+	if ld.proc != nil {
+		return fmt.Sprintf("[%s] %q / %q (%q, %d, %s)", ld.id, ld.who, ld.why, ld.peer, ld.cookie, ld.proc)
+	}
+	return fmt.Sprintf("[%s] %q / %q (%q, %d)", ld.id, ld.who, ld.why, ld.peer, ld.cookie)
+}
+
+// key returns the lock table key for this lock.
+func (ld *lockDetails) key() lockKey {
+	return lockKey{uid: ld.uid, peer: ld.peer, cookie: ld.cookie}
+}
+
+// SetRules replaces the rules that add what-classes to new locks (see policy.Policy.Rules). Locks already held keep
+// theirs.
+func (b *Bridge) SetRules(rules []policy.Rule) error {
+	for _, r := range rules {
+		if err := r.Validate(); err != nil {
+			return err
+		}
+	}
+	if derr := b.do("SetRules", func() { b.rules = rules }); derr != nil {
+		return derr
+	}
+	return nil
+}
+
+// SetRewrites replaces the rewrites of the who and why of new requests (see policy.Policy.Rewrites). Locks already
+// held keep the names they were taken with.
+func (b *Bridge) SetRewrites(rewrites []policy.Rewrite) error {
+	for _, r := range rewrites {
+		if err := r.Validate(); err != nil {
+			return err
+		}
+	}
+	if derr := b.do("SetRewrites", func() { b.rewrites = rewrites }); derr != nil {
+		return derr
+	}
+	return nil
+}
+
+// SetDefaultReasons replaces the reasons given to new requests that came without a why (see
+// policy.Policy.DefaultReasons).
+func (b *Bridge) SetDefaultReasons(reasons []policy.DefaultReason) error {
+	for _, r := range reasons {
+		if err := r.Validate(); err != nil {
+			return err
+		}
+	}
+	if derr := b.do("SetDefaultReasons", func() { b.reasons = reasons }); derr != nil {
+		return derr
+	}
+	return nil
+}
+
+// Name returns the bridge's own unique name on the bus. Front-ends use it as the peer for their own locks.
+func (b *Bridge) Name() dbus.Sender {
+	names := b.dbusConn.Names()
+	if len(names) == 0 {
+		// Only happens once the connection has been closed.
+		return ""
+	}
+	return dbus.Sender(names[0])
+}
+
+// Locks returns a snapshot of every lock currently held through the bridge.
+func (b *Bridge) Locks() []Lock {
+	var locks []Lock
+	b.do("Locks", func() {
+		locks = make([]Lock, 0, len(b.locks))
+		for _, ld := range b.locks {
+			locks = append(locks, ld.public())
+		}
+	})
+	return locks
+}
+
+// Metrics returns a snapshot of the bridge's internal counters.
+func (b *Bridge) Metrics() map[string]uint64 {
+	return b.metrics.snapshot()
+}
+
+// Exemplars returns, for each counter of Metrics that counts locks and each bucket of the locks_held_seconds
+// histogram, the correlation ID of the lock it last counted, so that a jump in a counter leads straight to the log
+// lines of a lock behind it.
+func (b *Bridge) Exemplars() map[string]string {
+	return b.metrics.exemplarSnapshot()
+}
+
+// Inhibit takes a lock on behalf of from and returns its cookie, exactly as if from had called
+// org.freedesktop.ScreenSaver.Inhibit (minus the strict-mode header checks).
+func (b *Bridge) Inhibit(from dbus.Sender, who, why string) (uint32, error) {
+	cookie, err := b.inhibit(from, "", who, why)
+	if err != nil {
+		return 0, err
+	}
+	return uint32(cookie), nil
+}
+
+// InhibitWhat is Inhibit for the given colon-separated logind what-classes rather than those the bridge's
+// configuration picks. A ttl above 0 detaches the lock from from, until it is released or ttl has passed.
+func (b *Bridge) InhibitWhat(from dbus.Sender, who, why, what string, ttl time.Duration) (uint32, error) {
+	if _, err := policy.ParseWhat(what); err != nil {
+		return 0, err
+	}
+	cookie, err := b.take(from, who, why, lockRequest{what: what, ttl: ttl})
+	if err != nil {
+		return 0, err
+	}
+	return uint32(cookie), nil
+}
+
+// UnInhibit releases a lock previously taken by from.
+func (b *Bridge) UnInhibit(from dbus.Sender, cookie uint32) error {
+	if err := b.unInhibit(from, cookie); err != nil {
+		return err
+	}
+	return nil
+}
+
+// heartbeatTick runs a single heartbeat pass, dropping locks whose peers have gone away.
+func (b *Bridge) heartbeatTick() {
+	defer b.recoverPanic("heartbeat", nil)
+
+	b.tracef("Heartbeat checker running.\n")
+	b.errLog.flush()
+	start := time.Now()
+	// Not every peer implements the org.freedesktop.DBus.Peer interface, so we'll simply lookup every active peer on the bus.
+	// Using that, we can determine if a peer that requested the inhibit is still alive.
+	var activeNames []dbus.Sender
+	if err := b.dbusConn.BusObject().CallWithContext(b.ctx, listNames, 0).Store(&activeNames); err != nil {
+		b.errLog.log("Error calling %q: %v\n", listNames, err)
+		return
+	}
+
+	nameMap := make(map[dbus.Sender]struct{})
+	for _, n := range activeNames {
+		nameMap[n] = struct{}{}
+	}
+
+	// Checking a peer process means reading /proc, which adds up with thousands of locks, so it is done against a
+	// snapshot rather than on the actor. The fields that change while a lock is held are copied, as the actor goes on
+	// writing them.
+	var locks []heartbeatLock
+	if err := b.do("heartbeat", func() {
+		locks = make([]heartbeatLock, 0, len(b.locks))
+		for _, ld := range b.locks {
+			locks = append(locks, heartbeatLock{ld: ld, expires: ld.expires, interval: ld.interval, window: ld.window})
+		}
+	}); err != nil {
+		return
+	}
+
+	dead := make(map[*lockDetails]string)
+	alive := make(map[peerProcess]bool)
+	now := b.opts.Clock.Now()
+	for _, hl := range locks {
+		ld := hl.ld
+		b.tracef("Heartbeat checking: %s\n", ld)
+		if hl.window != "" {
+			// Window locks last as long as their window, which the WindowWatcher follows.
+			continue
+		}
+		if !hl.expires.IsZero() {
+			// Detached locks don't depend on their peer.
+			if now.After(hl.expires) && hl.interval > 0 {
+				dead[ld] = "keepalive missed"
+			} else if now.After(hl.expires) {
+				dead[ld] = "expired"
+			}
+			continue
+		}
+		if _, ok := nameMap[ld.peer]; !ok && !b.hasOwner(ld.peer) {
+			b.log.Debugf("Missing peer %q; Dropping: %s\n", ld.peer, ld)
+			dead[ld] = "peer left the bus"
+			continue
+		}
+		if ld.proc == nil {
+			continue
+		}
+		// A chatty peer may hold many locks; its process only needs checking once.
+		ok, seen := alive[*ld.proc]
+		if !seen {
+			ok = ld.proc.alive()
+			alive[*ld.proc] = ok
+		}
+		if !ok {
+			b.log.Debugf("Peer process for %q is gone or was replaced; Dropping: %s\n", ld.peer, ld)
+			dead[ld] = "peer process exited"
+		}
+	}
+
+	reaped, left := 0, 0
+	b.do("heartbeat", func() {
+		for ld, reason := range dead {
+			// The peer may have released the lock itself in the meantime, or kept it alive.
+			if b.locks[ld.key()] != ld || !ld.expires.IsZero() && !now.After(ld.expires) {
+				continue
+			}
+			switch {
+			case ld.interval > 0:
+				b.log.Printf("No keepalive for %s; Dropping: %s\n", ld.interval, ld)
+				b.metrics.addFor(metricKeepaliveMissed, ld.id)
+			case !ld.expires.IsZero():
+				b.log.Debugf("Expired; Dropping: %s\n", ld)
+			}
+			b.dropLock(ld, reason)
+			b.metrics.addFor(metricLocksReaped, ld.id)
+			reaped++
+		}
+		left = len(b.locks)
+	})
+	// Wall time rather than the Clock's, as it measures the work done.
+	b.metrics.add(metricHeartbeats, 1)
+	b.metrics.set(metricHeartbeatTook, uint64(time.Since(start)/time.Microsecond))
+	// The heartbeat pauses once no lock is left, so what it did up to then is reported right away.
+	b.hb.pass(now, len(locks), reaped)
+	if left == 0 && len(locks) > 0 || now.Sub(b.hb.since) >= b.opts.HeartbeatReport {
+		b.log.Debugf("Heartbeat: %s.\n", b.hb.report(now))
+	}
+
+	b.SessionIdle()
+	b.acquirePending()
+	b.downgradeBlocks()
+	b.releaseOverheld()
+	b.pokeLockers()
+	b.clearIdleHints()
+	b.reconcileFds()
+}
+
+// heartbeatLock is a lock as a heartbeat pass checks it, off the actor.
+type heartbeatLock struct {
+	ld       *lockDetails
+	expires  time.Time
+	interval time.Duration
+	window   string
+}
+
+// heartbeatStats sums up the heartbeat passes since the last report.
+type heartbeatStats struct {
+	since   time.Time // of the first pass, zero before it
+	passes  int
+	checked int // by the latest pass
+	reaped  int
+}
+
+// pass records a heartbeat pass at now that checked and reaped as many locks.
+func (s *heartbeatStats) pass(now time.Time, checked, reaped int) {
+	if s.since.IsZero() {
+		s.since = now
+	}
+	s.passes++
+	s.checked = checked
+	s.reaped += reaped
+}
+
+// report describes the passes so far, e.g. "checked 14 locks, reaped 1 in 60 passes over 10m0s", and starts afresh.
+func (s *heartbeatStats) report(now time.Time) string {
+	r := fmt.Sprintf("checked %d locks, reaped %d in %d passes over %s", s.checked, s.reaped, s.passes, now.Sub(s.since).Round(time.Second))
+	*s = heartbeatStats{}
+	return r
+}
+
+// Close stops serving requests and releases every lock held through the bridge. Shutdown always happens in the same
+// order: the bus connection is closed so no new requests arrive, background work is cancelled and waited for, and
+// only then are the locks released. The returned error is the first failure of any background task.
+func (b *Bridge) Close() error {
+	// Stop programatic inhibits
+	b.dbusConn.Close()
+	// With all inhibit sources stopped, we can shut down the background work.
+	b.cancel()
+	err := b.group.Wait()
+	// Close any open files to release all inhibits, then stop the actor.
+	first := false
+	var schedules []Schedule
+	if derr := b.do("Close", func() {
+		if b.closed {
+			return
+		}
+		b.closed, first = true, true
+		schedules = b.schedules
+		for _, ld := range b.locks {
+			if ld.pending() {
+				continue
+			}
+			b.fds.untrack(ld.fd)
+			if err := ld.fd.Close(); err != nil {
+				b.log.Debugf("Error closing lock for %q: %v\n", ld, err)
+			}
+		}
+		close(b.events)
+	}); derr != nil || !first {
+		// Already closed.
+		return err
+	}
+	close(b.quit)
+	b.sinkEvents()
+	b.backend.Close()
+	if b.opts.Store != nil {
+		b.forgetLocks(schedules)
+	}
+
+	return err
+}
+
+// abandon undoes what NewBridge set up before it failed: it stops the background work and the actor, releases the
+// locks adopted or recovered, and withdraws the exports and the compat names claimed. The bus connection and the
+// backend are NewBridge's to close, if it opened them.
+func (b *Bridge) abandon() {
+	b.cancel()
+	b.group.Wait()
+	var compat []string
+	b.do("abandon", func() {
+		compat = b.compat
+		for _, ld := range b.locks {
+			if ld.pending() {
+				continue
+			}
+			b.fds.untrack(ld.fd)
+			ld.fd.Close()
+		}
+		b.closed = true
+		close(b.events)
+	})
+	close(b.quit)
+	for _, api := range compatAPIs {
+		for _, n := range compat {
+			if n == api.name {
+				if err := dropCompat(b.exports, api); err != nil {
+					b.log.Debugf("%v\n", err)
+				}
+			}
+		}
+	}
+	b.exports.unexportAll()
+}
+
+// inhibit takes a lock for a request that arrived on via (see RequestsVia), or from the Go API if via is empty.
+func (b *Bridge) inhibit(from dbus.Sender, via, who, why string) (uint, *dbus.Error) {
+	return b.inhibitClasses(from, via, who, why, nil)
+}
+
+// inhibitClasses is inhibit for a request that asks for the what-classes classes itself, such as
+// org.gnome.SessionManager's. Rules still add theirs, and idle among them stands for Policy.What. nil takes Policy.What
+// alone. Electron apps that don't name themselves are named after their executable (see electronApp).
+func (b *Bridge) inhibitClasses(from dbus.Sender, via, who, why string, classes []string) (uint, *dbus.Error) {
+	if via != "" {
+		b.metrics.add(metricRequestsVia+via, 1)
+	}
+	var req lockRequest
+	if classes != nil {
+		who = b.electronWho(from, who)
+		req.base = classes
+		for _, c := range classes {
+			if c == policy.WhatIdle {
+				req.base = append(append([]string(nil), b.policy.What...), classes...)
+				break
+			}
+		}
+	}
+	return b.take(from, who, why, req)
+}
+
+// lockRequest is what a caller may ask of a lock beyond org.freedesktop.ScreenSaver.Inhibit.
+type lockRequest struct {
+	// what are the backend what-classes. Empty takes the classes the policy and rules give who/why.
+	what string
+	// base, if not nil, replaces Policy.What as the what-classes rules add to, when what is empty.
+	base []string
+	// ttl, if above 0, detaches the lock from its peer: it stays held after the peer leaves the bus, until it is
+	// released or ttl has passed.
+	ttl time.Duration
+	// delay only delays sleep and shutdown rather than blocking them. Rules may force it (see policy.Rule.Mode).
+	delay bool
+	// window, if set, detaches the lock from its peer like ttl, but until the window closes (see Options.Windows).
+	window string
+	// keepalive, if above 0, detaches the lock from its peer like ttl, but only until keepalive passes without a
+	// Keepalive for it.
+	keepalive time.Duration
+}
+
+// take hands out a lock to from, emitting LockDenied if it can't.
+func (b *Bridge) take(from dbus.Sender, who, why string, req lockRequest) (uint, *dbus.Error) {
+	id := newLockID()
+	// Ahead of the queue, so that a request held up doesn't keep others from a worker.
+	err := b.injectFault(id, from, who, why)
+	done := func() {}
+	if err == nil {
+		done, err = b.enqueue(id, from)
+	}
+	var cookie uint
+	if err == nil {
+		cookie, err = b.grant(id, from, who, why, req)
+		done()
+	}
+	if err != nil {
+		denied := Lock{ID: id, Peer: string(from), Who: policy.Sanitize(who), Why: policy.Sanitize(why), What: req.what, Window: req.window}
+		b.do("Inhibit", func() {
+			b.metrics.addFor(metricLocksDenied, id)
+			b.emit(Event{Type: LockDenied, Lock: denied, Message: err.Error()})
+		})
+	}
+	return cookie, err
+}
+
+// grant does the work of take, for the request with correlation ID id.
+func (b *Bridge) grant(id string, from dbus.Sender, who, why string, req lockRequest) (uint, *dbus.Error) {
+	who, why = policy.Sanitize(who), policy.Sanitize(why)
+
+	uid, err := b.peerUID(from)
+	if err != nil {
+		b.errLog.log("[%s] Inhibit from %q denied: %v\n", id, from, err)
+		return 0, newError(ErrorDenied, "%v", err)
+	}
+
+	proc, err := b.lookupPeerProcess(from)
+	if err != nil {
+		b.errLog.log("[%s] Couldn't identify process for %q: %v\n", id, from, err)
+	}
+	var app string
+	if proc != nil {
+		if app = flatpakApp(proc.pid); app != "" {
+			b.log.Debugf("[%s] %q is the Flatpak app %s.\n", id, from, app)
+		}
+	}
+	session := b.sessionClass(proc)
+
+	var (
+		derr    *dbus.Error
+		fd      *os.File
+		delayFd *os.File
+	)
+	what, explicit, noLock, delay := req.what, req.what != "", false, req.delay
+	self := from == b.Name()
+	degraded := false
+	if err := b.do("Inhibit", func() {
+		degraded = b.degraded
+		if !b.serving {
+			derr = newError(ErrorUnavailable, "waiting in line for %s", screensaver)
+			return
+		}
+		// The front-end's own locks are left alone: it looks them up again by the names it gave them.
+		if !self {
+			who, why = policy.Apply(b.rewrites, who, why)
+			why = policy.DefaultWhy(b.reasons, who, why)
+		}
+		if !explicit {
+			base := b.policy.What
+			if req.base != nil {
+				base = req.base
+			}
+			what = policy.What(base, b.rules, who, why)
+			noLock = policy.NoLock(b.rules, who, why)
+		}
+		if b.remote(session) && what != policy.WithoutSleep(what) {
+			b.log.Debugf("[%s] Not inhibiting sleep for %q from a %s session.\n", id, from, session)
+			what = policy.WithoutSleep(what)
+		}
+		delay = delay || policy.Delay(b.rules, who, why)
+		if what, derr = b.permitted(id, from, what, delay, explicit); derr != nil {
+			return
+		}
+		if derr = b.checkLimit(uid, from); derr != nil || explicit || delay {
+			return
+		}
+		var twin *lockDetails
+		metric := metricLocksShared
+		if _, q := b.quirksFor(app, who); q.DedupeTabs {
+			twin, metric = b.tabTwin(uid, from, what, why), metricTabsShared
+		}
+		if twin == nil && b.opts.DedupePortal {
+			twin, metric = b.portalTwin(uid, from, app, what, who, why), metricLocksShared
+		}
+		if twin != nil && !twin.downgraded {
+			var err error
+			if fd, err = shareFd(twin.fd); err != nil {
+				b.log.Debugf("[%s] Couldn't share the backend lock of %s: %v\n", id, twin, err)
+				return
+			}
+			b.log.Debugf("[%s] Sharing the backend lock of %s with %q.\n", id, twin, from)
+			b.metrics.addFor(metric, id)
+		}
+	}); err != nil {
+		return 0, err
+	}
+	if derr != nil {
+		return 0, derr
+	}
+
+	// Without a delayable class, a delay-mode lock is just a block-mode one.
+	if _, d := policy.SplitDelay(what); d == "" {
+		delay = false
+	}
+	jit := b.jit(what, delay)
+	if jit && fd == nil && !b.idleSoon() {
+		b.log.Debugf("[%s] Deferring the backend lock for %q until idle is near.\n", id, from)
+	} else if fd == nil {
+		inhibit := b.acquireInhibit
+		if degraded {
+			// Known to be down: one attempt tells whether it is back, without holding the caller up.
+			inhibit = b.backendInhibit
+		}
+		if delay {
+			fd, delayFd, err = splitInhibit(what, func(what, mode string) (*os.File, error) {
+				return inhibit(what, mode, who, why)
+			})
+		} else {
+			fd, err = inhibit(what, "block", who, why)
+		}
+		switch {
+		case err == nil && degraded:
+			b.do("Inhibit", b.backendBack)
+		case err != nil && b.opts.SoftFail:
+			b.do("Inhibit", func() { b.softFail(err) })
+			b.errLog.log("[%s] Inhibit for %q failed, issuing a no-op lock: %v\n", id, from, err)
+			b.metrics.addFor(metricLocksNoop, id)
+		case err != nil && b.opts.Provisional:
+			// Hand out a cookie anyway; the heartbeat acquires the lock once the backend becomes available.
+			b.errLog.log("[%s] Inhibit for %q failed, issuing a provisional cookie: %v\n", id, from, err)
+		case errors.Is(err, errBreakerOpen):
+			b.errLog.log("[%s] Inhibit for %q failed: %v\n", id, from, err)
+			return 0, newError(ErrorBackendDown, "%v", err)
+		case err != nil:
+			b.errLog.log("[%s] Inhibit for %q failed: %v\n", id, from, err)
+			return 0, newError(ErrorUnavailable, "%v", err)
+		}
+	}
+
+	var cookie uint
+	if err := b.do("Inhibit", func() {
+		// Checked again in case the peer raced itself past the first check.
+		if derr = b.checkLimit(uid, from); derr != nil {
+			return
+		}
+
+		ld := &lockDetails{
+			id:     id,
+			cookie: b.newCookie(uid, from),
+			peer:   from,
+			who:    who,
+			why:    why,
+			what:   what,
+			fd:     fd,
+			proc:   proc,
+			uid:    uid,
+			names:  b.owners.ownedBy(from),
+			app:    app,
+			since:  b.opts.Clock.Now(),
+		}
+		if req.ttl > 0 {
+			ld.expires = ld.since.Add(req.ttl)
+		}
+		if ld.interval = req.keepalive; ld.interval > 0 {
+			ld.expires = ld.since.Add(ld.interval)
+		}
+		if ld.window = req.window; ld.window != "" {
+			if err := b.watchWindow(ld); err != nil {
+				b.errLog.log("[%s] Inhibit for %q failed: can't follow window %s: %v\n", id, from, ld.window, err)
+				derr = newError(ErrorInvalidArgs, "can't follow window %s: %v", ld.window, err)
+				return
+			}
+		}
+		ld.noLock, ld.delay, ld.downgraded, ld.session, ld.jit = noLock, delayFd, delay, session, jit
+		b.locks[ld.key()] = ld
+		if !ld.pending() {
+			b.fds.track(ld.fd, ld.String())
+		}
+		if ld.delay != nil {
+			b.fds.track(ld.delay, ld.String())
+		}
+
+		mode := "block"
+		if delay {
+			mode = "delay"
+		}
+		b.log.Debugf("Inhibit: %s, what %s, mode %s\n", ld, ld.what, mode)
+		b.emit(Event{Type: LockAdded, Lock: ld.public()})
+		b.metrics.addFor(metricLocksGranted, ld.id)
+		b.apps.taken(ld, ld.since)
+		if !self {
+			b.userActive(ld.since)
+		}
+		b.wake()
+		cookie = ld.cookie
+	}); err != nil {
+		derr = err
+	}
+	if derr != nil {
+		if fd != nil {
+			fd.Close()
+		}
+		if delayFd != nil {
+			delayFd.Close()
+		}
+		return 0, derr
+	}
+
+	return cookie, nil
+}
+
+// checkLimit returns an ErrorLimit error if peer already holds Options.MaxLocksPerPeer locks. It must be called on
+// the actor.
+func (b *Bridge) checkLimit(uid uint32, peer dbus.Sender) *dbus.Error {
+	if b.opts.MaxLocksPerPeer <= 0 {
+		return nil
+	}
+	n := 0
+	for k := range b.locks {
+		if k.uid == uid && k.peer == peer {
+			n++
+		}
+	}
+	if n < b.opts.MaxLocksPerPeer {
+		return nil
+	}
+	b.errLog.log("Inhibit from %q denied: already holds %d locks\n", peer, n)
+	return newError(ErrorLimit, "%q already holds %d locks", peer, n)
+}
+
+// newLockID returns a correlation ID for a lock request. It follows the request from the policy decisions on it through
+// the lock's heartbeats to its release, in log lines, where it is printed in brackets, the Removed signal and metric
+// exemplars (see Bridge.Exemplars), and survives hot upgrades, takeovers and crashes with the lock. Unlike the cookie,
+// which is only unique among the locks held at the same time and may be handed out again once its lock is released,
+// it tells apart the locks of the bridge's lifetime, though only with high probability: being 64 random bits, two of
+// them share one with a chance of about one in 37 million once there have been a million requests, and of one in two
+// after some 5 billion.
+func newLockID() string {
+	return fmt.Sprintf("%016x", rand.Uint64())
+}
+
+// newCookie returns a random, non-zero cookie that isn't already in use within the namespace of peer, nor by any
+// other peer's lock object (see lockPath), so that it is unique across peers. It must be called on the actor.
+func (b *Bridge) newCookie(uid uint32, peer dbus.Sender) uint {
+	for {
+		c := uint(rand.Uint32())
+		if c == 0 {
+			continue
+		}
+		if _, ok := b.objects[lockPath(c)]; ok {
+			continue
+		}
+		if _, ok := b.locks[lockKey{uid: uid, peer: peer, cookie: c}]; !ok {
+			return c
+		}
+	}
+}
+
+func (b *Bridge) unInhibit(from dbus.Sender, cookie uint32) *dbus.Error {
+	uid, err := b.peerUID(from)
+	if err != nil {
+		b.errLog.log("UnInhibit from %q denied: %v\n", from, err)
+		return newError(ErrorDenied, "%v", err)
+	}
+
+	proc, err := b.lookupPeerProcess(from)
+	if err != nil {
+		b.errLog.log("Couldn't identify process for %q: %v\n", from, err)
+	}
+
+	var derr *dbus.Error
+	if err := b.do("UnInhibit", func() {
+		// Locks are only ever looked up within the caller's own namespace, so an unknown cookie and one belonging
+		// to another peer are indistinguishable to the caller.
+		ld, ok := b.locks[lockKey{uid: uid, peer: from, cookie: uint(cookie)}]
+		if !ok {
+			b.errLog.log("UnInhibit with invalid cookie %d from %q\n", cookie, from)
+			derr = newError(ErrorInvalidCookie, "%d is an invalid cookie for %q", cookie, from)
+			return
+		}
+
+		if ld.proc != nil && (proc == nil || *proc != *ld.proc) {
+			b.errLog.log("[%s] UnInhibit of cookie %d denied; process behind %q changed\n", ld.id, cookie, from)
+			derr = newError(ErrorDenied, "process behind %q no longer matches the one holding cookie %d", from, cookie)
+			return
+		}
+
+		if err := b.dropLock(ld, "released by peer"); err != nil {
+			derr = newError(ErrorInternal, "%v", err)
+			return
+		}
+
+		b.metrics.addFor(metricLocksReleased, ld.id)
+		b.log.Debugf("UnInhibit: %s, held %s\n", ld, ld.held(b.opts.Clock.Now()).Round(time.Second))
+		b.userActive(b.opts.Clock.Now())
+	}); err != nil {
+		return err
+	}
+
+	return derr
+}
+
+// dropLock removes ld from the lock table, releases its backend lock, records how long it was held and announces it
+// with LockRemoved and the Removed signal, with reason. It must be called on the actor.
+func (b *Bridge) dropLock(ld *lockDetails, reason string) error {
+	now := b.opts.Clock.Now()
+	delete(b.locks, ld.key())
+	b.emit(Event{Type: LockRemoved, Lock: ld.public(), Message: reason, Held: ld.held(now)})
+	b.signalRemoved(ld, now, reason)
+	b.metrics.observe(metricLocksHeld, heldBuckets, ld.held(now), ld.id)
+	b.apps.released(ld, now)
+	if ld.unwatch != nil {
+		ld.unwatch()
+	}
+	if ld.pending() {
+		return nil
+	}
+	b.fds.untrack(ld.fd)
+	if ld.delay != nil {
+		b.fds.untrack(ld.delay)
+		ld.delay.Close()
+	}
+
+	if err := ld.fd.Close(); err != nil {
+		return fmt.Errorf("[%s] failed to close clock for cookie %d -> %s", ld.id, ld.cookie, ld.fd.Name())
+	}
+
+	return nil
+}
@@ -0,0 +1,212 @@
+package bridge_test
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/coltwillcox/inhibitor/pkg/bridge"
+	"github.com/coltwillcox/inhibitor/pkg/bridge/bridgetest"
+	"github.com/godbus/dbus/v5"
+)
+
+const (
+	self  = dbus.Sender(":1.1")
+	alice = dbus.Sender(":1.42")
+	mal   = dbus.Sender(":1.43")
+	bob   = dbus.Sender(":1.44")
+
+	aliceUID = 1000 // mal's too
+	bobUID   = 1001
+)
+
+// fakes is a bridge running on the bridgetest fakes, with alice and mal connected as one user and bob as another,
+// neither of them root.
+type fakes struct {
+	bus   *bridgetest.Bus
+	be    *bridgetest.Backend
+	clock *bridgetest.Clock
+	b     *bridge.Bridge
+}
+
+func newFakes(t testing.TB, opts bridge.Options) *fakes {
+	t.Helper()
+	f := &fakes{
+		bus:   bridgetest.NewBus(string(self)),
+		be:    bridgetest.NewBackend(),
+		clock: bridgetest.NewClock(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)),
+	}
+	f.bus.AddPeer(alice, os.Getpid(), aliceUID)
+	f.bus.AddPeer(mal, os.Getpid(), aliceUID)
+	f.bus.AddPeer(bob, os.Getpid(), bobUID)
+
+	if opts.Prog == "" {
+		opts.Prog = "test"
+	}
+	b, err := bridge.NewBridge(context.Background(), opts, bridge.WithBus(f.bus), bridge.WithBackend(f.be), bridge.WithClock(f.clock))
+	if err != nil {
+		t.Fatalf("NewBridge() failed: %v", err)
+	}
+	t.Cleanup(func() {
+		b.Close()
+		f.be.Close()
+	})
+	f.b = b
+	return f
+}
+
+// holds reports whether the bridge holds a lock with the given cookie for peer.
+func (f *fakes) holds(peer dbus.Sender, cookie uint32) bool {
+	for _, l := range f.b.Locks() {
+		if dbus.Sender(l.Peer) == peer && l.Cookie == cookie {
+			return true
+		}
+	}
+	return false
+}
+
+func TestInhibit(t *testing.T) {
+	f := newFakes(t, bridge.Options{})
+
+	cookie, err := f.b.Inhibit(alice, "Firefox", "video-playing")
+	if err != nil {
+		t.Fatalf("Inhibit() failed: %v", err)
+	}
+	locks := f.b.Locks()
+	if len(locks) != 1 {
+		t.Fatalf("Locks() = %v, want a single lock", locks)
+	}
+	if l := locks[0]; l.Cookie != cookie || dbus.Sender(l.Peer) != alice || l.Who != "Firefox" || l.Why != "video-playing" || l.UID != aliceUID {
+		t.Errorf("Locks()[0] = %+v, want cookie %d for %q from %q", l, cookie, "Firefox", alice)
+	}
+	if calls := f.be.Calls(); len(calls) != 1 || calls[0].Who != "test" || calls[0].Why != "Firefox video-playing" {
+		t.Errorf("backend calls = %+v, want a single one on behalf of %q", calls, "test")
+	}
+	if got := f.b.TrackedFds(); got != 1 {
+		t.Errorf("TrackedFds() = %d, want 1", got)
+	}
+
+	other, err := f.b.Inhibit(alice, "Firefox", "another tab")
+	if err != nil {
+		t.Fatalf("second Inhibit() failed: %v", err)
+	}
+	if other == cookie {
+		t.Errorf("second Inhibit() reused cookie %d", cookie)
+	}
+}
+
+func TestInhibitBackendFailure(t *testing.T) {
+	f := newFakes(t, bridge.Options{})
+	f.be.Fail(os.ErrPermission)
+
+	if _, err := f.b.Inhibit(alice, "app", "testing"); err == nil {
+		t.Errorf("Inhibit() succeeded with a failing backend")
+	}
+	if locks := f.b.Locks(); len(locks) != 0 {
+		t.Errorf("Locks() = %v, want none", locks)
+	}
+	if got := f.b.TrackedFds(); got != 0 {
+		t.Errorf("TrackedFds() = %d, want 0", got)
+	}
+}
+
+func TestUnInhibit(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		from    dbus.Sender
+		cookie  func(uint32) uint32
+		wantErr bool
+	}{
+		{name: "owner", from: alice, cookie: func(c uint32) uint32 { return c }},
+		{name: "other peer of the same user", from: mal, cookie: func(c uint32) uint32 { return c }, wantErr: true},
+		{name: "other user", from: bob, cookie: func(c uint32) uint32 { return c }, wantErr: true},
+		{name: "unknown cookie", from: alice, cookie: func(c uint32) uint32 { return c + 1 }, wantErr: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			f := newFakes(t, bridge.Options{})
+			cookie, err := f.b.Inhibit(alice, "app", "testing")
+			if err != nil {
+				t.Fatalf("Inhibit() failed: %v", err)
+			}
+
+			err = f.b.UnInhibit(tc.from, tc.cookie(cookie))
+			if gotErr := err != nil; gotErr != tc.wantErr {
+				t.Fatalf("UnInhibit(%q) = %v, want error: %t", tc.from, err, tc.wantErr)
+			}
+			if got, want := f.holds(alice, cookie), tc.wantErr; got != want {
+				t.Errorf("lock held after UnInhibit(%q): %t, want %t", tc.from, got, want)
+			}
+			if got, want := f.b.TrackedFds(), len(f.b.Locks()); got != want {
+				t.Errorf("TrackedFds() = %d, want %d", got, want)
+			}
+		})
+	}
+}
+
+func TestUnInhibitTwice(t *testing.T) {
+	f := newFakes(t, bridge.Options{})
+	cookie, err := f.b.Inhibit(alice, "app", "testing")
+	if err != nil {
+		t.Fatalf("Inhibit() failed: %v", err)
+	}
+	if err := f.b.UnInhibit(alice, cookie); err != nil {
+		t.Fatalf("UnInhibit() failed: %v", err)
+	}
+	if err := f.b.UnInhibit(alice, cookie); err == nil {
+		t.Errorf("second UnInhibit() succeeded")
+	}
+}
+
+func TestHeartbeatReap(t *testing.T) {
+	f := newFakes(t, bridge.Options{})
+	gone, err := f.b.Inhibit(alice, "app", "testing")
+	if err != nil {
+		t.Fatalf("Inhibit() failed: %v", err)
+	}
+	kept, err := f.b.Inhibit(bob, "app", "testing")
+	if err != nil {
+		t.Fatalf("Inhibit() failed: %v", err)
+	}
+
+	f.b.HeartbeatTick()
+	if !f.holds(alice, gone) || !f.holds(bob, kept) {
+		t.Fatalf("heartbeat dropped a lock of a connected peer: %v", f.b.Locks())
+	}
+
+	f.bus.RemovePeer(alice)
+	f.b.HeartbeatTick()
+	if f.holds(alice, gone) {
+		t.Errorf("heartbeat kept the lock of %q, which left the bus", alice)
+	}
+	if !f.holds(bob, kept) {
+		t.Errorf("heartbeat dropped the lock of %q, which is still connected", bob)
+	}
+	if got := f.b.TrackedFds(); got != 1 {
+		t.Errorf("TrackedFds() = %d, want 1", got)
+	}
+	if got := f.b.Metrics()["locks_reaped"]; got != 1 {
+		t.Errorf("locks_reaped = %d, want 1", got)
+	}
+}
+
+func TestHeartbeatExpiry(t *testing.T) {
+	f := newFakes(t, bridge.Options{})
+	cookie, err := f.b.InhibitWhat(alice, "app", "testing", "sleep", time.Minute)
+	if err != nil {
+		t.Fatalf("InhibitWhat() failed: %v", err)
+	}
+
+	// A detached lock outlives its peer until it expires.
+	f.bus.RemovePeer(alice)
+	f.b.HeartbeatTick()
+	if !f.holds(alice, cookie) {
+		t.Fatalf("heartbeat dropped a lock that hasn't expired")
+	}
+
+	f.clock.Set(f.clock.Now().Add(2 * time.Minute))
+	f.b.HeartbeatTick()
+	if f.holds(alice, cookie) {
+		t.Errorf("heartbeat kept a lock past its expiry")
+	}
+}
@@ -0,0 +1,62 @@
+package bridgetest
+
+import (
+	"context"
+	"os"
+	"sync"
+)
+
+// Inhibit is one call made to a fake Backend.
+type Inhibit struct {
+	What, Who, Why, Mode string
+}
+
+// Backend is a fake backend.Backend that hands out the read end of a pipe for each lock. It closes the write end
+// straight away, so that a lock holds a single fd, which is gone once the bridge releases the lock. The zero value is
+// not usable; use NewBackend.
+type Backend struct {
+	mtx   sync.Mutex
+	calls []Inhibit
+	err   error
+}
+
+// NewBackend returns a fake backend that grants every lock.
+func NewBackend() *Backend {
+	return &Backend{}
+}
+
+// Fail makes subsequent Inhibit calls return err, or succeed again if err is nil.
+func (fb *Backend) Fail(err error) {
+	fb.mtx.Lock()
+	defer fb.mtx.Unlock()
+	fb.err = err
+}
+
+// Calls returns every Inhibit call made so far, including failed ones.
+func (fb *Backend) Calls() []Inhibit {
+	fb.mtx.Lock()
+	defer fb.mtx.Unlock()
+	return append([]Inhibit(nil), fb.calls...)
+}
+
+// Inhibit implements backend.Backend.
+func (fb *Backend) Inhibit(ctx context.Context, what, who, why, mode string) (*os.File, error) {
+	fb.mtx.Lock()
+	defer fb.mtx.Unlock()
+	fb.calls = append(fb.calls, Inhibit{What: what, Who: who, Why: why, Mode: mode})
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if fb.err != nil {
+		return nil, fb.err
+	}
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	w.Close()
+	return r, nil
+}
+
+// Close implements backend.Backend. The fake holds nothing open.
+func (fb *Backend) Close() {}
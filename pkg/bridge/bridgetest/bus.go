@@ -0,0 +1,365 @@
+// Package bridgetest provides in-memory fakes of the bus, the backend and the clock a bridge.Bridge runs on, so that
+// bridge behaviour (inhibit, uninhibit, peer heartbeats, name owner changes, lock expiry) can be exercised without a
+// live bus or logind, and without waiting.
+//
+//	bus := bridgetest.NewBus(":1.1")
+//	bus.AddPeer(":1.42", os.Getpid(), uint32(os.Getuid()))
+//	be := bridgetest.NewBackend()
+//	b, err := bridge.NewBridge(ctx, bridge.Options{Prog: "test"}, bridge.WithBus(bus), bridge.WithBackend(be))
+//	...
+//	cookie, err := b.Inhibit(":1.42", "app", "testing")
+//	bus.RemovePeer(":1.42") // the next heartbeat drops the lock
+package bridgetest
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/godbus/dbus/v5"
+)
+
+const (
+	busName     = "org.freedesktop.DBus"
+	busPath     = "/org/freedesktop/DBus"
+	errNameLost = "org.freedesktop.DBus.Error.NameHasNoOwner"
+
+	introspectable = "org.freedesktop.DBus.Introspectable"
+)
+
+// Peer is a fake connection on the bus.
+type Peer struct {
+	PID int
+	UID uint32
+}
+
+// Bus is a fake bridge.Bus. The zero value is not usable; use NewBus.
+type Bus struct {
+	mtx      sync.Mutex
+	name     string
+	peers    map[dbus.Sender]Peer
+	owners   map[string]dbus.Sender
+	exports  map[dbus.ObjectPath]map[string]interface{}
+	signals  []chan<- *dbus.Signal
+	handlers map[string]func(args ...interface{}) ([]interface{}, error)
+	closed   bool
+}
+
+// NewBus returns a fake bus on which the bridge has the unique name name and is the only peer, running as the
+// calling process.
+func NewBus(name string) *Bus {
+	return &Bus{
+		name:     name,
+		peers:    map[dbus.Sender]Peer{dbus.Sender(name): {PID: os.Getpid(), UID: uint32(os.Getuid())}},
+		owners:   make(map[string]dbus.Sender),
+		exports:  make(map[dbus.ObjectPath]map[string]interface{}),
+		handlers: make(map[string]func(args ...interface{}) ([]interface{}, error)),
+	}
+}
+
+// AddPeer connects a peer with the given unique name, process and user.
+func (fb *Bus) AddPeer(peer dbus.Sender, pid int, uid uint32) {
+	fb.mtx.Lock()
+	defer fb.mtx.Unlock()
+	fb.peers[peer] = Peer{PID: pid, UID: uid}
+}
+
+// RemovePeer disconnects a peer, dropping any well-known names it owned and signalling the change.
+func (fb *Bus) RemovePeer(peer dbus.Sender) {
+	fb.mtx.Lock()
+	delete(fb.peers, peer)
+	var lost []string
+	for n, o := range fb.owners {
+		if o == peer {
+			delete(fb.owners, n)
+			lost = append(lost, n)
+		}
+	}
+	fb.mtx.Unlock()
+
+	for _, n := range lost {
+		fb.ownerChanged(n, string(peer), "")
+	}
+	fb.ownerChanged(string(peer), string(peer), "")
+}
+
+// SetOwner moves the well-known name to owner, or releases it if owner is empty, and signals the change.
+func (fb *Bus) SetOwner(name string, owner dbus.Sender) {
+	fb.mtx.Lock()
+	old := fb.owners[name]
+	if owner == "" {
+		delete(fb.owners, name)
+	} else {
+		fb.owners[name] = owner
+	}
+	fb.mtx.Unlock()
+
+	fb.ownerChanged(name, string(old), string(owner))
+}
+
+// Handle makes calls to method (e.g. "org.freedesktop.PolicyKit1.Authority.CheckAuthorization") on any object other
+// than the bus itself return whatever fn returns.
+func (fb *Bus) Handle(method string, fn func(args ...interface{}) ([]interface{}, error)) {
+	fb.mtx.Lock()
+	defer fb.mtx.Unlock()
+	fb.handlers[method] = fn
+}
+
+// Exported returns the value exported at path under iface, or nil.
+func (fb *Bus) Exported(path dbus.ObjectPath, iface string) interface{} {
+	fb.mtx.Lock()
+	defer fb.mtx.Unlock()
+	return fb.exports[path][iface]
+}
+
+// Emit delivers sig to every channel registered with Signal. It blocks while any of them is full.
+func (fb *Bus) Emit(sig *dbus.Signal) {
+	fb.mtx.Lock()
+	defer fb.mtx.Unlock()
+	if fb.closed {
+		return
+	}
+	for _, ch := range fb.signals {
+		ch <- sig
+	}
+}
+
+func (fb *Bus) ownerChanged(name, old, new string) {
+	fb.Emit(&dbus.Signal{
+		Sender: busName,
+		Path:   busPath,
+		Name:   busName + ".NameOwnerChanged",
+		Body:   []interface{}{name, old, new},
+	})
+}
+
+// Names implements bridge.Bus.
+func (fb *Bus) Names() []string {
+	return []string{fb.name}
+}
+
+// BusObject implements bridge.Bus.
+func (fb *Bus) BusObject() dbus.BusObject {
+	return fb.Object(busName, busPath)
+}
+
+// Object implements bridge.Bus.
+func (fb *Bus) Object(dest string, path dbus.ObjectPath) dbus.BusObject {
+	return &object{bus: fb, dest: dest, path: path}
+}
+
+// Export implements bridge.Bus. Exporting nil removes the export.
+func (fb *Bus) Export(v interface{}, path dbus.ObjectPath, iface string) error {
+	fb.mtx.Lock()
+	defer fb.mtx.Unlock()
+	if v == nil {
+		delete(fb.exports[path], iface)
+		return nil
+	}
+	if fb.exports[path] == nil {
+		fb.exports[path] = make(map[string]interface{})
+	}
+	fb.exports[path][iface] = v
+	return nil
+}
+
+// AddMatchSignal implements bridge.Bus. Every signal is delivered regardless of match rules.
+func (fb *Bus) AddMatchSignal(options ...dbus.MatchOption) error {
+	return nil
+}
+
+// Signal implements bridge.Bus.
+func (fb *Bus) Signal(ch chan<- *dbus.Signal) {
+	fb.mtx.Lock()
+	defer fb.mtx.Unlock()
+	fb.signals = append(fb.signals, ch)
+}
+
+// RequestName implements bridge.Bus.
+func (fb *Bus) RequestName(name string, flags dbus.RequestNameFlags) (dbus.RequestNameReply, error) {
+	fb.mtx.Lock()
+	defer fb.mtx.Unlock()
+	if o, ok := fb.owners[name]; ok && o != dbus.Sender(fb.name) {
+		return dbus.RequestNameReplyExists, nil
+	}
+	fb.owners[name] = dbus.Sender(fb.name)
+	return dbus.RequestNameReplyPrimaryOwner, nil
+}
+
+// Close implements bridge.Bus. Like a real connection, it closes every channel registered with Signal.
+func (fb *Bus) Close() error {
+	fb.mtx.Lock()
+	defer fb.mtx.Unlock()
+	if fb.closed {
+		return nil
+	}
+	fb.closed = true
+	for _, ch := range fb.signals {
+		close(ch)
+	}
+	return nil
+}
+
+// call answers the org.freedesktop.DBus methods the bridge uses from the fake's state, and everything else from the
+// handlers registered with Handle.
+func (fb *Bus) call(dest string, method string, args ...interface{}) ([]interface{}, error) {
+	if dest != busName {
+		fb.mtx.Lock()
+		fn, ok := fb.handlers[method]
+		closed := fb.closed
+		fb.mtx.Unlock()
+		if closed {
+			return nil, dbus.ErrClosed
+		}
+		if !ok {
+			return nil, dbus.MakeFailedError(fmt.Errorf("no handler for %s", method))
+		}
+		return fn(args...)
+	}
+
+	fb.mtx.Lock()
+	defer fb.mtx.Unlock()
+	if fb.closed {
+		return nil, dbus.ErrClosed
+	}
+
+	peer := func() (Peer, error) {
+		if len(args) != 1 {
+			return Peer{}, dbus.MakeFailedError(fmt.Errorf("%s: want 1 argument, got %d", method, len(args)))
+		}
+		s, _ := args[0].(string)
+		p, ok := fb.peers[dbus.Sender(s)]
+		if !ok {
+			return Peer{}, dbus.NewError(errNameLost, []interface{}{s})
+		}
+		return p, nil
+	}
+
+	switch method {
+	case busName + ".ListNames":
+		var names []string
+		for p := range fb.peers {
+			names = append(names, string(p))
+		}
+		for n := range fb.owners {
+			names = append(names, n)
+		}
+		return []interface{}{names}, nil
+	case busName + ".GetNameOwner":
+		if len(args) == 1 {
+			if n, ok := args[0].(string); ok {
+				if o, ok := fb.owners[n]; ok {
+					return []interface{}{string(o)}, nil
+				}
+			}
+		}
+		return nil, dbus.NewError(errNameLost, args)
+	case busName + ".NameHasOwner":
+		if len(args) == 1 {
+			if n, ok := args[0].(string); ok {
+				_, peer := fb.peers[dbus.Sender(n)]
+				_, owned := fb.owners[n]
+				return []interface{}{peer || owned}, nil
+			}
+		}
+		return nil, dbus.MakeFailedError(fmt.Errorf("%s: want 1 string argument", method))
+	case busName + ".GetConnectionUnixProcessID":
+		p, err := peer()
+		if err != nil {
+			return nil, err
+		}
+		return []interface{}{uint32(p.PID)}, nil
+	case busName + ".GetConnectionUnixUser":
+		p, err := peer()
+		if err != nil {
+			return nil, err
+		}
+		return []interface{}{p.UID}, nil
+	}
+	return nil, dbus.NewError("org.freedesktop.DBus.Error.UnknownMethod", []interface{}{method})
+}
+
+// introspect answers a call the bridge makes to itself (see bridge.Options.Watchdog) from what it exported at path.
+func (fb *Bus) introspect(path dbus.ObjectPath) ([]interface{}, error) {
+	fb.mtx.Lock()
+	v, _ := fb.exports[path][introspectable].(interface {
+		Introspect() (string, *dbus.Error)
+	})
+	fb.mtx.Unlock()
+	if v == nil {
+		return nil, dbus.NewError("org.freedesktop.DBus.Error.UnknownObject", []interface{}{string(path)})
+	}
+	xml, err := v.Introspect()
+	if err != nil {
+		return nil, err
+	}
+	return []interface{}{xml}, nil
+}
+
+// object is a dbus.BusObject on a fake Bus. Calls complete synchronously.
+type object struct {
+	bus  *Bus
+	dest string
+	path dbus.ObjectPath
+}
+
+func (o *object) Call(method string, flags dbus.Flags, args ...interface{}) *dbus.Call {
+	return o.CallWithContext(context.Background(), method, flags, args...)
+}
+
+func (o *object) CallWithContext(ctx context.Context, method string, flags dbus.Flags, args ...interface{}) *dbus.Call {
+	c := &dbus.Call{Destination: o.dest, Path: o.path, Method: method, Args: args}
+	if err := ctx.Err(); err != nil {
+		c.Err = err
+		return c
+	}
+	if o.dest == o.bus.name && method == introspectable+".Introspect" {
+		c.Body, c.Err = o.bus.introspect(o.path)
+		return c
+	}
+	c.Body, c.Err = o.bus.call(o.dest, method, args...)
+	return c
+}
+
+func (o *object) Go(method string, flags dbus.Flags, ch chan *dbus.Call, args ...interface{}) *dbus.Call {
+	return o.GoWithContext(context.Background(), method, flags, ch, args...)
+}
+
+func (o *object) GoWithContext(ctx context.Context, method string, flags dbus.Flags, ch chan *dbus.Call, args ...interface{}) *dbus.Call {
+	c := o.CallWithContext(ctx, method, flags, args...)
+	if ch == nil {
+		ch = make(chan *dbus.Call, 1)
+	}
+	c.Done = ch
+	ch <- c
+	return c
+}
+
+func (o *object) AddMatchSignal(iface, member string, options ...dbus.MatchOption) *dbus.Call {
+	return &dbus.Call{}
+}
+
+func (o *object) RemoveMatchSignal(iface, member string, options ...dbus.MatchOption) *dbus.Call {
+	return &dbus.Call{}
+}
+
+func (o *object) GetProperty(p string) (dbus.Variant, error) {
+	return dbus.Variant{}, dbus.MakeFailedError(fmt.Errorf("no property %s", p))
+}
+
+func (o *object) StoreProperty(p string, value interface{}) error {
+	return dbus.MakeFailedError(fmt.Errorf("no property %s", p))
+}
+
+func (o *object) SetProperty(p string, v interface{}) error {
+	return dbus.MakeFailedError(fmt.Errorf("no property %s", p))
+}
+
+func (o *object) Destination() string {
+	return o.dest
+}
+
+func (o *object) Path() dbus.ObjectPath {
+	return o.path
+}
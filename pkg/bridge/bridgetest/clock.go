@@ -0,0 +1,128 @@
+package bridgetest
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/coltwillcox/inhibitor/pkg/bridge"
+)
+
+// Clock is a fake bridge.Clock that only moves when Advance is called, so that heartbeats, lock expiry and the like
+// happen exactly when a test says. The zero value is not usable; use NewClock.
+//
+//	clock := bridgetest.NewClock(time.Date(2024, 3, 31, 1, 0, 0, 0, time.UTC))
+//	b, err := bridge.NewBridge(ctx, opts, bridge.WithClock(clock))
+//	...
+//	clock.Advance(time.Minute) // runs every timer due within the minute, in order
+type Clock struct {
+	mtx    sync.Mutex
+	now    time.Time
+	timers []*timer
+}
+
+// NewClock returns a fake clock that reads start.
+func NewClock(start time.Time) *Clock {
+	return &Clock{now: start}
+}
+
+// Now implements bridge.Clock.
+func (c *Clock) Now() time.Time {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return c.now
+}
+
+// NewTimer implements bridge.Clock.
+func (c *Clock) NewTimer(d time.Duration) bridge.Timer {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	t := &timer{c: c, ch: make(chan time.Time, 1)}
+	c.schedule(t, d)
+	return t
+}
+
+// Advance moves the clock forward by d, firing the timers that fall due on the way in the order they do. The clock
+// reads each timer's deadline as it fires, so that whatever the timer wakes up sees the time it was due.
+func (c *Clock) Advance(d time.Duration) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	end := c.now.Add(d)
+	for len(c.timers) > 0 && !c.timers[0].when.After(end) {
+		t := c.timers[0]
+		c.timers = c.timers[1:]
+		t.active = false
+		if t.when.After(c.now) {
+			c.now = t.when
+		}
+		select {
+		case t.ch <- c.now:
+		default:
+			// Like a time.Timer, a fired timer nobody read keeps its first time.
+		}
+	}
+	c.now = end
+}
+
+// Set moves the clock to now without firing anything, as a stepped wall clock would. It may go backwards.
+func (c *Clock) Set(now time.Time) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.now = now
+}
+
+// Timers returns how many timers are waiting to fire.
+func (c *Clock) Timers() int {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return len(c.timers)
+}
+
+// schedule makes t fire d from now. c.mtx must be held.
+func (c *Clock) schedule(t *timer, d time.Duration) {
+	t.when = c.now.Add(d)
+	t.active = true
+	c.timers = append(c.timers, t)
+	sort.SliceStable(c.timers, func(i, j int) bool { return c.timers[i].when.Before(c.timers[j].when) })
+}
+
+// unschedule keeps t from firing and reports whether it was going to. c.mtx must be held.
+func (c *Clock) unschedule(t *timer) bool {
+	if !t.active {
+		return false
+	}
+	t.active = false
+	for i, o := range c.timers {
+		if o == t {
+			c.timers = append(c.timers[:i], c.timers[i+1:]...)
+			break
+		}
+	}
+	return true
+}
+
+// timer is a bridge.Timer of a Clock.
+type timer struct {
+	c      *Clock
+	ch     chan time.Time
+	when   time.Time
+	active bool
+}
+
+func (t *timer) C() <-chan time.Time {
+	return t.ch
+}
+
+func (t *timer) Reset(d time.Duration) bool {
+	t.c.mtx.Lock()
+	defer t.c.mtx.Unlock()
+	active := t.c.unschedule(t)
+	t.c.schedule(t, d)
+	return active
+}
+
+func (t *timer) Stop() bool {
+	t.c.mtx.Lock()
+	defer t.c.mtx.Unlock()
+	return t.c.unschedule(t)
+}
@@ -0,0 +1,20 @@
+package bridge
+
+import (
+	"github.com/godbus/dbus/v5"
+)
+
+// Bus is the subset of *dbus.Conn the bridge uses. It exists so that the bridge can be driven without a live bus;
+// see the bridgetest package for a fake.
+type Bus interface {
+	Names() []string
+	BusObject() dbus.BusObject
+	Object(dest string, path dbus.ObjectPath) dbus.BusObject
+	Export(v interface{}, path dbus.ObjectPath, iface string) error
+	AddMatchSignal(options ...dbus.MatchOption) error
+	Signal(ch chan<- *dbus.Signal)
+	RequestName(name string, flags dbus.RequestNameFlags) (dbus.RequestNameReply, error)
+	Close() error
+}
+
+var _ Bus = (*dbus.Conn)(nil)
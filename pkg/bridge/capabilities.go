@@ -0,0 +1,93 @@
+package bridge
+
+import (
+	"sort"
+
+	"github.com/coltwillcox/inhibitor/pkg/backend"
+	"github.com/coltwillcox/inhibitor/pkg/policy"
+	"github.com/godbus/dbus/v5"
+)
+
+// The categories of Capabilities.
+const (
+	// CapInterfaces are the D-Bus names served: org.freedesktop.ScreenSaver while owned, the management interface,
+	// the lock objects' interface and any compat names claimed.
+	CapInterfaces = "interfaces"
+	// CapPaths are the object paths org.freedesktop.ScreenSaver is served on.
+	CapPaths = "paths"
+	// CapBackend names the backend locks are taken from, followed by the optional abilities it has.
+	CapBackend = "backend"
+	// CapFeatures are the optional behaviours enabled in the running bridge, e.g. "jit" or "hot-upgrade".
+	CapFeatures = "features"
+	// CapDenied are the what-classes and modes, as class/mode (e.g. "sleep/block"), the backend was found at startup
+	// to refuse this user. Locks leave them out.
+	CapDenied = "denied"
+)
+
+// Capabilities describes what the running bridge offers, by category (see CapInterfaces and the like), so that
+// clients and applets can adapt to it rather than to a version number. Each list is sorted.
+func (b *Bridge) Capabilities() map[string][]string {
+	caps := map[string][]string{CapInterfaces: {ControlInterface, LockInterface}, CapPaths: {string(screensaverPath)}}
+	b.do("Capabilities", func() {
+		if b.serving {
+			caps[CapInterfaces] = append(caps[CapInterfaces], screensaver)
+		}
+		if b.legacy {
+			caps[CapPaths] = append(caps[CapPaths], string(legacyPath))
+		}
+		caps[CapInterfaces] = append(caps[CapInterfaces], b.compat...)
+		for p := range b.paths {
+			caps[CapPaths] = append(caps[CapPaths], string(p))
+		}
+		caps[CapDenied] = b.deniedList()
+	})
+
+	be := []string{"custom"}
+	if _, ok := b.backend.(*backend.Logind); ok {
+		be[0] = "logind"
+	}
+	if _, ok := b.backend.(backend.IdleHinter); ok {
+		be = append(be, "idle-hint")
+	}
+	if _, ok := b.backend.(backend.SessionClasser); ok {
+		be = append(be, "session-class")
+	}
+	if _, ok := b.backend.(backend.Prober); ok {
+		be = append(be, "probe")
+	}
+	caps[CapBackend] = be
+
+	features := []string{}
+	for name, on := range map[string]bool{
+		"system":        b.opts.System,
+		"strict":        b.policy.Strict,
+		"provisional":   b.opts.Provisional,
+		"max-block":     b.opts.MaxBlock > 0,
+		"jit":           b.jit(policy.WhatIdle, false),
+		"idle-hint":     b.opts.IdleHint,
+		"remote-sleep":  b.opts.RemoteSleep,
+		"dedupe-portal": b.opts.DedupePortal,
+		"queue":         b.opts.Queue,
+		"hot-upgrade":   b.opts.Upgrade != nil,
+		"takeover":      b.opts.HandedOff != nil,
+		"window-locks":  b.opts.Windows != nil,
+		"persistent":    b.opts.Store != nil,
+		"soft-fail":     b.opts.SoftFail,
+	} {
+		if on {
+			features = append(features, name)
+		}
+	}
+	caps[CapFeatures] = features
+
+	for _, c := range []string{CapInterfaces, CapPaths, CapFeatures} {
+		sort.Strings(caps[c])
+	}
+	return caps
+}
+
+// GetCapabilities returns what the running bridge offers (see Bridge.Capabilities).
+func (c *controlAPI) GetCapabilities() (caps map[string][]string, err *dbus.Error) {
+	defer c.b.recoverPanic("GetCapabilities", &err)
+	return c.b.Capabilities(), nil
+}
@@ -0,0 +1,358 @@
+package bridge
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/coltwillcox/inhibitor/pkg/policy"
+	"github.com/godbus/dbus/v5"
+	"github.com/godbus/dbus/v5/introspect"
+)
+
+// Other inhibit APIs that some applications use instead of org.freedesktop.ScreenSaver.
+const (
+	powerManagement      = "org.freedesktop.PowerManagement"
+	powerManagementPath  = "/org/freedesktop/PowerManagement/Inhibit"
+	powerManagementIface = "org.freedesktop.PowerManagement.Inhibit"
+	gnomeSession         = "org.gnome.SessionManager"
+	gnomeSessionPath     = "/org/gnome/SessionManager"
+	mateSession          = "org.mate.SessionManager"
+	mateSessionPath      = "/org/mate/SessionManager"
+	kdePolicyAgent       = "org.kde.Solid.PowerManagement.PolicyAgent"
+	kdePolicyAgentPath   = "/org/kde/Solid/PowerManagement/PolicyAgent"
+
+	// The org.gnome.SessionManager inhibit flags, which MATE's fork of it shares.
+	gnomeInhibitLogout     = 1
+	gnomeInhibitSwitchUser = 2
+	gnomeInhibitSuspend    = 4
+	gnomeInhibitIdle       = 8
+	// kdeChangeScreenSettings is the org.kde.Solid.PowerManagement.PolicyAgent inhibition type that keeps the screen
+	// from dimming and blanking, the only kind a bridge takes.
+	kdeChangeScreenSettings = 4
+)
+
+// The compat toggles, which turn what is served besides org.freedesktop.ScreenSaver on /org/freedesktop/ScreenSaver
+// on or off one at a time (see Options.Compat).
+const (
+	// CompatLegacyPath serves org.freedesktop.ScreenSaver on /ScreenSaver as well, for Firefox and other older
+	// clients. It is on unless turned off.
+	CompatLegacyPath = "legacy_path"
+	// CompatPowerManagement serves org.freedesktop.PowerManagement, as used by older KDE and Xfce applications.
+	CompatPowerManagement = "power_management"
+	// CompatGNOME serves org.gnome.SessionManager's inhibit methods, as used by GTK applications and Firefox.
+	CompatGNOME = "gnome"
+	// CompatKDE serves org.kde.Solid.PowerManagement.PolicyAgent's inhibit methods, as used by KDE applications.
+	CompatKDE = "kde"
+	// CompatMATE serves org.mate.SessionManager's inhibit methods, as used by MATE applications.
+	CompatMATE = "mate"
+)
+
+// compatAPI is one of the compat interfaces, served under a bus name of its own.
+type compatAPI struct {
+	toggle, name, iface string
+	path                dbus.ObjectPath
+	new                 func(t inhibitTarget) interface{}
+}
+
+// compatAPIs are the compat interfaces, in the order they are claimed.
+var compatAPIs = []compatAPI{
+	{CompatPowerManagement, powerManagement, powerManagementIface, powerManagementPath, func(t inhibitTarget) interface{} { return &powerManagementAPI{t: t} }},
+	{CompatGNOME, gnomeSession, gnomeSession, gnomeSessionPath, func(t inhibitTarget) interface{} { return &gnomeSessionAPI{t: t, via: CompatGNOME} }},
+	{CompatKDE, kdePolicyAgent, kdePolicyAgent, kdePolicyAgentPath, func(t inhibitTarget) interface{} { return &kdePolicyAgentAPI{t: t} }},
+	{CompatMATE, mateSession, mateSession, mateSessionPath, func(t inhibitTarget) interface{} { return &gnomeSessionAPI{t: t, via: CompatMATE} }},
+}
+
+// CompatToggles returns the names of the compat toggles, sorted.
+func CompatToggles() []string {
+	toggles := []string{CompatLegacyPath}
+	for _, api := range compatAPIs {
+		toggles = append(toggles, api.toggle)
+	}
+	sort.Strings(toggles)
+	return toggles
+}
+
+// compatOn reports whether toggle is on in toggles, a set of compat toggles by name. Those it leaves out are on if
+// def is set; the legacy path always is.
+func compatOn(toggles map[string]bool, toggle string, def bool) bool {
+	if on, ok := toggles[toggle]; ok {
+		return on
+	}
+	return def || toggle == CompatLegacyPath
+}
+
+// inhibitTarget is what the compat interfaces translate their calls into: a Bridge, or a Proxy.
+type inhibitTarget interface {
+	// inhibit takes a lock for a request that arrived on via (see RequestsVia).
+	inhibit(from dbus.Sender, via, who, why string) (uint, *dbus.Error)
+	unInhibit(from dbus.Sender, cookie uint32) *dbus.Error
+	inhibited() bool
+}
+
+// classTarget is an inhibitTarget that can take what-classes other than idle. A Proxy can't: it forwards to
+// org.freedesktop.ScreenSaver, which only inhibits idle.
+type classTarget interface {
+	// inhibitClasses takes a lock for the what-classes classes, for a request that arrived on via.
+	inhibitClasses(from dbus.Sender, via, who, why string, classes []string) (uint, *dbus.Error)
+	// inhibitedClasses reports whether any lock held takes one of classes.
+	inhibitedClasses(classes []string) bool
+}
+
+// gnomeClasses translates org.gnome.SessionManager inhibit flags into logind what-classes: idle, suspend to sleep and
+// logout to shutdown, which logs the session out too and is as far as logind goes. Switching users has no
+// counterpart, as it leaves the session running.
+func gnomeClasses(flags uint32) []string {
+	var classes []string
+	if flags&gnomeInhibitIdle != 0 {
+		classes = append(classes, policy.WhatIdle)
+	}
+	if flags&gnomeInhibitSuspend != 0 {
+		classes = append(classes, "sleep")
+	}
+	if flags&gnomeInhibitLogout != 0 {
+		classes = append(classes, policy.WhatShutdown)
+	}
+	return classes
+}
+
+// powerManagementAPI is org.freedesktop.PowerManagement.Inhibit, as used by older KDE and Xfce applications.
+type powerManagementAPI struct {
+	t inhibitTarget
+}
+
+// Inhibit implements org.freedesktop.PowerManagement.Inhibit.Inhibit.
+func (pm *powerManagementAPI) Inhibit(from dbus.Sender, application, reason string) (uint32, *dbus.Error) {
+	cookie, err := pm.t.inhibit(from, CompatPowerManagement, application, reason)
+	return uint32(cookie), err
+}
+
+// UnInhibit implements org.freedesktop.PowerManagement.Inhibit.UnInhibit.
+func (pm *powerManagementAPI) UnInhibit(from dbus.Sender, cookie uint32) *dbus.Error {
+	return pm.t.unInhibit(from, cookie)
+}
+
+// HasInhibit implements org.freedesktop.PowerManagement.Inhibit.HasInhibit.
+func (pm *powerManagementAPI) HasInhibit() (bool, *dbus.Error) {
+	return pm.t.inhibited(), nil
+}
+
+// gnomeSessionAPI is the inhibit part of org.gnome.SessionManager, as used by GTK applications and Firefox, and of
+// MATE's fork of it.
+type gnomeSessionAPI struct {
+	t   inhibitTarget
+	via string // CompatGNOME or CompatMATE
+}
+
+// Inhibit implements org.gnome.SessionManager.Inhibit, taking the what-classes flags translate to (see gnomeClasses).
+// Through a Proxy, only idle inhibits are supported.
+func (gs *gnomeSessionAPI) Inhibit(from dbus.Sender, appID string, toplevelXID uint32, reason string, flags uint32) (uint32, *dbus.Error) {
+	ct, ok := gs.t.(classTarget)
+	if !ok {
+		if flags&gnomeInhibitIdle == 0 {
+			return 0, newError(ErrorNotSupported, "only idle inhibits (flag %d) are supported, got flags %d", gnomeInhibitIdle, flags)
+		}
+		cookie, err := gs.t.inhibit(from, gs.via, appID, reason)
+		return uint32(cookie), err
+	}
+	classes := gnomeClasses(flags)
+	if len(classes) == 0 {
+		return 0, newError(ErrorNotSupported, "flags %d have no logind what-class; switching users (flag %d) can't be inhibited", flags, gnomeInhibitSwitchUser)
+	}
+	cookie, err := ct.inhibitClasses(from, gs.via, appID, reason, classes)
+	return uint32(cookie), err
+}
+
+// Uninhibit implements org.gnome.SessionManager.Uninhibit.
+func (gs *gnomeSessionAPI) Uninhibit(from dbus.Sender, cookie uint32) *dbus.Error {
+	return gs.t.unInhibit(from, cookie)
+}
+
+// IsInhibited implements org.gnome.SessionManager.IsInhibited.
+func (gs *gnomeSessionAPI) IsInhibited(flags uint32) (bool, *dbus.Error) {
+	if ct, ok := gs.t.(classTarget); ok {
+		return ct.inhibitedClasses(gnomeClasses(flags)), nil
+	}
+	return flags&gnomeInhibitIdle != 0 && gs.t.inhibited(), nil
+}
+
+// kdePolicyAgentAPI is the inhibit part of org.kde.Solid.PowerManagement.PolicyAgent, as used by KDE applications.
+type kdePolicyAgentAPI struct {
+	t inhibitTarget
+}
+
+// AddInhibition implements org.kde.Solid.PowerManagement.PolicyAgent.AddInhibition. Only inhibitions of screen
+// settings, i.e. idle, are supported.
+func (kp *kdePolicyAgentAPI) AddInhibition(from dbus.Sender, types uint32, appName, reason string) (uint32, *dbus.Error) {
+	if types&kdeChangeScreenSettings == 0 {
+		return 0, newError(ErrorNotSupported, "only screen settings inhibitions (type %d) are supported, got types %d", kdeChangeScreenSettings, types)
+	}
+	cookie, err := kp.t.inhibit(from, CompatKDE, appName, reason)
+	return uint32(cookie), err
+}
+
+// ReleaseInhibition implements org.kde.Solid.PowerManagement.PolicyAgent.ReleaseInhibition.
+func (kp *kdePolicyAgentAPI) ReleaseInhibition(from dbus.Sender, cookie uint32) *dbus.Error {
+	return kp.t.unInhibit(from, cookie)
+}
+
+// HasInhibition implements org.kde.Solid.PowerManagement.PolicyAgent.HasInhibition.
+func (kp *kdePolicyAgentAPI) HasInhibition(types uint32) (bool, *dbus.Error) {
+	return types&kdeChangeScreenSettings != 0 && kp.t.inhibited(), nil
+}
+
+// exportCompat serves the compat interfaces toggles turns on (see compatOn, with def) on conn, translated into calls
+// on t. A name that is already owned, e.g. by a running gnome-session, is skipped. It returns the names claimed.
+func exportCompat(ex *exports, t inhibitTarget, log Logger, toggles map[string]bool, def bool) ([]string, error) {
+	var claimed []string
+	for _, api := range compatAPIs {
+		if !compatOn(toggles, api.toggle, def) {
+			continue
+		}
+		ok, err := claimCompat(ex, t, log, api)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			claimed = append(claimed, api.name)
+		}
+	}
+	return claimed, nil
+}
+
+// claimCompat exports api, translated into calls on t, and claims its name. It reports whether the name was claimed:
+// if another process owns it, the export is withdrawn again.
+func claimCompat(ex *exports, t inhibitTarget, log Logger, api compatAPI) (bool, error) {
+	v := api.new(t)
+	if err := ex.export(v, api.path, introspect.Interface{Name: api.iface, Methods: introspect.Methods(v)}); err != nil {
+		return false, err
+	}
+	r, err := ex.conn.RequestName(api.name, dbus.NameFlagDoNotQueue)
+	if err != nil {
+		ex.unexport(api.path, api.iface)
+		return false, fmt.Errorf("conn.RequestName(%q, 0): %v", api.name, err)
+	}
+	if r != dbus.RequestNameReplyPrimaryOwner {
+		ex.unexport(api.path, api.iface)
+		log.Printf("%s is owned by another process; not serving it.\n", api.name)
+		return false, nil
+	}
+	return true, nil
+}
+
+// dropCompat releases api's name and withdraws its export.
+func dropCompat(ex *exports, api compatAPI) error {
+	ex.unexport(api.path, api.iface)
+	if err := ex.conn.BusObject().Call("org.freedesktop.DBus.ReleaseName", 0, api.name).Err; err != nil {
+		return fmt.Errorf("couldn't release %q: %v", api.name, err)
+	}
+	return nil
+}
+
+// SetCompat turns the compat toggles (see Options.Compat) on or off as toggles says, claiming the names of those
+// turned on and releasing those of the ones turned off. Toggles it leaves out are off, but for the legacy path.
+// Names that another process owned when they were turned on are tried again.
+func (b *Bridge) SetCompat(toggles map[string]bool) error {
+	for toggle := range toggles {
+		if !validToggle(toggle) {
+			return fmt.Errorf("unknown compat toggle %q", toggle)
+		}
+	}
+
+	// Names are claimed and released off the actor, which must never block on the bus, so changes are taken one at a
+	// time instead.
+	b.compatMtx.Lock()
+	defer b.compatMtx.Unlock()
+	var legacy bool
+	claimed := make(map[string]bool)
+	if derr := b.do("SetCompat", func() {
+		legacy = b.legacy
+		for _, n := range b.compat {
+			claimed[n] = true
+		}
+	}); derr != nil {
+		return derr
+	}
+
+	err := b.setCompat(toggles, &legacy, claimed)
+	var names []string
+	for _, api := range compatAPIs {
+		if claimed[api.name] {
+			names = append(names, api.name)
+		}
+	}
+	if derr := b.do("SetCompat", func() { b.legacy, b.compat = legacy, names }); derr != nil {
+		return derr
+	}
+	return err
+}
+
+// setCompat serves, or withdraws, what toggles says, updating legacy and claimed, the names claimed, as it goes. It
+// must not be called on the actor.
+func (b *Bridge) setCompat(toggles map[string]bool, legacy *bool, claimed map[string]bool) error {
+	if on := compatOn(toggles, CompatLegacyPath, false); on != *legacy {
+		if on {
+			if err := b.exportScreenSaverOn(legacyPath); err != nil {
+				return err
+			}
+			b.log.Debugf("Serving %s on %q.\n", screensaver, legacyPath)
+		} else {
+			b.exports.unexport(legacyPath, screensaver)
+			b.log.Debugf("No longer serving %s on %q.\n", screensaver, legacyPath)
+		}
+		*legacy = on
+	}
+
+	for _, api := range compatAPIs {
+		switch on := compatOn(toggles, api.toggle, false); {
+		case on && !claimed[api.name]:
+			ok, err := claimCompat(b.exports, b, b.log, api)
+			if err != nil {
+				return err
+			}
+			if ok {
+				claimed[api.name] = true
+				b.log.Debugf("Serving %s.\n", api.name)
+			}
+		case !on && claimed[api.name]:
+			if err := dropCompat(b.exports, api); err != nil {
+				b.log.Printf("%v\n", err)
+			}
+			delete(claimed, api.name)
+			b.log.Debugf("No longer serving %s.\n", api.name)
+		}
+	}
+	return nil
+}
+
+// validToggle reports whether toggle names a compat toggle.
+func validToggle(toggle string) bool {
+	for _, t := range CompatToggles() {
+		if t == toggle {
+			return true
+		}
+	}
+	return false
+}
+
+// inhibited reports whether any lock is held.
+func (b *Bridge) inhibited() bool {
+	var held bool
+	b.do("inhibited", func() { held = len(b.locks) > 0 })
+	return held
+}
+
+// inhibitedClasses reports whether any lock held takes one of classes.
+func (b *Bridge) inhibitedClasses(classes []string) bool {
+	var held bool
+	b.do("inhibited", func() {
+		for _, ld := range b.locks {
+			for _, w := range strings.Split(ld.what, ":") {
+				for _, c := range classes {
+					held = held || w == c
+				}
+			}
+		}
+	})
+	return held
+}
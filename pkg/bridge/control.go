@@ -0,0 +1,317 @@
+package bridge
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/coltwillcox/inhibitor/pkg/policy"
+	"github.com/godbus/dbus/v5"
+	"github.com/godbus/dbus/v5/introspect"
+)
+
+// The bridge's management interface, served alongside org.freedesktop.ScreenSaver under ServiceName.
+const (
+	ServiceName      = screensaver
+	ControlInterface = "io.github.coltwillcox.Inhibitor"
+	ControlPath      = "/io/github/coltwillcox/Inhibitor"
+)
+
+// maxNote is the longest note, in bytes, Annotate accepts.
+const maxNote = 256
+
+// controlAPI is the bridge's own management interface, used to inspect and release locks held through it. Callers
+// only ever see and manage locks owned by their own uid unless they are root or polkit authorizes them as an admin.
+type controlAPI struct {
+	b *Bridge
+
+	mtx      sync.Mutex
+	takeover *pendingTakeover // guarded by mtx
+}
+
+// lockInfo is the D-Bus representation of a single lock.
+type lockInfo struct {
+	Cookie  uint32
+	Peer    string
+	Who     string
+	Why     string
+	UID     uint32
+	What    string
+	Expires int64 // Unix time, or 0 if the lock lasts as long as its peer
+	Note    string
+	Window  string
+	Held    uint64 // seconds since the lock was handed out
+	ID      string // correlation ID (see newLockID)
+	App     string // Flatpak application ID, if the peer is sandboxed
+}
+
+// info returns the D-Bus representation of ld at now.
+func (ld *lockDetails) info(now time.Time) lockInfo {
+	info := lockInfo{
+		Cookie: uint32(ld.cookie),
+		Peer:   string(ld.peer),
+		Who:    ld.who,
+		Why:    ld.why,
+		UID:    ld.uid,
+		What:   ld.what,
+		Note:   ld.note,
+		Window: ld.window,
+		Held:   uint64(ld.held(now) / time.Second),
+		ID:     ld.id,
+		App:    ld.app,
+	}
+	if !ld.expires.IsZero() {
+		info.Expires = ld.expires.Unix()
+	}
+	return info
+}
+
+func (b *Bridge) exportControl() error {
+	c := &controlAPI{b: b}
+	if err := b.exports.export(&controlObject{b: b}, ControlPath, controlPropertiesIntrospection); err != nil {
+		return err
+	}
+	return b.exports.export(c, ControlPath, introspect.Interface{Name: ControlInterface, Methods: introspect.Methods(c), Signals: controlSignals, Properties: controlProperties})
+}
+
+// caller resolves the uid of from and whether it may manage other users' locks.
+func (c *controlAPI) caller(from dbus.Sender) (uid uint32, admin bool, err *dbus.Error) {
+	uid, e := c.b.peerUID(from)
+	if e != nil {
+		return 0, false, newError(ErrorDenied, "%v", e)
+	}
+
+	return uid, uid == 0 || c.b.polkitAdmin(from), nil
+}
+
+// ListInhibits returns the locks visible to the caller.
+func (c *controlAPI) ListInhibits(from dbus.Sender) (infos []lockInfo, err *dbus.Error) {
+	defer c.b.recoverPanic("ListInhibits", &err)
+
+	uid, admin, err := c.caller(from)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.list("ListInhibits", uid, admin)
+}
+
+// ListAllInhibits returns every user's locks, for an admin's view of what is keeping the machine awake. Unlike
+// ListInhibits, which quietly narrows the list to the caller's own locks, it fails for anyone but an admin, so an
+// aggregate is never mistaken for a complete one.
+func (c *controlAPI) ListAllInhibits(from dbus.Sender) (infos []lockInfo, err *dbus.Error) {
+	defer c.b.recoverPanic("ListAllInhibits", &err)
+
+	if !c.b.opts.System {
+		return nil, newError(ErrorNotSupported, "a session bridge only serves its own user")
+	}
+	_, admin, err := c.caller(from)
+	if err != nil {
+		return nil, err
+	}
+	if !admin {
+		c.b.errLog.log("ListAllInhibits from %q denied\n", from)
+		return nil, newError(ErrorDenied, "%q may not list other users' locks", from)
+	}
+
+	return c.list("ListAllInhibits", 0, true)
+}
+
+// list returns the locks of uid, or every lock if all is set.
+func (c *controlAPI) list(name string, uid uint32, all bool) ([]lockInfo, *dbus.Error) {
+	infos := []lockInfo{}
+	if err := c.b.do(name, func() {
+		now := c.b.opts.Clock.Now()
+		for _, ld := range c.b.locks {
+			if ld.uid != uid && !all {
+				continue
+			}
+			infos = append(infos, ld.info(now))
+		}
+	}); err != nil {
+		return nil, err
+	}
+
+	return infos, nil
+}
+
+// Release drops a lock on behalf of the peer that holds it. Locks the caller isn't allowed to manage are reported
+// exactly like ones that don't exist.
+func (c *controlAPI) Release(from dbus.Sender, peer string, cookie uint32) (err *dbus.Error) {
+	defer c.b.recoverPanic("Release", &err)
+
+	uid, admin, err := c.caller(from)
+	if err != nil {
+		return err
+	}
+
+	if derr := c.b.do("Release", func() {
+		for _, ld := range c.b.locks {
+			if string(ld.peer) != peer || ld.cookie != uint(cookie) || (ld.uid != uid && !admin) {
+				continue
+			}
+			err = c.release(from, ld)
+			return
+		}
+
+		c.b.errLog.log("Release of invalid cookie %d for %q from %q\n", cookie, peer, from)
+		err = newError(ErrorInvalidCookie, "%d is an invalid cookie for %q", cookie, peer)
+	}); derr != nil {
+		return derr
+	}
+
+	return err
+}
+
+// release drops ld on behalf of from, which may manage it. It must be called on the actor.
+func (c *controlAPI) release(from dbus.Sender, ld *lockDetails) *dbus.Error {
+	if err := c.b.dropLock(ld, fmt.Sprintf("released by %s", from)); err != nil {
+		return newError(ErrorInternal, "%v", err)
+	}
+	c.b.metrics.addFor(metricLocksRevoked, ld.id)
+	c.b.log.Debugf("Released by %q: %s\n", from, ld)
+	return nil
+}
+
+// Annotate attaches a free-form note, such as "known leak, ticket #42", to a lock, replacing any earlier one; an empty
+// note removes it. The note shows up wherever the lock is listed and lasts as long as the lock. The same callers may
+// annotate a lock as may release it.
+func (c *controlAPI) Annotate(from dbus.Sender, peer string, cookie uint32, note string) (err *dbus.Error) {
+	defer c.b.recoverPanic("Annotate", &err)
+
+	if len(note) > maxNote {
+		return newError(ErrorInvalidArgs, "note is %d bytes long, more than %d", len(note), maxNote)
+	}
+	uid, admin, err := c.caller(from)
+	if err != nil {
+		return err
+	}
+
+	if derr := c.b.do("Annotate", func() {
+		for _, ld := range c.b.locks {
+			if string(ld.peer) != peer || ld.cookie != uint(cookie) || (ld.uid != uid && !admin) {
+				continue
+			}
+			ld.note = note
+			c.b.log.Debugf("Annotated by %q: %s: %q\n", from, ld, note)
+			c.b.emit(Event{Type: LockAnnotated, Lock: ld.public(), Message: fmt.Sprintf("annotated by %s", from)})
+			return
+		}
+
+		c.b.errLog.log("Annotate of invalid cookie %d for %q from %q\n", cookie, peer, from)
+		err = newError(ErrorInvalidCookie, "%d is an invalid cookie for %q", cookie, peer)
+	}); derr != nil {
+		return derr
+	}
+
+	return err
+}
+
+// InhibitMode is org.freedesktop.ScreenSaver.Inhibit with a choice of logind mode: "block", or "delay" to only hold
+// sleep and shutdown off for logind's InhibitDelayMaxSec rather than veto them. Rules may force "delay" regardless.
+// The lock is released with org.freedesktop.ScreenSaver.UnInhibit like any other.
+func (c *controlAPI) InhibitMode(from dbus.Sender, who, why, mode string) (cookie uint32, err *dbus.Error) {
+	defer c.b.recoverPanic("InhibitMode", &err)
+
+	if mode != "block" && mode != "delay" {
+		return 0, newError(ErrorInvalidArgs, "invalid mode %q, want \"block\" or \"delay\"", mode)
+	}
+	ck, err := c.b.take(from, who, why, lockRequest{delay: mode == "delay"})
+	if err != nil {
+		return 0, err
+	}
+
+	return uint32(ck), nil
+}
+
+// InhibitWhat is InhibitMode for the given colon-separated logind what-classes instead of those the bridge's
+// configuration picks, for systemd-inhibit style callers that know what they need held off.
+func (c *controlAPI) InhibitWhat(from dbus.Sender, who, why, what, mode string) (cookie uint32, err *dbus.Error) {
+	defer c.b.recoverPanic("InhibitWhat", &err)
+
+	if _, e := policy.ParseWhat(what); e != nil {
+		return 0, newError(ErrorInvalidArgs, "%v", e)
+	}
+	if mode != "block" && mode != "delay" {
+		return 0, newError(ErrorInvalidArgs, "invalid mode %q, want \"block\" or \"delay\"", mode)
+	}
+	ck, err := c.b.take(from, who, why, lockRequest{what: what, delay: mode == "delay"})
+	if err != nil {
+		return 0, err
+	}
+
+	return uint32(ck), nil
+}
+
+// InhibitShutdown takes a logind shutdown inhibit for a long-running job. Unlike Inhibit, the lock stays held after the
+// caller leaves the bus, until it is released (see Release) or ttl seconds have passed, so a script can protect a job
+// it doesn't itself wait on.
+func (c *controlAPI) InhibitShutdown(from dbus.Sender, who, why string, ttl uint32) (cookie uint32, err *dbus.Error) {
+	defer c.b.recoverPanic("InhibitShutdown", &err)
+
+	if ttl == 0 {
+		return 0, newError(ErrorInvalidArgs, "a shutdown inhibit needs a ttl")
+	}
+	ck, err := c.b.take(from, who, why, lockRequest{what: policy.WhatShutdown, ttl: time.Duration(ttl) * time.Second})
+	if err != nil {
+		return 0, err
+	}
+	c.b.log.Printf("Shutdown inhibited for %q until released or %s have passed: %q / %q\n", from, time.Duration(ttl)*time.Second, who, why)
+
+	return uint32(ck), nil
+}
+
+// FdStats returns the number of logind fds currently held, the number open in the process overall and the number of
+// accounting discrepancies found since startup.
+func (c *controlAPI) FdStats() (tracked, open, discrepancies uint32, err *dbus.Error) {
+	defer c.b.recoverPanic("FdStats", &err)
+
+	fds, e := openFds()
+	if e != nil {
+		return 0, 0, 0, newError(ErrorInternal, "%v", e)
+	}
+
+	return c.b.fds.count(), uint32(len(fds)), uint32(c.b.metrics.get(metricFdDiscrepancies)), nil
+}
+
+// Metrics returns a snapshot of the bridge's internal counters.
+func (c *controlAPI) Metrics() (counters map[string]uint64, err *dbus.Error) {
+	defer c.b.recoverPanic("Metrics", &err)
+	return c.b.metrics.snapshot(), nil
+}
+
+// GetExemplars returns the correlation ID of the lock last counted by each lock counter and histogram bucket of
+// Metrics (see Bridge.Exemplars).
+func (c *controlAPI) GetExemplars() (exemplars map[string]string, err *dbus.Error) {
+	defer c.b.recoverPanic("GetExemplars", &err)
+	return c.b.metrics.exemplarSnapshot(), nil
+}
+
+// Upgrade asks the front-end to replace the running process with a new binary, handing over every lock. Only the
+// daemon's own user or an admin may do so. The reply is sent before the upgrade starts, so the caller should watch
+// ServiceName change owners to learn whether it succeeded.
+func (c *controlAPI) Upgrade(from dbus.Sender) (err *dbus.Error) {
+	defer c.b.recoverPanic("Upgrade", &err)
+
+	uid, admin, err := c.caller(from)
+	if err != nil {
+		return err
+	}
+	if uid != uint32(os.Getuid()) && !admin {
+		c.b.errLog.log("Upgrade from %q denied\n", from)
+		return newError(ErrorDenied, "%q may not upgrade the daemon", from)
+	}
+	if c.b.opts.Upgrade == nil {
+		return newError(ErrorNotSupported, "hot upgrades are disabled")
+	}
+
+	c.b.log.Printf("Upgrade requested by %q.\n", from)
+	time.AfterFunc(upgradeDelay, func() {
+		if err := c.b.opts.Upgrade(); err != nil {
+			c.b.log.Printf("Upgrade failed: %v\n", err)
+		}
+	})
+
+	return nil
+}
@@ -0,0 +1,174 @@
+package bridge_test
+
+import (
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/coltwillcox/inhibitor/pkg/bridge"
+	"github.com/godbus/dbus/v5"
+)
+
+const (
+	root  = dbus.Sender(":1.50")
+	admin = dbus.Sender(":1.51")
+
+	adminUID = 1002
+)
+
+// controlObject is the part of the control interface that crosses users.
+type controlObject interface {
+	ListInhibits(from dbus.Sender) ([]bridge.LockInfo, *dbus.Error)
+	ListAllInhibits(from dbus.Sender) ([]bridge.LockInfo, *dbus.Error)
+	Release(from dbus.Sender, peer string, cookie uint32) *dbus.Error
+}
+
+// newSystem returns a system bridge with alice and bob's locks held, root connected, and admin connected as a third
+// user that polkit authorizes to manage every lock.
+func newSystem(t *testing.T) (f *fakes, c controlObject, aliceCookie, bobCookie uint32) {
+	t.Helper()
+	f = newFakes(t, bridge.Options{System: true})
+	f.bus.AddPeer(root, os.Getpid(), 0)
+	f.bus.AddPeer(admin, os.Getpid(), adminUID)
+	f.bus.Handle("org.freedesktop.PolicyKit1.Authority.CheckAuthorization", func(args ...interface{}) ([]interface{}, error) {
+		details, _ := reflect.ValueOf(args[0]).FieldByName("Details").Interface().(map[string]dbus.Variant)
+		name, _ := details["name"].Value().(string)
+		return []interface{}{[]interface{}{dbus.Sender(name) == admin, false, map[string]string{}}}, nil
+	})
+
+	var err error
+	if aliceCookie, err = f.b.Inhibit(alice, "app", "alice's"); err != nil {
+		t.Fatalf("Inhibit() failed: %v", err)
+	}
+	if bobCookie, err = f.b.Inhibit(bob, "app", "bob's"); err != nil {
+		t.Fatalf("Inhibit() failed: %v", err)
+	}
+	return f, exported[controlObject](t, f, bridge.ControlPath, bridge.ControlInterface), aliceCookie, bobCookie
+}
+
+// peers returns the peers of the locks in infos.
+func peers(infos []bridge.LockInfo) map[dbus.Sender]bool {
+	ps := make(map[dbus.Sender]bool)
+	for _, i := range infos {
+		ps[dbus.Sender(i.Peer)] = true
+	}
+	return ps
+}
+
+func TestListInhibitsPerUID(t *testing.T) {
+	_, c, _, _ := newSystem(t)
+	for _, tc := range []struct {
+		from dbus.Sender
+		want map[dbus.Sender]bool
+	}{
+		{alice, map[dbus.Sender]bool{alice: true}},
+		{bob, map[dbus.Sender]bool{bob: true}},
+		{mal, map[dbus.Sender]bool{alice: true}}, // alice's uid, so alice's locks
+		{root, map[dbus.Sender]bool{alice: true, bob: true}},
+		{admin, map[dbus.Sender]bool{alice: true, bob: true}},
+	} {
+		infos, err := c.ListInhibits(tc.from)
+		if err != nil {
+			t.Errorf("ListInhibits(%q) failed: %v", tc.from, err)
+			continue
+		}
+		if got := peers(infos); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("ListInhibits(%q) listed locks of %v, want %v", tc.from, got, tc.want)
+		}
+	}
+}
+
+func TestListAllInhibits(t *testing.T) {
+	for _, tc := range []struct {
+		from    dbus.Sender
+		wantErr bool
+	}{
+		{alice, true},
+		{bob, true},
+		{root, false},
+		{admin, false},
+	} {
+		t.Run(string(tc.from), func(t *testing.T) {
+			_, c, _, _ := newSystem(t)
+			infos, err := c.ListAllInhibits(tc.from)
+			if gotErr := err != nil; gotErr != tc.wantErr {
+				t.Fatalf("ListAllInhibits(%q) = %v, want error: %t", tc.from, err, tc.wantErr)
+			}
+			if err == nil && len(infos) != 2 {
+				t.Errorf("ListAllInhibits(%q) = %v, want both locks", tc.from, infos)
+			}
+		})
+	}
+}
+
+func TestListAllInhibitsSession(t *testing.T) {
+	f := newFakes(t, bridge.Options{})
+	c := exported[controlObject](t, f, bridge.ControlPath, bridge.ControlInterface)
+	if _, err := c.ListAllInhibits(alice); err == nil {
+		t.Errorf("ListAllInhibits() succeeded on a session bridge")
+	}
+}
+
+func TestReleasePerUID(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		from    dbus.Sender
+		peer    dbus.Sender
+		wantErr bool
+	}{
+		{"own lock", alice, alice, false},
+		{"own user's lock", mal, alice, false},
+		{"other user's lock", bob, alice, true},
+		{"other user's lock the other way", alice, bob, true},
+		{"root", root, bob, false},
+		{"polkit admin", admin, alice, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			f, c, aliceCookie, bobCookie := newSystem(t)
+			cookie := aliceCookie
+			if tc.peer == bob {
+				cookie = bobCookie
+			}
+
+			err := c.Release(tc.from, string(tc.peer), cookie)
+			if gotErr := err != nil; gotErr != tc.wantErr {
+				t.Fatalf("Release(%q, %q) = %v, want error: %t", tc.from, tc.peer, err, tc.wantErr)
+			}
+			if got, want := f.holds(tc.peer, cookie), tc.wantErr; got != want {
+				t.Errorf("lock of %q held after Release(%q): %t, want %t", tc.peer, tc.from, got, want)
+			}
+			if got, want := len(f.b.Locks()), 1; !tc.wantErr && got != want {
+				t.Errorf("%d locks left, want %d", got, want)
+			}
+		})
+	}
+}
+
+func TestReleaseDeniedLikeUnknown(t *testing.T) {
+	_, c, aliceCookie, _ := newSystem(t)
+	denied := c.Release(bob, string(alice), aliceCookie)
+	unknown := c.Release(bob, string(alice), aliceCookie+1)
+	if denied == nil || unknown == nil {
+		t.Fatalf("Release() = %v and %v, want both to fail", denied, unknown)
+	}
+	if denied.Name != unknown.Name {
+		t.Errorf("denied Release() failed with %q, unknown cookie with %q; want the same", denied.Name, unknown.Name)
+	}
+}
+
+func TestPolkitOnlyOnSystem(t *testing.T) {
+	f := newFakes(t, bridge.Options{})
+	f.bus.AddPeer(admin, os.Getpid(), adminUID)
+	f.bus.Handle("org.freedesktop.PolicyKit1.Authority.CheckAuthorization", func(args ...interface{}) ([]interface{}, error) {
+		t.Errorf("session bridge consulted polkit")
+		return []interface{}{[]interface{}{true, false, map[string]string{}}}, nil
+	})
+	cookie, err := f.b.Inhibit(alice, "app", "testing")
+	if err != nil {
+		t.Fatalf("Inhibit() failed: %v", err)
+	}
+	c := exported[controlObject](t, f, bridge.ControlPath, bridge.ControlInterface)
+	if err := c.Release(admin, string(alice), cookie); err == nil {
+		t.Errorf("Release() by another user succeeded on a session bridge")
+	}
+}
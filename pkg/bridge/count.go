@@ -0,0 +1,97 @@
+package bridge
+
+import (
+	"github.com/godbus/dbus/v5"
+	"github.com/godbus/dbus/v5/introspect"
+)
+
+// activeInhibitorCount is the property of the management interface that counts the locks held, for status bars and
+// applets to bind to rather than add up the lock signals themselves.
+const activeInhibitorCount = "ActiveInhibitorCount"
+
+// controlProperties are the properties of the management interface, for introspection.
+var controlProperties = []introspect.Property{
+	{Name: activeInhibitorCount, Type: "u", Access: "read", Annotations: []introspect.Annotation{
+		{Name: "org.freedesktop.DBus.Property.EmitsChangedSignal", Value: "true"},
+	}},
+}
+
+// controlPropertiesIntrospection is the introspection data of org.freedesktop.DBus.Properties on ControlPath.
+var controlPropertiesIntrospection = introspect.Interface{
+	Name:    propertiesIface,
+	Methods: introspect.Methods(&controlObject{}),
+	Signals: lockPropertiesIntrospection.Signals,
+}
+
+// controlObject is org.freedesktop.DBus.Properties on ControlPath. The count covers every user's locks: on the system
+// bus, PropertiesChanged reaches every peer anyway.
+type controlObject struct {
+	b *Bridge
+}
+
+// GetAll implements org.freedesktop.DBus.Properties.GetAll.
+func (o *controlObject) GetAll(iface string) (props map[string]dbus.Variant, err *dbus.Error) {
+	defer o.b.recoverPanic("GetAll", &err)
+
+	if iface != ControlInterface {
+		return nil, newError(errUnknownInterface, "%s has no properties of %q", ControlPath, iface)
+	}
+	var n uint32
+	if derr := o.b.do("GetAll", func() { n = uint32(len(o.b.locks)) }); derr != nil {
+		return nil, derr
+	}
+	return map[string]dbus.Variant{activeInhibitorCount: dbus.MakeVariant(n)}, nil
+}
+
+// Get implements org.freedesktop.DBus.Properties.Get.
+func (o *controlObject) Get(iface, name string) (v dbus.Variant, err *dbus.Error) {
+	props, err := o.GetAll(iface)
+	if err != nil {
+		return dbus.Variant{}, err
+	}
+	v, ok := props[name]
+	if !ok {
+		return dbus.Variant{}, newError(errUnknownProperty, "%s has no property %q", ControlInterface, name)
+	}
+	return v, nil
+}
+
+// Set implements org.freedesktop.DBus.Properties.Set. Every property is read-only.
+func (o *controlObject) Set(iface, name string, v dbus.Variant) *dbus.Error {
+	return newError(errPropertyReadOnly, "%s.%s is read-only", iface, name)
+}
+
+// countChanged announces that the number of locks held changed. The PropertiesChanged signal goes out
+// Options.SignalBatch after the first change, with the count by then, so that a burst of locks taken or released moves
+// the count once; if the burst left it where it was, nothing goes out. It must be called on the actor.
+func (b *Bridge) countChanged() {
+	if b.opts.SignalBatch <= 0 {
+		b.flushCount()
+		return
+	}
+	if b.countDue {
+		return
+	}
+	b.countDue = true
+	timer := b.opts.Clock.NewTimer(b.opts.SignalBatch)
+	go func() {
+		defer timer.Stop()
+		select {
+		case <-timer.C():
+			b.do("flushCount", b.flushCount)
+		case <-b.ctx.Done():
+		}
+	}()
+}
+
+// flushCount sends PropertiesChanged for ActiveInhibitorCount if it differs from the one last sent. It must be called
+// on the actor.
+func (b *Bridge) flushCount() {
+	b.countDue = false
+	n := uint32(len(b.locks))
+	if n == b.countSent {
+		return
+	}
+	b.countSent = n
+	b.signals.add(ControlPath, propertiesChanged, ControlInterface, map[string]dbus.Variant{activeInhibitorCount: dbus.MakeVariant(n)}, []string{})
+}
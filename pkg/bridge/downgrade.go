@@ -0,0 +1,72 @@
+package bridge
+
+import (
+	"os"
+
+	"github.com/coltwillcox/inhibitor/pkg/policy"
+)
+
+// downgradeBlocks moves the sleep and shutdown classes of every lock that has blocked them for longer than
+// Options.MaxBlock to delay mode. The new backend locks are taken before the old one is released, so the lock never
+// lapses. Like acquirePending, the backend is called off the actor.
+func (b *Bridge) downgradeBlocks() {
+	if b.opts.MaxBlock <= 0 {
+		return
+	}
+
+	var due []*lockDetails
+	if err := b.do("downgradeBlocks", func() {
+		cutoff := b.opts.Clock.Now().Add(-b.opts.MaxBlock)
+		for _, ld := range b.locks {
+			if ld.pending() || ld.downgraded || ld.since.After(cutoff) {
+				continue
+			}
+			if _, delay := policy.SplitDelay(ld.what); delay != "" {
+				if _, derr := b.permitted("", "", delay, true, true); derr != nil {
+					// The backend refuses to delay them; the lock keeps blocking.
+					continue
+				}
+				due = append(due, ld)
+			}
+		}
+	}); err != nil {
+		return
+	}
+
+	for _, ld := range due {
+		fd, delayFd, err := splitInhibit(ld.what, func(what, mode string) (*os.File, error) {
+			return b.backendInhibit(what, mode, ld.who, ld.why)
+		})
+		if err != nil {
+			b.errLog.log("Couldn't downgrade %s to delay mode: %v\n", ld, err)
+			return
+		}
+
+		adopted := false
+		b.do("downgradeBlocks", func() {
+			// The peer may have released the lock in the meantime.
+			if b.locks[ld.key()] != ld {
+				return
+			}
+			b.fds.untrack(ld.fd)
+			ld.fd.Close()
+			ld.fd, ld.delay = fd, delayFd
+			b.fds.track(ld.fd, ld.String())
+			if ld.delay != nil {
+				b.fds.track(ld.delay, ld.String())
+			}
+			ld.downgraded = true
+			adopted = true
+
+			_, delay := policy.SplitDelay(ld.what)
+			b.log.Printf("Downgraded %s after %s: %s now only delayed.\n", ld, b.opts.MaxBlock, delay)
+			b.metrics.addFor(metricLocksDowngraded, ld.id)
+		})
+		if !adopted {
+			fd.Close()
+			if delayFd != nil {
+				delayFd.Close()
+			}
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package bridge
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/godbus/dbus/v5"
+)
+
+// electronResources are where an Electron app keeps its code, beside its executable: packed, unpacked, or the
+// default app of a bare electron binary.
+var electronResources = []string{"resources/app.asar", "resources/app", "resources/default_app.asar"}
+
+// electronApp returns the name of the executable pid runs if it is an Electron app, such as "slack" or "code", or
+// "" if it isn't one. The executable is looked up through the process's root, so this works inside a container, but
+// a Flatpak app's peer process is its xdg-dbus-proxy, which isn't.
+func electronApp(pid uint32) string {
+	exe, err := os.Readlink(fmt.Sprintf("/proc/%d/exe", pid))
+	if err != nil {
+		return ""
+	}
+	root := fmt.Sprintf("/proc/%d/root", pid)
+	for _, res := range electronResources {
+		if _, err := os.Stat(filepath.Join(root, filepath.Dir(exe), res)); err == nil {
+			return filepath.Base(exe)
+		}
+	}
+	return ""
+}
+
+// electronWho returns who, unless from is an Electron app that didn't name itself, as Chromium passes on "electron"
+// or nothing when the app doesn't set its name. Its executable's name is returned then, so that rules and listings
+// can tell the apps apart.
+func (b *Bridge) electronWho(from dbus.Sender, who string) string {
+	if who != "" && !strings.EqualFold(who, "electron") {
+		return who
+	}
+	proc, err := b.lookupPeerProcess(from)
+	if err != nil {
+		return who
+	}
+	name := electronApp(proc.pid)
+	if name == "" {
+		return who
+	}
+	b.log.Debugf("%q is the Electron app %s.\n", from, name)
+	return name
+}
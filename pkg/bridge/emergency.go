@@ -0,0 +1,144 @@
+package bridge
+
+import (
+	"time"
+
+	"github.com/coltwillcox/inhibitor/pkg/policy"
+	"github.com/godbus/dbus/v5"
+	"github.com/godbus/dbus/v5/introspect"
+)
+
+// The signals of the management interface, on ControlPath.
+const (
+	// EmergencyRelease announces the locks ReleaseBlocking dropped and why.
+	EmergencyRelease = ControlInterface + ".EmergencyRelease"
+	// Removed announces every lock released, for whatever reason, with how long it was held.
+	Removed = ControlInterface + ".Removed"
+	// RemovedBatch announces several locks released within Options.SignalBatch of each other in one go, in place of
+	// their Removed signals, with the reason each was released for.
+	RemovedBatch = ControlInterface + ".RemovedBatch"
+)
+
+// controlSignals are the signals of the management interface, for introspection.
+var controlSignals = []introspect.Signal{{
+	Name: "EmergencyRelease",
+	Args: []introspect.Arg{
+		{Name: "reason", Type: "s"},
+		{Name: "locks", Type: dbus.SignatureOf([]lockInfo{}).String()},
+	},
+}, {
+	Name: "Removed",
+	Args: []introspect.Arg{
+		{Name: "lock", Type: dbus.SignatureOf(lockInfo{}).String()},
+		{Name: "reason", Type: "s"},
+	},
+}, {
+	Name: "RemovedBatch",
+	Args: []introspect.Arg{
+		{Name: "locks", Type: dbus.SignatureOf([]lockInfo{}).String()},
+		{Name: "reasons", Type: "as"},
+	},
+}}
+
+// emitter is implemented by buses that can send signals, as *dbus.Conn does. Others, such as bridgetest's fake, don't
+// get them.
+type emitter interface {
+	Emit(path dbus.ObjectPath, name string, values ...interface{}) error
+}
+
+// removedSignal is a Removed signal held back for Options.SignalBatch.
+type removedSignal struct {
+	lock   lockInfo
+	reason string
+}
+
+// signalRemoved queues the Removed signal for ld, dropped at now for reason. Every peer on the bus gets it, so on the
+// system bus, like the lock objects' PropertiesChanged, it leaves out what only the lock's owner and admins may read:
+// who, why, note and window. With Options.SignalBatch, the signal waits that long for others to go out with. It must
+// be called on the actor.
+func (b *Bridge) signalRemoved(ld *lockDetails, now time.Time, reason string) {
+	info := ld.info(now)
+	if b.opts.System {
+		info.Who, info.Why, info.Note, info.Window, info.App = "", "", "", "", ""
+	}
+	if b.opts.SignalBatch <= 0 {
+		b.signals.add(ControlPath, Removed, info, reason)
+		return
+	}
+	b.removed = append(b.removed, removedSignal{info, reason})
+	if len(b.removed) > 1 {
+		return
+	}
+	timer := b.opts.Clock.NewTimer(b.opts.SignalBatch)
+	go func() {
+		defer timer.Stop()
+		select {
+		case <-timer.C():
+			b.do("flushRemoved", b.flushRemoved)
+		case <-b.ctx.Done():
+		}
+	}()
+}
+
+// flushRemoved sends the Removed signals held back by signalRemoved: a single one as it is, several as one
+// RemovedBatch, so that a browser exiting with 40 locks wakes subscribers once rather than 40 times. It must be called
+// on the actor.
+func (b *Bridge) flushRemoved() {
+	switch len(b.removed) {
+	case 0:
+		return
+	case 1:
+		b.signals.add(ControlPath, Removed, b.removed[0].lock, b.removed[0].reason)
+	default:
+		locks := make([]lockInfo, 0, len(b.removed))
+		reasons := make([]string, 0, len(b.removed))
+		for _, r := range b.removed {
+			locks = append(locks, r.lock)
+			reasons = append(reasons, r.reason)
+		}
+		b.signals.add(ControlPath, RemovedBatch, locks, reasons)
+	}
+	b.removed = nil
+}
+
+// blocks reports whether ld holds sleep or shutdown off in block mode, or will once it gets its backend lock.
+func (ld *lockDetails) blocks() bool {
+	_, delay := policy.SplitDelay(ld.what)
+	return delay != "" && !ld.downgraded
+}
+
+// ReleaseBlocking drops every lock that blocks sleep or shutdown, for when holding them off would do harm, such as
+// when the battery is about to run out, and announces them with the EmergencyRelease signal. Locks that only delay
+// sleep and shutdown, or only inhibit idle, are kept: they don't stand in the way of an orderly suspend. It returns
+// the locks dropped.
+func (b *Bridge) ReleaseBlocking(reason string) ([]Lock, error) {
+	var (
+		released []Lock
+		infos    []lockInfo
+		err      error
+	)
+	if derr := b.do("ReleaseBlocking", func() {
+		now := b.opts.Clock.Now()
+		for _, ld := range b.locks {
+			if !ld.blocks() {
+				continue
+			}
+			if e := b.dropLock(ld, reason); e != nil && err == nil {
+				err = e
+			}
+			b.log.Printf("Released %s: %s.\n", ld, reason)
+			b.metrics.addFor(metricLocksEmergency, ld.id)
+			released = append(released, ld.public())
+			infos = append(infos, ld.info(now))
+		}
+	}); derr != nil {
+		return nil, derr
+	}
+
+	if e, ok := b.dbusConn.(emitter); ok && len(infos) > 0 {
+		if eerr := e.Emit(ControlPath, EmergencyRelease, reason, infos); eerr != nil {
+			b.errLog.log("Couldn't emit %s: %v\n", EmergencyRelease, eerr)
+		}
+	}
+	return released, err
+}
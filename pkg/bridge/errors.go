@@ -0,0 +1,40 @@
+package bridge
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/godbus/dbus/v5"
+)
+
+// D-Bus error names returned by the bridge. They are part of its interface: clients may match on them, so they
+// never change meaning. Malformed requests get the standard ErrorInvalidArgs instead.
+const (
+	// ErrorInvalidCookie means the cookie doesn't name a lock the caller holds (or may manage).
+	ErrorInvalidCookie = "org.freedesktop.ScreenSaver.Error.InvalidCookie"
+	// ErrorDenied means the caller couldn't be identified or isn't allowed to do what it asked.
+	ErrorDenied = "org.freedesktop.ScreenSaver.Error.Denied"
+	// ErrorLimit means the caller already holds as many locks as it is allowed to.
+	ErrorLimit = "org.freedesktop.ScreenSaver.Error.Limit"
+	// ErrorUnavailable means the backend couldn't take the lock.
+	ErrorUnavailable = "org.freedesktop.ScreenSaver.Error.Unavailable"
+	// ErrorBackendDown means the backend failed so many times in a row that it isn't called until it is back (see
+	// Options.BreakerThreshold); the request may be retried later.
+	ErrorBackendDown = "org.freedesktop.ScreenSaver.Error.BackendDown"
+	// ErrorBusy means the bridge has too many requests in hand to take this one; it may be retried later.
+	ErrorBusy = "org.freedesktop.ScreenSaver.Error.Busy"
+	// ErrorInternal means the bridge itself failed; the request may or may not have taken effect.
+	ErrorInternal = "org.freedesktop.ScreenSaver.Error.Internal"
+	// ErrorNotSupported means the request is valid but disabled in this instance.
+	ErrorNotSupported = "org.freedesktop.DBus.Error.NotSupported"
+	// ErrorInvalidArgs means the request was malformed.
+	ErrorInvalidArgs = "org.freedesktop.DBus.Error.InvalidArgs"
+)
+
+// ErrNameTaken is returned (wrapped) by NewBridge when another connection already owns org.freedesktop.ScreenSaver.
+var ErrNameTaken = errors.New("name already owned by another connection")
+
+// newError returns a D-Bus error with the given name and a formatted message.
+func newError(name, format string, args ...interface{}) *dbus.Error {
+	return dbus.NewError(name, []interface{}{fmt.Sprintf(format, args...)})
+}
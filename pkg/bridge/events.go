@@ -0,0 +1,221 @@
+package bridge
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// eventBuffer is how many events may queue up for a slow consumer before new ones are dropped.
+const eventBuffer = 64
+
+// EventType identifies what happened to a lock.
+type EventType int
+
+const (
+	// LockAdded is emitted when a lock is handed out, including provisional ones.
+	LockAdded EventType = iota
+	// LockAcquired is emitted when a provisional or just-in-time lock is finally taken from the backend.
+	LockAcquired
+	// LockRemoved is emitted when a lock is released for any reason; Event.Message says why.
+	LockRemoved
+	// OwnerChanged is emitted when a well-known name held by a lock's peer moves to another connection.
+	OwnerChanged
+	// NameLost is emitted, with a zero Lock, when another process replaces the bridge as owner of
+	// org.freedesktop.ScreenSaver (see Options.AllowReplacement).
+	NameLost
+	// NameAcquired is emitted, with a zero Lock, when a queued bridge becomes the owner of
+	// org.freedesktop.ScreenSaver (see Options.Queue).
+	NameAcquired
+	// LockAnnotated is emitted when a lock's note is set or removed through the control interface.
+	LockAnnotated
+	// LockDenied is emitted when a request for a lock is refused; Event.Message says why. The Lock describes what was
+	// asked for, with no cookie.
+	LockDenied
+	// SuspendVetoed is emitted when the lid was closed but locks kept the machine from suspending (see
+	// Options.SuspendVeto). Event.Message names every application that did; the Lock is the one held longest.
+	SuspendVetoed
+)
+
+// String returns the name of the event type.
+func (t EventType) String() string {
+	switch t {
+	case LockAdded:
+		return "added"
+	case LockAcquired:
+		return "acquired"
+	case LockRemoved:
+		return "removed"
+	case OwnerChanged:
+		return "owner-changed"
+	case NameLost:
+		return "name-lost"
+	case NameAcquired:
+		return "name-acquired"
+	case LockAnnotated:
+		return "annotated"
+	case LockDenied:
+		return "denied"
+	case SuspendVetoed:
+		return "suspend-vetoed"
+	}
+	return fmt.Sprintf("EventType(%d)", int(t))
+}
+
+// Event describes a change to the bridge's lock set.
+type Event struct {
+	Type EventType
+	Lock Lock
+	// Message is a human readable explanation, where there is one.
+	Message string
+	// Held is how long the lock was held, for LockRemoved.
+	Held time.Duration
+}
+
+// String returns a useful textual representation of an event.
+func (e Event) String() string {
+	if e.Type == NameLost || e.Type == NameAcquired {
+		return fmt.Sprintf("%s: %s", e.Type, e.Message)
+	}
+	if e.Type == LockRemoved {
+		return fmt.Sprintf("%s: %s (%s, held %s)", e.Type, e.Lock, e.Message, e.Held.Round(time.Second))
+	}
+	if e.Message != "" {
+		return fmt.Sprintf("%s: %s (%s)", e.Type, e.Lock, e.Message)
+	}
+	return fmt.Sprintf("%s: %s", e.Type, e.Lock)
+}
+
+// Lock is the public view of a lock held through the bridge.
+type Lock struct {
+	Cookie  uint32
+	Peer    string
+	Who     string
+	Why     string
+	What    string // the backend what-classes, e.g. "idle:sleep"
+	UID     uint32
+	Pending bool      // Provisional lock still waiting for the backend, or just-in-time one not yet needed.
+	Since   time.Time // When the lock was handed out.
+	Expires time.Time // Zero unless the lock outlives its peer, until then.
+	// Downgraded is set once the lock only delays sleep and shutdown (see Options.MaxBlock).
+	Downgraded bool
+	// NoLock is set if the lock also keeps the session from locking (see policy.Rule.NoLock).
+	NoLock bool
+	// App is the Flatpak application ID of the peer, if it is sandboxed.
+	App string
+	// Session is the class of the peer's session (see backend.SessionClasser), if known.
+	Session string
+	// Note is the lock's annotation, if an admin or its owner set one through the control interface.
+	Note string
+	// Window is the window the lock lasts as long as, e.g. "x11:0x3a00007", if it was taken with InhibitWindow.
+	Window string
+	// ID is the correlation ID of the lock, or of the request for it, which its log lines are tagged with.
+	ID string
+	// Keepalive is how often Keepalive must renew the lock, which then expires at Expires, if it was taken with
+	// InhibitKeepalive.
+	Keepalive time.Duration
+}
+
+// String returns a useful textual representation of a lock.
+func (l Lock) String() string {
+	return fmt.Sprintf("[%s] %q / %q (%q, %d)", l.ID, l.Who, l.Why, l.Peer, l.Cookie)
+}
+
+func (ld *lockDetails) public() Lock {
+	return Lock{
+		Cookie:     uint32(ld.cookie),
+		Peer:       string(ld.peer),
+		Who:        ld.who,
+		Why:        ld.why,
+		What:       ld.what,
+		UID:        ld.uid,
+		Pending:    ld.pending(),
+		Since:      ld.since,
+		Expires:    ld.expires,
+		Downgraded: ld.downgraded,
+		NoLock:     ld.noLock,
+		App:        ld.app,
+		Session:    ld.session,
+		Note:       ld.note,
+		Window:     ld.window,
+		ID:         ld.id,
+		Keepalive:  ld.interval,
+	}
+}
+
+// Events returns the channel on which lock changes are published. There is a single channel per bridge, so events
+// are delivered to only one reader; it is closed by Close. Events are dropped (and counted) rather than block the
+// bridge if the reader falls behind; Options.EventSink gets them all.
+func (b *Bridge) Events() <-chan Event {
+	return b.events
+}
+
+// emit publishes ev without blocking. It must be called on the actor.
+func (b *Bridge) emit(ev Event) {
+	if b.closed {
+		return
+	}
+	b.syncObject(ev)
+	if ev.Type == LockAdded || ev.Type == LockRemoved {
+		b.countChanged()
+	}
+	if b.opts.Store != nil && ev.Type.persists() {
+		b.persistLater()
+	}
+	if b.opts.EventSink != nil {
+		b.sink.add(ev)
+	}
+	select {
+	case b.events <- ev:
+	default:
+		b.metrics.add(metricEventsDropped, 1)
+	}
+}
+
+// eventQueue holds the events for Options.EventSink until sendEvents passes them on, in the order they were emitted.
+type eventQueue struct {
+	mtx     sync.Mutex
+	pending []Event
+	wake    chan struct{}
+}
+
+func newEventQueue() *eventQueue {
+	return &eventQueue{wake: make(chan struct{}, 1)}
+}
+
+// add queues ev. It never blocks.
+func (q *eventQueue) add(ev Event) {
+	q.mtx.Lock()
+	q.pending = append(q.pending, ev)
+	q.mtx.Unlock()
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// sendEvents passes the queued events on to Options.EventSink until the bridge is closed, which passes on the rest.
+func (b *Bridge) sendEvents() error {
+	for {
+		select {
+		case <-b.ctx.Done():
+			return nil
+		case <-b.sink.wake:
+		}
+		b.sinkEvents()
+	}
+}
+
+// sinkEvents passes every queued event on to Options.EventSink. It must not be called on the actor.
+func (b *Bridge) sinkEvents() {
+	if b.opts.EventSink == nil {
+		return
+	}
+	b.sink.mtx.Lock()
+	pending := b.sink.pending
+	b.sink.pending = nil
+	b.sink.mtx.Unlock()
+	for _, ev := range pending {
+		b.opts.EventSink(ev)
+	}
+}
@@ -0,0 +1,14 @@
+package bridge
+
+// HeartbeatTick runs a single heartbeat pass, for tests that drive the bridge without waiting for its timer.
+func (b *Bridge) HeartbeatTick() {
+	b.heartbeatTick()
+}
+
+// TrackedFds returns the number of backend fds the bridge holds.
+func (b *Bridge) TrackedFds() int {
+	return int(b.fds.count())
+}
+
+// LockInfo is what the control interface lists locks as.
+type LockInfo = lockInfo
@@ -0,0 +1,68 @@
+package bridge
+
+import (
+	"math/rand"
+
+	"github.com/coltwillcox/inhibitor/pkg/policy"
+	"github.com/godbus/dbus/v5"
+)
+
+// faultErrors are the D-Bus errors of the failures a policy.Fault simulates, by failure.
+var faultErrors = map[string]string{
+	policy.FaultDenied:      ErrorDenied,
+	policy.FaultLimit:       ErrorLimit,
+	policy.FaultUnavailable: ErrorUnavailable,
+	policy.FaultBackendDown: ErrorBackendDown,
+	policy.FaultBusy:        ErrorBusy,
+	policy.FaultInternal:    ErrorInternal,
+}
+
+// SetFaults replaces the faults injected into lock requests (see policy.Policy.Faults). nil turns them off.
+func (b *Bridge) SetFaults(faults []policy.Fault) error {
+	for _, f := range faults {
+		if err := f.Validate(); err != nil {
+			return err
+		}
+	}
+	if derr := b.do("SetFaults", func() { b.faults = faults }); derr != nil {
+		return derr
+	}
+	return nil
+}
+
+// injectFault applies the first fault matching the request with correlation ID id, if any hits it: it holds the
+// request up for the fault's delay and then returns the error the fault simulates, or nil to let it through.
+func (b *Bridge) injectFault(id string, from dbus.Sender, who, why string) *dbus.Error {
+	var fault *policy.Fault
+	if derr := b.do("injectFault", func() {
+		for i := range b.faults {
+			if b.faults[i].Matches(who, why) {
+				fault = &b.faults[i]
+				break
+			}
+		}
+	}); derr != nil || fault == nil {
+		return derr
+	}
+	if fault.Rate > 0 && rand.Float64() >= fault.Rate {
+		return nil
+	}
+	b.metrics.addFor(metricFaultsInjected, id)
+
+	// Validated by SetFaults.
+	if delay, _ := fault.DelayFor(); delay > 0 {
+		b.log.Printf("[%s] Developer mode: holding up Inhibit from %q (%q / %q) for %s.\n", id, from, who, why, delay)
+		timer := b.opts.Clock.NewTimer(delay)
+		defer timer.Stop()
+		select {
+		case <-timer.C():
+		case <-b.ctx.Done():
+			return newError(ErrorUnavailable, "shutting down")
+		}
+	}
+	if fault.Error == "" {
+		return nil
+	}
+	b.log.Printf("[%s] Developer mode: failing Inhibit from %q (%q / %q) with %s.\n", id, from, who, why, fault.Error)
+	return newError(faultErrors[fault.Error], "simulated %s failure (developer mode)", fault.Error)
+}
@@ -0,0 +1,125 @@
+package bridge
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// fdTracker records every logind inhibit fd we hold along with a tag naming its owner, so that the fds actually open
+// in the process can be reconciled against what the lock table thinks it holds.
+type fdTracker struct {
+	mtx sync.Mutex
+	fds map[int]string
+}
+
+func newFdTracker() *fdTracker {
+	return &fdTracker{fds: make(map[int]string)}
+}
+
+// track records f as held on behalf of owner.
+func (t *fdTracker) track(f *os.File, owner string) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	t.fds[int(f.Fd())] = owner
+}
+
+// untrack forgets f. It must be called before f is closed, while its fd number is still meaningful.
+func (t *fdTracker) untrack(f *os.File) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	delete(t.fds, int(f.Fd()))
+}
+
+// count returns the number of tracked fds.
+func (t *fdTracker) count() uint32 {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	return uint32(len(t.fds))
+}
+
+// openFds returns the targets of every fd open in this process, keyed by fd number.
+func openFds() (map[int]string, error) {
+	entries, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		return nil, err
+	}
+
+	fds := make(map[int]string, len(entries))
+	for _, e := range entries {
+		fd, err := strconv.Atoi(e.Name())
+		if err != nil {
+			continue
+		}
+		// The fd used to read the directory itself will have vanished by now.
+		target, err := os.Readlink("/proc/self/fd/" + e.Name())
+		if err != nil {
+			continue
+		}
+		fds[fd] = target
+	}
+
+	return fds, nil
+}
+
+// openFd returns the target of a single open fd.
+func openFd(fd int) (string, bool) {
+	target, err := os.Readlink("/proc/self/fd/" + strconv.Itoa(fd))
+	return target, err == nil
+}
+
+// reconcileFds compares the lock table, the tracked fds and the fds actually open in the process, logging every
+// mismatch. The process' fds are listed before handing the comparison to the actor.
+func (b *Bridge) reconcileFds() {
+	open, err := openFds()
+	if err != nil {
+		b.errLog.log("Couldn't list open fds: %v\n", err)
+		return
+	}
+
+	b.do("reconcileFds", func() { b.compareFds(open) })
+}
+
+// compareFds does the work of reconcileFds against open, a listing of the process' fds. It must be called on the
+// actor.
+func (b *Bridge) compareFds(open map[int]string) {
+	var problems []string
+
+	lockFds := make(map[int]*lockDetails, len(b.locks))
+	for _, ld := range b.locks {
+		if !ld.pending() {
+			lockFds[int(ld.fd.Fd())] = ld
+		}
+		if ld.delay != nil {
+			lockFds[int(ld.delay.Fd())] = ld
+		}
+	}
+
+	b.fds.mtx.Lock()
+	for fd, owner := range b.fds.fds {
+		target, ok := open[fd]
+		if !ok {
+			// Possibly opened by an Inhibit since we listed the directory.
+			target, ok = openFd(fd)
+		}
+		if !ok || !strings.HasPrefix(target, "pipe:") {
+			problems = append(problems, fmt.Sprintf("fd %d tracked for %s is not an open inhibit pipe", fd, owner))
+		}
+		if _, ok := lockFds[fd]; !ok {
+			problems = append(problems, fmt.Sprintf("fd %d tracked for %s has no lock; leaked", fd, owner))
+		}
+	}
+	for fd, ld := range lockFds {
+		if _, ok := b.fds.fds[fd]; !ok {
+			problems = append(problems, fmt.Sprintf("fd %d for %s isn't tracked", fd, ld))
+		}
+	}
+	b.fds.mtx.Unlock()
+	b.metrics.add(metricFdDiscrepancies, uint64(len(problems)))
+
+	for _, p := range problems {
+		b.errLog.log("fd accounting: %s\n", p)
+	}
+}
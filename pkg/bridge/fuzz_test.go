@@ -0,0 +1,168 @@
+package bridge_test
+
+import (
+	"testing"
+
+	"github.com/coltwillcox/inhibitor/pkg/bridge"
+	"github.com/godbus/dbus/v5"
+)
+
+// The exported objects the fuzz targets call, with the method sets the bus would dispatch to.
+type (
+	screenSaverObject interface {
+		Inhibit(from dbus.Sender, msg dbus.Message, who, why string) (uint, *dbus.Error)
+		UnInhibit(from dbus.Sender, msg dbus.Message, cookie uint32) *dbus.Error
+	}
+	powerManagementObject interface {
+		Inhibit(from dbus.Sender, application, reason string) (uint32, *dbus.Error)
+		UnInhibit(from dbus.Sender, cookie uint32) *dbus.Error
+	}
+	gnomeSessionObject interface {
+		Inhibit(from dbus.Sender, appID string, toplevelXID uint32, reason string, flags uint32) (uint32, *dbus.Error)
+		Uninhibit(from dbus.Sender, cookie uint32) *dbus.Error
+	}
+	kdePolicyAgentObject interface {
+		AddInhibition(from dbus.Sender, types uint32, appName, reason string) (uint32, *dbus.Error)
+		ReleaseInhibition(from dbus.Sender, cookie uint32) *dbus.Error
+	}
+)
+
+// allCompat turns every compat toggle on.
+func allCompat() map[string]bool {
+	toggles := make(map[string]bool)
+	for _, t := range bridge.CompatToggles() {
+		toggles[t] = true
+	}
+	return toggles
+}
+
+// exported returns what the bridge exported at path under iface, failing the test if it isn't there.
+func exported[T any](t *testing.T, f *fakes, path dbus.ObjectPath, iface string) T {
+	t.Helper()
+	v, ok := f.bus.Exported(path, iface).(T)
+	if !ok {
+		t.Fatalf("nothing usable exported at %q under %q: %T", path, iface, f.bus.Exported(path, iface))
+	}
+	return v
+}
+
+// checkFds fails the test unless the bridge tracks exactly one backend fd for each lock it holds that isn't pending.
+func checkFds(t *testing.T, f *fakes, when string) {
+	t.Helper()
+	held := 0
+	for _, l := range f.b.Locks() {
+		if !l.Pending {
+			held++
+		}
+	}
+	if got := f.b.TrackedFds(); got != held {
+		t.Fatalf("%s: TrackedFds() = %d, want %d", when, got, held)
+	}
+}
+
+// checkPanics fails the test if the bridge recovered from a panic.
+func checkPanics(t *testing.T, f *fakes) {
+	t.Helper()
+	if n := f.b.Metrics()["panics_recovered"]; n != 0 {
+		t.Fatalf("%d panics recovered", n)
+	}
+}
+
+// release is a lock taken by a fuzz target, with the call that releases it.
+type release struct {
+	cookie uint32
+	fn     func(from dbus.Sender, cookie uint32) *dbus.Error
+}
+
+func FuzzInhibit(f *testing.F) {
+	f.Add("firefox", "video-playing", uint32(0))
+	f.Add("", "", uint32(1))
+	f.Add("org.mozilla.firefox", "audio-playing", uint32(1<<31))
+	f.Add("chromium\x00", "\xff\xfe", uint32(7))
+
+	f.Fuzz(func(t *testing.T, who, why string, delta uint32) {
+		fk := newFakes(t, bridge.Options{})
+		var taken []release
+		for _, path := range []dbus.ObjectPath{"/org/freedesktop/ScreenSaver", "/ScreenSaver"} {
+			ss := exported[screenSaverObject](t, fk, path, "org.freedesktop.ScreenSaver")
+			for i := 0; i < 2; i++ {
+				cookie, err := ss.Inhibit(alice, dbus.Message{}, who, why)
+				if err == nil {
+					taken = append(taken, release{uint32(cookie), func(from dbus.Sender, cookie uint32) *dbus.Error {
+						return ss.UnInhibit(from, dbus.Message{}, cookie)
+					}})
+				}
+				checkFds(t, fk, "after Inhibit")
+			}
+		}
+
+		for _, r := range taken {
+			if err := r.fn(mal, r.cookie+delta); err == nil {
+				t.Fatalf("UnInhibit(%q, %d) released a lock of %q", mal, r.cookie+delta, alice)
+			}
+			if err := r.fn(bob, r.cookie); err == nil {
+				t.Fatalf("UnInhibit(%q, %d) released a lock of %q", bob, r.cookie, alice)
+			}
+		}
+		checkFds(t, fk, "after foreign UnInhibits")
+
+		for _, r := range taken {
+			if err := r.fn(alice, r.cookie); err != nil {
+				t.Fatalf("UnInhibit(%q, %d) failed: %v", alice, r.cookie, err)
+			}
+			checkFds(t, fk, "after UnInhibit")
+		}
+		if locks := fk.b.Locks(); len(locks) != 0 {
+			t.Fatalf("Locks() = %v after releasing everything", locks)
+		}
+		checkPanics(t, fk)
+	})
+}
+
+func FuzzCompat(f *testing.F) {
+	f.Add("org.gnome.Totem", "Playing", uint32(8), uint32(4), uint32(0))
+	f.Add("kdenlive", "Rendering", uint32(15), uint32(0), uint32(1))
+	f.Add("", "", uint32(2), uint32(0xffffffff), uint32(1<<31))
+
+	f.Fuzz(func(t *testing.T, app, reason string, flags, types, delta uint32) {
+		fk := newFakes(t, bridge.Options{Compat: allCompat()})
+		pm := exported[powerManagementObject](t, fk, "/org/freedesktop/PowerManagement/Inhibit", "org.freedesktop.PowerManagement.Inhibit")
+		gnome := exported[gnomeSessionObject](t, fk, "/org/gnome/SessionManager", "org.gnome.SessionManager")
+		mate := exported[gnomeSessionObject](t, fk, "/org/mate/SessionManager", "org.mate.SessionManager")
+		kde := exported[kdePolicyAgentObject](t, fk, "/org/kde/Solid/PowerManagement/PolicyAgent", "org.kde.Solid.PowerManagement.PolicyAgent")
+
+		var taken []release
+		take := func(cookie uint32, err *dbus.Error, fn func(dbus.Sender, uint32) *dbus.Error) {
+			if err == nil {
+				taken = append(taken, release{cookie, fn})
+			}
+			checkFds(t, fk, "after inhibiting")
+		}
+		cookie, err := pm.Inhibit(alice, app, reason)
+		take(cookie, err, pm.UnInhibit)
+		cookie, err = gnome.Inhibit(alice, app, 0, reason, flags)
+		take(cookie, err, gnome.Uninhibit)
+		cookie, err = mate.Inhibit(alice, app, 0, reason, flags)
+		take(cookie, err, mate.Uninhibit)
+		cookie, err = kde.AddInhibition(alice, types, app, reason)
+		take(cookie, err, kde.ReleaseInhibition)
+
+		for _, r := range taken {
+			if err := r.fn(mal, r.cookie+delta); err == nil {
+				t.Fatalf("%q released cookie %d of %q", mal, r.cookie+delta, alice)
+			}
+		}
+		checkFds(t, fk, "after foreign releases")
+
+		for _, r := range taken {
+			if err := r.fn(alice, r.cookie); err != nil {
+				t.Fatalf("releasing cookie %d failed: %v", r.cookie, err)
+			}
+			checkFds(t, fk, "after releasing")
+		}
+		if locks := fk.b.Locks(); len(locks) != 0 {
+			t.Fatalf("Locks() = %v after releasing everything", locks)
+		}
+		checkPanics(t, fk)
+	})
+}
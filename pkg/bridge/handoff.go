@@ -0,0 +1,212 @@
+package bridge
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/coltwillcox/inhibitor/pkg/policy"
+	"github.com/godbus/dbus/v5"
+)
+
+const (
+	// handoffNameWait is how long a successor waits for its predecessor's bus connection to go away and release
+	// org.freedesktop.ScreenSaver.
+	handoffNameWait = 5 * time.Second
+	// upgradeDelay gives the Upgrade reply time to reach the caller before the process is replaced.
+	upgradeDelay = 100 * time.Millisecond
+)
+
+// Handoff is the lock table of a frozen bridge, in a form that survives serialization. A successor passes it back in
+// Options.Handoff, together with the lock fds themselves, to carry on where its predecessor stopped.
+type Handoff struct {
+	// Name is the predecessor's unique bus name. Locks it held on its own behalf move to the successor's name.
+	Name  string
+	Locks []HandoffLock
+	// Schedules are the pending schedules, which outlive the predecessor whether or not it released its locks.
+	Schedules []Schedule `json:",omitempty"`
+}
+
+// HandoffLock is a single lock in a Handoff.
+type HandoffLock struct {
+	Cookie   uint32
+	Peer     string
+	Who, Why string
+	What     string
+	UID      uint32
+	PID      uint32 // 0 if the peer's process wasn't identified
+	Start    uint64
+	Names    []string
+	FD       int // the lock's fd number in the predecessor, or -1 for a provisional lock
+	Since    time.Time
+	Expires  time.Time
+	// Downgraded is set once sleep and shutdown are only delayed. DelayFD is then the delay-mode fd if FD still
+	// blocks other classes, or -1.
+	Downgraded bool
+	DelayFD    int
+	NoLock     bool
+	App        string
+	Session    string
+	Note       string
+	Window     string
+	ID         string        // the lock's correlation ID; empty from a version without them
+	Keepalive  time.Duration // the keepalive interval, or 0 if the lock isn't kept alive
+}
+
+// FDs returns the fds hl refers to.
+func (hl HandoffLock) FDs() []int {
+	var fds []int
+	if hl.FD >= 0 {
+		fds = append(fds, hl.FD)
+	}
+	if hl.Downgraded && hl.DelayFD >= 0 {
+		fds = append(fds, hl.DelayFD)
+	}
+	return fds
+}
+
+// Freeze stops all changes to the lock table and returns it, so that a front-end can hand it over to a new process
+// (typically by exec'ing one that inherits the fds). The actor stays parked, so Inhibit, UnInhibit and the heartbeat
+// block until thaw is called; call it only if the handover failed.
+func (b *Bridge) Freeze() (h *Handoff, thaw func(), err error) {
+	hc := make(chan *Handoff, 1)
+	thawc := make(chan struct{})
+	go func() {
+		if derr := b.do("Freeze", func() {
+			hc <- b.snapshot()
+			<-thawc
+		}); derr != nil {
+			// Either the bridge is closed or the snapshot panicked; the op never parked.
+			hc <- nil
+		}
+	}()
+
+	if h = <-hc; h == nil {
+		return nil, nil, fmt.Errorf("couldn't freeze the lock table")
+	}
+	return h, func() { close(thawc) }, nil
+}
+
+// snapshot returns the lock table as a Handoff. It must be called on the actor.
+func (b *Bridge) snapshot() *Handoff {
+	h := &Handoff{Name: string(b.Name()), Locks: make([]HandoffLock, 0, len(b.locks))}
+	for _, ld := range b.locks {
+		hl := HandoffLock{
+			Cookie:     uint32(ld.cookie),
+			Peer:       string(ld.peer),
+			Who:        ld.who,
+			Why:        ld.why,
+			What:       ld.what,
+			UID:        ld.uid,
+			Names:      ld.names,
+			FD:         -1,
+			Since:      ld.since,
+			Expires:    ld.expires,
+			Downgraded: ld.downgraded,
+			DelayFD:    -1,
+			NoLock:     ld.noLock,
+			App:        ld.app,
+			Session:    ld.session,
+			Note:       ld.note,
+			Window:     ld.window,
+			ID:         ld.id,
+			Keepalive:  ld.interval,
+		}
+		if ld.proc != nil {
+			hl.PID, hl.Start = ld.proc.pid, ld.proc.start
+		}
+		if !ld.pending() {
+			hl.FD = int(ld.fd.Fd())
+		}
+		if ld.delay != nil {
+			hl.DelayFD = int(ld.delay.Fd())
+		}
+		h.Locks = append(h.Locks, hl)
+	}
+	h.Schedules = append(h.Schedules, b.schedules...)
+
+	return h
+}
+
+// adopt imports a predecessor's lock table, saying how in the LockAdded events. It must be called on the actor, before
+// the bridge starts serving requests.
+func (b *Bridge) adopt(h *Handoff, how string) {
+	self := b.Name()
+	for _, hl := range h.Locks {
+		ld := &lockDetails{
+			cookie:     uint(hl.Cookie),
+			peer:       dbus.Sender(hl.Peer),
+			who:        hl.Who,
+			why:        hl.Why,
+			what:       hl.What,
+			uid:        hl.UID,
+			names:      hl.Names,
+			since:      hl.Since,
+			expires:    hl.Expires,
+			downgraded: hl.Downgraded,
+			noLock:     hl.NoLock,
+			app:        hl.App,
+			session:    hl.Session,
+			note:       hl.Note,
+			window:     hl.Window,
+			id:         hl.ID,
+			interval:   hl.Keepalive,
+		}
+		if ld.what == "" {
+			// Handed over by a version that only took idle locks.
+			ld.what = policy.WhatIdle
+		}
+		if ld.id == "" {
+			ld.id = newLockID()
+		}
+		if ld.peer == dbus.Sender(h.Name) {
+			ld.peer = self
+		}
+		ld.jit = b.jit(ld.what, ld.downgraded)
+		if hl.PID != 0 {
+			ld.proc = &peerProcess{pid: hl.PID, start: hl.Start}
+		}
+		if hl.FD >= 0 {
+			ld.fd = os.NewFile(uintptr(hl.FD), "inhibit")
+			b.fds.track(ld.fd, ld.String())
+		}
+		if hl.Downgraded && hl.DelayFD >= 0 {
+			ld.delay = os.NewFile(uintptr(hl.DelayFD), "inhibit")
+			b.fds.track(ld.delay, ld.String())
+		}
+		if ld.window != "" {
+			if b.opts.Windows == nil {
+				b.log.Printf("Window locks are disabled; %s now lasts as long as its peer instead of window %s.\n", ld, ld.window)
+				ld.window = ""
+			} else if err := b.watchWindow(ld); err != nil {
+				b.log.Printf("Can't follow window %s any more: %v; %s now lasts as long as its peer.\n", ld.window, err, ld)
+				ld.window = ""
+			}
+		}
+		b.locks[ld.key()] = ld
+		b.log.Debugf("Adopted: %s\n", ld)
+		b.emit(Event{Type: LockAdded, Lock: ld.public(), Message: how})
+	}
+	for _, s := range h.Schedules {
+		b.schedules = append(b.schedules, s)
+		b.armSchedule(s)
+	}
+}
+
+// requestNameAfterHandoff claims org.freedesktop.ScreenSaver, waiting for the predecessor's connection to release it.
+func requestNameAfterHandoff(conn Bus, flags dbus.RequestNameFlags) error {
+	deadline := time.Now().Add(handoffNameWait)
+	for {
+		r, err := conn.RequestName(screensaver, flags)
+		if err != nil {
+			return fmt.Errorf("conn.RequestName(%q, 0): %v", screensaver, err)
+		}
+		if r == dbus.RequestNameReplyPrimaryOwner {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("conn.RequestName(%q, 0): %w after %s", screensaver, ErrNameTaken, handoffNameWait)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
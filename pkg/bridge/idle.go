@@ -0,0 +1,79 @@
+package bridge
+
+import (
+	"context"
+	"time"
+
+	"github.com/coltwillcox/inhibitor/pkg/backend"
+	"github.com/godbus/dbus/v5"
+)
+
+// Where the idle time SessionIdle returns came from.
+const (
+	// IdleCompositor is Mutter's idle monitor, which counts from the last input event.
+	IdleCompositor = "compositor"
+	// IdleEstimate is the bridge's own guess, from when the user was last seen (see Bridge.SessionIdle).
+	IdleEstimate = "estimate"
+)
+
+// SessionIdle returns how long the session has been idle, and where that came from: IdleCompositor if Mutter reports
+// it, and otherwise IdleEstimate, counted from the latest sign of the user the bridge has, which is a
+// SimulateUserActivity call, a lock taken or released by a peer, or logind's IdleSinceHint if the backend implements
+// backend.IdleSincer. Without any of those since it started, the estimate counts from then. It also updates the
+// session_idle_seconds metric.
+func (b *Bridge) SessionIdle() (time.Duration, string) {
+	idle, source := b.sessionIdle()
+	b.metrics.set(metricSessionIdle, uint64(idle/time.Second))
+	return idle, source
+}
+
+func (b *Bridge) sessionIdle() (time.Duration, string) {
+	if idle, err := b.mutterIdle(); err == nil {
+		return idle, IdleCompositor
+	}
+
+	var active time.Time
+	if err := b.do("SessionIdle", func() { active = b.active }); err != nil {
+		return 0, IdleEstimate
+	}
+	now := b.opts.Clock.Now()
+	if is, ok := b.backend.(backend.IdleSincer); ok {
+		ctx, cancel := context.WithTimeout(b.ctx, jitTimeout)
+		defer cancel()
+		if since, _, err := is.IdleSince(ctx); err != nil {
+			b.errLog.log("Couldn't read logind's idle hint: %v\n", err)
+		} else if at := now.Add(-time.Since(since)); !since.IsZero() && at.After(active) {
+			// The hint goes by the wall clock, the bridge by its Clock, so it is carried over as an age.
+			active = at
+		}
+	}
+	if idle := now.Sub(active); idle > 0 {
+		return idle, IdleEstimate
+	}
+	return 0, IdleEstimate
+}
+
+// userActive records that the user was seen at now (see SessionIdle). It must be called on the actor.
+func (b *Bridge) userActive(now time.Time) {
+	if now.After(b.active) {
+		b.active = now
+	}
+}
+
+// SimulateUserActivity implements org.freedesktop.ScreenSaver.SimulateUserActivity. The bridge can't reset the
+// compositor's idle timer, so it only counts as the user being active for GetSessionIdleTime; lockers that run their
+// own timer are poked for no_lock rules (see pokeLockers).
+func (ss *screenSaver) SimulateUserActivity(from dbus.Sender) (derr *dbus.Error) {
+	defer ss.b.recoverPanic("SimulateUserActivity", &derr)
+
+	ss.b.tracef("SimulateUserActivity from %q.\n", from)
+	return ss.b.do("SimulateUserActivity", func() { ss.b.userActive(ss.b.opts.Clock.Now()) })
+}
+
+// GetSessionIdleTime implements org.freedesktop.ScreenSaver.GetSessionIdleTime, in seconds (see Bridge.SessionIdle).
+func (ss *screenSaver) GetSessionIdleTime() (seconds uint32, derr *dbus.Error) {
+	defer ss.b.recoverPanic("GetSessionIdleTime", &derr)
+
+	idle, _ := ss.b.SessionIdle()
+	return uint32(idle / time.Second), nil
+}
@@ -0,0 +1,31 @@
+package bridge
+
+import (
+	"github.com/coltwillcox/inhibitor/pkg/backend"
+)
+
+// clearIdleHints tells the backend that the sessions of every process holding a lock aren't idle (see
+// Options.IdleHint). It runs on every heartbeat.
+func (b *Bridge) clearIdleHints() {
+	hinter, ok := b.backend.(backend.IdleHinter)
+	if !b.opts.IdleHint || !ok {
+		return
+	}
+
+	var pids []uint32
+	if err := b.do("clearIdleHints", func() {
+		seen := make(map[uint32]bool)
+		for _, ld := range b.locks {
+			if ld.proc != nil && !seen[ld.proc.pid] {
+				seen[ld.proc.pid] = true
+				pids = append(pids, ld.proc.pid)
+			}
+		}
+	}); err != nil || len(pids) == 0 {
+		return
+	}
+
+	if err := hinter.ClearIdleHints(b.ctx, pids); err != nil {
+		b.errLog.log("Couldn't clear the idle hint: %v\n", err)
+	}
+}
@@ -0,0 +1,120 @@
+package bridge
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/godbus/dbus/v5/introspect"
+)
+
+// peerIntrospection describes org.freedesktop.DBus.Peer, which godbus answers on every path by itself.
+var peerIntrospection = introspect.Interface{
+	Name: "org.freedesktop.DBus.Peer",
+	Methods: []introspect.Method{
+		{Name: "Ping"},
+		{Name: "GetMachineId", Args: []introspect.Arg{{Name: "machine_uuid", Type: "s", Direction: "out"}}},
+	},
+}
+
+// exports exports objects on a bus and answers Introspect on every path it exported one on with everything served
+// there: each interface exported through it, org.freedesktop.DBus.Peer and Introspectable, and the paths below. Paths
+// shared by several interfaces, such as an extra path of org.freedesktop.ScreenSaver that is also a compat one, then
+// show their true surface, and objects that come and go, like the lock objects, appear as children of their parents.
+type exports struct {
+	conn  Bus
+	mtx   sync.Mutex
+	paths map[dbus.ObjectPath]map[string]introspect.Interface
+}
+
+func newExports(conn Bus) *exports {
+	return &exports{conn: conn, paths: make(map[dbus.ObjectPath]map[string]introspect.Interface)}
+}
+
+// export exports v on path as the interface iface describes, and lists iface in path's introspection. Exporting an
+// interface again replaces it, which also exports the introspection again.
+func (e *exports) export(v interface{}, path dbus.ObjectPath, iface introspect.Interface) error {
+	if err := e.conn.Export(v, path, iface.Name); err != nil {
+		return fmt.Errorf("couldn't export %q on %q: %v", iface.Name, path, err)
+	}
+	e.mtx.Lock()
+	if e.paths[path] == nil {
+		e.paths[path] = make(map[string]introspect.Interface)
+	}
+	e.paths[path][iface.Name] = iface
+	e.mtx.Unlock()
+	if err := e.conn.Export(pathIntrospection{e, path}, path, intro); err != nil {
+		return fmt.Errorf("couldn't export %q on %q: %v", intro, path, err)
+	}
+	return nil
+}
+
+// unexport withdraws the interface name from path, along with path's introspection once nothing is left there.
+func (e *exports) unexport(path dbus.ObjectPath, name string) {
+	e.conn.Export(nil, path, name)
+	e.mtx.Lock()
+	delete(e.paths[path], name)
+	empty := len(e.paths[path]) == 0
+	if empty {
+		delete(e.paths, path)
+	}
+	e.mtx.Unlock()
+	if empty {
+		e.conn.Export(nil, path, intro)
+	}
+}
+
+// unexportAll withdraws everything exported.
+func (e *exports) unexportAll() {
+	e.mtx.Lock()
+	paths := e.paths
+	e.paths = make(map[dbus.ObjectPath]map[string]introspect.Interface)
+	e.mtx.Unlock()
+	for path, ifaces := range paths {
+		for name := range ifaces {
+			e.conn.Export(nil, path, name)
+		}
+		e.conn.Export(nil, path, intro)
+	}
+}
+
+// introspect returns the introspection data of path.
+func (e *exports) introspect(path dbus.ObjectPath) string {
+	e.mtx.Lock()
+	ifaces := make([]introspect.Interface, 0, len(e.paths[path])+2)
+	for _, iface := range e.paths[path] {
+		ifaces = append(ifaces, iface)
+	}
+	prefix := string(path) + "/"
+	if path == "/" {
+		prefix = "/"
+	}
+	children := make(map[string]bool)
+	for p := range e.paths {
+		if rest := strings.TrimPrefix(string(p), prefix); rest != string(p) && rest != "" {
+			children[strings.SplitN(rest, "/", 2)[0]] = true
+		}
+	}
+	e.mtx.Unlock()
+
+	sort.Slice(ifaces, func(i, j int) bool { return ifaces[i].Name < ifaces[j].Name })
+	node := &introspect.Node{Interfaces: append(ifaces, introspect.IntrospectData, peerIntrospection)}
+	for c := range children {
+		node.Children = append(node.Children, introspect.Node{Name: c})
+	}
+	sort.Slice(node.Children, func(i, j int) bool { return node.Children[i].Name < node.Children[j].Name })
+	return string(introspect.NewIntrospectable(node))
+}
+
+// pathIntrospection is the Introspectable exports exports on each of its paths.
+type pathIntrospection struct {
+	e    *exports
+	path dbus.ObjectPath
+}
+
+// Introspect implements org.freedesktop.DBus.Introspectable.Introspect.
+func (pi pathIntrospection) Introspect() (string, *dbus.Error) {
+	return pi.e.introspect(pi.path), nil
+}
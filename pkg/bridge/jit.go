@@ -0,0 +1,89 @@
+package bridge
+
+import (
+	"context"
+	"time"
+
+	"github.com/coltwillcox/inhibitor/pkg/policy"
+	"github.com/godbus/dbus/v5"
+)
+
+const (
+	// jitLead is how long before Options.JIT a just-in-time lock takes its backend inhibit. It must comfortably
+	// exceed jitPoll.
+	jitLead = 30 * time.Second
+	// jitPoll is how often the idle time is read while just-in-time locks are held.
+	jitPoll = 10 * time.Second
+	// jitTimeout bounds a single read of the idle time, which take waits on.
+	jitTimeout = time.Second
+
+	// The idle time is read from Mutter, GNOME's compositor, which counts it from the last input event.
+	mutterIdleName    = "org.gnome.Mutter.IdleMonitor"
+	mutterIdlePath    = "/org/gnome/Mutter/IdleMonitor/Core"
+	mutterIdleGetIdle = "org.gnome.Mutter.IdleMonitor.GetIdletime"
+)
+
+// jit reports whether a lock on the what-classes what is taken just in time. Only idle locks are: whether sleep or
+// shutdown is blocked has nothing to do with how long the session has been idle.
+func (b *Bridge) jit(what string, delay bool) bool {
+	return b.opts.JIT > 0 && !b.opts.System && what == policy.WhatIdle && !delay
+}
+
+// idleSoon reports whether the session has been idle for long enough that the screen may blank within jitLead. If
+// the idle time can't be read, it errs on the side of taking the locks.
+func (b *Bridge) idleSoon() bool {
+	idle, err := b.mutterIdle()
+	if err != nil {
+		b.errLog.log("Couldn't read the idle time, taking just-in-time locks now: %v\n", err)
+		return true
+	}
+	return idle >= b.opts.JIT-jitLead
+}
+
+// mutterIdle returns how long the session has been idle, as Mutter counts it from the last input event.
+func (b *Bridge) mutterIdle() (time.Duration, error) {
+	ctx, cancel := context.WithTimeout(b.ctx, jitTimeout)
+	defer cancel()
+	var ms uint64
+	if err := b.dbusConn.Object(mutterIdleName, mutterIdlePath).CallWithContext(ctx, mutterIdleGetIdle, dbus.FlagNoAutoStart).Store(&ms); err != nil {
+		return 0, err
+	}
+	return time.Duration(ms) * time.Millisecond, nil
+}
+
+// jitTick takes the backend inhibits of just-in-time locks once the session is about to go idle, and releases them
+// again once the user is back, so that they only show up among logind's inhibitors when they make a difference.
+func (b *Bridge) jitTick() {
+	defer b.recoverPanic("jit", nil)
+
+	soon := b.idleSoon()
+	var due []*lockDetails
+	if err := b.do("jit", func() {
+		for _, ld := range b.locks {
+			if !ld.jit {
+				continue
+			}
+			switch {
+			case soon && ld.pending():
+				due = append(due, ld)
+			case !soon && !ld.pending():
+				b.fds.untrack(ld.fd)
+				ld.fd.Close()
+				ld.fd = nil
+				b.log.Debugf("User active; deferring the backend lock of %s.\n", ld)
+				b.lockChanged(ld.key(), ld.public())
+				b.metrics.addFor(metricJITReleased, ld.id)
+			}
+		}
+	}); err != nil {
+		return
+	}
+
+	for _, ld := range due {
+		if err := b.acquire(ld, "just-in-time"); err != nil {
+			b.errLog.log("Couldn't acquire just-in-time lock: %v\n", err)
+			return
+		}
+		b.metrics.addFor(metricJITAcquired, ld.id)
+	}
+}
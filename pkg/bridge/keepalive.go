@@ -0,0 +1,94 @@
+package bridge
+
+import (
+	"time"
+
+	"github.com/coltwillcox/inhibitor/pkg/policy"
+	"github.com/godbus/dbus/v5"
+)
+
+// InhibitKeepalive is InhibitWhat for a lock that lasts only as long as its holder keeps calling Keepalive for it,
+// at least once every interval, rather than as long as from stays on the bus. It suits scripts that reach the
+// bridge over a fresh connection each time, or through the --fifo, whose crashes leave no bus name behind. An empty
+// what takes the classes the bridge's configuration picks.
+func (b *Bridge) InhibitKeepalive(from dbus.Sender, who, why, what string, interval time.Duration) (uint32, error) {
+	cookie, err := b.inhibitKeepalive(from, who, why, what, interval)
+	if err != nil {
+		return 0, err
+	}
+	return cookie, nil
+}
+
+func (b *Bridge) inhibitKeepalive(from dbus.Sender, who, why, what string, interval time.Duration) (uint32, *dbus.Error) {
+	if what != "" {
+		if _, err := policy.ParseWhat(what); err != nil {
+			return 0, newError(ErrorInvalidArgs, "%v", err)
+		}
+	}
+	if interval < time.Second {
+		return 0, newError(ErrorInvalidArgs, "a keepalive interval must be at least a second, got %s", interval)
+	}
+	cookie, err := b.take(from, who, why, lockRequest{what: what, keepalive: interval})
+	if err != nil {
+		return 0, err
+	}
+	return uint32(cookie), nil
+}
+
+// Keepalive renews the keepalive lock (see InhibitKeepalive) cookie of from's user for another interval. It needn't
+// come from the peer that took the lock.
+func (b *Bridge) Keepalive(from dbus.Sender, cookie uint32) error {
+	if err := b.keepalive(from, cookie); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (b *Bridge) keepalive(from dbus.Sender, cookie uint32) *dbus.Error {
+	uid, err := b.peerUID(from)
+	if err != nil {
+		b.errLog.log("Keepalive from %q denied: %v\n", from, err)
+		return newError(ErrorDenied, "%v", err)
+	}
+
+	var found bool
+	if derr := b.do("Keepalive", func() {
+		for _, ld := range b.locks {
+			if ld.uid != uid || ld.cookie != uint(cookie) || ld.interval == 0 {
+				continue
+			}
+			ld.expires = b.opts.Clock.Now().Add(ld.interval)
+			b.tracef("Kept alive by %q until %s: %s\n", from, ld.expires.Format(time.RFC3339), ld)
+			b.persistLater()
+			found = true
+			return
+		}
+	}); derr != nil {
+		return derr
+	}
+	if !found {
+		b.errLog.log("Keepalive of invalid cookie %d from %q\n", cookie, from)
+		return newError(ErrorInvalidCookie, "%d isn't a keepalive lock of yours", cookie)
+	}
+	return nil
+}
+
+// InhibitKeepalive takes a lock of the colon-separated logind what-classes what, or those rules pick if empty, that
+// is released unless Keepalive is called for it at least every interval seconds (see Bridge.InhibitKeepalive). It is
+// released early with Release, giving the caller's name at the time as the peer.
+func (c *controlAPI) InhibitKeepalive(from dbus.Sender, who, why, what string, interval uint32) (cookie uint32, err *dbus.Error) {
+	defer c.b.recoverPanic("InhibitKeepalive", &err)
+
+	cookie, err = c.b.inhibitKeepalive(from, who, why, what, time.Duration(interval)*time.Second)
+	if err != nil {
+		return 0, err
+	}
+	c.b.log.Printf("Keepalive lock for %q, renewed every %s: %q / %q\n", from, time.Duration(interval)*time.Second, who, why)
+	return cookie, nil
+}
+
+// Keepalive renews one of the caller's user's keepalive locks, from any connection.
+func (c *controlAPI) Keepalive(from dbus.Sender, cookie uint32) (err *dbus.Error) {
+	defer c.b.recoverPanic("Keepalive", &err)
+	return c.b.keepalive(from, cookie)
+}
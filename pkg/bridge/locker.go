@@ -0,0 +1,49 @@
+package bridge
+
+import (
+	"github.com/godbus/dbus/v5"
+)
+
+// locker is a screen locker that runs its own idle timer, which SimulateUserActivity resets.
+type locker struct {
+	name  string
+	path  dbus.ObjectPath
+	iface string
+}
+
+// lockers are the lockers poked while a lock with NoLock is held. Only those already on the bus are called; none is
+// ever started.
+var lockers = []locker{
+	{"org.gnome.ScreenSaver", "/org/gnome/ScreenSaver", "org.gnome.ScreenSaver"},
+	{"org.mate.ScreenSaver", "/org/mate/ScreenSaver", "org.mate.ScreenSaver"},
+	{"org.cinnamon.ScreenSaver", "/org/cinnamon/ScreenSaver", "org.cinnamon.ScreenSaver"},
+	{"org.xfce.ScreenSaver", "/org/xfce/ScreenSaver", "org.xfce.ScreenSaver"},
+}
+
+// pokeLockers resets the idle timer of every running locker while any lock keeps the session from locking. It runs
+// on every heartbeat, which is well inside any sensible lock timeout.
+func (b *Bridge) pokeLockers() {
+	var running []locker
+	if err := b.do("pokeLockers", func() {
+		for _, ld := range b.locks {
+			if !ld.noLock {
+				continue
+			}
+			for _, l := range lockers {
+				if _, ok := b.owners[l.name]; ok {
+					running = append(running, l)
+				}
+			}
+			return
+		}
+	}); err != nil {
+		return
+	}
+
+	for _, l := range running {
+		b.log.Debugf("Resetting the idle timer of %s.\n", l.name)
+		if err := b.dbusConn.Object(l.name, l.path).CallWithContext(b.ctx, l.iface+".SimulateUserActivity", dbus.FlagNoAutoStart).Err; err != nil {
+			b.errLog.log("Couldn't reset the idle timer of %s: %v\n", l.name, err)
+		}
+	}
+}
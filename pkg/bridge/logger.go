@@ -0,0 +1,26 @@
+package bridge
+
+// Logger receives the bridge's log output. Debugf is used for routine status updates that are only interesting when
+// running verbosely; Printf for things that should always be seen, such as recovered panics and security events.
+type Logger interface {
+	Debugf(format string, args ...interface{})
+	Printf(format string, args ...interface{})
+}
+
+type nopLogger struct{}
+
+func (nopLogger) Debugf(string, ...interface{}) {}
+func (nopLogger) Printf(string, ...interface{}) {}
+
+// Tracer is an optional extension of Logger for detail too fine even for Debugf, such as every lock each heartbeat
+// pass checks. Loggers that don't implement it don't get that detail.
+type Tracer interface {
+	Tracef(format string, args ...interface{})
+}
+
+// tracef logs through the Logger's Tracef, if it has one.
+func (b *Bridge) tracef(format string, args ...interface{}) {
+	if t, ok := b.log.(Tracer); ok {
+		t.Tracef(format, args...)
+	}
+}
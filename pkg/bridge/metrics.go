@@ -0,0 +1,174 @@
+package bridge
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/coltwillcox/inhibitor/pkg/telemetry"
+)
+
+// heldBuckets are the upper bounds of the locks_held_seconds histogram: from a video call's worth of locks to the
+// ones held overnight.
+var heldBuckets = []time.Duration{time.Minute, 10 * time.Minute, time.Hour, 4 * time.Hour, 12 * time.Hour}
+
+// Counter names reported through the control interface.
+const (
+	metricFdDiscrepancies = "fd_discrepancies"
+	metricPanics          = "panics_recovered"
+	metricEventsDropped   = "events_dropped"
+	metricLocksGranted    = "locks_granted"
+	metricLocksReleased   = "locks_released"   // by the peer itself
+	metricLocksReaped     = "locks_reaped"     // by the heartbeat, after the peer went away
+	metricLocksRevoked    = "locks_revoked"    // by an admin or the owner change policy
+	metricLocksShared     = "locks_shared"     // backend locks shared with a portal twin (see Options.DedupePortal)
+	metricTabsShared      = "tabs_shared"      // backend locks shared among a browser's tabs (see policy.Quirks)
+	metricLocksOverheld   = "locks_overheld"   // browser locks released after their policy.Quirks.MaxHold
+	metricLocksDowngraded = "locks_downgraded" // from block to delay mode (see Options.MaxBlock)
+	metricWatchdogRepairs = "watchdog_repairs" // names claimed or objects exported again (see Options.Watchdog)
+	metricWakeups         = "wakeups"          // of the timer running periodic work, such as the heartbeat
+	metricHeartbeats      = "heartbeats"       // passes of the heartbeat that checked peers
+	metricJITAcquired     = "jit_acquired"     // just-in-time locks taken from the backend (see Options.JIT)
+	metricJITReleased     = "jit_released"     // just-in-time locks given back once the user was active again
+	metricLocksEmergency  = "locks_emergency"  // released by ReleaseBlocking, e.g. on a critical battery
+	metricLocksDenied     = "locks_denied"     // requests refused, e.g. over Options.MaxLocksPerPeer
+	metricLocksNoop       = "locks_noop"       // handed out while the backend was down (see Options.SoftFail)
+	metricKeepaliveMissed = "keepalive_missed" // keepalive locks reaped after a Keepalive didn't come in time
+	metricBreakerTrips    = "breaker_trips"    // times the circuit breaker opened (see Options.BreakerThreshold)
+	metricBreakerRejected = "breaker_rejected" // backend calls failed at once while it was open
+	metricSuspendsVetoed  = "suspends_vetoed"  // lid closes that locks kept from suspending (see Options.SuspendVeto)
+	metricFaultsInjected  = "faults_injected"  // requests failed or held up on purpose (see policy.Fault)
+
+	metricRequestsRejected = "requests_rejected"  // refused because Options.RequestQueue requests were waiting
+	metricRequestsTimedOut = "requests_timed_out" // refused after waiting Options.RequestTimeout
+	metricRequestsVia      = "requests_via_"      // followed by the name or path requests arrived on (see RequestsVia)
+
+	// Histograms (see counters.observe).
+	metricLocksHeld = "locks_held_seconds" // how long released locks were held, by heldBuckets
+
+	// Gauges, set rather than added to (see Options.Usage).
+	metricRSS        = "rss_bytes"
+	metricGoroutines = "goroutines"
+	metricOpenFds    = "open_fds"
+
+	metricRequestsWaiting = "requests_waiting"       // for a worker (see Bridge.enqueue)
+	metricDegraded        = "backend_degraded"       // 1 while locks are no-ops (see Options.SoftFail)
+	metricBreakerOpen     = "breaker_open"           // 1 while the circuit breaker is open
+	metricSessionIdle     = "session_idle_seconds"   // as of the last heartbeat or GetSessionIdleTime (see SessionIdle)
+	metricHeartbeatTook   = "heartbeat_microseconds" // the last heartbeat pass took to check peers and reap locks
+)
+
+// The names and paths requests arrive on that no compat toggle names (see RequestsVia).
+const (
+	// ViaScreenSaver is org.freedesktop.ScreenSaver on /org/freedesktop/ScreenSaver.
+	ViaScreenSaver = "screensaver"
+	// ViaExtraPaths is org.freedesktop.ScreenSaver on any of Options.ExtraPaths.
+	ViaExtraPaths = "extra_paths"
+)
+
+// RequestsVia picks out of metrics, as Metrics returns them, how many Inhibit requests arrived on each name and path
+// served: ViaScreenSaver, ViaExtraPaths and each compat toggle (see CompatToggles), whether it is on or not. Locks
+// taken through the management interface aren't counted. Names that receive none can be turned off.
+func RequestsVia(metrics map[string]uint64) map[string]uint64 {
+	via := map[string]uint64{ViaScreenSaver: metrics[metricRequestsVia+ViaScreenSaver], ViaExtraPaths: metrics[metricRequestsVia+ViaExtraPaths]}
+	for _, t := range CompatToggles() {
+		via[t] = metrics[metricRequestsVia+t]
+	}
+	return via
+}
+
+// BackendState picks out of metrics, as Metrics returns them, whether the circuit breaker is open (see
+// Options.BreakerThreshold) and whether locks are no-ops (see Options.SoftFail).
+func BackendState(metrics map[string]uint64) (breakerOpen, degraded bool) {
+	return metrics[metricBreakerOpen] != 0, metrics[metricDegraded] != 0
+}
+
+// counters is a minimal registry of monotonically increasing counters, and a few gauges, exported via the control
+// interface, with the exemplars of those that count locks. Every update is passed on to sink (see
+// Options.MetricsSink).
+type counters struct {
+	mtx       sync.Mutex
+	m         map[string]uint64
+	exemplars map[string]string // the correlation ID last counted, by counter (see addFor)
+	sink      telemetry.Sink
+}
+
+func newCounters(sink telemetry.Sink) *counters {
+	return &counters{m: make(map[string]uint64), exemplars: make(map[string]string), sink: sink}
+}
+
+// add increments the named counter by n.
+func (c *counters) add(name string, n uint64) {
+	c.mtx.Lock()
+	c.m[name] += n
+	c.mtx.Unlock()
+	c.sink.Count(name, n)
+}
+
+// set sets the named gauge to v.
+func (c *counters) set(name string, v uint64) {
+	c.mtx.Lock()
+	c.m[name] = v
+	c.mtx.Unlock()
+	c.sink.Gauge(name, v)
+}
+
+// addFor increments the named counter by one for the lock with correlation ID id, which becomes its exemplar.
+func (c *counters) addFor(name, id string) {
+	c.mtx.Lock()
+	c.m[name]++
+	c.exemplars[name] = id
+	c.mtx.Unlock()
+	c.sink.Count(name, 1)
+}
+
+// observe records d, of the lock with correlation ID id, in the histogram name, Prometheus style: name_le_S counts
+// the observations of at most S seconds for each of bounds, and name_le_inf all of them, so the buckets are
+// cumulative; name_sum adds up the seconds observed. id becomes the exemplar of the smallest bucket d falls in. The
+// sink gets d itself, to bucket or summarize as it sees fit.
+func (c *counters) observe(name string, bounds []time.Duration, d time.Duration, id string) {
+	defer c.sink.Observe(name, d)
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	bucket := name + "_le_inf"
+	for i := len(bounds) - 1; i >= 0; i-- {
+		if le := bounds[i]; d <= le {
+			bucket = fmt.Sprintf("%s_le_%d", name, le/time.Second)
+			c.m[bucket]++
+		}
+	}
+	c.m[name+"_le_inf"]++
+	c.m[name+"_sum"] += uint64(d / time.Second)
+	c.exemplars[bucket] = id
+}
+
+// get returns the current value of the named counter.
+func (c *counters) get(name string) uint64 {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return c.m[name]
+}
+
+// exemplarSnapshot returns a copy of every exemplar.
+func (c *counters) exemplarSnapshot() map[string]string {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	s := make(map[string]string, len(c.exemplars))
+	for k, v := range c.exemplars {
+		s[k] = v
+	}
+	return s
+}
+
+// snapshot returns a copy of every counter.
+func (c *counters) snapshot() map[string]uint64 {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	s := make(map[string]uint64, len(c.m))
+	for k, v := range c.m {
+		s[k] = v
+	}
+	return s
+}
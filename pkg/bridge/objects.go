@@ -0,0 +1,350 @@
+package bridge
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/godbus/dbus/v5/introspect"
+)
+
+const (
+	// LockInterface is the interface of the lock objects under LockPathPrefix, whose properties describe a lock and
+	// whose Release method drops it.
+	LockInterface = ControlInterface + ".Lock"
+	// LockPathPrefix is where every lock is published as an object of its own, named by its cookie, for generic D-Bus
+	// tooling such as busctl tree or d-feet. /org/freedesktop/ScreenSaver is their org.freedesktop.DBus.ObjectManager.
+	LockPathPrefix = screensaverPath + "/lock/"
+
+	objectManager     = "org.freedesktop.DBus.ObjectManager"
+	propertiesIface   = "org.freedesktop.DBus.Properties"
+	interfacesAdded   = objectManager + ".InterfacesAdded"
+	interfacesRemoved = objectManager + ".InterfacesRemoved"
+	propertiesChanged = propertiesIface + ".PropertiesChanged"
+
+	errUnknownInterface = "org.freedesktop.DBus.Error.UnknownInterface"
+	errUnknownProperty  = "org.freedesktop.DBus.Error.UnknownProperty"
+	errPropertyReadOnly = "org.freedesktop.DBus.Error.PropertyReadOnly"
+)
+
+// lockProperties are the properties of LockInterface, for introspection. Expires and Since are Unix times; Expires is
+// 0 if the lock lasts as long as its peer.
+var lockProperties = []introspect.Property{
+	{Name: "Cookie", Type: "u", Access: "read"},
+	{Name: "Peer", Type: "s", Access: "read"},
+	{Name: "Who", Type: "s", Access: "read"},
+	{Name: "Why", Type: "s", Access: "read"},
+	{Name: "What", Type: "s", Access: "read"},
+	{Name: "UID", Type: "u", Access: "read"},
+	{Name: "Since", Type: "x", Access: "read"},
+	{Name: "Expires", Type: "x", Access: "read"},
+	{Name: "Pending", Type: "b", Access: "read"},
+	{Name: "App", Type: "s", Access: "read"},
+	{Name: "Note", Type: "s", Access: "read"},
+	{Name: "Window", Type: "s", Access: "read"},
+	{Name: "ID", Type: "s", Access: "read"},
+}
+
+// objectManagerSignals are the signals of org.freedesktop.DBus.ObjectManager, for introspection.
+var objectManagerSignals = []introspect.Signal{
+	{Name: "InterfacesAdded", Args: []introspect.Arg{{Name: "object_path", Type: "o"}, {Name: "interfaces_and_properties", Type: "a{sa{sv}}"}}},
+	{Name: "InterfacesRemoved", Args: []introspect.Arg{{Name: "object_path", Type: "o"}, {Name: "interfaces", Type: "as"}}},
+}
+
+// lockPath returns the object path of the lock with cookie. newCookie keeps cookies unique across peers, so that
+// every lock gets a path of its own.
+func lockPath(cookie uint) dbus.ObjectPath {
+	return dbus.ObjectPath(fmt.Sprintf("%s%d", LockPathPrefix, cookie))
+}
+
+// properties returns the LockInterface properties of l.
+func (l Lock) properties() map[string]dbus.Variant {
+	var expires int64
+	if !l.Expires.IsZero() {
+		expires = l.Expires.Unix()
+	}
+	return map[string]dbus.Variant{
+		"Cookie":  dbus.MakeVariant(l.Cookie),
+		"Peer":    dbus.MakeVariant(l.Peer),
+		"Who":     dbus.MakeVariant(l.Who),
+		"Why":     dbus.MakeVariant(l.Why),
+		"What":    dbus.MakeVariant(l.What),
+		"UID":     dbus.MakeVariant(l.UID),
+		"Since":   dbus.MakeVariant(l.Since.Unix()),
+		"Expires": dbus.MakeVariant(expires),
+		"Pending": dbus.MakeVariant(l.Pending),
+		"App":     dbus.MakeVariant(l.App),
+		"Note":    dbus.MakeVariant(l.Note),
+		"Window":  dbus.MakeVariant(l.Window),
+		"ID":      dbus.MakeVariant(l.ID),
+	}
+}
+
+// syncObject keeps the lock objects in step with ev. It must be called on the actor.
+func (b *Bridge) syncObject(ev Event) {
+	k := lockKey{uid: ev.Lock.UID, peer: dbus.Sender(ev.Lock.Peer), cookie: uint(ev.Lock.Cookie)}
+	switch ev.Type {
+	case LockAdded:
+		b.exportLock(k, ev.Lock)
+	case LockRemoved:
+		b.unexportLock(k)
+	case LockAcquired, LockAnnotated:
+		b.lockChanged(k, ev.Lock)
+	}
+}
+
+// exportLock publishes the lock under k as an object. It must be called on the actor.
+func (b *Bridge) exportLock(k lockKey, l Lock) {
+	p := lockPath(k.cookie)
+	if other, ok := b.objects[p]; ok && other != k {
+		// Only locks adopted from a version that didn't keep cookies unique can clash.
+		b.log.Debugf("Not publishing %s: %s is taken.\n", l, p)
+		return
+	}
+	c := &controlAPI{b: b}
+	if err := b.exports.export(&lockObject{c: c, path: p}, p, lockPropertiesIntrospection); err != nil {
+		b.errLog.log("%v\n", err)
+		return
+	}
+	b.exports.export(&lockAPI{c: c, path: p}, p, lockIntrospection)
+	b.objects[p] = k
+	b.signals.add(screensaverPath, interfacesAdded, p, map[string]map[string]dbus.Variant{LockInterface: b.signalled(l)})
+}
+
+// unexportLock withdraws the object of the lock under k. It must be called on the actor.
+func (b *Bridge) unexportLock(k lockKey) {
+	p := lockPath(k.cookie)
+	if b.objects[p] != k {
+		return
+	}
+	b.exports.unexport(p, propertiesIface)
+	b.exports.unexport(p, LockInterface)
+	delete(b.objects, p)
+	b.signals.add(screensaverPath, interfacesRemoved, p, []string{LockInterface})
+}
+
+// lockChanged announces that the properties of the lock under k changed. It must be called on the actor.
+func (b *Bridge) lockChanged(k lockKey, l Lock) {
+	p := lockPath(k.cookie)
+	if b.objects[p] != k {
+		return
+	}
+	if b.opts.System {
+		names := make([]string, 0, len(lockProperties))
+		for _, prop := range lockProperties {
+			names = append(names, prop.Name)
+		}
+		b.signals.add(p, propertiesChanged, LockInterface, map[string]dbus.Variant{}, names)
+		return
+	}
+	b.signals.add(p, propertiesChanged, LockInterface, l.properties(), []string{})
+}
+
+// signalled returns the properties of l to broadcast. On the system bus, where anyone could listen, they are left out;
+// only the lock's owner and admins may read them (see lockObject.GetAll).
+func (b *Bridge) signalled(l Lock) map[string]dbus.Variant {
+	if b.opts.System {
+		return map[string]dbus.Variant{}
+	}
+	return l.properties()
+}
+
+// visibleLock returns the lock published at p if the caller, uid, may see it, or nil. It must be called on the actor.
+func (b *Bridge) visibleLock(p dbus.ObjectPath, uid uint32, admin bool) *lockDetails {
+	k, ok := b.objects[p]
+	if !ok {
+		return nil
+	}
+	ld := b.locks[k]
+	if ld == nil || (ld.uid != uid && !admin) {
+		return nil
+	}
+	return ld
+}
+
+// objectManagerAPI is org.freedesktop.DBus.ObjectManager on /org/freedesktop/ScreenSaver.
+type objectManagerAPI struct {
+	c *controlAPI
+}
+
+// GetManagedObjects implements org.freedesktop.DBus.ObjectManager.GetManagedObjects. Like ListInhibits, it only
+// returns the caller's own locks unless the caller is an admin.
+func (om *objectManagerAPI) GetManagedObjects(from dbus.Sender) (objects map[dbus.ObjectPath]map[string]map[string]dbus.Variant, err *dbus.Error) {
+	defer om.c.b.recoverPanic("GetManagedObjects", &err)
+
+	uid, admin, err := om.c.caller(from)
+	if err != nil {
+		return nil, err
+	}
+
+	objects = make(map[dbus.ObjectPath]map[string]map[string]dbus.Variant)
+	if derr := om.c.b.do("GetManagedObjects", func() {
+		for p := range om.c.b.objects {
+			if ld := om.c.b.visibleLock(p, uid, admin); ld != nil {
+				objects[p] = map[string]map[string]dbus.Variant{LockInterface: ld.public().properties()}
+			}
+		}
+	}); derr != nil {
+		return nil, derr
+	}
+	return objects, nil
+}
+
+// lockObject is org.freedesktop.DBus.Properties on a lock's object. Only the lock's owner and admins may read them.
+type lockObject struct {
+	c    *controlAPI
+	path dbus.ObjectPath
+}
+
+// GetAll implements org.freedesktop.DBus.Properties.GetAll.
+func (o *lockObject) GetAll(from dbus.Sender, iface string) (props map[string]dbus.Variant, err *dbus.Error) {
+	defer o.c.b.recoverPanic("GetAll", &err)
+
+	if iface != LockInterface {
+		return nil, newError(errUnknownInterface, "%s has no properties of %q", o.path, iface)
+	}
+	uid, admin, err := o.c.caller(from)
+	if err != nil {
+		return nil, err
+	}
+
+	var l *Lock
+	if derr := o.c.b.do("GetAll", func() {
+		if ld := o.c.b.visibleLock(o.path, uid, admin); ld != nil {
+			pub := ld.public()
+			l = &pub
+		}
+	}); derr != nil {
+		return nil, derr
+	}
+	if l == nil {
+		return nil, newError(ErrorDenied, "%s isn't one of the locks of uid %d", o.path, uid)
+	}
+	return l.properties(), nil
+}
+
+// Get implements org.freedesktop.DBus.Properties.Get.
+func (o *lockObject) Get(from dbus.Sender, iface, name string) (v dbus.Variant, err *dbus.Error) {
+	props, err := o.GetAll(from, iface)
+	if err != nil {
+		return dbus.Variant{}, err
+	}
+	v, ok := props[name]
+	if !ok {
+		return dbus.Variant{}, newError(errUnknownProperty, "%s has no property %q", LockInterface, name)
+	}
+	return v, nil
+}
+
+// Set implements org.freedesktop.DBus.Properties.Set. Every property is read-only; notes are set with Annotate.
+func (o *lockObject) Set(iface, name string, v dbus.Variant) *dbus.Error {
+	return newError(errPropertyReadOnly, "%s.%s is read-only", iface, name)
+}
+
+// lockAPI is LockInterface on a lock's object.
+type lockAPI struct {
+	c    *controlAPI
+	path dbus.ObjectPath
+}
+
+// Release drops the lock, like the control interface's Release: only its owner and admins may, and the lock of anyone
+// else is reported exactly like one that doesn't exist.
+func (l *lockAPI) Release(from dbus.Sender) (err *dbus.Error) {
+	defer l.c.b.recoverPanic("Lock.Release", &err)
+
+	uid, admin, err := l.c.caller(from)
+	if err != nil {
+		return err
+	}
+
+	if derr := l.c.b.do("Lock.Release", func() {
+		ld := l.c.b.visibleLock(l.path, uid, admin)
+		if ld == nil {
+			l.c.b.errLog.log("Release of %s from %q denied\n", l.path, from)
+			err = newError(ErrorInvalidCookie, "%s isn't one of the locks of uid %d", l.path, uid)
+			return
+		}
+		err = l.c.release(from, ld)
+	}); derr != nil {
+		return derr
+	}
+
+	return err
+}
+
+// The introspection data of the interfaces of a lock's object.
+var (
+	lockPropertiesIntrospection = introspect.Interface{
+		Name:    propertiesIface,
+		Methods: introspect.Methods(&lockObject{}),
+		Signals: []introspect.Signal{{
+			Name: "PropertiesChanged",
+			Args: []introspect.Arg{{Name: "interface_name", Type: "s"}, {Name: "changed_properties", Type: "a{sv}"}, {Name: "invalidated_properties", Type: "as"}},
+		}},
+	}
+	lockIntrospection = introspect.Interface{Name: LockInterface, Methods: introspect.Methods(&lockAPI{}), Properties: lockProperties}
+)
+
+// exportObjectManager serves org.freedesktop.DBus.ObjectManager on /org/freedesktop/ScreenSaver, whose introspection
+// then lists it, next to org.freedesktop.ScreenSaver, and the lock objects below it.
+func (b *Bridge) exportObjectManager() error {
+	c := &controlAPI{b: b}
+	return b.exports.export(&objectManagerAPI{c: c}, screensaverPath, introspect.Interface{
+		Name:    objectManager,
+		Methods: introspect.Methods(&objectManagerAPI{}),
+		Signals: objectManagerSignals,
+	})
+}
+
+// signalQueue sends the lock objects' signals in the order they were queued, off the actor, which must never block on
+// the bus.
+type signalQueue struct {
+	mtx     sync.Mutex
+	pending []queuedSignal
+	wake    chan struct{}
+}
+
+type queuedSignal struct {
+	path   dbus.ObjectPath
+	name   string
+	values []interface{}
+}
+
+func newSignalQueue() *signalQueue {
+	return &signalQueue{wake: make(chan struct{}, 1)}
+}
+
+// add queues a signal. It never blocks.
+func (q *signalQueue) add(path dbus.ObjectPath, name string, values ...interface{}) {
+	q.mtx.Lock()
+	q.pending = append(q.pending, queuedSignal{path, name, values})
+	q.mtx.Unlock()
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// sendSignals sends the queued signals until the bridge is closed. Buses that can't send signals just drop them.
+func (b *Bridge) sendSignals() error {
+	e, _ := b.dbusConn.(emitter)
+	for {
+		select {
+		case <-b.ctx.Done():
+			return nil
+		case <-b.signals.wake:
+		}
+		b.signals.mtx.Lock()
+		pending := b.signals.pending
+		b.signals.pending = nil
+		b.signals.mtx.Unlock()
+		if e == nil {
+			continue
+		}
+		for _, s := range pending {
+			if err := e.Emit(s.path, s.name, s.values...); err != nil {
+				b.errLog.log("Couldn't emit %s: %v\n", s.name, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package bridge
+
+import (
+	"time"
+
+	"github.com/coltwillcox/inhibitor/pkg/backend"
+	"github.com/coltwillcox/inhibitor/pkg/policy"
+	"github.com/coltwillcox/inhibitor/pkg/telemetry"
+)
+
+// Option configures a Bridge in NewBridge. Options are applied in order, so a later one wins. An Options value is an
+// Option too, which sets every field at once: pass it first and the With options after it to override single
+// dependencies, as tests do:
+//
+//	b, err := bridge.NewBridge(ctx, bridge.Options{Prog: "test"}, bridge.WithBus(bus), bridge.WithBackend(be))
+type Option interface {
+	apply(*Options)
+}
+
+// apply implements Option by replacing every field.
+func (o Options) apply(opts *Options) {
+	*opts = o
+}
+
+// optionFunc is an Option that changes a single field.
+type optionFunc func(*Options)
+
+func (f optionFunc) apply(opts *Options) {
+	f(opts)
+}
+
+// WithBus serves requests on bus rather than a connection of the bridge's own (see Options.Bus).
+func WithBus(bus Bus) Option {
+	return optionFunc(func(o *Options) { o.Bus = bus })
+}
+
+// WithBackend takes locks from be rather than logind (see Options.Backend).
+func WithBackend(be backend.Backend) Option {
+	return optionFunc(func(o *Options) { o.Backend = be })
+}
+
+// WithPolicy decides which requests are accepted with p (see Options.Policy).
+func WithPolicy(p *policy.Policy) Option {
+	return optionFunc(func(o *Options) { o.Policy = p })
+}
+
+// WithLogger sends log output to l (see Options.Logger).
+func WithLogger(l Logger) Option {
+	return optionFunc(func(o *Options) { o.Logger = l })
+}
+
+// WithClock tells the time with c (see Options.Clock).
+func WithClock(c Clock) Option {
+	return optionFunc(func(o *Options) { o.Clock = c })
+}
+
+// WithEventSink passes every event on to sink, none dropped (see Options.EventSink).
+func WithEventSink(sink func(Event)) Option {
+	return optionFunc(func(o *Options) { o.EventSink = sink })
+}
+
+// WithMetricsSink passes every metric update on to sink (see Options.MetricsSink).
+func WithMetricsSink(sink telemetry.Sink) Option {
+	return optionFunc(func(o *Options) { o.MetricsSink = sink })
+}
+
+// Clock tells the bridge the time and wakes it up: its periodic work, such as the heartbeat, lock expiry and
+// downgrades, backend retries and the usage statistics all go by it, so that tests and simulations can substitute one
+// they fast-forward (see bridgetest.Clock). Only the handover of a hot upgrade or takeover, which other processes take
+// part in, goes by the system clock.
+//
+// Lock times are compared, not read as dates, so a Clock only has to be steady: the system one reads the monotonic
+// clock, which DST and time zone changes or a stepped wall clock leave alone.
+type Clock interface {
+	Now() time.Time
+	// NewTimer returns a Timer that fires once d has passed.
+	NewTimer(d time.Duration) Timer
+}
+
+// Timer is a single event of a Clock, like a time.Timer.
+type Timer interface {
+	// C receives the time once the timer fires.
+	C() <-chan time.Time
+	// Reset makes the timer fire once d has passed from now. It reports whether the timer was active; like
+	// time.Timer.Reset, it should only be called on a stopped or fired timer whose channel has been drained.
+	Reset(d time.Duration) bool
+	// Stop keeps the timer from firing. It reports whether the timer was active.
+	Stop() bool
+}
+
+// systemClock is the Clock of the real world.
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) NewTimer(d time.Duration) Timer {
+	return systemTimer{time.NewTimer(d)}
+}
+
+type systemTimer struct {
+	t *time.Timer
+}
+
+func (t systemTimer) C() <-chan time.Time        { return t.t.C }
+func (t systemTimer) Reset(d time.Duration) bool { return t.t.Reset(d) }
+func (t systemTimer) Stop() bool                 { return t.t.Stop() }
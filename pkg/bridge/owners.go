@@ -0,0 +1,150 @@
+package bridge
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/coltwillcox/inhibitor/pkg/policy"
+	"github.com/godbus/dbus/v5"
+)
+
+const (
+	getNameOwner     = "org.freedesktop.DBus.GetNameOwner"
+	nameOwnerChanged = "NameOwnerChanged"
+)
+
+// nameOwners mirrors the owner of every well-known name on the bus, so that locks can record which well-known names
+// their peer held and we can tell when one of those names moves to a different connection.
+type nameOwners map[string]dbus.Sender
+
+// ownedBy returns the well-known names currently owned by peer. It must be called on the actor.
+func (no nameOwners) ownedBy(peer dbus.Sender) []string {
+	var names []string
+	for n, o := range no {
+		if o == peer {
+			names = append(names, n)
+		}
+	}
+	return names
+}
+
+// watchNameOwners seeds the name owner table and then follows NameOwnerChanged until the bridge's context is done or
+// the bus connection closes.
+func (b *Bridge) watchNameOwners() error {
+	if err := b.dbusConn.AddMatchSignal(dbus.WithMatchInterface("org.freedesktop.DBus"), dbus.WithMatchMember(nameOwnerChanged)); err != nil {
+		return fmt.Errorf("couldn't watch %s: %v", nameOwnerChanged, err)
+	}
+	ch := make(chan *dbus.Signal, 64)
+	b.dbusConn.Signal(ch)
+
+	var names []string
+	if err := b.dbusConn.BusObject().CallWithContext(b.ctx, listNames, 0).Store(&names); err != nil {
+		return fmt.Errorf("calling %q: %v", listNames, err)
+	}
+	owners := make(nameOwners)
+	for _, n := range names {
+		if strings.HasPrefix(n, ":") {
+			continue
+		}
+		var owner string
+		if err := b.dbusConn.BusObject().CallWithContext(b.ctx, getNameOwner, 0, n).Store(&owner); err == nil {
+			owners[n] = dbus.Sender(owner)
+		}
+	}
+	b.do("watchNameOwners", func() {
+		for n, o := range owners {
+			b.owners[n] = o
+		}
+		// The owner may have gone away before NameOwnerChanged was watched.
+		if self := b.Name(); !b.serving && owners[screensaver] == self {
+			b.serving = true
+			b.log.Printf("%s acquired.\n", screensaver)
+			b.emit(Event{Type: NameAcquired, Message: "took over while starting"})
+		}
+	})
+
+	b.group.Go(func() error {
+		for {
+			select {
+			case <-b.ctx.Done():
+				return nil
+			case sig, ok := <-ch:
+				if !ok {
+					return nil
+				}
+				if sig.Name != "org.freedesktop.DBus."+nameOwnerChanged || len(sig.Body) != 3 {
+					continue
+				}
+				name, _ := sig.Body[0].(string)
+				oldOwner, _ := sig.Body[1].(string)
+				newOwner, _ := sig.Body[2].(string)
+				b.nameOwnerChanged(name, dbus.Sender(oldOwner), dbus.Sender(newOwner))
+			}
+		}
+	})
+
+	return nil
+}
+
+// nameOwnerChanged updates the owner table and audits any lock whose peer held name when it was requested but has now
+// lost it to a different connection.
+func (b *Bridge) nameOwnerChanged(name string, oldOwner, newOwner dbus.Sender) {
+	defer b.recoverPanic(nameOwnerChanged, nil)
+
+	if strings.HasPrefix(name, ":") {
+		return
+	}
+
+	b.do(nameOwnerChanged, func() {
+		if newOwner == "" {
+			delete(b.owners, name)
+		} else {
+			b.owners[name] = newOwner
+		}
+		if oldOwner == "" || newOwner == "" {
+			return
+		}
+
+		if name == screensaver {
+			switch self := b.Name(); self {
+			case oldOwner:
+				b.serving = false
+				b.log.Printf("%s was taken over by %q.\n", screensaver, newOwner)
+				b.emit(Event{Type: NameLost, Message: fmt.Sprintf("replaced by %s", newOwner)})
+				return
+			case newOwner:
+				b.serving = true
+				b.log.Printf("%s acquired from %q.\n", screensaver, oldOwner)
+				b.emit(Event{Type: NameAcquired, Message: fmt.Sprintf("took over from %s", oldOwner)})
+				return
+			}
+		}
+
+		for _, ld := range b.locks {
+			if ld.peer != oldOwner || !ld.heldName(name) {
+				continue
+			}
+
+			b.log.Printf("SECURITY: %q moved from %q to %q while %s was held (policy: %s)\n", name, oldOwner, newOwner, ld, b.policy.OwnerChange)
+			msg := fmt.Sprintf("%s changed owner while holding a screen lock inhibit.", name)
+			if b.policy.OwnerChange == policy.OwnerChangeRelease {
+				if err := b.dropLock(ld, "peer lost "+name); err != nil {
+					b.log.Debugf("Error releasing %s: %v\n", ld, err)
+				}
+				b.metrics.addFor(metricLocksRevoked, ld.id)
+				msg += " The inhibit was released."
+			}
+			b.emit(Event{Type: OwnerChanged, Lock: ld.public(), Message: msg})
+		}
+	})
+}
+
+// heldName reports whether ld's peer owned the well-known name when the lock was requested.
+func (ld *lockDetails) heldName(name string) bool {
+	for _, n := range ld.names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,77 @@
+package bridge
+
+import (
+	"errors"
+
+	"github.com/coltwillcox/inhibitor/pkg/store"
+)
+
+// locksRecord is the Options.Store record the lock table is kept in, as a Handoff.
+const locksRecord = "locks"
+
+// persists reports whether an event of type t changes what Options.Store keeps.
+func (t EventType) persists() bool {
+	return t == LockAdded || t == LockAcquired || t == LockRemoved || t == LockAnnotated
+}
+
+// persistLater has persistLoop save the lock table. It must be called on the actor, and never blocks.
+func (b *Bridge) persistLater() {
+	select {
+	case b.persistCh <- struct{}{}:
+	default:
+		// A save is already due, and it takes whatever changed since.
+	}
+}
+
+// persistLoop saves the lock table to Options.Store whenever persistLater asks, until the bridge is closed. The store
+// is written off the actor, so that a slow disk doesn't hold up requests.
+func (b *Bridge) persistLoop() error {
+	for {
+		select {
+		case <-b.ctx.Done():
+			return nil
+		case <-b.persistCh:
+		}
+		var h *Handoff
+		if err := b.do("persist", func() { h = b.snapshot() }); err != nil {
+			return nil
+		}
+		if err := store.PutJSON(b.opts.Store, locksRecord, h); err != nil {
+			b.errLog.log("Error saving the lock table: %v\n", err)
+		}
+	}
+}
+
+// forgetLocks empties the saved lock table once every lock has been released, so that the next bridge has nothing to
+// recover but the pending schedules.
+func (b *Bridge) forgetLocks(schedules []Schedule) {
+	if err := store.PutJSON(b.opts.Store, locksRecord, &Handoff{Name: string(b.Name()), Locks: []HandoffLock{}, Schedules: schedules}); err != nil {
+		b.log.Printf("Error saving the lock table: %v\n", err)
+	}
+}
+
+// recoverLocks takes back the locks Options.Store says a previous bridge held when it went away without releasing
+// them, which only happens if it crashed. Like adopted ones, they keep their cookies, so that their peers can still
+// release them, but the backend inhibits died with the process, so they start out provisional and are taken again
+// by the next heartbeat, once it has reaped those whose peers went away too. It must be called on the actor, before
+// the bridge starts serving requests.
+func (b *Bridge) recoverLocks() {
+	var h Handoff
+	if err := store.GetJSON(b.opts.Store, locksRecord, &h); err != nil {
+		if !errors.Is(err, store.ErrNotFound) {
+			b.log.Printf("Not recovering locks: %v\n", err)
+		}
+		return
+	}
+	if len(h.Locks) == 0 && len(h.Schedules) == 0 {
+		return
+	}
+	for i := range h.Locks {
+		// The fd numbers were the crashed process's.
+		h.Locks[i].FD, h.Locks[i].DelayFD = -1, -1
+	}
+	if len(h.Locks) > 0 {
+		b.log.Printf("Recovering %d locks held when the previous instance stopped.\n", len(h.Locks))
+	}
+	b.adopt(&h, "recovered after the previous instance stopped")
+}
@@ -0,0 +1,46 @@
+package bridge
+
+import (
+	"github.com/godbus/dbus/v5"
+)
+
+const (
+	polkitName       = "org.freedesktop.PolicyKit1"
+	polkitPath       = "/org/freedesktop/PolicyKit1/Authority"
+	polkitCheckAuth  = "org.freedesktop.PolicyKit1.Authority.CheckAuthorization"
+	polkitManageLock = "io.github.coltwillcox.inhibitor.manage-all"
+)
+
+// polkitSubject is the (sa{sv}) subject struct expected by CheckAuthorization.
+type polkitSubject struct {
+	Kind    string
+	Details map[string]dbus.Variant
+}
+
+// polkitResult is the (bba{ss}) result struct returned by CheckAuthorization.
+type polkitResult struct {
+	IsAuthorized bool
+	IsChallenge  bool
+	Details      map[string]string
+}
+
+// polkitAdmin reports whether polkit authorizes peer to manage every user's locks. Only meaningful on the system bus;
+// session bridges serve a single user and never consult polkit.
+func (b *Bridge) polkitAdmin(peer dbus.Sender) bool {
+	if !b.opts.System {
+		return false
+	}
+
+	subject := polkitSubject{
+		Kind:    "system-bus-name",
+		Details: map[string]dbus.Variant{"name": dbus.MakeVariant(string(peer))},
+	}
+	var res polkitResult
+	err := b.dbusConn.Object(polkitName, polkitPath).CallWithContext(b.ctx, polkitCheckAuth, 0, subject, polkitManageLock, map[string]string{}, uint32(0), "").Store(&res)
+	if err != nil {
+		b.errLog.log("Polkit check for %q failed: %v\n", peer, err)
+		return false
+	}
+
+	return res.IsAuthorized
+}
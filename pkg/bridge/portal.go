@@ -0,0 +1,74 @@
+package bridge
+
+import (
+	"os"
+	"strings"
+	"syscall"
+
+	"github.com/godbus/dbus/v5"
+)
+
+// portalPrefixes are the well-known name prefixes of xdg-desktop-portal and its backends, which inhibit on behalf of
+// sandboxed applications.
+var portalPrefixes = []string{"org.freedesktop.portal.", "org.freedesktop.impl.portal."}
+
+// isPortal reports whether a peer owning names is part of xdg-desktop-portal.
+func isPortal(names []string) bool {
+	for _, n := range names {
+		for _, p := range portalPrefixes {
+			if strings.HasPrefix(n, p) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// appName reduces who to a form that matches between the portal, which passes on the application ID, and the
+// application itself: "org.mozilla.firefox" and "Firefox" both become "firefox".
+func appName(who string) string {
+	who = strings.ToLower(who)
+	if i := strings.LastIndexByte(who, '.'); i >= 0 {
+		who = who[i+1:]
+	}
+	return who
+}
+
+// appKey is appName of a lock's Flatpak application ID if it is known, and of who otherwise.
+func AppKey(app, who string) string {
+	if app != "" {
+		return appName(app)
+	}
+	return appName(who)
+}
+
+// portalTwin returns a held lock that a new request from peer duplicates: the same user, application, reason and
+// what-classes, with exactly one of the two coming through the portal. Flatpak browsers often inhibit both ways at
+// once. app is the peer's Flatpak application ID, if known, which identifies it better than who. It must be called
+// on the actor.
+func (b *Bridge) portalTwin(uid uint32, peer dbus.Sender, app, what, who, why string) *lockDetails {
+	portal := isPortal(b.owners.ownedBy(peer))
+	name := AppKey(app, who)
+	for _, ld := range b.locks {
+		if ld.uid != uid || ld.pending() || ld.why != why || ld.what != what || AppKey(ld.app, ld.who) != name {
+			continue
+		}
+		if isPortal(ld.names) != portal {
+			return ld
+		}
+	}
+	return nil
+}
+
+// shareFd returns a duplicate of a lock's backend fd. The backend releases its lock once every copy is closed, so
+// each lock sharing it can still be released on its own.
+func shareFd(f *os.File) (*os.File, error) {
+	syscall.ForkLock.RLock()
+	defer syscall.ForkLock.RUnlock()
+	fd, err := syscall.Dup(int(f.Fd()))
+	if err != nil {
+		return nil, err
+	}
+	syscall.CloseOnExec(fd)
+	return os.NewFile(uintptr(fd), f.Name()), nil
+}
@@ -0,0 +1,111 @@
+package bridge
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/coltwillcox/inhibitor/pkg/backend"
+	"github.com/coltwillcox/inhibitor/pkg/policy"
+	"github.com/godbus/dbus/v5"
+)
+
+// probeTimeout bounds the startup probe of what the backend permits, so that a backend that doesn't answer doesn't
+// hold startup up.
+const probeTimeout = 5 * time.Second
+
+// probeBackend finds out which what-classes and modes the backend refuses this user (see backend.Prober), such as
+// sleep, which polkit commonly restricts, so that locks leave them out, or are refused with the polkit action that
+// would allow them, rather than failing with whatever error the backend gives. If the backend can't tell, e.g.
+// because it isn't up yet, every class is taken as permitted and requests find out for themselves.
+func (b *Bridge) probeBackend() {
+	prober, ok := b.backend.(backend.Prober)
+	if !ok {
+		return
+	}
+	ctx, cancel := context.WithTimeout(b.ctx, probeTimeout)
+	defer cancel()
+
+	denied := make(map[string]bool)
+	var actions []string
+	for _, w := range policy.Classes() {
+		modes := []string{"block"}
+		if policy.Delayable(w) {
+			modes = append(modes, "delay")
+		}
+		for _, mode := range modes {
+			ok, err := prober.Permitted(ctx, w, mode)
+			if err != nil {
+				b.log.Printf("Couldn't find out what the backend permits; leaving it to each request: %v\n", err)
+				return
+			}
+			if !ok {
+				denied[w+"/"+mode] = true
+				actions = append(actions, backend.PolkitAction(w, mode))
+			}
+		}
+	}
+	if len(denied) == 0 {
+		b.log.Debugf("The backend permits every what-class in every mode.\n")
+		return
+	}
+
+	b.do("probeBackend", func() {
+		b.denied = denied
+		b.log.Printf("The backend doesn't permit %s; locks leave them out. The polkit actions %s would allow them.\n", strings.Join(b.deniedList(), ", "), strings.Join(actions, ", "))
+		base := policy.What(b.policy.What, nil, "", "")
+		if what, derr := b.permitted("", "", base, false, false); derr != nil {
+			b.log.Printf("WARNING: the backend permits none of the what-classes locks take (%s); requests for them are refused.\n", base)
+		} else if what != base {
+			b.log.Printf("WARNING: locks only take %s of the what-classes %s.\n", what, base)
+		}
+	})
+}
+
+// deniedList returns the what-classes and modes the backend refuses, as class/mode, sorted. It must be called on the
+// actor.
+func (b *Bridge) deniedList() []string {
+	list := []string{}
+	for cm := range b.denied {
+		list = append(list, cm)
+	}
+	sort.Strings(list)
+	return list
+}
+
+// permitted leaves the what-classes the backend refuses (see probeBackend) out of what, a lock's colon-separated
+// classes, each in delay mode if delay is set and it can be. A lock that asked for its classes explicitly is refused
+// instead, as is one left with none; the error names the polkit actions that would allow them. It must be called on
+// the actor.
+func (b *Bridge) permitted(id string, from dbus.Sender, what string, delay, explicit bool) (string, *dbus.Error) {
+	if len(b.denied) == 0 {
+		return what, nil
+	}
+	var kept, refused, actions []string
+	for _, w := range strings.Split(what, ":") {
+		mode := "block"
+		if delay && policy.Delayable(w) {
+			mode = "delay"
+		}
+		if b.denied[w+"/"+mode] {
+			refused = append(refused, w)
+			actions = append(actions, backend.PolkitAction(w, mode))
+		} else if w != "" {
+			kept = append(kept, w)
+		}
+	}
+	switch {
+	case len(refused) == 0:
+		return what, nil
+	case explicit || len(kept) == 0:
+		if from != "" {
+			b.errLog.log("[%s] Inhibit from %q denied: the backend doesn't permit %s\n", id, from, strings.Join(refused, ":"))
+		}
+		return "", newError(ErrorDenied, "not permitted to inhibit %s; the polkit action %s would allow it", strings.Join(refused, ":"), strings.Join(actions, ", "))
+	}
+	if from != "" {
+		b.log.Debugf("[%s] Leaving %s out of the lock for %q: the backend doesn't permit it.\n", id, strings.Join(refused, ":"), from)
+	}
+	return strings.Join(kept, ":"), nil
+}
@@ -0,0 +1,120 @@
+package bridge
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/godbus/dbus/v5"
+)
+
+const (
+	getConnPID = "org.freedesktop.DBus.GetConnectionUnixProcessID"
+	getConnUID = "org.freedesktop.DBus.GetConnectionUnixUser"
+)
+
+// peerProcess identifies the process behind a bus peer. The start time is recorded alongside the pid so that a
+// recycled pid is never mistaken for the process that originally requested a lock.
+type peerProcess struct {
+	pid   uint32
+	start uint64
+}
+
+// String returns a useful textual representation of a peer process.
+func (pp *peerProcess) String() string {
+	return fmt.Sprintf("pid %d, started %d", pp.pid, pp.start)
+}
+
+// alive reports whether the process is still running and is the same process that was originally recorded.
+func (pp *peerProcess) alive() bool {
+	start, err := processStartTime(pp.pid)
+	return err == nil && start == pp.start
+}
+
+// lookupPeerProcess asks the bus for the pid behind peer and pairs it with the process start time.
+func (b *Bridge) lookupPeerProcess(peer dbus.Sender) (*peerProcess, error) {
+	var pid uint32
+	if err := b.dbusConn.BusObject().CallWithContext(b.ctx, getConnPID, 0, string(peer)).Store(&pid); err != nil {
+		return nil, fmt.Errorf("calling %q for %q: %v", getConnPID, peer, err)
+	}
+
+	start, err := processStartTime(pid)
+	if err != nil {
+		return nil, err
+	}
+
+	return &peerProcess{pid: pid, start: start}, nil
+}
+
+// hasOwner double-checks a peer that ListNames didn't return. Behind xdg-dbus-proxy, as in a Flatpak sandbox,
+// ListNames only returns names the sandbox may see, while NameHasOwner still answers for peers that talked to us.
+func (b *Bridge) hasOwner(peer dbus.Sender) bool {
+	var ok bool
+	if err := b.dbusConn.BusObject().CallWithContext(b.ctx, nameHasOwner, 0, string(peer)).Store(&ok); err != nil {
+		b.errLog.log("Error calling %q for %q: %v\n", nameHasOwner, peer, err)
+		// Unsure, so keep the lock; the process check still catches a peer that exited.
+		return true
+	}
+	return ok
+}
+
+// flatpakApp returns the Flatpak application ID of pid, or "" if it isn't sandboxed. Flatpak binds the app's
+// .flatpak-info into the sandbox of both the app and its xdg-dbus-proxy, which is the peer process the bus reports
+// for apps that talk to us directly, so this identifies them either way.
+func flatpakApp(pid uint32) string {
+	data, err := os.ReadFile(fmt.Sprintf("/proc/%d/root/.flatpak-info", pid))
+	if err != nil {
+		return ""
+	}
+
+	section := ""
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = line[1 : len(line)-1]
+			continue
+		}
+		if k, v, ok := strings.Cut(line, "="); ok && section == "Application" && strings.TrimSpace(k) == "name" {
+			return strings.TrimSpace(v)
+		}
+	}
+	return ""
+}
+
+// processStartTime returns the start time of pid, in clock ticks since boot, as reported by /proc/<pid>/stat.
+func processStartTime(pid uint32) (uint64, error) {
+	b, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return 0, err
+	}
+
+	// The command name (field 2) is wrapped in parens and may itself contain spaces or parens, so start parsing
+	// after the last closing paren.
+	s := string(b)
+	idx := strings.LastIndexByte(s, ')')
+	if idx < 0 {
+		return 0, fmt.Errorf("malformed stat for pid %d", pid)
+	}
+	// fields[0] is field 3 (state), so starttime (field 22) is fields[19].
+	fields := strings.Fields(s[idx+1:])
+	if len(fields) < 20 {
+		return 0, fmt.Errorf("short stat for pid %d", pid)
+	}
+
+	return strconv.ParseUint(fields[19], 10, 64)
+}
+
+// peerUID returns the uid owning peer's connection. On the session bus every peer belongs to the user running the
+// bridge, so failing to look it up is only fatal on the system bus, where it keys the per-user lock tables.
+func (b *Bridge) peerUID(peer dbus.Sender) (uint32, error) {
+	var uid uint32
+	if err := b.dbusConn.BusObject().CallWithContext(b.ctx, getConnUID, 0, string(peer)).Store(&uid); err != nil {
+		if b.opts.System {
+			return 0, fmt.Errorf("calling %q for %q: %v", getConnUID, peer, err)
+		}
+		return uint32(os.Getuid()), nil
+	}
+
+	return uid, nil
+}
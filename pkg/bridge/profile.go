@@ -0,0 +1,239 @@
+package bridge
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/coltwillcox/inhibitor/pkg/policy"
+	"github.com/godbus/dbus/v5"
+)
+
+// profileWho is the who of a profile's lock, followed by the profile's name. The lock is the bridge's own, so it
+// lasts until the profile is deactivated or expires, and is handed over and recovered like any other.
+const profileWho = "profile:"
+
+// ProfileState is the D-Bus representation of a profile (see policy.Profile) and whether it is active.
+type ProfileState struct {
+	Name    string
+	What    string
+	Why     string
+	Active  bool
+	Cookie  uint32 // of the profile's lock while active
+	Expires int64  // Unix time the profile expires, or 0 if it is inactive or active until deactivated
+}
+
+// SetProfiles replaces the profiles that can be activated (see policy.Policy.Profiles). Active profiles stay active,
+// with the locks they were activated with, until deactivated, even if they are no longer defined.
+func (b *Bridge) SetProfiles(profiles []policy.Profile) error {
+	if err := policy.ValidateProfiles(profiles); err != nil {
+		return err
+	}
+	if derr := b.do("SetProfiles", func() { b.profiles = profiles }); derr != nil {
+		return derr
+	}
+	return nil
+}
+
+// profile returns the profile called name. It must be called on the actor.
+func (b *Bridge) profile(name string) (policy.Profile, bool) {
+	for _, p := range b.profiles {
+		if p.Name == name {
+			return p, true
+		}
+	}
+	return policy.Profile{}, false
+}
+
+// profileLock returns the lock of the profile called name, or nil if it isn't active. It must be called on the actor.
+func (b *Bridge) profileLock(name string) *lockDetails {
+	self := b.Name()
+	for _, ld := range b.locks {
+		if ld.peer == self && ld.who == profileWho+name {
+			return ld
+		}
+	}
+	return nil
+}
+
+// ActivateProfile takes the lock of the profile called name, held by the bridge itself until DeactivateProfile or,
+// if expire is above 0, until expire has passed; 0 uses the profile's own expiry. Activating an active profile starts
+// its expiry afresh. It returns the lock's cookie.
+func (b *Bridge) ActivateProfile(name string, expire time.Duration) (uint32, error) {
+	cookie, err := b.activateProfile(name, expire)
+	if err != nil {
+		return 0, err
+	}
+	return cookie, nil
+}
+
+func (b *Bridge) activateProfile(name string, expire time.Duration) (uint32, *dbus.Error) {
+	var (
+		p     policy.Profile
+		found bool
+		old   uint
+	)
+	if derr := b.do("ActivateProfile", func() {
+		p, found = b.profile(name)
+		if ld := b.profileLock(name); ld != nil {
+			old = ld.cookie
+		}
+	}); derr != nil {
+		return 0, derr
+	}
+	if !found {
+		return 0, newError(ErrorInvalidArgs, "no profile %q", name)
+	}
+	if expire <= 0 {
+		// Validated by SetProfiles.
+		expire, _ = p.ExpireAfter()
+	}
+	why := p.Why
+	if why == "" {
+		why = p.Name
+	}
+
+	self := b.Name()
+	// The new lock is taken before the old one is released, so that nothing slips through in between.
+	cookie, derr := b.take(self, profileWho+p.Name, why, lockRequest{what: strings.Join(p.What, ":"), ttl: expire, delay: p.Mode == "delay"})
+	if derr != nil {
+		return 0, derr
+	}
+	if old != 0 {
+		if err := b.unInhibit(self, uint32(old)); err != nil {
+			b.log.Printf("Error releasing the previous lock of profile %q: %v\n", name, err)
+		}
+	}
+	if expire > 0 {
+		b.log.Printf("Profile %q active for %s: %s\n", name, expire, strings.Join(p.What, ":"))
+	} else {
+		b.log.Printf("Profile %q active until deactivated: %s\n", name, strings.Join(p.What, ":"))
+	}
+	return uint32(cookie), nil
+}
+
+// DeactivateProfile releases the lock of the profile called name. It fails if the profile isn't active.
+func (b *Bridge) DeactivateProfile(name string) error {
+	if err := b.deactivateProfile(name); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (b *Bridge) deactivateProfile(name string) *dbus.Error {
+	var cookie uint
+	if derr := b.do("DeactivateProfile", func() {
+		if ld := b.profileLock(name); ld != nil {
+			cookie = ld.cookie
+		}
+	}); derr != nil {
+		return derr
+	}
+	if cookie == 0 {
+		return newError(ErrorInvalidArgs, "profile %q isn't active", name)
+	}
+	if err := b.unInhibit(b.Name(), uint32(cookie)); err != nil {
+		return err
+	}
+	b.log.Printf("Profile %q deactivated.\n", name)
+	return nil
+}
+
+// ToggleProfile deactivates the profile called name if it is active, and activates it with its own expiry otherwise,
+// for hotkeys. It reports whether the profile is now active.
+func (b *Bridge) ToggleProfile(name string) (bool, error) {
+	active, err := b.toggleProfile(name)
+	if err != nil {
+		return false, err
+	}
+	return active, nil
+}
+
+func (b *Bridge) toggleProfile(name string) (bool, *dbus.Error) {
+	var active bool
+	if derr := b.do("ToggleProfile", func() { active = b.profileLock(name) != nil }); derr != nil {
+		return false, derr
+	}
+	if active {
+		return false, b.deactivateProfile(name)
+	}
+	_, err := b.activateProfile(name, 0)
+	return err == nil, err
+}
+
+// Profiles returns every profile that can be activated, and those still active that no longer can, sorted by name.
+func (b *Bridge) Profiles() []ProfileState {
+	states := []ProfileState{}
+	b.do("Profiles", func() {
+		listed := make(map[string]bool)
+		for _, p := range b.profiles {
+			s := ProfileState{Name: p.Name, What: strings.Join(p.What, ":"), Why: p.Why}
+			if s.Why == "" {
+				s.Why = p.Name
+			}
+			if ld := b.profileLock(p.Name); ld != nil {
+				s.active(ld)
+			}
+			states = append(states, s)
+			listed[p.Name] = true
+		}
+		self := b.Name()
+		for _, ld := range b.locks {
+			name := strings.TrimPrefix(ld.who, profileWho)
+			if ld.peer != self || name == ld.who || listed[name] {
+				continue
+			}
+			s := ProfileState{Name: name, What: ld.what, Why: ld.why}
+			s.active(ld)
+			states = append(states, s)
+		}
+	})
+	sort.Slice(states, func(i, j int) bool { return states[i].Name < states[j].Name })
+	return states
+}
+
+// active marks s active with the lock ld.
+func (s *ProfileState) active(ld *lockDetails) {
+	s.Active, s.Cookie = true, uint32(ld.cookie)
+	if !ld.expires.IsZero() {
+		s.Expires = ld.expires.Unix()
+	}
+}
+
+// ActivateProfile activates the profile called name (see Bridge.ActivateProfile) for seconds, or for the profile's
+// own expiry if 0, and returns its lock's cookie.
+func (c *controlAPI) ActivateProfile(from dbus.Sender, name string, seconds uint32) (cookie uint32, err *dbus.Error) {
+	defer c.b.recoverPanic("ActivateProfile", &err)
+
+	if _, err := c.daemonUser(from, "ActivateProfile"); err != nil {
+		return 0, err
+	}
+	return c.b.activateProfile(name, time.Duration(seconds)*time.Second)
+}
+
+// DeactivateProfile deactivates the profile called name.
+func (c *controlAPI) DeactivateProfile(from dbus.Sender, name string) (err *dbus.Error) {
+	defer c.b.recoverPanic("DeactivateProfile", &err)
+
+	if _, err := c.daemonUser(from, "DeactivateProfile"); err != nil {
+		return err
+	}
+	return c.b.deactivateProfile(name)
+}
+
+// ToggleProfile deactivates the profile called name if it is active and activates it otherwise, and reports whether
+// it is now active.
+func (c *controlAPI) ToggleProfile(from dbus.Sender, name string) (active bool, err *dbus.Error) {
+	defer c.b.recoverPanic("ToggleProfile", &err)
+
+	if _, err := c.daemonUser(from, "ToggleProfile"); err != nil {
+		return false, err
+	}
+	return c.b.toggleProfile(name)
+}
+
+// ListProfiles returns every profile and whether it is active.
+func (c *controlAPI) ListProfiles() (profiles []ProfileState, err *dbus.Error) {
+	defer c.b.recoverPanic("ListProfiles", &err)
+	return c.b.Profiles(), nil
+}
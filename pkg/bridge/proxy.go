@@ -0,0 +1,185 @@
+package bridge
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/godbus/dbus/v5"
+)
+
+// Proxy serves the compat interfaces (see Options.Compat) by forwarding them to whichever other process owns
+// org.freedesktop.ScreenSaver, typically a desktop environment's own screensaver that doesn't speak them. It takes
+// no locks itself: it is a translator, not an inhibitor.
+type Proxy struct {
+	conn   Bus
+	log    Logger
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	mtx      sync.Mutex
+	upstream dbus.Sender            // the owner that issued cookies
+	cookies  map[uint32]dbus.Sender // upstream cookie to the peer it was forwarded for
+}
+
+// NewProxy claims the compat names on conn and forwards their calls until ctx is cancelled or Close is called. compat
+// turns them off one at a time, like Options.Compat, but those it leaves out are on. It fails if none of the names
+// could be claimed. Close closes conn.
+func NewProxy(ctx context.Context, conn Bus, compat map[string]bool, log Logger) (*Proxy, error) {
+	if log == nil {
+		log = nopLogger{}
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	p := &Proxy{
+		conn:    conn,
+		log:     log,
+		ctx:     ctx,
+		cancel:  cancel,
+		done:    make(chan struct{}),
+		cookies: make(map[uint32]dbus.Sender),
+	}
+
+	if err := conn.AddMatchSignal(dbus.WithMatchInterface("org.freedesktop.DBus"), dbus.WithMatchMember(nameOwnerChanged)); err != nil {
+		cancel()
+		return nil, fmt.Errorf("couldn't watch %s: %v", nameOwnerChanged, err)
+	}
+	ch := make(chan *dbus.Signal, 64)
+	conn.Signal(ch)
+	go p.watch(ch)
+
+	claimed, err := exportCompat(newExports(conn), p, log, compat, true)
+	if err == nil && len(claimed) == 0 {
+		err = fmt.Errorf("no compat name could be claimed")
+	}
+	if err != nil {
+		p.Close()
+		return nil, err
+	}
+	log.Printf("Forwarding %s to the owner of %s.\n", strings.Join(claimed, ", "), screensaver)
+
+	return p, nil
+}
+
+// Close releases every forwarded lock and closes the bus connection.
+func (p *Proxy) Close() error {
+	p.cancel()
+	<-p.done
+
+	p.mtx.Lock()
+	upstream, cookies := p.upstream, p.cookies
+	p.cookies = make(map[uint32]dbus.Sender)
+	p.mtx.Unlock()
+
+	for cookie := range cookies {
+		p.release(upstream, cookie)
+	}
+	return p.conn.Close()
+}
+
+// watch follows NameOwnerChanged, releasing the locks of peers that leave the bus and forgetting every cookie when
+// the upstream owner changes, since they died with it.
+func (p *Proxy) watch(ch chan *dbus.Signal) {
+	defer close(p.done)
+	for {
+		select {
+		case <-p.ctx.Done():
+			return
+		case sig, ok := <-ch:
+			if !ok {
+				return
+			}
+			if sig.Name != "org.freedesktop.DBus."+nameOwnerChanged || len(sig.Body) != 3 {
+				continue
+			}
+			name, _ := sig.Body[0].(string)
+			oldOwner, _ := sig.Body[1].(string)
+			newOwner, _ := sig.Body[2].(string)
+			p.nameOwnerChanged(name, dbus.Sender(oldOwner), dbus.Sender(newOwner))
+		}
+	}
+}
+
+func (p *Proxy) nameOwnerChanged(name string, oldOwner, newOwner dbus.Sender) {
+	p.mtx.Lock()
+	upstream := p.upstream
+	var orphaned []uint32
+	switch {
+	case name == screensaver && oldOwner != "" && oldOwner == p.upstream:
+		if len(p.cookies) > 0 {
+			p.log.Printf("%s moved from %q to %q; dropping %d forwarded locks.\n", screensaver, oldOwner, newOwner, len(p.cookies))
+		}
+		p.cookies = make(map[uint32]dbus.Sender)
+		p.upstream = ""
+	case strings.HasPrefix(name, ":") && newOwner == "":
+		for cookie, peer := range p.cookies {
+			if peer == dbus.Sender(name) {
+				p.log.Debugf("Peer %q left; releasing forwarded lock %d.\n", name, cookie)
+				orphaned = append(orphaned, cookie)
+				delete(p.cookies, cookie)
+			}
+		}
+	}
+	p.mtx.Unlock()
+
+	for _, cookie := range orphaned {
+		p.release(upstream, cookie)
+	}
+}
+
+// inhibit forwards an Inhibit to the current owner of org.freedesktop.ScreenSaver.
+func (p *Proxy) inhibit(from dbus.Sender, via, who, why string) (uint, *dbus.Error) {
+	var owner string
+	if err := p.conn.BusObject().Call(getNameOwner, 0, screensaver).Store(&owner); err != nil {
+		return 0, newError(ErrorUnavailable, "no %s to forward to: %v", screensaver, err)
+	}
+	var cookie uint32
+	if err := p.conn.Object(owner, screensaverPath).Call(screensaver+".Inhibit", 0, who, why).Store(&cookie); err != nil {
+		p.log.Printf("Forwarding Inhibit for %q to %q failed: %v\n", from, owner, err)
+		return 0, newError(ErrorUnavailable, "%v", err)
+	}
+
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	if p.upstream != dbus.Sender(owner) {
+		p.cookies = make(map[uint32]dbus.Sender)
+		p.upstream = dbus.Sender(owner)
+	}
+	p.cookies[cookie] = from
+	p.log.Debugf("Forwarded Inhibit: %q / %q (%q, %d) to %q\n", who, why, from, cookie, owner)
+
+	return uint(cookie), nil
+}
+
+// unInhibit forwards an UnInhibit for a lock from was given.
+func (p *Proxy) unInhibit(from dbus.Sender, cookie uint32) *dbus.Error {
+	p.mtx.Lock()
+	peer, ok := p.cookies[cookie]
+	if ok && peer == from {
+		delete(p.cookies, cookie)
+	}
+	upstream := p.upstream
+	p.mtx.Unlock()
+	if !ok || peer != from {
+		return newError(ErrorInvalidCookie, "no lock with cookie %d for %s", cookie, from)
+	}
+
+	p.release(upstream, cookie)
+	p.log.Debugf("Forwarded UnInhibit: (%q, %d) to %q\n", from, cookie, upstream)
+	return nil
+}
+
+// inhibited reports whether any forwarded lock is held.
+func (p *Proxy) inhibited() bool {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	return len(p.cookies) > 0
+}
+
+// release releases cookie with the upstream owner that issued it. Bus calls are made without p.mtx held.
+func (p *Proxy) release(upstream dbus.Sender, cookie uint32) {
+	if err := p.conn.Object(string(upstream), screensaverPath).Call(screensaver+".UnInhibit", 0, cookie).Err; err != nil {
+		p.log.Printf("Forwarding UnInhibit of %d to %q failed: %v\n", cookie, upstream, err)
+	}
+}
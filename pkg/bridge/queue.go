@@ -0,0 +1,69 @@
+package bridge
+
+import (
+	"sync"
+
+	"github.com/godbus/dbus/v5"
+)
+
+// requestWorkers is how many lock requests are worked on at once. Each one can take a backend call, so a burst, such
+// as a browser restoring dozens of tabs that each play media, would otherwise pile up that many calls on logind.
+const requestWorkers = 4
+
+// requestQueue admits lock requests to the workers, in the order they came, keeping at most max waiting. godbus runs
+// every method call in a goroutine of its own; the queue keeps how many of them do any work bounded, and refuses the
+// rest rather than let them pile up.
+type requestQueue struct {
+	slots   chan struct{} // a token per worker at work
+	mtx     sync.Mutex
+	waiting int
+	max     int
+}
+
+func newRequestQueue(workers, max int) *requestQueue {
+	return &requestQueue{slots: make(chan struct{}, workers), max: max}
+}
+
+// enqueue waits for a worker for the lock request with correlation ID id from from, for up to Options.RequestTimeout.
+// It returns a func that hands the worker on to the next request, or an ErrorBusy error if the queue is full or the
+// wait timed out. Only requests for locks wait their turn: releasing one never does, since it is what frees the
+// bridge up.
+func (b *Bridge) enqueue(id string, from dbus.Sender) (func(), *dbus.Error) {
+	q := b.queue
+	done := func() { <-q.slots }
+	select {
+	case q.slots <- struct{}{}:
+		return done, nil
+	default:
+	}
+
+	q.mtx.Lock()
+	if q.waiting >= q.max {
+		q.mtx.Unlock()
+		b.metrics.addFor(metricRequestsRejected, id)
+		b.errLog.log("[%s] Inhibit from %q refused: %d requests are already waiting\n", id, from, q.max)
+		return nil, newError(ErrorBusy, "too many requests are waiting; try again later")
+	}
+	q.waiting++
+	b.metrics.set(metricRequestsWaiting, uint64(q.waiting))
+	q.mtx.Unlock()
+	defer func() {
+		q.mtx.Lock()
+		q.waiting--
+		b.metrics.set(metricRequestsWaiting, uint64(q.waiting))
+		q.mtx.Unlock()
+	}()
+
+	timer := b.opts.Clock.NewTimer(b.opts.RequestTimeout)
+	defer timer.Stop()
+	select {
+	case q.slots <- struct{}{}:
+		return done, nil
+	case <-timer.C():
+		b.metrics.addFor(metricRequestsTimedOut, id)
+		b.errLog.log("[%s] Inhibit from %q refused: no worker became free within %s\n", id, from, b.opts.RequestTimeout)
+		return nil, newError(ErrorBusy, "the request waited longer than %s; try again later", b.opts.RequestTimeout)
+	case <-b.ctx.Done():
+		return nil, newError(ErrorUnavailable, "shutting down")
+	}
+}
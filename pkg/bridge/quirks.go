@@ -0,0 +1,61 @@
+package bridge
+
+import (
+	"github.com/coltwillcox/inhibitor/pkg/policy"
+	"github.com/godbus/dbus/v5"
+)
+
+// SetQuirks replaces the browser quirks (see policy.Policy.Quirks). New locks share backend inhibits by the new
+// quirks, while the locks already held are released after the new MaxHold.
+func (b *Bridge) SetQuirks(quirks map[string]policy.Quirks) error {
+	if err := policy.ValidateQuirks(quirks); err != nil {
+		return err
+	}
+	if derr := b.do("SetQuirks", func() { b.quirks = quirks }); derr != nil {
+		return derr
+	}
+	return nil
+}
+
+// quirksFor returns the quirks for a lock of the Flatpak application app, if known, or who, and the browser family
+// they are for, which is empty if it isn't a browser. It must be called on the actor.
+func (b *Bridge) quirksFor(app, who string) (string, policy.Quirks) {
+	browser := policy.Browser(app, who)
+	if browser == "" {
+		return "", policy.Quirks{}
+	}
+	if q, ok := b.quirks[browser]; ok {
+		return browser, q
+	}
+	return browser, policy.DefaultQuirks()[browser]
+}
+
+// tabTwin returns a held lock of peer that a new request of a browser duplicates, with the same reason and
+// what-classes, as each tab playing a video asks for one. It must be called on the actor.
+func (b *Bridge) tabTwin(uid uint32, peer dbus.Sender, what, why string) *lockDetails {
+	for _, ld := range b.locks {
+		if ld.uid == uid && ld.peer == peer && !ld.pending() && !ld.downgraded && ld.why == why && ld.what == what {
+			return ld
+		}
+	}
+	return nil
+}
+
+// releaseOverheld drops the locks of browsers that have been held longer than their family's MaxHold, which a tab
+// closed while playing leaves behind.
+func (b *Bridge) releaseOverheld() {
+	b.do("releaseOverheld", func() {
+		now := b.opts.Clock.Now()
+		for _, ld := range b.locks {
+			browser, q := b.quirksFor(ld.app, ld.who)
+			// Validated by SetQuirks.
+			maxHold, _ := q.MaxHoldAfter()
+			if maxHold <= 0 || ld.held(now) < maxHold {
+				continue
+			}
+			b.log.Printf("Held longer than the %s max_hold of %s; Dropping: %s\n", browser, maxHold, ld)
+			b.dropLock(ld, "held past max_hold")
+			b.metrics.addFor(metricLocksOverheld, ld.id)
+		}
+	})
+}
@@ -0,0 +1,199 @@
+package bridge_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/coltwillcox/inhibitor/pkg/bridge"
+	"github.com/coltwillcox/inhibitor/pkg/policy"
+	"github.com/godbus/dbus/v5"
+)
+
+// request is an Inhibit call made by a test.
+type request struct {
+	from     dbus.Sender
+	who, why string
+}
+
+func TestTabDedupe(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		quirks    map[string]policy.Quirks
+		requests  []request
+		wantCalls int
+	}{
+		{
+			name:      "firefox tabs",
+			requests:  []request{{alice, "Firefox", "video-playing"}, {alice, "Firefox", "video-playing"}, {alice, "Firefox", "video-playing"}},
+			wantCalls: 1,
+		},
+		{
+			name:      "chromium tabs",
+			requests:  []request{{alice, "Google Chrome", "Playing audio"}, {alice, "Google Chrome", "Playing audio"}},
+			wantCalls: 1,
+		},
+		{
+			name:      "flatpak browser",
+			requests:  []request{{alice, "org.mozilla.firefox", "video-playing"}, {alice, "org.mozilla.firefox", "video-playing"}},
+			wantCalls: 1,
+		},
+		{
+			name:      "different reasons",
+			requests:  []request{{alice, "Firefox", "video-playing"}, {alice, "Firefox", "audio-playing"}},
+			wantCalls: 2,
+		},
+		{
+			name:      "different connections",
+			requests:  []request{{alice, "Firefox", "video-playing"}, {mal, "Firefox", "video-playing"}},
+			wantCalls: 2,
+		},
+		{
+			name:      "not a browser",
+			requests:  []request{{alice, "vlc", "Playing video"}, {alice, "vlc", "Playing video"}},
+			wantCalls: 2,
+		},
+		{
+			name:      "turned off",
+			quirks:    map[string]policy.Quirks{policy.BrowserFirefox: {DedupeTabs: false}},
+			requests:  []request{{alice, "Firefox", "video-playing"}, {alice, "Firefox", "video-playing"}},
+			wantCalls: 2,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			f := newFakes(t, bridge.Options{})
+			if tc.quirks != nil {
+				if err := f.b.SetQuirks(tc.quirks); err != nil {
+					t.Fatalf("SetQuirks() failed: %v", err)
+				}
+			}
+
+			var cookies []uint32
+			for _, r := range tc.requests {
+				cookie, err := f.b.Inhibit(r.from, r.who, r.why)
+				if err != nil {
+					t.Fatalf("Inhibit(%q, %q, %q) failed: %v", r.from, r.who, r.why, err)
+				}
+				cookies = append(cookies, cookie)
+			}
+			if got := len(f.be.Calls()); got != tc.wantCalls {
+				t.Errorf("%d backend calls, want %d", got, tc.wantCalls)
+			}
+			if got, want := f.b.Metrics()["tabs_shared"], uint64(len(tc.requests)-tc.wantCalls); got != want {
+				t.Errorf("tabs_shared = %d, want %d", got, want)
+			}
+
+			// Every tab keeps its own cookie, and the backend inhibit lasts until the last is released.
+			for i, cookie := range cookies {
+				if err := f.b.UnInhibit(tc.requests[i].from, cookie); err != nil {
+					t.Fatalf("UnInhibit(%d) failed: %v", cookie, err)
+				}
+				if got, want := f.b.TrackedFds(), len(cookies)-i-1; got != want {
+					t.Errorf("TrackedFds() = %d after releasing %d of %d locks, want %d", got, i+1, len(cookies), want)
+				}
+			}
+		})
+	}
+}
+
+func TestReleaseOverheld(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		quirks   map[string]policy.Quirks
+		after    time.Duration
+		who      string
+		wantHeld bool
+	}{
+		{"firefox past max_hold", map[string]policy.Quirks{policy.BrowserFirefox: {MaxHold: "1h"}}, 2 * time.Hour, "Firefox", false},
+		{"flatpak firefox past max_hold", map[string]policy.Quirks{policy.BrowserFirefox: {MaxHold: "1h"}}, 2 * time.Hour, "org.mozilla.firefox", false},
+		{"firefox within max_hold", map[string]policy.Quirks{policy.BrowserFirefox: {MaxHold: "1h"}}, 30 * time.Minute, "Firefox", true},
+		{"other browser", map[string]policy.Quirks{policy.BrowserFirefox: {MaxHold: "1h"}}, 2 * time.Hour, "Chromium", true},
+		{"not a browser", map[string]policy.Quirks{policy.BrowserFirefox: {MaxHold: "1h"}, policy.BrowserChromium: {MaxHold: "1h"}}, 2 * time.Hour, "vlc", true},
+		{"no max_hold", nil, 24 * time.Hour, "Firefox", true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			f := newFakes(t, bridge.Options{})
+			if tc.quirks != nil {
+				if err := f.b.SetQuirks(tc.quirks); err != nil {
+					t.Fatalf("SetQuirks() failed: %v", err)
+				}
+			}
+			// The tab was closed without UnInhibit, but the browser stays on the bus.
+			cookie, err := f.b.Inhibit(alice, tc.who, "video-playing")
+			if err != nil {
+				t.Fatalf("Inhibit() failed: %v", err)
+			}
+
+			f.clock.Set(f.clock.Now().Add(tc.after))
+			f.b.HeartbeatTick()
+			if got := f.holds(alice, cookie); got != tc.wantHeld {
+				t.Errorf("lock held after %s: %t, want %t", tc.after, got, tc.wantHeld)
+			}
+			var wantOverheld uint64
+			if !tc.wantHeld {
+				wantOverheld = 1
+			}
+			if got := f.b.Metrics()["locks_overheld"]; got != wantOverheld {
+				t.Errorf("locks_overheld = %d, want %d", got, wantOverheld)
+			}
+			if got, want := f.b.TrackedFds(), len(f.b.Locks()); got != want {
+				t.Errorf("TrackedFds() = %d, want %d", got, want)
+			}
+		})
+	}
+}
+
+func TestLegacyPath(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		compat map[string]bool
+		want   bool
+	}{
+		{"default", nil, true},
+		{"on", map[string]bool{bridge.CompatLegacyPath: true}, true},
+		{"off", map[string]bool{bridge.CompatLegacyPath: false}, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			f := newFakes(t, bridge.Options{Compat: tc.compat})
+			v := f.bus.Exported("/ScreenSaver", "org.freedesktop.ScreenSaver")
+			if got := v != nil; got != tc.want {
+				t.Fatalf("/ScreenSaver served: %t, want %t", got, tc.want)
+			}
+			if v == nil {
+				return
+			}
+
+			// Firefox inhibits on /ScreenSaver, and the lock is the same whichever path releases it.
+			legacy := v.(screenSaverObject)
+			cookie, derr := legacy.Inhibit(alice, dbus.Message{}, "Firefox", "video-playing")
+			if derr != nil {
+				t.Fatalf("Inhibit() on /ScreenSaver failed: %v", derr)
+			}
+			if !f.holds(alice, uint32(cookie)) {
+				t.Fatalf("no lock held for cookie %d", cookie)
+			}
+			ss := exported[screenSaverObject](t, f, "/org/freedesktop/ScreenSaver", "org.freedesktop.ScreenSaver")
+			if derr := ss.UnInhibit(alice, dbus.Message{}, uint32(cookie)); derr != nil {
+				t.Fatalf("UnInhibit() on /org/freedesktop/ScreenSaver failed: %v", derr)
+			}
+			if f.holds(alice, uint32(cookie)) {
+				t.Errorf("lock for cookie %d still held", cookie)
+			}
+		})
+	}
+}
+
+func TestLegacyPathToggle(t *testing.T) {
+	f := newFakes(t, bridge.Options{})
+	if err := f.b.SetCompat(map[string]bool{bridge.CompatLegacyPath: false}); err != nil {
+		t.Fatalf("SetCompat() failed: %v", err)
+	}
+	if v := f.bus.Exported("/ScreenSaver", "org.freedesktop.ScreenSaver"); v != nil {
+		t.Errorf("/ScreenSaver still served after turning legacy_path off")
+	}
+	if err := f.b.SetCompat(map[string]bool{bridge.CompatLegacyPath: true}); err != nil {
+		t.Fatalf("SetCompat() failed: %v", err)
+	}
+	if v := f.bus.Exported("/ScreenSaver", "org.freedesktop.ScreenSaver"); v == nil {
+		t.Errorf("/ScreenSaver not served after turning legacy_path on")
+	}
+}
@@ -0,0 +1,96 @@
+package bridge
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// logLimiter coalesces repeated log lines so that a misbehaving peer can't flood the log. Lines are grouped by their
+// format string rather than their formatted text, so errors that only differ in a cookie or peer name still coalesce.
+type logLimiter struct {
+	mtx    sync.Mutex
+	window time.Duration
+	lines  map[string]*limitedLine
+	logger Logger
+	wake   func() // called when a line is first suppressed, so that it gets flushed
+}
+
+// limitedLine tracks how often a single format was logged within the current window.
+type limitedLine struct {
+	start      time.Time
+	suppressed int
+	last       string // The most recently suppressed message, reported when the window closes.
+}
+
+func newLogLimiter(window time.Duration, log Logger) *logLimiter {
+	return &logLimiter{
+		window: window,
+		lines:  make(map[string]*limitedLine),
+		logger: log,
+	}
+}
+
+// log writes the message as a debug line unless the same format was already logged within the window, in which case it
+// is counted and reported later as a single "repeated N times" line.
+func (l *logLimiter) log(format string, args ...interface{}) {
+	if l.window <= 0 {
+		l.logger.Debugf(format, args...)
+		return
+	}
+
+	msg := fmt.Sprintf(format, args...)
+	now := time.Now()
+
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	if ll, ok := l.lines[format]; ok {
+		if now.Sub(ll.start) < l.window {
+			if ll.suppressed == 0 && l.wake != nil {
+				l.wake()
+			}
+			ll.suppressed++
+			ll.last = msg
+			return
+		}
+		ll.report(l.logger)
+	}
+
+	l.lines[format] = &limitedLine{start: now}
+	l.logger.Debugf("%s", msg)
+}
+
+// pending reports whether any suppressed line is waiting to be reported by flush.
+func (l *logLimiter) pending() bool {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	for _, ll := range l.lines {
+		if ll.suppressed > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// flush reports and forgets every line whose window has closed. It is called periodically so that suppressed counts
+// are reported even if the error never recurs, and so the table doesn't grow without bound.
+func (l *logLimiter) flush() {
+	now := time.Now()
+
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	for format, ll := range l.lines {
+		if now.Sub(ll.start) >= l.window {
+			ll.report(l.logger)
+			delete(l.lines, format)
+		}
+	}
+}
+
+func (ll *limitedLine) report(log Logger) {
+	if ll.suppressed > 0 {
+		log.Debugf("Last message repeated %d times in %s; most recently: %s", ll.suppressed, time.Since(ll.start).Round(time.Second), ll.last)
+	}
+}
@@ -0,0 +1,58 @@
+package bridge
+
+import (
+	"runtime/debug"
+
+	"github.com/godbus/dbus/v5"
+)
+
+// recoverPanic is deferred at the top of every exported D-Bus method and background loop iteration, so that one bad
+// request can't take the whole bridge down. A panic is logged with its stack and counted. The lock table can't need
+// repair, since it is only touched on the actor, which repairs it itself (see apply). If derr is non-nil the caller
+// receives a failure rather than a zero reply.
+func (b *Bridge) recoverPanic(where string, derr **dbus.Error) {
+	r := recover()
+	if r == nil {
+		return
+	}
+
+	b.log.Printf("Recovered panic in %s: %v\n%s", where, r, debug.Stack())
+	b.metrics.add(metricPanics, 1)
+
+	if derr != nil {
+		*derr = newError(ErrorInternal, "internal error in %s", where)
+	}
+}
+
+// repairLocks restores the lock table invariants after a panic: every entry is non-nil and stored under its own key,
+// and the fd tracker holds exactly the fds of live, non-provisional locks. It must be called on the actor.
+func (b *Bridge) repairLocks() {
+	for k, ld := range b.locks {
+		if ld == nil {
+			b.log.Printf("Repair: removing empty lock table entry %v\n", k)
+			delete(b.locks, k)
+			continue
+		}
+		if k != ld.key() {
+			b.log.Printf("Repair: re-keying %s\n", ld)
+			delete(b.locks, k)
+			b.locks[ld.key()] = ld
+		}
+	}
+
+	b.fds.mtx.Lock()
+	b.fds.fds = make(map[int]string, len(b.locks))
+	b.fds.mtx.Unlock()
+	for _, ld := range b.locks {
+		if !ld.pending() {
+			b.fds.track(ld.fd, ld.String())
+		}
+	}
+
+	open, err := openFds()
+	if err != nil {
+		b.errLog.log("Couldn't list open fds: %v\n", err)
+		return
+	}
+	b.compareFds(open)
+}
@@ -0,0 +1,135 @@
+package bridge
+
+import (
+	"errors"
+	"os"
+	"time"
+
+	"github.com/coltwillcox/inhibitor/pkg/policy"
+)
+
+// initialInhibitBackoff is the delay before the first retry of a failed backend Inhibit. It doubles on each attempt.
+const initialInhibitBackoff = 100 * time.Millisecond
+
+// backendInhibit takes a single inhibit of the what-classes what in mode from the backend on behalf of who/why.
+// It fails at once while the circuit breaker is open (see Options.BreakerThreshold).
+func (b *Bridge) backendInhibit(what, mode, who, why string) (fd *os.File, err error) {
+	err = b.backendCall(func() (err error) {
+		fd, err = b.backend.Inhibit(b.ctx, what, b.opts.Prog, who+" "+why, mode)
+		return err
+	})
+	return fd, err
+}
+
+// splitInhibit takes the backend inhibits, through take, for a lock that only delays sleep and shutdown: those in
+// delay mode and the rest, if any, blocked. logind refuses to delay any other class. delayFd is only set if both are
+// needed; otherwise fd is whichever one was.
+func splitInhibit(what string, take func(what, mode string) (*os.File, error)) (fd, delayFd *os.File, err error) {
+	block, delay := policy.SplitDelay(what)
+	if delay == "" {
+		fd, err = take(what, "block")
+		return fd, nil, err
+	}
+	if delayFd, err = take(delay, "delay"); err != nil || block == "" {
+		return delayFd, nil, err
+	}
+	if fd, err = take(block, "block"); err != nil {
+		delayFd.Close()
+		return nil, nil, err
+	}
+	return fd, delayFd, nil
+}
+
+// acquireInhibit takes a backend inhibit, retrying with exponential backoff so that transient failures (such as
+// logind restarting) don't immediately fail the requesting application.
+func (b *Bridge) acquireInhibit(what, mode, who, why string) (*os.File, error) {
+	backoff := initialInhibitBackoff
+	for attempt := 0; ; attempt++ {
+		fd, err := b.backendInhibit(what, mode, who, why)
+		if err == nil {
+			return fd, nil
+		}
+		if attempt >= b.opts.InhibitRetries || errors.Is(err, errBreakerOpen) {
+			return nil, err
+		}
+
+		b.log.Debugf("Backend Inhibit failed (attempt %d/%d), retrying in %s: %v\n", attempt+1, b.opts.InhibitRetries+1, backoff, err)
+		timer := b.opts.Clock.NewTimer(backoff)
+		select {
+		case <-timer.C():
+		case <-b.ctx.Done():
+			timer.Stop()
+			return nil, b.ctx.Err()
+		}
+		backoff *= 2
+	}
+}
+
+// acquirePending makes one attempt to take the backend inhibit for every provisional lock. The backend is called
+// from the caller's goroutine, not the actor, so that a slow backend doesn't hold up everyone else.
+func (b *Bridge) acquirePending() {
+	var pending []*lockDetails
+	if err := b.do("acquirePending", func() {
+		for _, ld := range b.locks {
+			// Just-in-time locks are waiting for idle, not the backend (see jitTick).
+			if ld.pending() && !ld.jit {
+				pending = append(pending, ld)
+			}
+		}
+	}); err != nil {
+		return
+	}
+
+	for _, ld := range pending {
+		if err := b.acquire(ld, "provisional"); err != nil {
+			b.errLog.log("Still unable to acquire provisional lock: %v\n", err)
+			return
+		}
+	}
+}
+
+// acquire takes the backend inhibit of the pending lock ld, a kind lock. It must not be called on the actor.
+func (b *Bridge) acquire(ld *lockDetails, kind string) error {
+	var fd, delayFd *os.File
+	var err error
+	if ld.downgraded {
+		fd, delayFd, err = splitInhibit(ld.what, func(what, mode string) (*os.File, error) {
+			return b.backendInhibit(what, mode, ld.who, ld.why)
+		})
+	} else {
+		fd, err = b.backendInhibit(ld.what, "block", ld.who, ld.why)
+	}
+	if err != nil {
+		return err
+	}
+
+	adopted := false
+	b.do("acquire", func() {
+		if b.locks[ld.key()] != ld || !ld.pending() {
+			// Released while we were waiting on the backend.
+			return
+		}
+		ld.fd, ld.delay = fd, delayFd
+		b.fds.track(ld.fd, ld.String())
+		if ld.delay != nil {
+			b.fds.track(ld.delay, ld.String())
+		}
+		b.log.Debugf("Acquired %s lock: %s\n", kind, ld)
+		b.emit(Event{Type: LockAcquired, Lock: ld.public()})
+		b.backendBack()
+		adopted = true
+	})
+	if !adopted {
+		fd.Close()
+		if delayFd != nil {
+			delayFd.Close()
+		}
+	}
+	return nil
+}
+
+// pending reports whether ld is a provisional lock still waiting for the backend, or a just-in-time one not yet
+// needed.
+func (ld *lockDetails) pending() bool {
+	return ld.fd == nil
+}
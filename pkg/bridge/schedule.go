@@ -0,0 +1,221 @@
+package bridge
+
+import (
+	"os"
+	"sort"
+	"time"
+
+	"github.com/coltwillcox/inhibitor/pkg/policy"
+	"github.com/godbus/dbus/v5"
+)
+
+const (
+	// scheduleWho is the who of a scheduled lock that wasn't given one.
+	scheduleWho = "schedule"
+	// scheduleRecheck is how often a pending schedule compares its start with the wall clock, which moves on while
+	// the machine is suspended, unlike the timers waiting for it.
+	scheduleRecheck = time.Minute
+)
+
+// Schedule is a lock the bridge takes itself at a set time and holds for a set while, e.g. for a nightly backup
+// window, so that the caller needn't be running when it starts. Pending schedules are handed over and recovered
+// with the lock table.
+type Schedule struct {
+	ID      uint32
+	Who     string
+	Why     string
+	What    string // colon-separated logind what-classes
+	Mode    string // "block" or "delay"
+	At      int64  // Unix time the lock is taken
+	Seconds uint32 // how long it is held
+	UID     uint32 // of whoever scheduled it
+}
+
+// start and end return when s's lock is taken and released.
+func (s Schedule) start() time.Time { return time.Unix(s.At, 0) }
+func (s Schedule) end() time.Time   { return s.start().Add(time.Duration(s.Seconds) * time.Second) }
+
+// Schedule adds s, whose ID is ignored, to the pending schedules and returns its ID. Its lock is taken at s.At, or
+// right away if that has passed, and released once s.Seconds have passed since s.At.
+func (b *Bridge) Schedule(s Schedule) (uint32, error) {
+	id, err := b.schedule(s)
+	if err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+func (b *Bridge) schedule(s Schedule) (uint32, *dbus.Error) {
+	if _, err := policy.ParseWhat(s.What); err != nil {
+		return 0, newError(ErrorInvalidArgs, "%v", err)
+	}
+	if s.Mode == "" {
+		s.Mode = "block"
+	}
+	if s.Mode != "block" && s.Mode != "delay" {
+		return 0, newError(ErrorInvalidArgs, "invalid mode %q, want \"block\" or \"delay\"", s.Mode)
+	}
+	if s.Seconds == 0 {
+		return 0, newError(ErrorInvalidArgs, "a schedule needs a duration")
+	}
+	if !s.end().After(b.opts.Clock.Now()) {
+		return 0, newError(ErrorInvalidArgs, "the schedule would be over by %s", s.end().Format(time.RFC3339))
+	}
+	if s.Who == "" {
+		s.Who = scheduleWho
+	}
+	s.Who, s.Why = policy.Sanitize(s.Who), policy.Sanitize(s.Why)
+
+	if derr := b.do("Schedule", func() {
+		s.ID = 1
+		for _, p := range b.schedules {
+			if p.ID >= s.ID {
+				s.ID = p.ID + 1
+			}
+		}
+		b.schedules = append(b.schedules, s)
+		b.persistLater()
+	}); derr != nil {
+		return 0, derr
+	}
+	b.log.Printf("Scheduled %d: %q / %q, what %s, from %s for %s.\n", s.ID, s.Who, s.Why, s.What, s.start().Format(time.RFC3339), time.Duration(s.Seconds)*time.Second)
+	b.armSchedule(s)
+	return s.ID, nil
+}
+
+// armSchedule takes s's lock once its time has come, unless it has been cancelled by then.
+func (b *Bridge) armSchedule(s Schedule) {
+	go func() {
+		for {
+			wait := s.start().Sub(b.opts.Clock.Now())
+			if wait <= 0 {
+				b.startSchedule(s.ID)
+				return
+			}
+			if wait > scheduleRecheck {
+				wait = scheduleRecheck
+			}
+			timer := b.opts.Clock.NewTimer(wait)
+			select {
+			case <-timer.C():
+			case <-b.ctx.Done():
+				timer.Stop()
+				return
+			}
+		}
+	}()
+}
+
+// startSchedule takes the lock of the pending schedule id, for whatever is left of its duration, and forgets it.
+func (b *Bridge) startSchedule(id uint32) {
+	var (
+		s     Schedule
+		found bool
+	)
+	if derr := b.do("startSchedule", func() {
+		for i, p := range b.schedules {
+			if p.ID == id {
+				s, found = p, true
+				b.schedules = append(b.schedules[:i], b.schedules[i+1:]...)
+				b.persistLater()
+				return
+			}
+		}
+	}); derr != nil || !found {
+		return
+	}
+
+	left := s.end().Sub(b.opts.Clock.Now())
+	if left <= 0 {
+		b.log.Printf("Schedule %d (%q / %q) was over before it could start.\n", s.ID, s.Who, s.Why)
+		return
+	}
+	cookie, err := b.take(b.Name(), s.Who, s.Why, lockRequest{what: s.What, ttl: left, delay: s.Mode == "delay"})
+	if err != nil {
+		b.log.Printf("Error starting schedule %d (%q / %q): %v\n", s.ID, s.Who, s.Why, err)
+		return
+	}
+	b.log.Printf("Schedule %d started: %q / %q held for %s (cookie %d).\n", s.ID, s.Who, s.Why, left.Round(time.Second), cookie)
+}
+
+// CancelSchedule drops the pending schedule id. A schedule that has started is a lock like any other, released with
+// UnInhibit or Release.
+func (b *Bridge) CancelSchedule(id uint32) error {
+	if err := b.cancelSchedule(id, 0, true); err != nil {
+		return err
+	}
+	return nil
+}
+
+// cancelSchedule drops the pending schedule id if uid scheduled it or all is set.
+func (b *Bridge) cancelSchedule(id, uid uint32, all bool) *dbus.Error {
+	var found bool
+	if derr := b.do("CancelSchedule", func() {
+		for i, s := range b.schedules {
+			if s.ID == id && (s.UID == uid || all) {
+				found = true
+				b.schedules = append(b.schedules[:i], b.schedules[i+1:]...)
+				b.persistLater()
+				return
+			}
+		}
+	}); derr != nil {
+		return derr
+	}
+	if !found {
+		return newError(ErrorInvalidArgs, "no pending schedule %d", id)
+	}
+	b.log.Printf("Schedule %d cancelled.\n", id)
+	return nil
+}
+
+// Schedules returns the pending schedules, soonest first.
+func (b *Bridge) Schedules() []Schedule {
+	schedules := []Schedule{}
+	b.do("Schedules", func() { schedules = append(schedules, b.schedules...) })
+	sort.Slice(schedules, func(i, j int) bool { return schedules[i].At < schedules[j].At })
+	return schedules
+}
+
+// Schedule schedules a lock, held by the daemon, from at (Unix time) for seconds (see Bridge.Schedule). Only the
+// daemon's own user or an admin may, since the lock is the daemon's. It returns the schedule's ID.
+func (c *controlAPI) Schedule(from dbus.Sender, who, why, what, mode string, at int64, seconds uint32) (id uint32, err *dbus.Error) {
+	defer c.b.recoverPanic("Schedule", &err)
+
+	uid, err := c.daemonUser(from, "Schedule")
+	if err != nil {
+		return 0, err
+	}
+	return c.b.schedule(Schedule{Who: who, Why: why, What: what, Mode: mode, At: at, Seconds: seconds, UID: uid})
+}
+
+// ListSchedules returns the pending schedules, soonest first.
+func (c *controlAPI) ListSchedules() (schedules []Schedule, err *dbus.Error) {
+	defer c.b.recoverPanic("ListSchedules", &err)
+	return c.b.Schedules(), nil
+}
+
+// CancelSchedule drops a pending schedule. Admins may drop anyone's, others only their own.
+func (c *controlAPI) CancelSchedule(from dbus.Sender, id uint32) (err *dbus.Error) {
+	defer c.b.recoverPanic("CancelSchedule", &err)
+
+	uid, admin, err := c.caller(from)
+	if err != nil {
+		return err
+	}
+	return c.b.cancelSchedule(id, uid, admin)
+}
+
+// daemonUser checks that from, calling method, is the daemon's own user or an admin, as needed to have the daemon
+// hold locks of its own, and returns its uid.
+func (c *controlAPI) daemonUser(from dbus.Sender, method string) (uint32, *dbus.Error) {
+	uid, admin, err := c.caller(from)
+	if err != nil {
+		return 0, err
+	}
+	if uid != uint32(os.Getuid()) && !admin {
+		c.b.errLog.log("%s from %q denied\n", method, from)
+		return 0, newError(ErrorDenied, "%q may not call %s", from, method)
+	}
+	return uid, nil
+}
@@ -0,0 +1,174 @@
+package bridge
+
+import (
+	_ "embed"
+	"encoding/xml"
+	"fmt"
+	"strings"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/godbus/dbus/v5/introspect"
+)
+
+var (
+	//go:embed org.freedesktop.ScreenSaver.xml
+	screensaverInterface string
+	// screensaverIntrospection is org.freedesktop.ScreenSaver's introspection data, generated from screenSaver's
+	// methods with the embedded file's descriptions taking their place. screensaverIntrospectionErr says what of the
+	// file couldn't be used, if anything.
+	screensaverIntrospection, screensaverIntrospectionErr = overrideIntrospection(
+		introspect.Interface{Name: screensaver, Methods: introspect.Methods(&screenSaver{})}, screensaverInterface)
+)
+
+// overrideIntrospection returns gen, introspection data generated from the methods of an exported object, with the
+// methods described in override, the hand-written XML of the interface, taking their place. override supplies what
+// can't be generated, such as argument names; methods it leaves out are still listed, so that new ones show up
+// without it being touched. An override that is empty or doesn't parse is ignored, and so is that of a method whose
+// arguments no longer match the Go one's or which doesn't exist: the error says what was ignored, and gen is
+// returned with the rest applied.
+func overrideIntrospection(gen introspect.Interface, override string) (introspect.Interface, error) {
+	if strings.TrimSpace(override) == "" {
+		return gen, fmt.Errorf("no introspection data is embedded for %s", gen.Name)
+	}
+	var o introspect.Interface
+	if err := xml.Unmarshal([]byte(override), &o); err != nil {
+		return gen, fmt.Errorf("parsing the embedded introspection data for %s: %v", gen.Name, err)
+	}
+	if o.Name != gen.Name {
+		return gen, fmt.Errorf("the embedded introspection data describes %q rather than %s", o.Name, gen.Name)
+	}
+
+	methods := make(map[string]int, len(gen.Methods))
+	for i, m := range gen.Methods {
+		methods[m.Name] = i
+	}
+	var ignored []string
+	for _, m := range o.Methods {
+		i, ok := methods[m.Name]
+		if !ok || !sameArgs(m.Args, gen.Methods[i].Args) {
+			ignored = append(ignored, m.Name)
+			continue
+		}
+		gen.Methods[i] = m
+	}
+	gen.Signals, gen.Properties, gen.Annotations = o.Signals, o.Properties, o.Annotations
+	if len(ignored) > 0 {
+		return gen, fmt.Errorf("the embedded introspection data for %s doesn't match the methods %s", gen.Name, strings.Join(ignored, ", "))
+	}
+	return gen, nil
+}
+
+// sameArgs reports whether a and b are the same arguments, names aside.
+func sameArgs(a, b []introspect.Arg) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	dir := func(arg introspect.Arg) string {
+		if arg.Direction == "" {
+			// The default for method arguments.
+			return "in"
+		}
+		return arg.Direction
+	}
+	for i := range a {
+		if a[i].Type != b[i].Type || dir(a[i]) != dir(b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// screenSaver is the object exported as org.freedesktop.ScreenSaver. It is kept apart from Bridge so that the Go
+// API and the D-Bus API can differ.
+type screenSaver struct {
+	b   *Bridge
+	via string // the path it is served on, as RequestsVia names it
+}
+
+func (b *Bridge) exportScreenSaver() error {
+	return b.exportScreenSaverOn(screensaverPath)
+}
+
+func (b *Bridge) exportScreenSaverOn(p dbus.ObjectPath) error {
+	via := ViaExtraPaths
+	switch p {
+	case screensaverPath:
+		via = ViaScreenSaver
+	case legacyPath:
+		via = CompatLegacyPath
+	}
+	return b.exports.export(&screenSaver{b: b, via: via}, p, screensaverIntrospection)
+}
+
+// SetExtraPaths replaces the extra object paths org.freedesktop.ScreenSaver is served on (see Options.ExtraPaths),
+// exporting new ones and withdrawing those no longer listed. The standard paths are left alone: /ScreenSaver is up to
+// CompatLegacyPath. Nothing changes if any path is invalid.
+func (b *Bridge) SetExtraPaths(paths []dbus.ObjectPath) error {
+	var err error
+	if derr := b.do("SetExtraPaths", func() { err = b.setExtraPaths(paths) }); derr != nil {
+		return derr
+	}
+	return err
+}
+
+// setExtraPaths must be called on the actor.
+func (b *Bridge) setExtraPaths(paths []dbus.ObjectPath) error {
+	want := make(map[dbus.ObjectPath]bool)
+	for _, p := range paths {
+		if !p.IsValid() {
+			return fmt.Errorf("invalid object path %q", p)
+		}
+		if p != screensaverPath && p != legacyPath {
+			want[p] = true
+		}
+	}
+
+	for p := range b.paths {
+		if want[p] {
+			continue
+		}
+		b.exports.unexport(p, screensaver)
+		delete(b.paths, p)
+		b.log.Debugf("No longer serving %s on %q.\n", screensaver, p)
+	}
+	for p := range want {
+		if b.paths[p] {
+			continue
+		}
+		if err := b.exportScreenSaverOn(p); err != nil {
+			return err
+		}
+		b.paths[p] = true
+		b.log.Debugf("Serving %s on %q.\n", screensaver, p)
+	}
+
+	return nil
+}
+
+// Inhibit implements org.freedesktop.ScreenSaver.Inhibit.
+func (ss *screenSaver) Inhibit(from dbus.Sender, msg dbus.Message, who, why string) (cookie uint, derr *dbus.Error) {
+	defer ss.b.recoverPanic("Inhibit", &derr)
+
+	if err := ss.b.policy.CheckCall(msg, screensaverPath, screensaver, "ss"); err != nil {
+		ss.b.errLog.log("Strict mode rejected Inhibit from %q: %v\n", from, err)
+		return 0, err
+	}
+	if err := ss.b.policy.CheckArgs(who, why); err != nil {
+		ss.b.errLog.log("Strict mode rejected Inhibit from %q: %v\n", from, err)
+		return 0, err
+	}
+
+	return ss.b.inhibit(from, ss.via, who, why)
+}
+
+// UnInhibit implements org.freedesktop.ScreenSaver.UnInhibit.
+func (ss *screenSaver) UnInhibit(from dbus.Sender, msg dbus.Message, cookie uint32) (derr *dbus.Error) {
+	defer ss.b.recoverPanic("UnInhibit", &derr)
+
+	if err := ss.b.policy.CheckCall(msg, screensaverPath, screensaver, "u"); err != nil {
+		ss.b.errLog.log("Strict mode rejected UnInhibit from %q: %v\n", from, err)
+		return err
+	}
+
+	return ss.b.unInhibit(from, cookie)
+}
@@ -0,0 +1,27 @@
+package bridge
+
+import (
+	"github.com/coltwillcox/inhibitor/pkg/backend"
+)
+
+// sessionClass returns the class of the session proc runs in (see backend.SessionClasser), or "" if it isn't known.
+func (b *Bridge) sessionClass(proc *peerProcess) string {
+	classer, ok := b.backend.(backend.SessionClasser)
+	if !ok || proc == nil {
+		return ""
+	}
+
+	class, err := classer.SessionClass(b.ctx, proc.pid)
+	if err != nil {
+		b.errLog.log("Couldn't find the session of %s: %v\n", proc, err)
+		return ""
+	}
+	return class
+}
+
+// remote reports whether locks from a session of class may not inhibit sleep (see Options.RemoteSleep): sleeping
+// doesn't disturb a remote or headless user the way it does one at the machine, and keeping a machine up for them is
+// rarely what whoever is in front of it wants.
+func (b *Bridge) remote(class string) bool {
+	return !b.opts.RemoteSleep && (class == backend.SessionRemote || class == backend.SessionHeadless)
+}
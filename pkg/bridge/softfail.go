@@ -0,0 +1,33 @@
+package bridge
+
+// softFail switches the bridge to no-op locks after the backend failed with err (see Options.SoftFail), warning
+// loudly: while it lasts, nothing is actually inhibited. It must be called on the actor.
+func (b *Bridge) softFail(err error) {
+	if b.degraded {
+		return
+	}
+	b.degraded = true
+	b.metrics.set(metricDegraded, 1)
+	b.log.Printf("WARNING: the backend is unavailable (%v); handing out no-op locks, which inhibit nothing, until it is back.\n", err)
+}
+
+// backendBack ends soft failure once the backend took a lock again. The heartbeat takes the remaining no-op locks for
+// real. It must be called on the actor.
+func (b *Bridge) backendBack() {
+	if !b.degraded {
+		return
+	}
+	b.degraded = false
+	b.metrics.set(metricDegraded, 0)
+	n := 0
+	for _, ld := range b.locks {
+		if ld.pending() && !ld.jit {
+			n++
+		}
+	}
+	if n == 0 {
+		b.log.Printf("The backend is available again; locks inhibit again.\n")
+		return
+	}
+	b.log.Printf("The backend is available again; the heartbeat takes the other %d no-op locks for real.\n", n)
+}
@@ -0,0 +1,40 @@
+package bridge
+
+import (
+	"sort"
+	"time"
+)
+
+// Summary describes a bridge's run so far. Front-ends report it at shutdown to explain why a machine never slept.
+type Summary struct {
+	Started time.Time
+	Taken   time.Time
+	// Held is every lock held when the summary was taken, longest-held first.
+	Held []Lock
+	// Counters are the bridge's internal counters, including locks granted, released, reaped and revoked.
+	Counters map[string]uint64
+}
+
+// Summary returns a summary of the bridge's run. Call it before Close, which releases every lock.
+func (b *Bridge) Summary() *Summary {
+	s := &Summary{
+		Started:  b.started,
+		Taken:    b.opts.Clock.Now(),
+		Held:     b.Locks(),
+		Counters: b.metrics.snapshot(),
+	}
+
+	// Ordered fully, so that two summaries of the same state read the same.
+	sort.Slice(s.Held, func(i, j int) bool {
+		a, c := s.Held[i], s.Held[j]
+		if !a.Since.Equal(c.Since) {
+			return a.Since.Before(c.Since)
+		}
+		if a.Peer != c.Peer {
+			return a.Peer < c.Peer
+		}
+		return a.Cookie < c.Cookie
+	})
+
+	return s
+}
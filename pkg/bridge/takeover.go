@@ -0,0 +1,156 @@
+package bridge
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+)
+
+// takeoverConfirmWait is how long a bridge stays frozen after Handoff for the successor to confirm with HandoffDone,
+// before it gives up on it and carries on serving.
+const takeoverConfirmWait = 5 * time.Second
+
+// handoffFd carries a lock fd in a Handoff reply. The fds are sent as an array of structs, a(h), since godbus fails to
+// decode a plain array of fds.
+type handoffFd struct {
+	FD dbus.UnixFD
+}
+
+// pendingTakeover is a Handoff awaiting confirmation.
+type pendingTakeover struct {
+	to    dbus.Sender
+	thaw  func()
+	timer *time.Timer
+}
+
+// Handoff freezes the bridge and passes its lock table to a successor: the table as JSON, with each lock's FD an
+// index into fds. The successor must confirm with HandoffDone, after which Options.HandedOff runs and the successor
+// claims ServiceName; without confirmation the bridge thaws and carries on. Only the daemon's own user or an admin
+// may take the locks over.
+func (c *controlAPI) Handoff(from dbus.Sender) (state string, fds []handoffFd, err *dbus.Error) {
+	defer c.b.recoverPanic("Handoff", &err)
+
+	uid, admin, err := c.caller(from)
+	if err != nil {
+		return "", nil, err
+	}
+	if uid != uint32(os.Getuid()) && !admin {
+		c.b.errLog.log("Handoff to %q denied\n", from)
+		return "", nil, newError(ErrorDenied, "%q may not take the locks over", from)
+	}
+	if c.b.opts.HandedOff == nil {
+		return "", nil, newError(ErrorNotSupported, "handoffs are disabled")
+	}
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if c.takeover != nil {
+		return "", nil, newError(ErrorDenied, "already handing over to %q", c.takeover.to)
+	}
+
+	h, thaw, e := c.b.Freeze()
+	if e != nil {
+		return "", nil, newError(ErrorInternal, "%v", e)
+	}
+	for i := range h.Locks {
+		if hl := &h.Locks[i]; hl.FD >= 0 {
+			fds = append(fds, handoffFd{FD: dbus.UnixFD(hl.FD)})
+			hl.FD = len(fds) - 1
+		}
+		if hl := &h.Locks[i]; hl.Downgraded && hl.DelayFD >= 0 {
+			fds = append(fds, handoffFd{FD: dbus.UnixFD(hl.DelayFD)})
+			hl.DelayFD = len(fds) - 1
+		}
+	}
+	data, e := json.Marshal(h)
+	if e != nil {
+		thaw()
+		return "", nil, newError(ErrorInternal, "%v", e)
+	}
+
+	c.b.log.Printf("Handing %d locks over to %q.\n", len(h.Locks), from)
+	c.takeover = &pendingTakeover{to: from, thaw: thaw}
+	c.takeover.timer = time.AfterFunc(takeoverConfirmWait, func() {
+		c.mtx.Lock()
+		defer c.mtx.Unlock()
+		if c.takeover == nil || c.takeover.to != from {
+			return
+		}
+		c.b.log.Printf("%q never confirmed the handoff; carrying on.\n", from)
+		c.takeover.thaw()
+		c.takeover = nil
+	})
+
+	return string(data), fds, nil
+}
+
+// HandoffDone confirms that the caller of Handoff has taken the locks over. The reply is sent before
+// Options.HandedOff runs.
+func (c *controlAPI) HandoffDone(from dbus.Sender) (err *dbus.Error) {
+	defer c.b.recoverPanic("HandoffDone", &err)
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if c.takeover == nil || c.takeover.to != from {
+		return newError(ErrorDenied, "no handoff to %q in progress", from)
+	}
+	if !c.takeover.timer.Stop() {
+		return newError(ErrorDenied, "the handoff to %q timed out", from)
+	}
+
+	c.b.log.Printf("Handoff to %q confirmed.\n", from)
+	time.AfterFunc(upgradeDelay, c.b.opts.HandedOff)
+	return nil
+}
+
+// RequestHandoff takes the lock table over from the bridge serving ServiceName on conn. The returned Handoff is
+// meant for Options.Handoff, its lock fds already open in this process. Call confirm once ready to take over; the
+// predecessor then runs its Options.HandedOff, which should exit and so release ServiceName.
+func RequestHandoff(conn Bus) (h *Handoff, confirm func() error, err error) {
+	obj := conn.Object(ServiceName, ControlPath)
+
+	var (
+		state string
+		fds   []handoffFd
+	)
+	if err := obj.Call(ControlInterface+".Handoff", 0).Store(&state, &fds); err != nil {
+		return nil, nil, err
+	}
+	closeAll := func() {
+		for _, fd := range fds {
+			os.NewFile(uintptr(fd.FD), "inhibit").Close()
+		}
+	}
+
+	h = &Handoff{}
+	if err := json.Unmarshal([]byte(state), h); err != nil {
+		closeAll()
+		return nil, nil, fmt.Errorf("couldn't parse the handoff: %v", err)
+	}
+	resolve := func(hl *HandoffLock, fd *int) error {
+		if *fd >= len(fds) {
+			return fmt.Errorf("lock %d refers to fd %d of %d", hl.Cookie, *fd, len(fds))
+		}
+		*fd = int(fds[*fd].FD)
+		return nil
+	}
+	for i := range h.Locks {
+		hl := &h.Locks[i]
+		var err error
+		if hl.FD >= 0 {
+			err = resolve(hl, &hl.FD)
+		}
+		if err == nil && hl.Downgraded && hl.DelayFD >= 0 {
+			err = resolve(hl, &hl.DelayFD)
+		}
+		if err != nil {
+			closeAll()
+			return nil, nil, err
+		}
+	}
+
+	return h, func() error { return obj.Call(ControlInterface+".HandoffDone", 0).Err }, nil
+}
@@ -0,0 +1,191 @@
+package bridge
+
+import (
+	"sort"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+)
+
+const (
+	// TopRetention is how far back TopInhibitors can look.
+	TopRetention = 24 * time.Hour
+	// topBucket is the resolution of TopInhibitors' window.
+	topBucket = time.Minute
+)
+
+// TopInhibitor is an application's share of the inhibit time in a recent window.
+type TopInhibitor struct {
+	App     string // its Flatpak application ID, or else the who of its locks
+	UID     uint32
+	Seconds uint64 // lock time within the window, counting every lock on its own
+	Locks   uint32 // taken within the window
+	Held    uint32 // held now
+}
+
+// usageKey is what TopInhibitors adds locks up under.
+type usageKey struct {
+	uid uint32
+	app string
+}
+
+// usageBucket is what an application did in one topBucket.
+type usageBucket struct {
+	held  time.Duration // of every lock, each on its own
+	locks uint32        // taken
+}
+
+// appUsage is the lock time and locks taken of every application over the last TopRetention, by topBucket. It is
+// only touched on the actor.
+type appUsage struct {
+	buckets map[usageKey]map[int64]*usageBucket // by bucket number since the epoch
+	pruned  int64                               // the bucket buckets were last pruned in
+}
+
+// usageKey returns what TopInhibitors counts ld under.
+func (ld *lockDetails) usageKey() usageKey {
+	if ld.app != "" {
+		return usageKey{ld.uid, ld.app}
+	}
+	return usageKey{ld.uid, ld.who}
+}
+
+// bucket returns the bucket of k that t falls in.
+func (u *appUsage) bucket(k usageKey, t time.Time) *usageBucket {
+	n := t.UnixNano() / int64(topBucket)
+	if u.buckets == nil {
+		u.buckets = make(map[usageKey]map[int64]*usageBucket)
+	}
+	if u.buckets[k] == nil {
+		u.buckets[k] = make(map[int64]*usageBucket)
+	}
+	if u.buckets[k][n] == nil {
+		u.buckets[k][n] = &usageBucket{}
+	}
+	return u.buckets[k][n]
+}
+
+// taken counts a lock ld taken at now.
+func (u *appUsage) taken(ld *lockDetails, now time.Time) {
+	u.bucket(ld.usageKey(), now).locks++
+	u.prune(now)
+}
+
+// released adds the time ld was held, up to now, split across the buckets it spans.
+func (u *appUsage) released(ld *lockDetails, now time.Time) {
+	k := ld.usageKey()
+	for from := latest(ld.since, now.Add(-TopRetention)); from.Before(now); {
+		end := from.Truncate(topBucket).Add(topBucket)
+		if end.After(now) {
+			end = now
+		}
+		u.bucket(k, from).held += end.Sub(from)
+		from = end
+	}
+	u.prune(now)
+}
+
+// prune forgets the buckets older than TopRetention, at most once a bucket.
+func (u *appUsage) prune(now time.Time) {
+	n := now.UnixNano() / int64(topBucket)
+	if n == u.pruned {
+		return
+	}
+	u.pruned = n
+	oldest := now.Add(-TopRetention).UnixNano() / int64(topBucket)
+	for k, buckets := range u.buckets {
+		for b := range buckets {
+			if b < oldest {
+				delete(buckets, b)
+			}
+		}
+		if len(buckets) == 0 {
+			delete(u.buckets, k)
+		}
+	}
+}
+
+func latest(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
+
+// TopInhibitors returns the n applications that held locks the longest over the last window, longest first, for
+// finding what is draining the battery. Locks still held count up to now. window is rounded to a minute and capped at
+// TopRetention; 0 means TopRetention. n 0 returns every application. Only uid's applications are included unless all
+// is set. Locks released before the bridge started, such as by a previous instance, aren't counted.
+func (b *Bridge) TopInhibitors(n int, window time.Duration, uid uint32, all bool) []TopInhibitor {
+	if window <= 0 || window > TopRetention {
+		window = TopRetention
+	}
+	type total struct {
+		held       time.Duration
+		locks, now uint32
+	}
+	totals := make(map[usageKey]*total)
+	get := func(k usageKey) *total {
+		if totals[k] == nil {
+			totals[k] = &total{}
+		}
+		return totals[k]
+	}
+	b.do("TopInhibitors", func() {
+		now := b.opts.Clock.Now()
+		since := now.Add(-window)
+		oldest := since.UnixNano() / int64(topBucket)
+		for k, buckets := range b.apps.buckets {
+			if k.uid != uid && !all {
+				continue
+			}
+			for i, bu := range buckets {
+				if i < oldest {
+					continue
+				}
+				t := get(k)
+				t.held += bu.held
+				t.locks += bu.locks
+			}
+		}
+		for _, ld := range b.locks {
+			k := ld.usageKey()
+			if k.uid != uid && !all {
+				continue
+			}
+			t := get(k)
+			t.now++
+			t.held += now.Sub(latest(ld.since, since))
+		}
+	})
+
+	list := make([]TopInhibitor, 0, len(totals))
+	for k, t := range totals {
+		if t.held > 0 || t.locks > 0 || t.now > 0 {
+			list = append(list, TopInhibitor{App: k.app, UID: k.uid, Seconds: uint64(t.held / time.Second), Locks: t.locks, Held: t.now})
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Seconds != list[j].Seconds {
+			return list[i].Seconds > list[j].Seconds
+		}
+		return list[i].App < list[j].App
+	})
+	if n > 0 && len(list) > n {
+		list = list[:n]
+	}
+	return list
+}
+
+// GetTopInhibitors returns the n applications that held locks the longest over the last window seconds (see
+// Bridge.TopInhibitors). Like ListInhibits, it only counts the caller's own locks unless the caller is an admin.
+func (c *controlAPI) GetTopInhibitors(from dbus.Sender, n, window uint32) (tops []TopInhibitor, err *dbus.Error) {
+	defer c.b.recoverPanic("GetTopInhibitors", &err)
+
+	uid, admin, err := c.caller(from)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.b.TopInhibitors(int(n), time.Duration(window)*time.Second, uid, admin), nil
+}
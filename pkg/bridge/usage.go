@@ -0,0 +1,109 @@
+package bridge
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// usage is the daemon's own resource usage, as sampled by usageTick.
+type usage struct {
+	rss        uint64 // bytes
+	goroutines uint64
+	fds        uint64
+}
+
+// usageStats are the resource usage at startup and the figures worth a warning.
+type usageStats struct {
+	base, warn usage
+}
+
+// usageFloor is how much each figure must grow beyond its baseline, besides doubling, before it is worth a warning:
+// a daemon that starts small doubles its goroutines or fds merely by serving a handful of clients.
+var usageFloor = usage{rss: 32 << 20, goroutines: 200, fds: 100}
+
+// readUsage samples the process's resident memory, goroutines and open fds.
+func readUsage() (usage, error) {
+	u := usage{goroutines: uint64(runtime.NumGoroutine())}
+	statm, err := os.ReadFile("/proc/self/statm")
+	if err != nil {
+		return u, err
+	}
+	fields := strings.Fields(string(statm))
+	if len(fields) < 2 {
+		return u, fmt.Errorf("malformed /proc/self/statm: %q", statm)
+	}
+	pages, err := strconv.ParseUint(fields[1], 10, 64)
+	if err != nil {
+		return u, fmt.Errorf("malformed /proc/self/statm: %q", statm)
+	}
+	u.rss = pages * uint64(os.Getpagesize())
+	fds, err := openFds()
+	if err != nil {
+		return u, err
+	}
+	u.fds = uint64(len(fds))
+	return u, nil
+}
+
+// usageTick publishes the daemon's resource usage as gauges and warns when any of it grows well past what it was at
+// startup, which in a daemon that runs for a whole session points to a leak. Once warned about, a figure is only
+// warned about again when it doubles again.
+func (b *Bridge) usageTick() {
+	defer b.recoverPanic("usage", nil)
+
+	u, err := readUsage()
+	if err != nil {
+		b.errLog.log("Couldn't sample resource usage: %v\n", err)
+		return
+	}
+	b.metrics.set(metricRSS, u.rss)
+	b.metrics.set(metricGoroutines, u.goroutines)
+	b.metrics.set(metricOpenFds, u.fds)
+
+	s := &b.usage
+	if s.base == (usage{}) {
+		s.base, s.warn = u, usage{
+			rss:        usageLimit(u.rss, usageFloor.rss),
+			goroutines: usageLimit(u.goroutines, usageFloor.goroutines),
+			fds:        usageLimit(u.fds, usageFloor.fds),
+		}
+		b.log.Debugf("Resource usage at startup: %s.\n", u)
+		return
+	}
+	b.checkUsage("resident memory", formatBytes, s.base.rss, u.rss, &s.warn.rss)
+	b.checkUsage("goroutines", formatCount, s.base.goroutines, u.goroutines, &s.warn.goroutines)
+	b.checkUsage("open fds", formatCount, s.base.fds, u.fds, &s.warn.fds)
+}
+
+// checkUsage warns if now has reached *limit, and raises *limit to double now.
+func (b *Bridge) checkUsage(what string, format func(uint64) string, base, now uint64, limit *uint64) {
+	if now < *limit {
+		return
+	}
+	b.log.Printf("USAGE: %s grew from %s at startup to %s; this may be a leak.\n", what, format(base), format(now))
+	*limit = 2 * now
+}
+
+// usageLimit is the first figure worth a warning for one that started at base.
+func usageLimit(base, floor uint64) uint64 {
+	if 2*base > base+floor {
+		return 2 * base
+	}
+	return base + floor
+}
+
+// String returns a useful textual representation of usage.
+func (u usage) String() string {
+	return fmt.Sprintf("%s resident, %d goroutines, %d open fds", formatBytes(u.rss), u.goroutines, u.fds)
+}
+
+func formatBytes(n uint64) string {
+	return fmt.Sprintf("%.1f MiB", float64(n)/(1<<20))
+}
+
+func formatCount(n uint64) string {
+	return strconv.FormatUint(n, 10)
+}
@@ -0,0 +1,110 @@
+package bridge
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/coltwillcox/inhibitor/pkg/backend"
+)
+
+// vetoesSuspend reports whether ld keeps a closed lid from suspending the machine: it blocks sleep, or the lid switch
+// itself, with its backend lock taken.
+func (ld *lockDetails) vetoesSuspend() bool {
+	if ld.pending() {
+		return false
+	}
+	for _, w := range strings.Split(ld.what, ":") {
+		if w == "handle-lid-switch" || w == "sleep" && !ld.downgraded {
+			return true
+		}
+	}
+	return false
+}
+
+// watchSleep starts following the backend's sleep events to report the locks that veto a suspend (see
+// Options.SuspendVeto). Backends that can't tell are left alone.
+func (b *Bridge) watchSleep() {
+	sw, ok := b.backend.(backend.SleepWatcher)
+	if !ok || b.opts.SuspendVeto <= 0 {
+		return
+	}
+	ch := make(chan backend.SleepEvent, 8)
+	if err := sw.WatchSleep(b.ctx, ch); err != nil {
+		b.log.Printf("Not reporting suspend vetoes: %v\n", err)
+		return
+	}
+	b.group.Go(func() error {
+		b.followSleep(ch)
+		return nil
+	})
+}
+
+// followSleep waits, each time the lid is closed while locks veto a suspend, for the machine to go to sleep anyway,
+// which logind does with the lid switch ignoring inhibitors. If it hasn't within Options.SuspendVeto, the suspend
+// counts as vetoed by the locks still held.
+func (b *Bridge) followSleep(ch <-chan backend.SleepEvent) {
+	timer := b.opts.Clock.NewTimer(b.opts.SuspendVeto)
+	stop := func() {
+		if !timer.Stop() {
+			select {
+			case <-timer.C():
+			default:
+			}
+		}
+	}
+	stop()
+	waiting := false
+	for {
+		select {
+		case <-b.ctx.Done():
+			stop()
+			return
+		case ev := <-ch:
+			if waiting {
+				stop()
+				waiting = false
+			}
+			if ev == backend.LidClosed && len(b.suspendVetoes()) > 0 {
+				timer.Reset(b.opts.SuspendVeto)
+				waiting = true
+			}
+		case <-timer.C():
+			waiting = false
+			b.reportVeto()
+		}
+	}
+}
+
+// suspendVetoes returns the locks that keep a closed lid from suspending the machine, the longest held first.
+func (b *Bridge) suspendVetoes() []Lock {
+	var vetoes []Lock
+	b.do("suspendVetoes", func() {
+		for _, ld := range b.locks {
+			if ld.vetoesSuspend() {
+				vetoes = append(vetoes, ld.public())
+			}
+		}
+	})
+	sort.Slice(vetoes, func(i, j int) bool { return vetoes[i].Since.Before(vetoes[j].Since) })
+	return vetoes
+}
+
+// reportVeto announces that the lid was closed without the machine suspending, naming the applications whose locks
+// kept it awake, with the SuspendVetoed event. The event's Lock is the one held longest.
+func (b *Bridge) reportVeto() {
+	vetoes := b.suspendVetoes()
+	if len(vetoes) == 0 {
+		return
+	}
+	names := make([]string, 0, len(vetoes))
+	for _, l := range vetoes {
+		names = append(names, fmt.Sprintf("%s (%s)", l.Who, l.Why))
+	}
+	msg := "suspend vetoed by " + strings.Join(names, ", ")
+	b.log.Printf("The lid was closed but the machine didn't suspend: %s.\n", msg)
+	b.do("reportVeto", func() {
+		b.metrics.addFor(metricSuspendsVetoed, vetoes[0].ID)
+		b.emit(Event{Type: SuspendVetoed, Lock: vetoes[0], Message: msg})
+	})
+}
@@ -0,0 +1,98 @@
+package bridge
+
+import (
+	"context"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+)
+
+// watchdogTimeout bounds the loopback call to each of our objects.
+const watchdogTimeout = 5 * time.Second
+
+// watchdogTick checks that the names the bridge claimed are still ours and that its objects answer Introspect when
+// called through the bus, claiming and exporting them again if not. It runs every Options.Watchdog. The bus, or our
+// own connection's handling of it, can break without telling us: a name dropped without NameOwnerChanged, an export
+// that no longer answers. Clients then fail while everything looks fine from here.
+func (b *Bridge) watchdogTick() {
+	defer b.recoverPanic("watchdog", nil)
+
+	self := b.Name()
+	if self == "" {
+		return
+	}
+
+	var (
+		serving bool
+		compat  []string
+		paths   = []dbus.ObjectPath{screensaverPath}
+	)
+	if err := b.do("watchdog", func() {
+		serving, compat = b.serving, b.compat
+		if b.legacy {
+			paths = append(paths, legacyPath)
+		}
+		for p := range b.paths {
+			paths = append(paths, p)
+		}
+	}); err != nil {
+		return
+	}
+
+	if serving {
+		b.checkName(self, screensaver, b.opts.nameFlags())
+	}
+	for _, n := range compat {
+		b.checkName(self, n, dbus.NameFlagDoNotQueue)
+	}
+
+	for _, p := range paths {
+		if err := b.ping(self, p); err != nil {
+			b.log.Printf("WATCHDOG: %s doesn't answer on %q (%v); exporting it again.\n", screensaver, p, err)
+			b.metrics.add(metricWatchdogRepairs, 1)
+			if err := b.exportScreenSaverOn(p); err != nil {
+				b.errLog.log("WATCHDOG: %v\n", err)
+			}
+		}
+	}
+	if err := b.ping(self, ControlPath); err != nil {
+		b.log.Printf("WATCHDOG: %s doesn't answer on %q (%v); exporting it again.\n", ControlInterface, ControlPath, err)
+		b.metrics.add(metricWatchdogRepairs, 1)
+		if err := b.exportControl(); err != nil {
+			b.errLog.log("WATCHDOG: %v\n", err)
+		}
+	}
+}
+
+// checkName claims name again if nobody owns it any more. A name owned by another connection is only reported:
+// legitimate takeovers come with NameOwnerChanged, and a missed one is no reason to fight over the name.
+func (b *Bridge) checkName(self dbus.Sender, name string, flags dbus.RequestNameFlags) {
+	var owner string
+	err := b.dbusConn.BusObject().CallWithContext(b.ctx, getNameOwner, 0, name).Store(&owner)
+	if b.ctx.Err() != nil {
+		return
+	}
+	switch {
+	case err == nil && dbus.Sender(owner) == self:
+		return
+	case err == nil:
+		b.log.Printf("WATCHDOG: %s is owned by %q, but no change of owner was seen.\n", name, owner)
+		b.metrics.add(metricWatchdogRepairs, 1)
+		return
+	}
+
+	b.log.Printf("WATCHDOG: %s has no owner (%v); claiming it again.\n", name, err)
+	b.metrics.add(metricWatchdogRepairs, 1)
+	if r, err := b.dbusConn.RequestName(name, flags); err != nil {
+		b.errLog.log("WATCHDOG: conn.RequestName(%q, 0): %v\n", name, err)
+	} else if r != dbus.RequestNameReplyPrimaryOwner && r != dbus.RequestNameReplyAlreadyOwner {
+		b.errLog.log("WATCHDOG: couldn't claim %s again (reply %d).\n", name, r)
+	}
+}
+
+// ping calls Introspect on our own object at path through the bus.
+func (b *Bridge) ping(self dbus.Sender, path dbus.ObjectPath) error {
+	ctx, cancel := context.WithTimeout(b.ctx, watchdogTimeout)
+	defer cancel()
+	return b.dbusConn.Object(string(self), path).CallWithContext(ctx, intro+".Introspect", 0).Err
+}
@@ -0,0 +1,85 @@
+package bridge
+
+import "time"
+
+// periodic is a job run by the bridge's wheel.
+type periodic struct {
+	every time.Duration
+	// idle jobs keep running while the bridge has nothing to do. Everything else pauses until it does.
+	idle bool
+	run  func()
+	next time.Time // zero while paused
+}
+
+// runWheel runs every periodic job of the bridge from a single timer, so that the daemon's own wakeups cost as little
+// battery as possible. Each job's runs are aligned to multiples of its interval, which makes jobs whose intervals
+// divide each other (the 10s heartbeat and the 1m watchdog) share wakeups. While no lock is held and nothing waits
+// to be logged, only idle jobs run; with none of those the bridge doesn't wake up at all until a lock is taken.
+func (b *Bridge) runWheel(jobs []*periodic) error {
+	clock := b.opts.Clock
+	// Start from a stopped timer, which a fake clock wouldn't fire until it's advanced.
+	timer := clock.NewTimer(time.Hour)
+	timer.Stop()
+	defer timer.Stop()
+
+	for {
+		now := clock.Now()
+		busy := b.busy()
+		var next time.Time
+		for _, j := range jobs {
+			if !busy && !j.idle {
+				j.next = time.Time{}
+				continue
+			}
+			if j.next.IsZero() {
+				j.next = align(now, j.every)
+			}
+			if !j.next.After(now) {
+				j.run()
+				j.next = align(clock.Now(), j.every)
+			}
+			if next.IsZero() || j.next.Before(next) {
+				next = j.next
+			}
+		}
+
+		var fire <-chan time.Time
+		if !next.IsZero() {
+			timer.Reset(next.Sub(clock.Now()))
+			fire = timer.C()
+		}
+		select {
+		case <-fire:
+			b.metrics.add(metricWakeups, 1)
+		case <-b.wakeCh:
+			// A lock was taken or an error suppressed: resume the paused jobs.
+			if !timer.Stop() && fire != nil {
+				<-timer.C()
+			}
+		case <-b.ctx.Done():
+			return nil
+		}
+	}
+}
+
+// align returns the first multiple of every after now.
+func align(now time.Time, every time.Duration) time.Time {
+	return now.Truncate(every).Add(every)
+}
+
+// busy reports whether the bridge has work for its periodic jobs: locks to check, or suppressed errors to report.
+func (b *Bridge) busy() bool {
+	var held bool
+	if err := b.do("busy", func() { held = len(b.locks) > 0 }); err != nil {
+		return false
+	}
+	return held || b.errLog.pending()
+}
+
+// wake tells the wheel that the bridge may have become busy.
+func (b *Bridge) wake() {
+	select {
+	case b.wakeCh <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,73 @@
+package bridge
+
+import (
+	"strings"
+
+	"github.com/godbus/dbus/v5"
+)
+
+// The kinds of window a lock can be bound to, named like xdg-desktop-portal's parent_window.
+const (
+	WindowX11     = "x11:"     // followed by the X window id, e.g. "x11:0x3a00007"
+	WindowWayland = "wayland:" // followed by an xdg-foreign handle the application exported for the toplevel
+)
+
+// WindowWatcher follows the windows locks taken with InhibitWindow are bound to.
+type WindowWatcher interface {
+	// Watch calls closed once window has gone away, unless stop is called first. It must not block, and closed may
+	// be called from any goroutine.
+	Watch(window string, closed func()) (stop func(), err error)
+}
+
+// validWindow reports whether window names a window a lock can be bound to.
+func validWindow(window string) bool {
+	for _, kind := range []string{WindowX11, WindowWayland} {
+		if id := strings.TrimPrefix(window, kind); id != window {
+			return id != "" && !strings.ContainsAny(id, " \t\n")
+		}
+	}
+	return false
+}
+
+// watchWindow starts following ld's window, dropping ld once it closes. It must be called on the actor.
+func (b *Bridge) watchWindow(ld *lockDetails) error {
+	stop, err := b.opts.Windows.Watch(ld.window, func() {
+		b.do("WindowClosed", func() {
+			// The lock may have been released in the meantime.
+			if b.locks[ld.key()] != ld {
+				return
+			}
+			b.log.Debugf("Window %s closed; Dropping: %s\n", ld.window, ld)
+			if err := b.dropLock(ld, "window closed"); err != nil {
+				b.errLog.log("%v\n", err)
+			}
+			b.metrics.addFor(metricLocksReaped, ld.id)
+		})
+	})
+	if err != nil {
+		return err
+	}
+	ld.unwatch = stop
+	return nil
+}
+
+// InhibitWindow is org.freedesktop.ScreenSaver.Inhibit for a lock bound to a toplevel window, given like
+// xdg-desktop-portal's parent_window: "x11:XID", or "wayland:HANDLE" with an xdg-foreign handle. The lock stays held
+// after the caller leaves the bus, until the window closes or the lock is released (see Release), so that neither a
+// script that exits right away nor an application that crashes leaves it behind.
+func (c *controlAPI) InhibitWindow(from dbus.Sender, who, why, window string) (cookie uint32, err *dbus.Error) {
+	defer c.b.recoverPanic("InhibitWindow", &err)
+
+	if c.b.opts.Windows == nil {
+		return 0, newError(ErrorNotSupported, "window locks are disabled")
+	}
+	if !validWindow(window) {
+		return 0, newError(ErrorInvalidArgs, "invalid window %q, want \"%sXID\" or \"%sHANDLE\"", window, WindowX11, WindowWayland)
+	}
+	ck, err := c.b.take(from, who, why, lockRequest{window: window})
+	if err != nil {
+		return 0, err
+	}
+
+	return uint32(ck), nil
+}
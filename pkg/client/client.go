@@ -0,0 +1,274 @@
+// Package client takes org.freedesktop.ScreenSaver inhibits for Go programs. It works against any implementation of
+// the interface, inhibitor or a desktop environment's own, and keeps locks held across a lost bus connection or a
+// restart of the implementation by taking them again.
+//
+//	c, err := client.New(ctx, client.Options{})
+//	...
+//	l, err := c.Inhibit(ctx, "myapp", "Playing video") // released when ctx is done
+//	...
+//	l.Release()
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+)
+
+const (
+	screensaver     = "org.freedesktop.ScreenSaver"
+	screensaverPath = "/org/freedesktop/ScreenSaver"
+	busIface        = "org.freedesktop.DBus"
+)
+
+// ErrClosed is returned by Inhibit on a closed Client.
+var ErrClosed = errors.New("client closed")
+
+// Options configure a Client.
+type Options struct {
+	// Connect opens the bus connection, and opens it again after it is lost. nil uses dbus.ConnectSessionBus. The
+	// Client takes ownership of the returned connections.
+	Connect func() (*dbus.Conn, error)
+	// Retry is how long to wait between attempts to reconnect. 0 means 5s.
+	Retry time.Duration
+}
+
+// Client takes inhibits and keeps them held. It is safe for concurrent use.
+type Client struct {
+	opts   Options
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	mtx   sync.Mutex
+	conn  *dbus.Conn         // nil while reconnecting
+	owner string             // the unique name owning the service, as last seen
+	locks map[*Lock]struct{} // guarded by mtx
+}
+
+// Lock is a held inhibit. Its cookie may change as the Client takes it again.
+type Lock struct {
+	c        *Client
+	who, why string
+	cookie   uint32 // 0 while not held; guarded by c.mtx
+	owner    string // the unique name holding the lock; guarded by c.mtx
+	released chan struct{}
+}
+
+// New connects to the bus. The Client keeps reconnecting until ctx is done or it is closed.
+func New(ctx context.Context, opts Options) (*Client, error) {
+	if opts.Connect == nil {
+		opts.Connect = func() (*dbus.Conn, error) { return dbus.ConnectSessionBus() }
+	}
+	if opts.Retry <= 0 {
+		opts.Retry = 5 * time.Second
+	}
+	conn, err := opts.Connect()
+	if err != nil {
+		return nil, fmt.Errorf("bus connect failed: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	c := &Client{opts: opts, ctx: ctx, cancel: cancel, done: make(chan struct{}), locks: make(map[*Lock]struct{})}
+	signals, err := c.attach(conn)
+	if err != nil {
+		conn.Close()
+		cancel()
+		return nil, err
+	}
+	go c.run(conn, signals)
+	return c, nil
+}
+
+// attach makes conn the Client's connection, watching the service change owners on it.
+func (c *Client) attach(conn *dbus.Conn) (<-chan *dbus.Signal, error) {
+	if err := conn.AddMatchSignal(dbus.WithMatchInterface(busIface), dbus.WithMatchMember("NameOwnerChanged"), dbus.WithMatchArg(0, screensaver)); err != nil {
+		return nil, fmt.Errorf("watching %s: %v", screensaver, err)
+	}
+	signals := make(chan *dbus.Signal, 8)
+	conn.Signal(signals)
+
+	var owner string
+	// No owner yet is fine: locks are taken once one shows up.
+	conn.BusObject().CallWithContext(c.ctx, busIface+".GetNameOwner", 0, screensaver).Store(&owner)
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.conn, c.owner = conn, owner
+	return signals, nil
+}
+
+// run takes the locks again whenever the connection is lost or the service changes owners, until the Client is done.
+func (c *Client) run(conn *dbus.Conn, signals <-chan *dbus.Signal) {
+	defer close(c.done)
+	for {
+		select {
+		case <-c.ctx.Done():
+			conn.Close()
+			return
+		case sig := <-signals:
+			if len(sig.Body) != 3 {
+				continue
+			}
+			if owner, _ := sig.Body[2].(string); owner != "" {
+				c.mtx.Lock()
+				if owner != c.owner {
+					// Whoever owned the name before took our locks with it.
+					c.owner = owner
+					c.reinhibit()
+				}
+				c.mtx.Unlock()
+			}
+		case <-conn.Context().Done():
+			// Locks die with the connection they were taken on.
+			c.mtx.Lock()
+			c.conn = nil
+			for l := range c.locks {
+				l.cookie = 0
+			}
+			c.mtx.Unlock()
+
+			var err error
+			if conn, signals, err = c.reconnect(); err != nil {
+				return
+			}
+			c.mtx.Lock()
+			c.reinhibit()
+			c.mtx.Unlock()
+		}
+	}
+}
+
+// reconnect opens a new connection, retrying every Options.Retry until the Client is done.
+func (c *Client) reconnect() (*dbus.Conn, <-chan *dbus.Signal, error) {
+	t := time.NewTicker(c.opts.Retry)
+	defer t.Stop()
+	for {
+		select {
+		case <-c.ctx.Done():
+			return nil, nil, c.ctx.Err()
+		case <-t.C:
+		}
+		conn, err := c.opts.Connect()
+		if err != nil {
+			continue
+		}
+		signals, err := c.attach(conn)
+		if err != nil {
+			conn.Close()
+			continue
+		}
+		return conn, signals, nil
+	}
+}
+
+// reinhibit takes every lock not held by the current owner again. A lock that can't be taken now is retried on the
+// next reconnect or owner change. It must be called with mtx held.
+func (c *Client) reinhibit() {
+	for l := range c.locks {
+		if l.cookie != 0 && l.owner == c.owner {
+			continue
+		}
+		if l.cookie != 0 && c.conn != nil {
+			// A successor that took the locks over, as inhibitor's --takeover and hot upgrades do, still holds the old
+			// cookie; anyone else just refuses it.
+			c.conn.Object(c.owner, screensaverPath).CallWithContext(c.ctx, screensaver+".UnInhibit", 0, l.cookie)
+		}
+		l.cookie = 0
+		if cookie, owner, err := c.inhibit(l.who, l.why); err == nil {
+			l.cookie, l.owner = cookie, owner
+		}
+	}
+}
+
+// inhibit calls Inhibit on the current owner of the service and returns the cookie along with that owner. Calling
+// the owner's unique name rather than the service makes sure the lock is released with whoever granted it. It must be
+// called with mtx held.
+func (c *Client) inhibit(who, why string) (cookie uint32, owner string, err error) {
+	if c.conn == nil {
+		return 0, "", errors.New("not connected")
+	}
+	if err := c.conn.BusObject().CallWithContext(c.ctx, busIface+".GetNameOwner", 0, screensaver).Store(&owner); err != nil {
+		return 0, "", err
+	}
+	if err := c.conn.Object(owner, screensaverPath).CallWithContext(c.ctx, screensaver+".Inhibit", 0, who, why).Store(&cookie); err != nil {
+		return 0, "", err
+	}
+	return cookie, owner, nil
+}
+
+// Inhibit takes a lock for who and why. The lock is held until it is released, ctx is done or the Client is closed.
+func (c *Client) Inhibit(ctx context.Context, who, why string) (*Lock, error) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if c.ctx.Err() != nil {
+		return nil, ErrClosed
+	}
+
+	cookie, owner, err := c.inhibit(who, why)
+	if err != nil {
+		return nil, err
+	}
+	l := &Lock{c: c, who: who, why: why, cookie: cookie, owner: owner, released: make(chan struct{})}
+	c.locks[l] = struct{}{}
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			l.Release()
+		case <-l.released:
+		}
+	}()
+	return l, nil
+}
+
+// Held reports whether the lock is currently held. It isn't while the Client reconnects, or if taking it again
+// failed.
+func (l *Lock) Held() bool {
+	l.c.mtx.Lock()
+	defer l.c.mtx.Unlock()
+	return l.cookie != 0
+}
+
+// Release releases the lock. Releasing it again does nothing.
+func (l *Lock) Release() error {
+	c := l.c
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if _, ok := c.locks[l]; !ok {
+		return nil
+	}
+	delete(c.locks, l)
+	close(l.released)
+
+	if l.cookie == 0 || c.conn == nil {
+		return nil
+	}
+	cookie := l.cookie
+	l.cookie = 0
+	return c.conn.Object(l.owner, screensaverPath).Call(screensaver+".UnInhibit", 0, cookie).Err
+}
+
+// Close releases every lock and disconnects.
+func (c *Client) Close() error {
+	c.mtx.Lock()
+	var locks []*Lock
+	for l := range c.locks {
+		locks = append(locks, l)
+	}
+	c.mtx.Unlock()
+
+	var err error
+	for _, l := range locks {
+		if e := l.Release(); e != nil && err == nil {
+			err = e
+		}
+	}
+	c.cancel()
+	<-c.done
+	return err
+}
@@ -0,0 +1,84 @@
+package policy
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// The failures a Fault can simulate, each answered with the D-Bus error a bridge returns when it happens for real.
+const (
+	// FaultDenied is a caller that couldn't be identified or isn't allowed the lock.
+	FaultDenied = "denied"
+	// FaultLimit is a caller over its lock limit.
+	FaultLimit = "limit"
+	// FaultUnavailable is the backend failing to take the lock.
+	FaultUnavailable = "unavailable"
+	// FaultBackendDown is the circuit breaker open after the backend kept failing.
+	FaultBackendDown = "backend_down"
+	// FaultBusy is the request queue full.
+	FaultBusy = "busy"
+	// FaultInternal is the bridge itself failing.
+	FaultInternal = "internal"
+)
+
+// FaultErrors are the failures a Fault can simulate, in order.
+var FaultErrors = []string{FaultDenied, FaultLimit, FaultUnavailable, FaultBackendDown, FaultBusy, FaultInternal}
+
+// Fault makes a bridge fail or hold up the lock requests it matches on purpose, so that an application's developer
+// can try out its error handling against a ScreenSaver implementation they control. Faults are meant for developer
+// mode only: a matching request is refused whatever the backend would have said.
+type Fault struct {
+	// Who and Why match case-insensitive substrings of a request's who and why, as they arrive. Empty matches
+	// anything.
+	Who string `json:"who,omitempty"`
+	Why string `json:"why,omitempty"`
+	// Error is the failure to answer matching requests with, one of FaultErrors. Empty lets them through, after
+	// Delay.
+	Error string `json:"error,omitempty"`
+	// Delay, a Go duration such as "3s", holds matching requests up that long before they are answered. Longer than
+	// the caller's D-Bus timeout, usually 25s, it times out instead.
+	Delay string `json:"delay,omitempty"`
+	// Rate is the share of matching requests the fault hits, picked at random, from 0 to 1. 0 hits them all.
+	Rate float64 `json:"rate,omitempty"`
+}
+
+// DelayFor returns f.Delay parsed, or 0 if requests aren't held up.
+func (f Fault) DelayFor() (time.Duration, error) {
+	if f.Delay == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(f.Delay)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid delay %q: want a duration such as \"3s\"", f.Delay)
+	}
+	return d, nil
+}
+
+// Validate checks that f names a known failure, a valid delay and rate, and does something.
+func (f Fault) Validate() error {
+	if f.Error == "" && f.Delay == "" {
+		return fmt.Errorf("fault for who %q, why %q neither fails nor delays requests", f.Who, f.Why)
+	}
+	if f.Error != "" {
+		known := false
+		for _, e := range FaultErrors {
+			known = known || e == f.Error
+		}
+		if !known {
+			return fmt.Errorf("fault for who %q, why %q: unknown error %q; want one of %s", f.Who, f.Why, f.Error, strings.Join(FaultErrors, ", "))
+		}
+	}
+	if _, err := f.DelayFor(); err != nil {
+		return fmt.Errorf("fault for who %q, why %q: %v", f.Who, f.Why, err)
+	}
+	if f.Rate < 0 || f.Rate > 1 {
+		return fmt.Errorf("fault for who %q, why %q: invalid rate %g, want 0 to 1", f.Who, f.Why, f.Rate)
+	}
+	return nil
+}
+
+// Matches reports whether f applies to a request from who for why.
+func (f Fault) Matches(who, why string) bool {
+	return containsFold(who, f.Who) && containsFold(why, f.Why)
+}
@@ -0,0 +1,34 @@
+package policy
+
+import (
+	"strings"
+	"testing"
+	"unicode"
+	"unicode/utf8"
+)
+
+func FuzzSanitize(f *testing.F) {
+	f.Add("firefox")
+	f.Add("video-playing\n\x1b[31mspoofed log line")
+	f.Add("\xff\xfe\x00")
+	f.Add(strings.Repeat("é", MaxArgLen))
+
+	f.Fuzz(func(t *testing.T, s string) {
+		got := Sanitize(s)
+		if !utf8.ValidString(got) {
+			t.Errorf("Sanitize(%q) = %q, which isn't valid UTF-8", s, got)
+		}
+		if len(got) > MaxArgLen {
+			t.Errorf("Sanitize(%q) is %d bytes long, want at most %d", s, len(got), MaxArgLen)
+		}
+		for _, r := range got {
+			if unicode.IsControl(r) {
+				t.Errorf("Sanitize(%q) = %q, which holds the control character %U", s, got, r)
+				break
+			}
+		}
+		if again := Sanitize(got); again != got {
+			t.Errorf("Sanitize(%q) = %q, but Sanitize of that = %q", s, got, again)
+		}
+	})
+}
@@ -0,0 +1,124 @@
+// Package policy decides which inhibit requests a bridge accepts and how their arguments are treated.
+package policy
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/godbus/dbus/v5"
+)
+
+// MaxArgLen is the longest who/why passed on to the backend or written to logs.
+const MaxArgLen = 256
+
+const errInvalidArgs = "org.freedesktop.DBus.Error.InvalidArgs"
+
+// OwnerChange is what to do with a lock when a well-known name its peer held moves to a different connection.
+type OwnerChange string
+
+const (
+	// OwnerChangeKeep leaves the lock in place; the event is only logged.
+	OwnerChangeKeep OwnerChange = "keep"
+	// OwnerChangeRelease drops the lock.
+	OwnerChangeRelease OwnerChange = "release"
+)
+
+// ParseOwnerChange validates an owner change policy name.
+func ParseOwnerChange(s string) (OwnerChange, error) {
+	switch oc := OwnerChange(s); oc {
+	case OwnerChangeKeep, OwnerChangeRelease:
+		return oc, nil
+	}
+	return "", fmt.Errorf("invalid owner change policy %q; must be %q or %q", s, OwnerChangeKeep, OwnerChangeRelease)
+}
+
+// Policy holds the rules a bridge applies to incoming requests.
+type Policy struct {
+	// Strict rejects calls that don't follow the org.freedesktop.ScreenSaver spec to the letter: empty arguments,
+	// unexpected object paths or interfaces, and mismatched signatures.
+	Strict bool
+	// OwnerChange decides the fate of a lock whose peer loses a well-known name it held to another connection.
+	OwnerChange OwnerChange
+	// What are the logind what-classes every lock takes. Defaults to WhatIdle.
+	What []string
+	// Rules add what-classes to matching requests. A bridge can replace them at runtime.
+	Rules []Rule
+	// Rewrites tidy up the who and why of requests before anything else sees them, rules included. A bridge can
+	// replace them at runtime.
+	Rewrites []Rewrite
+	// DefaultReasons fill in the why of requests that came without one, after Rewrites. A bridge can replace them at
+	// runtime.
+	DefaultReasons []DefaultReason
+	// Profiles are the named sets of inhibits the bridge can hold itself (see Profile). A bridge can replace them at
+	// runtime.
+	Profiles []Profile
+	// Quirks are the workarounds applied to the locks of each browser family (see Quirks), by family. Families left
+	// out get those of DefaultQuirks. A bridge can replace them at runtime.
+	Quirks map[string]Quirks
+	// Faults fail or hold up matching lock requests on purpose, for developers testing their applications (see
+	// Fault). The first matching one applies. A bridge can replace them at runtime.
+	Faults []Fault
+}
+
+// Default returns the lenient policy a bridge uses unless told otherwise.
+func Default() *Policy {
+	return &Policy{OwnerChange: OwnerChangeKeep}
+}
+
+// InvalidArgs returns an org.freedesktop.DBus.Error.InvalidArgs error with a descriptive message.
+func InvalidArgs(format string, args ...interface{}) *dbus.Error {
+	return &dbus.Error{Name: errInvalidArgs, Body: []interface{}{fmt.Sprintf(format, args...)}}
+}
+
+// CheckCall validates the headers of an incoming call when running strictly: it must be addressed to path and
+// iface and carry exactly signature. Lenient policies accept everything godbus could decode, including Firefox's
+// legacy path.
+func (p *Policy) CheckCall(msg dbus.Message, path dbus.ObjectPath, iface, signature string) *dbus.Error {
+	if !p.Strict {
+		return nil
+	}
+
+	member, _ := msg.Headers[dbus.FieldMember].Value().(string)
+	if got, _ := msg.Headers[dbus.FieldPath].Value().(dbus.ObjectPath); got != path {
+		return InvalidArgs("%s called on %q; the spec only defines %q", member, got, path)
+	}
+	if got, _ := msg.Headers[dbus.FieldInterface].Value().(string); got != iface {
+		return InvalidArgs("%s called on interface %q; expected %q", member, got, iface)
+	}
+	if sig, _ := msg.Headers[dbus.FieldSignature].Value().(dbus.Signature); sig.String() != signature {
+		return InvalidArgs("%s called with signature %q; expected %q", member, sig.String(), signature)
+	}
+
+	return nil
+}
+
+// CheckArgs validates the application name and reason of an Inhibit request when running strictly.
+func (p *Policy) CheckArgs(who, why string) *dbus.Error {
+	if p.Strict && (who == "" || why == "") {
+		return InvalidArgs("Inhibit requires a non-empty application_name and reason_for_inhibit")
+	}
+	return nil
+}
+
+// Sanitize strips control characters from a peer-supplied string and bounds its length, so arbitrary input can't
+// mangle logs or be rejected by the backend.
+func Sanitize(s string) string {
+	s = strings.Map(func(r rune) rune {
+		if r == utf8.RuneError || unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, s)
+
+	if len(s) > MaxArgLen {
+		cut := MaxArgLen
+		for cut > 0 && !utf8.RuneStart(s[cut]) {
+			cut--
+		}
+		s = s[:cut]
+	}
+
+	return s
+}
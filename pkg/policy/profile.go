@@ -0,0 +1,72 @@
+package policy
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Profile is a named set of inhibits the bridge holds itself while the profile is active, such as "presentation" for
+// idle, sleep and the lid switch, or "server-mode" for sleep and shutdown, so that a hotkey or script can switch a
+// whole situation on and off rather than take the locks one by one.
+type Profile struct {
+	Name string `json:"name"`
+	// What are the what-classes the profile's lock takes.
+	What []string `json:"what"`
+	// Why is the why of the profile's lock. Empty uses the name.
+	Why string `json:"why,omitempty"`
+	// Mode "delay" only delays sleep and shutdown rather than blocking them, like a rule's.
+	Mode string `json:"mode,omitempty"`
+	// Expire is how long, as a Go duration such as "2h", the profile stays active unless its activation says
+	// otherwise. Empty keeps it active until it is deactivated.
+	Expire string `json:"expire,omitempty"`
+}
+
+// Validate checks that p is named and only takes known what-classes.
+func (p Profile) Validate() error {
+	if p.Name == "" || strings.ContainsAny(p.Name, " \t\n") {
+		return fmt.Errorf("invalid profile name %q", p.Name)
+	}
+	if len(p.What) == 0 {
+		return fmt.Errorf("profile %q takes no what-class", p.Name)
+	}
+	for _, w := range p.What {
+		if !whatClasses[w] {
+			return fmt.Errorf("profile %q: invalid what-class %q", p.Name, w)
+		}
+	}
+	if p.Mode != "" && p.Mode != "delay" {
+		return fmt.Errorf("profile %q: invalid mode %q, want \"delay\"", p.Name, p.Mode)
+	}
+	if _, err := p.ExpireAfter(); err != nil {
+		return fmt.Errorf("profile %q: %v", p.Name, err)
+	}
+	return nil
+}
+
+// ExpireAfter returns p.Expire parsed, or 0 if p stays active until deactivated.
+func (p Profile) ExpireAfter() (time.Duration, error) {
+	if p.Expire == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(p.Expire)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid expire %q: want a duration such as \"2h\"", p.Expire)
+	}
+	return d, nil
+}
+
+// ValidateProfiles checks each of profiles and that no two share a name.
+func ValidateProfiles(profiles []Profile) error {
+	seen := make(map[string]bool)
+	for _, p := range profiles {
+		if err := p.Validate(); err != nil {
+			return err
+		}
+		if seen[p.Name] {
+			return fmt.Errorf("profile %q is defined twice", p.Name)
+		}
+		seen[p.Name] = true
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+package policy
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// The browser families Quirks are kept for.
+const (
+	// BrowserFirefox is Firefox and its forks, such as LibreWolf.
+	BrowserFirefox = "firefox"
+	// BrowserChromium is Chromium and the browsers built on it, such as Chrome, Brave and Vivaldi.
+	BrowserChromium = "chromium"
+)
+
+// browserNames are the lowercase substrings of a who or Flatpak application ID that identify each browser family.
+// Flatpak IDs such as "org.mozilla.firefox", "com.google.Chrome" and "com.brave.Browser" are covered, so a sandboxed
+// browser is recognized whether it calls directly or through the portal, which passes its ID on as the who.
+var browserNames = []struct{ substr, browser string }{
+	{"firefox", BrowserFirefox},
+	{"librewolf", BrowserFirefox},
+	{"waterfox", BrowserFirefox},
+	{"chrom", BrowserChromium},
+	{"brave", BrowserChromium},
+	{"vivaldi", BrowserChromium},
+	{"microsoft-edge", BrowserChromium},
+}
+
+// Browser returns the browser family of a request from the Flatpak application app, if known, or else who, or
+// empty if it isn't a browser.
+func Browser(app, who string) string {
+	for _, s := range []string{app, who} {
+		s = strings.ToLower(s)
+		for _, n := range browserNames {
+			if s != "" && strings.Contains(s, n.substr) {
+				return n.browser
+			}
+		}
+	}
+	return ""
+}
+
+// Quirks are the workarounds a bridge applies to one browser family's locks. Browsers are by far the busiest
+// clients, and each has its habits:
+//
+//   - Firefox calls org.freedesktop.ScreenSaver on /ScreenSaver rather than /org/freedesktop/ScreenSaver. That
+//     path is served unless the legacy_path compat toggle turns it off, so it needs no quirk here.
+//   - Both take a lock per tab or media element, with the same why ("video-playing", "Playing audio"), so a page
+//     of muted autoplay videos can hold a dozen identical logind inhibits. DedupeTabs has them share one.
+//   - Both sometimes leave a tab's lock behind when the tab is closed while playing, and since the browser stays on
+//     the bus, it is held until the browser exits. MaxHold puts a bound on that.
+//   - Flatpak'd browsers call with their application ID as the who, or through xdg-desktop-portal, which does the
+//     same. Browser recognizes those names too, and Options.DedupePortal in the bridge folds a portal lock into the
+//     browser's own.
+type Quirks struct {
+	// DedupeTabs shares one backend inhibit among the locks one browser connection holds with the same why and
+	// what-classes. Each keeps its cookie, and the inhibit is given back once the last is released.
+	DedupeTabs bool `json:"dedupe_tabs"`
+	// MaxHold, a Go duration such as "4h", releases the browser's locks once they have been held that long, as
+	// a tab closed without UnInhibit would otherwise hold its lock until the browser exits. A tab still playing
+	// will have to take its lock again, which browsers only do on their next change of state, so keep it longer than
+	// the longest video. Empty never releases them.
+	MaxHold string `json:"max_hold,omitempty"`
+}
+
+// DefaultQuirks returns the quirks of every browser family when the config doesn't set them: identical tab locks
+// are shared, and none is released for being held too long.
+func DefaultQuirks() map[string]Quirks {
+	return map[string]Quirks{
+		BrowserFirefox:  {DedupeTabs: true},
+		BrowserChromium: {DedupeTabs: true},
+	}
+}
+
+// MaxHoldAfter returns q.MaxHold parsed, or 0 if locks are never released for their age.
+func (q Quirks) MaxHoldAfter() (time.Duration, error) {
+	if q.MaxHold == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(q.MaxHold)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid max_hold %q: want a duration such as \"4h\"", q.MaxHold)
+	}
+	return d, nil
+}
+
+// ValidateQuirks checks that quirks, by browser family, only name known families and durations.
+func ValidateQuirks(quirks map[string]Quirks) error {
+	for browser, q := range quirks {
+		if browser != BrowserFirefox && browser != BrowserChromium {
+			return fmt.Errorf("unknown browser %q in quirks; want %q or %q", browser, BrowserFirefox, BrowserChromium)
+		}
+		if _, err := q.MaxHoldAfter(); err != nil {
+			return fmt.Errorf("%s quirks: %v", browser, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package policy
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBrowser(t *testing.T) {
+	for _, tc := range []struct {
+		app, who string
+		want     string
+	}{
+		{"", "Firefox", BrowserFirefox},
+		{"", "firefox-esr", BrowserFirefox},
+		{"", "LibreWolf", BrowserFirefox},
+		{"", "Waterfox", BrowserFirefox},
+		{"", "Chromium", BrowserChromium},
+		{"", "Google Chrome", BrowserChromium},
+		{"", "brave-browser", BrowserChromium},
+		{"", "Vivaldi", BrowserChromium},
+		{"", "microsoft-edge", BrowserChromium},
+		// Flatpak'd browsers, by application ID, directly or as the who the portal passes on.
+		{"org.mozilla.firefox", "", BrowserFirefox},
+		{"", "org.mozilla.firefox", BrowserFirefox},
+		{"io.gitlab.librewolf-community", "", BrowserFirefox},
+		{"com.google.Chrome", "", BrowserChromium},
+		{"org.chromium.Chromium", "", BrowserChromium},
+		{"com.brave.Browser", "", BrowserChromium},
+		{"com.microsoft.Edge", "microsoft-edge", BrowserChromium},
+		// The application ID wins over the who.
+		{"org.mozilla.firefox", "chromium", BrowserFirefox},
+		{"org.videolan.VLC", "firefox", BrowserFirefox},
+		{"", "vlc", ""},
+		{"org.videolan.VLC", "vlc", ""},
+		{"", "", ""},
+	} {
+		if got := Browser(tc.app, tc.who); got != tc.want {
+			t.Errorf("Browser(%q, %q) = %q, want %q", tc.app, tc.who, got, tc.want)
+		}
+	}
+}
+
+func TestMaxHoldAfter(t *testing.T) {
+	for _, tc := range []struct {
+		maxHold string
+		want    time.Duration
+		wantErr bool
+	}{
+		{"", 0, false},
+		{"4h", 4 * time.Hour, false},
+		{"90m", 90 * time.Minute, false},
+		{"0s", 0, true},
+		{"-1h", 0, true},
+		{"4", 0, true},
+		{"forever", 0, true},
+	} {
+		got, err := Quirks{MaxHold: tc.maxHold}.MaxHoldAfter()
+		if gotErr := err != nil; gotErr != tc.wantErr || got != tc.want {
+			t.Errorf("MaxHoldAfter(%q) = %s, %v; want %s, error: %t", tc.maxHold, got, err, tc.want, tc.wantErr)
+		}
+	}
+}
+
+func TestValidateQuirks(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		quirks  map[string]Quirks
+		wantErr bool
+	}{
+		{"none", nil, false},
+		{"defaults", DefaultQuirks(), false},
+		{"max hold", map[string]Quirks{BrowserFirefox: {MaxHold: "4h"}, BrowserChromium: {DedupeTabs: true, MaxHold: "2h"}}, false},
+		{"unknown browser", map[string]Quirks{"safari": {DedupeTabs: true}}, true},
+		{"bad max hold", map[string]Quirks{BrowserChromium: {MaxHold: "soon"}}, true},
+	} {
+		if err := ValidateQuirks(tc.quirks); (err != nil) != tc.wantErr {
+			t.Errorf("ValidateQuirks(%s) = %v, want error: %t", tc.name, err, tc.wantErr)
+		}
+	}
+}
+
+func TestDefaultQuirks(t *testing.T) {
+	for _, browser := range []string{BrowserFirefox, BrowserChromium} {
+		q, ok := DefaultQuirks()[browser]
+		if !ok || !q.DedupeTabs || q.MaxHold != "" {
+			t.Errorf("DefaultQuirks()[%q] = %+v, want tabs deduped and no max_hold", browser, q)
+		}
+	}
+}
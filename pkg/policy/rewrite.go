@@ -0,0 +1,69 @@
+package policy
+
+import "fmt"
+
+// Rewrite replaces the who or why of the requests it matches, so that the many names one application goes by, such
+// as "Mozilla Firefox", "firefox-esr" and "org.mozilla.firefox", show up as one in logs, metrics and rules.
+type Rewrite struct {
+	// Who and Why match case-insensitive substrings of a request's who and why. Empty matches anything.
+	Who string `json:"who,omitempty"`
+	Why string `json:"why,omitempty"`
+	// SetWho and SetWhy replace the who and why of matching requests. Empty leaves them alone.
+	SetWho string `json:"set_who,omitempty"`
+	SetWhy string `json:"set_why,omitempty"`
+}
+
+// Validate checks that r rewrites something.
+func (r Rewrite) Validate() error {
+	if r.SetWho == "" && r.SetWhy == "" {
+		return fmt.Errorf("rewrite for who %q, why %q sets neither set_who nor set_why", r.Who, r.Why)
+	}
+	return nil
+}
+
+// Apply rewrites who and why by every matching rewrite, in order. Each sees the result of those before it.
+func Apply(rewrites []Rewrite, who, why string) (string, string) {
+	for _, r := range rewrites {
+		if !containsFold(who, r.Who) || !containsFold(why, r.Why) {
+			continue
+		}
+		if r.SetWho != "" {
+			who = Sanitize(r.SetWho)
+		}
+		if r.SetWhy != "" {
+			why = Sanitize(r.SetWhy)
+		}
+	}
+	return who, why
+}
+
+// DefaultReason gives the requests of matching applications that came without a why one, so that logind's list of
+// inhibitors, logs and notifications say what the lock is for rather than nothing.
+type DefaultReason struct {
+	// Who matches a case-insensitive substring of a request's who. Empty matches anything.
+	Who string `json:"who,omitempty"`
+	// Why is the reason to give.
+	Why string `json:"why"`
+}
+
+// Validate checks that r gives a reason.
+func (r DefaultReason) Validate() error {
+	if Sanitize(r.Why) == "" {
+		return fmt.Errorf("default reason for who %q has no why", r.Who)
+	}
+	return nil
+}
+
+// DefaultWhy returns why, or if it is empty, the reason of the first default that matches who. It stays empty if
+// none does.
+func DefaultWhy(defaults []DefaultReason, who, why string) string {
+	if why != "" {
+		return why
+	}
+	for _, r := range defaults {
+		if containsFold(who, r.Who) {
+			return Sanitize(r.Why)
+		}
+	}
+	return why
+}
@@ -0,0 +1,168 @@
+package policy
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// WhatIdle is the logind what-class a lock takes unless configured otherwise: it stops the screen from blanking and
+// locking, but not the machine from suspending.
+const WhatIdle = "idle"
+
+// WhatShutdown is the logind what-class that holds off shutdowns and reboots.
+const WhatShutdown = "shutdown"
+
+// whatClasses are the what-classes logind accepts.
+var whatClasses = map[string]bool{
+	"idle":                 true,
+	"sleep":                true,
+	"shutdown":             true,
+	"handle-power-key":     true,
+	"handle-suspend-key":   true,
+	"handle-hibernate-key": true,
+	"handle-lid-switch":    true,
+}
+
+// Classes returns the what-classes logind accepts, sorted.
+func Classes() []string {
+	var ws []string
+	for w := range whatClasses {
+		ws = append(ws, w)
+	}
+	sort.Strings(ws)
+	return ws
+}
+
+// ParseWhat validates a colon-separated list of logind what-classes, such as "idle:sleep".
+func ParseWhat(s string) ([]string, error) {
+	var what []string
+	for _, w := range strings.Split(s, ":") {
+		if !whatClasses[w] {
+			return nil, fmt.Errorf("invalid what-class %q", w)
+		}
+		what = append(what, w)
+	}
+	return what, nil
+}
+
+// delayable are the what-classes logind can also take in delay mode.
+var delayable = map[string]bool{"sleep": true, WhatShutdown: true}
+
+// Delayable reports whether logind can take the what-class w in delay mode as well as block mode.
+func Delayable(w string) bool {
+	return delayable[w]
+}
+
+// SplitDelay splits colon-separated what-classes into those logind can only block and those it can also delay, e.g.
+// "idle:sleep" into "idle" and "sleep". Either may be empty.
+func SplitDelay(what string) (block, delay string) {
+	var b, d []string
+	for _, w := range strings.Split(what, ":") {
+		if delayable[w] {
+			d = append(d, w)
+		} else if w != "" {
+			b = append(b, w)
+		}
+	}
+	return strings.Join(b, ":"), strings.Join(d, ":")
+}
+
+// WithoutSleep removes sleep from colon-separated what-classes, falling back to WhatIdle if nothing else is left.
+func WithoutSleep(what string) string {
+	var ws []string
+	for _, w := range strings.Split(what, ":") {
+		if w != "sleep" && w != "" {
+			ws = append(ws, w)
+		}
+	}
+	if len(ws) == 0 {
+		return WhatIdle
+	}
+	return strings.Join(ws, ":")
+}
+
+// Rule adds logind what-classes to the locks of requests it matches, e.g. sleep for video players.
+type Rule struct {
+	// Who and Why match case-insensitive substrings of a request's who and why. Empty matches anything.
+	Who string `json:"who,omitempty"`
+	Why string `json:"why,omitempty"`
+	// What are the what-classes to add.
+	What []string `json:"what,omitempty"`
+	// NoLock also keeps the session from locking, for lockers that don't go by idle inhibits alone.
+	NoLock bool `json:"no_lock,omitempty"`
+	// Mode "delay" only ever gives matching requests delay-mode sleep and shutdown inhibits, so the application can
+	// hold a suspend or shutdown off for logind's InhibitDelayMaxSec but not veto it. Other classes stay blocked.
+	Mode string `json:"mode,omitempty"`
+}
+
+// Validate checks that r only names known what-classes.
+func (r Rule) Validate() error {
+	if len(r.What) == 0 && !r.NoLock && r.Mode == "" {
+		return fmt.Errorf("rule for who %q, why %q adds no what-class", r.Who, r.Why)
+	}
+	if r.Mode != "" && r.Mode != "delay" {
+		return fmt.Errorf("rule for who %q, why %q: invalid mode %q, want \"delay\"", r.Who, r.Why, r.Mode)
+	}
+	for _, w := range r.What {
+		if !whatClasses[w] {
+			return fmt.Errorf("rule for who %q, why %q: invalid what-class %q", r.Who, r.Why, w)
+		}
+	}
+	return nil
+}
+
+// Matches reports whether r applies to a request from who for why.
+func (r Rule) Matches(who, why string) bool {
+	return containsFold(who, r.Who) && containsFold(why, r.Why)
+}
+
+// What returns the what-classes, in logind's colon-separated form, a lock for who/why takes: base plus those of every
+// matching rule.
+func What(base []string, rules []Rule, who, why string) string {
+	seen := make(map[string]bool)
+	var what []string
+	add := func(ws []string) {
+		for _, w := range ws {
+			if !seen[w] {
+				seen[w] = true
+				what = append(what, w)
+			}
+		}
+	}
+
+	add(base)
+	for _, r := range rules {
+		if r.Matches(who, why) {
+			add(r.What)
+		}
+	}
+	if len(what) == 0 {
+		return WhatIdle
+	}
+	return strings.Join(what, ":")
+}
+
+// NoLock reports whether a matching rule keeps the session from locking while who holds a lock for why.
+func NoLock(rules []Rule, who, why string) bool {
+	for _, r := range rules {
+		if r.NoLock && r.Matches(who, why) {
+			return true
+		}
+	}
+	return false
+}
+
+// Delay reports whether a matching rule restricts who's locks for why to delay mode.
+func Delay(rules []Rule, who, why string) bool {
+	for _, r := range rules {
+		if r.Mode == "delay" && r.Matches(who, why) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsFold(s, substr string) bool {
+	return strings.Contains(strings.ToLower(s), strings.ToLower(substr))
+}
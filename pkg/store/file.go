@@ -0,0 +1,106 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// CorruptError is returned by OpenFile, with an empty store, when the file's content can't be read back, most likely
+// because a crash cut a write short. Whether to carry on afresh is up to the caller.
+type CorruptError struct {
+	Path string
+	Err  error
+}
+
+func (e *CorruptError) Error() string {
+	return fmt.Sprintf("parsing %q: %v", e.Path, e.Err)
+}
+
+func (e *CorruptError) Unwrap() error {
+	return e.Err
+}
+
+// File is a Store that keeps every record in a single JSON object, one member per key. The file is rewritten in place
+// on every change, since a sandboxed daemon can't replace it, and created by OpenFile if it doesn't exist yet, as the
+// sandbox only allows writing files that are there when it is applied.
+type File struct {
+	mtx     sync.Mutex
+	path    string
+	records map[string]json.RawMessage
+}
+
+// OpenFile reads the store at path, creating it if it doesn't exist.
+func OpenFile(path string) (*File, error) {
+	f := &File{path: path, records: make(map[string]json.RawMessage)}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return f, f.save()
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &f.records); err != nil {
+			f.records = make(map[string]json.RawMessage)
+			return f, &CorruptError{Path: path, Err: err}
+		}
+	}
+	return f, nil
+}
+
+// Path returns the file the store is kept in.
+func (f *File) Path() string {
+	return f.path
+}
+
+// Get implements Store.
+func (f *File) Get(key string) (json.RawMessage, error) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	v, ok := f.records[key]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return append(json.RawMessage(nil), v...), nil
+}
+
+// Put implements Store.
+func (f *File) Put(key string, value json.RawMessage) error {
+	if !json.Valid(value) {
+		return fmt.Errorf("record %q isn't valid JSON", key)
+	}
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	f.records[key] = append(json.RawMessage(nil), value...)
+	return f.save()
+}
+
+// Delete implements Store.
+func (f *File) Delete(key string) error {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	if _, ok := f.records[key]; !ok {
+		return nil
+	}
+	delete(f.records, key)
+	return f.save()
+}
+
+// Close implements Store.
+func (f *File) Close() error {
+	return nil
+}
+
+// save writes every record. f.mtx must be held.
+func (f *File) save() error {
+	data, err := json.Marshal(f.records)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(f.path, append(data, '\n'), 0600)
+}
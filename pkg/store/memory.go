@@ -0,0 +1,52 @@
+package store
+
+import (
+	"encoding/json"
+	"sync"
+)
+
+// Memory is a Store that keeps its records for as long as the process runs, for embedding and tests.
+type Memory struct {
+	mtx     sync.Mutex
+	records map[string]json.RawMessage
+}
+
+// NewMemory returns an empty Memory store.
+func NewMemory() *Memory {
+	return &Memory{records: make(map[string]json.RawMessage)}
+}
+
+// Get implements Store.
+func (m *Memory) Get(key string) (json.RawMessage, error) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	v, ok := m.records[key]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return append(json.RawMessage(nil), v...), nil
+}
+
+// Put implements Store.
+func (m *Memory) Put(key string, value json.RawMessage) error {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	m.records[key] = append(json.RawMessage(nil), value...)
+	return nil
+}
+
+// Delete implements Store.
+func (m *Memory) Delete(key string) error {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	delete(m.records, key)
+	return nil
+}
+
+// Close implements Store.
+func (m *Memory) Close() error {
+	return nil
+}
@@ -0,0 +1,79 @@
+package store
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// sqliteDrivers are the database/sql driver names SQLite drivers register under, in order of preference.
+var sqliteDrivers = []string{"sqlite", "sqlite3"}
+
+// SQLite is a Store that keeps its records in a table of a SQLite database, for sharing one with other tools or when
+// the records grow past what rewriting a file on every change is good for. It goes through database/sql, so a SQLite
+// driver, such as modernc.org/sqlite or github.com/mattn/go-sqlite3, must be linked into the program with a blank
+// import.
+type SQLite struct {
+	db *sql.DB
+}
+
+// OpenSQLite opens the database at path, creating it and its records table if need be.
+func OpenSQLite(path string) (*SQLite, error) {
+	linked := make(map[string]bool)
+	for _, d := range sql.Drivers() {
+		linked[d] = true
+	}
+	driver := ""
+	for _, d := range sqliteDrivers {
+		if linked[d] {
+			driver = d
+			break
+		}
+	}
+	if driver == "" {
+		return nil, errors.New("no SQLite driver is linked into this build; use a file store instead")
+	}
+	db, err := sql.Open(driver, path)
+	if err != nil {
+		return nil, fmt.Errorf("opening %q: %v", path, err)
+	}
+	if _, err := db.Exec(`CREATE TABLE IF NOT EXISTS records (key TEXT PRIMARY KEY, value TEXT NOT NULL)`); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("creating the records table in %q: %v", path, err)
+	}
+	return &SQLite{db: db}, nil
+}
+
+// Get implements Store.
+func (s *SQLite) Get(key string) (json.RawMessage, error) {
+	var v string
+	err := s.db.QueryRow(`SELECT value FROM records WHERE key = ?`, key).Scan(&v)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return json.RawMessage(v), nil
+}
+
+// Put implements Store.
+func (s *SQLite) Put(key string, value json.RawMessage) error {
+	if !json.Valid(value) {
+		return fmt.Errorf("record %q isn't valid JSON", key)
+	}
+	_, err := s.db.Exec(`INSERT INTO records (key, value) VALUES (?, ?) ON CONFLICT (key) DO UPDATE SET value = excluded.value`, key, string(value))
+	return err
+}
+
+// Delete implements Store.
+func (s *SQLite) Delete(key string) error {
+	_, err := s.db.Exec(`DELETE FROM records WHERE key = ?`, key)
+	return err
+}
+
+// Close implements Store.
+func (s *SQLite) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,101 @@
+// Package store persists small records, such as a bridge's lock table or the daily inhibit totals, behind one
+// interface, so that the features keeping them share a single storage layer.
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// ErrNotFound is returned by Get for a key that has no record.
+var ErrNotFound = errors.New("no such record")
+
+// Store keeps records by key. Records are JSON documents, which Get and Put pass as is; GetJSON and PutJSON encode
+// them. Stores are safe for concurrent use.
+type Store interface {
+	// Get returns the record of key, or ErrNotFound.
+	Get(key string) (json.RawMessage, error)
+	// Put replaces the record of key.
+	Put(key string, value json.RawMessage) error
+	// Delete removes the record of key, if there is one.
+	Delete(key string) error
+	Close() error
+}
+
+// The kinds of store a spec names.
+const (
+	KindMemory = "memory"
+	KindFile   = "file"
+	KindSQLite = "sqlite"
+)
+
+// ParseSpec splits a store spec into its kind and path: "memory", "file:PATH", "sqlite:PATH", or a bare path, which is
+// a file.
+func ParseSpec(spec string) (kind, path string, err error) {
+	if spec == KindMemory {
+		return KindMemory, "", nil
+	}
+	kind, path, found := strings.Cut(spec, ":")
+	switch {
+	case !found:
+		kind, path = KindFile, spec
+	case kind != KindFile && kind != KindSQLite:
+		return "", "", fmt.Errorf("invalid store %q: want %q, \"file:PATH\" or \"sqlite:PATH\"", spec, KindMemory)
+	}
+	if path == "" {
+		return "", "", fmt.Errorf("invalid store %q: no path", spec)
+	}
+	return kind, path, nil
+}
+
+// Open opens the store spec names (see ParseSpec). A file store whose content is unreadable is returned empty,
+// together with a *CorruptError.
+func Open(spec string) (Store, error) {
+	kind, path, err := ParseSpec(spec)
+	if err != nil {
+		return nil, err
+	}
+	switch kind {
+	case KindMemory:
+		return NewMemory(), nil
+	case KindSQLite:
+		return OpenSQLite(path)
+	}
+	return OpenFile(path)
+}
+
+// WritePaths returns what the store spec names writes to, for a sandbox to allow: a file store's file, or the
+// directory of a SQLite database, which keeps its journal next to it.
+func WritePaths(spec string) []string {
+	switch kind, path, _ := ParseSpec(spec); kind {
+	case KindFile:
+		return []string{path}
+	case KindSQLite:
+		return []string{filepath.Dir(path)}
+	}
+	return nil
+}
+
+// GetJSON decodes the record of key into v.
+func GetJSON(s Store, key string, v interface{}) error {
+	data, err := s.Get(key)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("decoding record %q: %v", key, err)
+	}
+	return nil
+}
+
+// PutJSON encodes v as the record of key.
+func PutJSON(s Store, key string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("encoding record %q: %v", key, err)
+	}
+	return s.Put(key, data)
+}
@@ -0,0 +1,107 @@
+package telemetry
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// prometheusPrefix is put before the name of every metric Prometheus scrapes.
+const prometheusPrefix = "inhibitor_"
+
+// promSummary is a distribution in Prometheus's summary form, without quantiles.
+type promSummary struct {
+	count uint64
+	sum   time.Duration
+}
+
+// Prometheus is a Sink that serves the metrics for Prometheus to scrape at /metrics, in its text format: counters
+// with a _total suffix, gauges as they are, and observations as summaries in seconds. Metrics show up once first
+// updated.
+type Prometheus struct {
+	srv *http.Server
+
+	mtx       sync.Mutex
+	counters  map[string]uint64
+	gauges    map[string]uint64
+	summaries map[string]*promSummary
+}
+
+// ListenPrometheus returns a Prometheus serving on addr, e.g. "127.0.0.1:9477". The listener is opened before it
+// returns, so that it is in place before a sandbox would forbid that.
+func ListenPrometheus(addr string) (*Prometheus, error) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	p := &Prometheus{
+		counters:  make(map[string]uint64),
+		gauges:    make(map[string]uint64),
+		summaries: make(map[string]*promSummary),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", p.serve)
+	p.srv = &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go p.srv.Serve(l)
+	return p, nil
+}
+
+// Count implements Sink.
+func (p *Prometheus) Count(name string, n uint64) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	p.counters[sanitize(name)] += n
+}
+
+// Gauge implements Sink.
+func (p *Prometheus) Gauge(name string, v uint64) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	p.gauges[sanitize(name)] = v
+}
+
+// Observe implements Sink.
+func (p *Prometheus) Observe(name string, d time.Duration) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	name = sanitize(name)
+	s := p.summaries[name]
+	if s == nil {
+		s = &promSummary{}
+		p.summaries[name] = s
+	}
+	s.count++
+	s.sum += d
+}
+
+// Close implements Sink, stopping the server.
+func (p *Prometheus) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	return p.srv.Shutdown(ctx)
+}
+
+// serve writes every metric, sorted by name.
+func (p *Prometheus) serve(w http.ResponseWriter, r *http.Request) {
+	p.mtx.Lock()
+	families := make([]string, 0, len(p.counters)+len(p.gauges)+len(p.summaries))
+	for name, v := range p.counters {
+		families = append(families, fmt.Sprintf("# TYPE %[1]s%[2]s_total counter\n%[1]s%[2]s_total %[3]d\n", prometheusPrefix, name, v))
+	}
+	for name, v := range p.gauges {
+		families = append(families, fmt.Sprintf("# TYPE %[1]s%[2]s gauge\n%[1]s%[2]s %[3]d\n", prometheusPrefix, name, v))
+	}
+	for name, s := range p.summaries {
+		families = append(families, fmt.Sprintf("# TYPE %[1]s%[2]s summary\n%[1]s%[2]s_sum %[3]g\n%[1]s%[2]s_count %[4]d\n", prometheusPrefix, name, s.sum.Seconds(), s.count))
+	}
+	p.mtx.Unlock()
+	// Each starts with its TYPE line, so they sort by name.
+	sort.Strings(families)
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.Write([]byte(strings.Join(families, "")))
+}
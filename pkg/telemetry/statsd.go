@@ -0,0 +1,51 @@
+package telemetry
+
+import (
+	"fmt"
+	"net"
+	"time"
+)
+
+// statsdPrefix is put before the name of every metric sent to statsd.
+const statsdPrefix = "inhibitor."
+
+// Statsd is a Sink that sends every update to a statsd daemon over UDP, as it happens: counters as counts, gauges as
+// gauges and observations as timings in milliseconds. Updates the network drops are lost, as statsd expects.
+type Statsd struct {
+	conn net.Conn
+}
+
+// DialStatsd returns a Statsd sending to the statsd daemon at addr, e.g. "127.0.0.1:8125".
+func DialStatsd(addr string) (*Statsd, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return &Statsd{conn: conn}, nil
+}
+
+// Count implements Sink.
+func (s *Statsd) Count(name string, n uint64) {
+	s.send("%s%s:%d|c", statsdPrefix, sanitize(name), n)
+}
+
+// Gauge implements Sink.
+func (s *Statsd) Gauge(name string, v uint64) {
+	s.send("%s%s:%d|g", statsdPrefix, sanitize(name), v)
+}
+
+// Observe implements Sink.
+func (s *Statsd) Observe(name string, d time.Duration) {
+	s.send("%s%s:%d|ms", statsdPrefix, sanitize(name), d/time.Millisecond)
+}
+
+// Close implements Sink.
+func (s *Statsd) Close() error {
+	return s.conn.Close()
+}
+
+// send writes one metric as a datagram of its own. Errors, such as nobody listening, are ignored: metrics are best
+// effort.
+func (s *Statsd) send(format string, args ...interface{}) {
+	fmt.Fprintf(s.conn, format, args...)
+}
@@ -0,0 +1,77 @@
+// Package telemetry passes a bridge's metrics on to a monitoring system as they change, behind one interface, so
+// that embedders can plug in their own besides the Prometheus and statsd sinks it comes with.
+package telemetry
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Sink receives every update of a bridge's metrics, named as its Metrics method names them, e.g. "locks_granted".
+// Sinks are called from many goroutines and must not block for long; they are safe for concurrent use.
+type Sink interface {
+	// Count adds n to the counter name.
+	Count(name string, n uint64)
+	// Gauge sets the gauge name to v.
+	Gauge(name string, v uint64)
+	// Observe records d in the distribution name, such as how long a released lock was held.
+	Observe(name string, d time.Duration)
+	Close() error
+}
+
+// Nop is the Sink that drops everything.
+type Nop struct{}
+
+func (Nop) Count(string, uint64)          {}
+func (Nop) Gauge(string, uint64)          {}
+func (Nop) Observe(string, time.Duration) {}
+func (Nop) Close() error                  { return nil }
+
+// The kinds of sink a spec names.
+const (
+	KindNone       = "none"
+	KindPrometheus = "prometheus"
+	KindStatsd     = "statsd"
+)
+
+// ParseSpec splits a sink spec into its kind and address: "none", "prometheus:ADDR" to serve /metrics on ADDR, such
+// as "127.0.0.1:9477", or "statsd:ADDR" to send to the statsd daemon at ADDR, such as "127.0.0.1:8125".
+func ParseSpec(spec string) (kind, addr string, err error) {
+	if spec == KindNone {
+		return KindNone, "", nil
+	}
+	kind, addr, _ = strings.Cut(spec, ":")
+	if kind != KindPrometheus && kind != KindStatsd {
+		return "", "", fmt.Errorf("invalid metrics sink %q: want %q, \"prometheus:ADDR\" or \"statsd:ADDR\"", spec, KindNone)
+	}
+	if addr == "" {
+		return "", "", fmt.Errorf("invalid metrics sink %q: no address", spec)
+	}
+	return kind, addr, nil
+}
+
+// Open opens the sink spec names (see ParseSpec).
+func Open(spec string) (Sink, error) {
+	kind, addr, err := ParseSpec(spec)
+	if err != nil {
+		return nil, err
+	}
+	switch kind {
+	case KindPrometheus:
+		return ListenPrometheus(addr)
+	case KindStatsd:
+		return DialStatsd(addr)
+	}
+	return Nop{}, nil
+}
+
+// sanitize returns name with every character a Prometheus or statsd metric name can't have replaced by an underscore.
+func sanitize(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' {
+			return r
+		}
+		return '_'
+	}, name)
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+const (
+	// poolWorkers is how many side tasks, such as notifications, run at once.
+	poolWorkers = 4
+	// poolQueue is how many side tasks may wait for a worker before further ones are dropped.
+	poolQueue = 64
+	// poolDrain is how long stop waits for queued tasks to finish on shutdown.
+	poolDrain = 2 * time.Second
+)
+
+// poolTask is a unit of side work: something done because of a lock change that the lock itself doesn't depend on.
+type poolTask struct {
+	name    string
+	timeout time.Duration
+	run     func(ctx context.Context) error
+}
+
+// workerPool runs side work on a fixed set of goroutines, so that a flood of lock changes can't pile goroutines up
+// and a slow notification daemon or hung hook doesn't hold up lock processing. Each task gets a context cancelled
+// after its timeout, which it must honour for the worker to move on.
+type workerPool struct {
+	tasks   chan poolTask
+	wg      sync.WaitGroup
+	mtx     sync.Mutex
+	stopped bool
+}
+
+// newWorkerPool starts the workers.
+func newWorkerPool() *workerPool {
+	p := &workerPool{tasks: make(chan poolTask, poolQueue)}
+	p.wg.Add(poolWorkers)
+	for n := 0; n < poolWorkers; n++ {
+		go p.work()
+	}
+	return p
+}
+
+// submit queues run as name, to be cancelled after timeout. It never blocks: with the queue full, or the pool stopped,
+// the task is dropped.
+func (p *workerPool) submit(name string, timeout time.Duration, run func(ctx context.Context) error) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	if p.stopped {
+		maybeLog("Dropping %s: shutting down.\n", name)
+		return
+	}
+	select {
+	case p.tasks <- poolTask{name: name, timeout: timeout, run: run}:
+	default:
+		reallyLog("Dropping %s: %d tasks are already waiting.\n", name, poolQueue)
+	}
+}
+
+func (p *workerPool) work() {
+	defer p.wg.Done()
+	for t := range p.tasks {
+		ctx, cancel := context.WithTimeout(context.Background(), t.timeout)
+		err := t.run(ctx)
+		cancel()
+		switch {
+		case errors.Is(err, context.DeadlineExceeded):
+			reallyLog("%s timed out after %s.\n", t.name, t.timeout)
+		case err != nil:
+			maybeLog("Error in %s: %v\n", t.name, err)
+		}
+	}
+}
+
+// stop lets the workers finish what is queued, waiting at most poolDrain. Tasks submitted later are dropped.
+func (p *workerPool) stop() {
+	p.mtx.Lock()
+	p.stopped = true
+	close(p.tasks)
+	p.mtx.Unlock()
+	done := make(chan struct{})
+	go func() {
+		p.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(poolDrain):
+		reallyLog("Gave up waiting for %d queued tasks on shutdown.\n", len(p.tasks))
+	}
+}
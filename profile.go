@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/coltwillcox/inhibitor/pkg/bridge"
+)
+
+// parseProfileSignals parses --profile_signals: comma-separated SIGNAL=PROFILE pairs, each signal as --caffeine_signal
+// takes it, for keybinding daemons that can only send signals. taken are the signals already in use.
+func parseProfileSignals(s string, taken ...syscall.Signal) (map[syscall.Signal]string, error) {
+	profiles := make(map[syscall.Signal]string)
+	if s == "" {
+		return profiles, nil
+	}
+	for _, pair := range strings.Split(s, ",") {
+		sigName, name, ok := strings.Cut(pair, "=")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("invalid %q: want SIGNAL=PROFILE", pair)
+		}
+		sig, err := parseSignal(sigName)
+		if err != nil {
+			return nil, err
+		}
+		if sig == 0 {
+			return nil, fmt.Errorf("invalid %q: want SIGNAL=PROFILE", pair)
+		}
+		for _, t := range taken {
+			if sig == t {
+				return nil, fmt.Errorf("%s is already in use", sigName)
+			}
+		}
+		if _, dup := profiles[sig]; dup {
+			return nil, fmt.Errorf("%s is given twice", sigName)
+		}
+		profiles[sig] = name
+	}
+	return profiles, nil
+}
+
+// profileToggle toggles the profile called name, as a --profile_signals signal asks.
+func (i *inhibitor) profileToggle(name string) {
+	active, err := i.bridge.ToggleProfile(name)
+	if err != nil {
+		reallyLog("Error toggling profile %q: %v\n", name, err)
+		return
+	}
+	if active {
+		i.notifyInhibitChange(tr("Profile %q on.", name), nil)
+	} else {
+		i.notifyInhibitChange(tr("Profile %q off.", name), nil)
+	}
+	i.setStatus()
+}
+
+// profileCommand runs `inhibitor profile`: with no arguments it lists the profiles, otherwise it turns one on (for an
+// optional duration), off, or toggles it.
+func profileCommand(system bool, args []string) error {
+	conn, err := connectBus(system)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	obj := conn.Object(bridge.ServiceName, bridge.ControlPath)
+
+	if len(args) == 0 {
+		var profiles []bridge.ProfileState
+		if err := obj.Call(bridge.ControlInterface+".ListProfiles", 0).Store(&profiles); err != nil {
+			return err
+		}
+		if len(profiles) == 0 {
+			fmt.Println("No profiles; the --config file defines them.")
+		}
+		for _, p := range profiles {
+			state := "off"
+			switch {
+			case p.Active && p.Expires != 0:
+				state = "on until " + time.Unix(p.Expires, 0).Format("15:04")
+			case p.Active:
+				state = "on"
+			}
+			fmt.Printf("  %-20s %-16s %s (%s)\n", p.Name, state, p.What, p.Why)
+		}
+		return nil
+	}
+
+	name := args[1]
+	switch args[0] {
+	case "on":
+		var d time.Duration
+		if len(args) == 3 {
+			if d, err = time.ParseDuration(args[2]); err != nil || d < time.Second {
+				return &exitError{exitUsage, fmt.Errorf("invalid duration %q", args[2])}
+			}
+		}
+		if err := obj.Call(bridge.ControlInterface+".ActivateProfile", 0, name, uint32(d/time.Second)).Err; err != nil {
+			return err
+		}
+		fmt.Printf("Profile %q on.\n", name)
+	case "off":
+		if err := obj.Call(bridge.ControlInterface+".DeactivateProfile", 0, name).Err; err != nil {
+			return err
+		}
+		fmt.Printf("Profile %q off.\n", name)
+	case "toggle":
+		var active bool
+		if err := obj.Call(bridge.ControlInterface+".ToggleProfile", 0, name).Store(&active); err != nil {
+			return err
+		}
+		if active {
+			fmt.Printf("Profile %q on.\n", name)
+		} else {
+			fmt.Printf("Profile %q off.\n", name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/coltwillcox/inhibitor/pkg/bridge"
+	"github.com/godbus/dbus/v5"
+)
+
+// runProxy forwards the compat names, but for those compat turns off, to the process owning
+// org.freedesktop.ScreenSaver until told to quit, and then exits.
+func runProxy(prog string, system bool, compat map[string]bool) {
+	log.SetPrefix(prog + ": ")
+
+	connect, bus := dbus.ConnectSessionBus, "session"
+	if system {
+		connect, bus = dbus.ConnectSystemBus, "system"
+	}
+	conn, err := connect()
+	if err != nil {
+		fatalf(exitBusUnavailable, "%s bus connect failed: %v\n", bus, err)
+	}
+
+	p, err := bridge.NewProxy(context.Background(), conn, compat, logger{})
+	if err != nil {
+		fatalf(exitNameTaken, "Proxy setup failure: %v\n", err)
+	}
+	if *sandbox {
+		if err := applySandbox(defaultSandboxPolicy()); err != nil {
+			fatalf(exitSandbox, "Sandbox failure: %v\n", err)
+		}
+	}
+	maybeLog("Running as a proxy.\n")
+
+	quitCh := make(chan os.Signal, 1)
+	signal.Notify(quitCh, syscall.SIGINT, syscall.SIGTERM)
+	s := <-quitCh
+	maybeLog("Received signal %q. Shutting down...\n", s)
+	if err := p.Close(); err != nil {
+		maybeLog("Error shutting down proxy: %v\n", err)
+	}
+	maybeLog("Goodbye.\n")
+	os.Exit(0)
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	prSetNoNewPrivs = 38
+	oPath           = 0x200000 // O_PATH, missing from the syscall package
+
+	sysLandlockCreateRuleset = 444
+	sysLandlockAddRule       = 445
+	sysLandlockRestrictSelf  = 446
+
+	landlockCreateRulesetVersion = 1 << 0
+	landlockRulePathBeneath      = 1
+
+	// Filesystem access rights from Landlock ABI v1.
+	landlockAccessFSExecute    = 1 << 0
+	landlockAccessFSWriteFile  = 1 << 1
+	landlockAccessFSReadFile   = 1 << 2
+	landlockAccessFSReadDir    = 1 << 3
+	landlockAccessFSRemoveDir  = 1 << 4
+	landlockAccessFSRemoveFile = 1 << 5
+	landlockAccessFSMakeChar   = 1 << 6
+	landlockAccessFSMakeDir    = 1 << 7
+	landlockAccessFSMakeReg    = 1 << 8
+	landlockAccessFSMakeSock   = 1 << 9
+	landlockAccessFSMakeFifo   = 1 << 10
+	landlockAccessFSMakeBlock  = 1 << 11
+	landlockAccessFSMakeSym    = 1 << 12
+
+	landlockAccessFSRead  = landlockAccessFSReadFile | landlockAccessFSReadDir
+	landlockAccessFSWrite = landlockAccessFSWriteFile | landlockAccessFSRemoveDir | landlockAccessFSRemoveFile |
+		landlockAccessFSMakeChar | landlockAccessFSMakeDir | landlockAccessFSMakeReg | landlockAccessFSMakeSock |
+		landlockAccessFSMakeFifo | landlockAccessFSMakeBlock | landlockAccessFSMakeSym
+	landlockAccessFSAll = landlockAccessFSExecute | landlockAccessFSRead | landlockAccessFSWrite
+)
+
+type landlockRulesetAttr struct {
+	handledAccessFS uint64
+}
+
+type landlockPathBeneathAttr struct {
+	allowedAccess uint64
+	parentFd      int32
+}
+
+// sandboxPolicy describes the filesystem access the daemon still needs once it has been sandboxed. Anything opened
+// before the sandbox is applied (the log file, bus sockets, logind fds) remains usable regardless.
+type sandboxPolicy struct {
+	readPaths  []string
+	writePaths []string
+	// execPaths may be executed. Allowing any also lets execve, and the syscalls a successor needs to sandbox
+	// itself, through seccomp.
+	execPaths []string
+}
+
+// defaultSandboxPolicy returns the filesystem access needed for normal operation: /proc for peer process validation
+// and the timezone data the log package loads lazily.
+func defaultSandboxPolicy() *sandboxPolicy {
+	return &sandboxPolicy{
+		readPaths: []string{"/proc", "/etc/localtime", "/usr/share/zoneinfo"},
+	}
+}
+
+// allowReexec lets the daemon exec a new copy of exe for a hot upgrade. The successor is started inside this sandbox,
+// so it also needs the dynamic loader and libraries. The binary's whole directory is allowed since an upgrade usually
+// replaces the file rather than rewriting it.
+func (p *sandboxPolicy) allowReexec(exe string) {
+	p.execPaths = append(p.execPaths, filepath.Dir(exe), "/lib", "/lib64", "/usr/lib", "/usr/lib64")
+	p.readPaths = append(p.readPaths, "/etc/ld.so.cache")
+}
+
+// applySandbox sets no_new_privs, restricts filesystem access with Landlock (where supported) and installs a seccomp
+// syscall allowlist. It is best effort: kernels lacking Landlock or seccomp support are logged, not fatal.
+func applySandbox(p *sandboxPolicy) error {
+	if _, _, errno := syscall.AllThreadsSyscall(syscall.SYS_PRCTL, prSetNoNewPrivs, 1, 0); errno != 0 {
+		if errno != syscall.ENOTSUP {
+			return fmt.Errorf("prctl(PR_SET_NO_NEW_PRIVS): %v", errno)
+		}
+		// cgo binaries can't use AllThreadsSyscall; the seccomp filter below syncs no_new_privs to all threads.
+		maybeLog("Can't set no_new_privs on all threads in a cgo build; relying on seccomp to do so.\n")
+	}
+
+	if err := applyLandlock(p); err != nil {
+		maybeLog("Landlock not applied: %v\n", err)
+	}
+
+	return applySeccomp(len(p.execPaths) > 0)
+}
+
+func applyLandlock(p *sandboxPolicy) error {
+	abi, _, errno := syscall.Syscall(sysLandlockCreateRuleset, 0, 0, landlockCreateRulesetVersion)
+	if errno != 0 {
+		return fmt.Errorf("kernel doesn't support landlock: %v", errno)
+	}
+	maybeLog("Landlock ABI version %d available.\n", abi)
+
+	attr := landlockRulesetAttr{handledAccessFS: landlockAccessFSAll}
+	r, _, errno := syscall.Syscall(sysLandlockCreateRuleset, uintptr(unsafe.Pointer(&attr)), unsafe.Sizeof(attr), 0)
+	if errno != 0 {
+		return fmt.Errorf("landlock_create_ruleset: %v", errno)
+	}
+	rulesetFd := int(r)
+	defer syscall.Close(rulesetFd)
+
+	for _, path := range p.readPaths {
+		if err := landlockAllow(rulesetFd, path, landlockAccessFSRead); err != nil {
+			return err
+		}
+	}
+	for _, path := range p.execPaths {
+		if err := landlockAllow(rulesetFd, path, landlockAccessFSExecute|landlockAccessFSRead); err != nil {
+			return err
+		}
+	}
+	for _, path := range p.writePaths {
+		if err := landlockAllow(rulesetFd, path, landlockAccessFSRead|landlockAccessFSWrite); err != nil {
+			return err
+		}
+	}
+
+	if _, _, errno := syscall.AllThreadsSyscall(sysLandlockRestrictSelf, uintptr(rulesetFd), 0, 0); errno != 0 {
+		if errno == syscall.ENOTSUP {
+			return errors.New("landlock can't be applied to all threads in a cgo build; build with CGO_ENABLED=0")
+		}
+		return fmt.Errorf("landlock_restrict_self: %v", errno)
+	}
+
+	return nil
+}
+
+func landlockAllow(rulesetFd int, path string, access uint64) error {
+	fd, err := syscall.Open(path, oPath|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		if errors.Is(err, syscall.ENOENT) {
+			// Nothing to allow.
+			return nil
+		}
+		return fmt.Errorf("opening %q for landlock rule: %v", path, err)
+	}
+	defer syscall.Close(fd)
+
+	var st syscall.Stat_t
+	if err := syscall.Fstat(fd, &st); err != nil {
+		return fmt.Errorf("stat %q: %v", path, err)
+	}
+	// Directory-only rights can't be granted on files.
+	if st.Mode&syscall.S_IFMT != syscall.S_IFDIR {
+		access &= landlockAccessFSExecute | landlockAccessFSWriteFile | landlockAccessFSReadFile
+	}
+
+	attr := landlockPathBeneathAttr{allowedAccess: access, parentFd: int32(fd)}
+	if _, _, errno := syscall.Syscall6(sysLandlockAddRule, uintptr(rulesetFd), landlockRulePathBeneath, uintptr(unsafe.Pointer(&attr)), 0, 0, 0); errno != 0 {
+		return fmt.Errorf("landlock_add_rule(%q): %v", path, errno)
+	}
+
+	return nil
+}
@@ -0,0 +1,22 @@
+//go:build !linux
+
+package main
+
+// sandboxPolicy is unused outside of Linux.
+type sandboxPolicy struct {
+	readPaths  []string
+	writePaths []string
+	execPaths  []string
+}
+
+func defaultSandboxPolicy() *sandboxPolicy {
+	return &sandboxPolicy{}
+}
+
+func (p *sandboxPolicy) allowReexec(exe string) {}
+
+// applySandbox is a no-op outside of Linux.
+func applySandbox(p *sandboxPolicy) error {
+	maybeLog("Sandboxing is only supported on Linux; skipping.\n")
+	return nil
+}
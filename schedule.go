@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/coltwillcox/inhibitor/pkg/bridge"
+)
+
+// scheduleLayouts are the forms --at takes besides a bare time of day.
+var scheduleLayouts = []string{"2006-01-02 15:04", time.RFC3339}
+
+// parseAt parses --at: a time of day ("22:00"), meaning its next occurrence after now, a local date and time
+// ("2006-01-02 15:04") or RFC 3339.
+func parseAt(s string, now time.Time) (time.Time, error) {
+	if t, err := time.ParseInLocation("15:04", s, now.Location()); err == nil {
+		at := time.Date(now.Year(), now.Month(), now.Day(), t.Hour(), t.Minute(), 0, 0, now.Location())
+		if !at.After(now) {
+			at = at.AddDate(0, 0, 1)
+		}
+		return at, nil
+	}
+	for _, layout := range scheduleLayouts {
+		if t, err := time.ParseInLocation(layout, s, now.Location()); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("want a time of day (\"22:00\"), a date and time (%q) or RFC 3339", scheduleLayouts[0])
+}
+
+// scheduleCommand runs `inhibitor schedule`: with at set it schedules a lock from then for length, with "cancel ID"
+// it cancels a pending schedule, and otherwise it lists them.
+func scheduleCommand(system bool, args []string, at time.Time, length time.Duration, who, why, what, mode string) error {
+	conn, err := connectBus(system)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	obj := conn.Object(bridge.ServiceName, bridge.ControlPath)
+
+	switch {
+	case len(args) == 2:
+		id, err := strconv.ParseUint(args[1], 10, 32)
+		if err != nil {
+			return &exitError{exitUsage, fmt.Errorf("invalid schedule %q", args[1])}
+		}
+		if err := obj.Call(bridge.ControlInterface+".CancelSchedule", 0, uint32(id)).Err; err != nil {
+			return err
+		}
+		fmt.Printf("Schedule %d cancelled.\n", id)
+	case !at.IsZero():
+		var id uint32
+		if err := obj.Call(bridge.ControlInterface+".Schedule", 0, who, why, what, mode, at.Unix(), uint32(length/time.Second)).Store(&id); err != nil {
+			return err
+		}
+		fmt.Printf("Schedule %d: %s from %s for %s.\n", id, what, at.Format("2006-01-02 15:04"), length)
+	default:
+		var schedules []bridge.Schedule
+		if err := obj.Call(bridge.ControlInterface+".ListSchedules", 0).Store(&schedules); err != nil {
+			return err
+		}
+		if len(schedules) == 0 {
+			fmt.Println("No pending schedules.")
+		}
+		for _, s := range schedules {
+			fmt.Printf("  %-4d %s  %-9s %-16s %s (%s)\n", s.ID, time.Unix(s.At, 0).Format("2006-01-02 15:04"), time.Duration(s.Seconds)*time.Second, s.What, s.Who, s.Why)
+		}
+	}
+	return nil
+}
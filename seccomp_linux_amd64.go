@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	sysSeccomp = 317
+
+	seccompSetModeFilter   = 1
+	seccompFilterFlagTsync = 1
+
+	seccompRetAllow = 0x7fff0000
+	seccompRetErrno = 0x00050000
+
+	auditArchX86_64 = 0xc000003e
+	x32SyscallBit   = 0x40000000
+
+	bpfLdWAbs  = 0x20 // BPF_LD | BPF_W | BPF_ABS
+	bpfJeqK    = 0x15 // BPF_JMP | BPF_JEQ | BPF_K
+	bpfJgeK    = 0x35 // BPF_JMP | BPF_JGE | BPF_K
+	bpfRetK    = 0x06 // BPF_RET | BPF_K
+	offsetNr   = 0    // offsetof(struct seccomp_data, nr)
+	offsetArch = 4    // offsetof(struct seccomp_data, arch)
+)
+
+type sockFilter struct {
+	code uint16
+	jt   uint8
+	jf   uint8
+	k    uint32
+}
+
+type sockFprog struct {
+	len    uint16
+	filter *sockFilter
+}
+
+// seccompAllowlist is every syscall the Go runtime, godbus and the daemon itself are expected to make after startup.
+// Anything else fails with EPERM rather than killing the process, so an omission degrades instead of crashing.
+var seccompAllowlist = []uint32{
+	syscall.SYS_READ, syscall.SYS_WRITE, syscall.SYS_CLOSE, syscall.SYS_FSTAT, syscall.SYS_LSEEK,
+	syscall.SYS_MMAP, syscall.SYS_MPROTECT, syscall.SYS_MUNMAP, syscall.SYS_BRK, syscall.SYS_MADVISE,
+	syscall.SYS_MINCORE, syscall.SYS_RT_SIGACTION, syscall.SYS_RT_SIGPROCMASK, syscall.SYS_RT_SIGRETURN,
+	syscall.SYS_SIGALTSTACK, syscall.SYS_IOCTL, syscall.SYS_PREAD64, syscall.SYS_PWRITE64, syscall.SYS_READV,
+	syscall.SYS_WRITEV, syscall.SYS_SCHED_YIELD, syscall.SYS_SCHED_GETAFFINITY, syscall.SYS_DUP, syscall.SYS_DUP2,
+	syscall.SYS_DUP3, syscall.SYS_NANOSLEEP, syscall.SYS_CLOCK_NANOSLEEP, syscall.SYS_CLOCK_GETTIME,
+	syscall.SYS_GETTIMEOFDAY, syscall.SYS_GETPID, syscall.SYS_GETTID, syscall.SYS_GETUID, syscall.SYS_GETEUID,
+	syscall.SYS_GETGID, syscall.SYS_GETEGID, syscall.SYS_SOCKET, syscall.SYS_CONNECT, syscall.SYS_SENDMSG,
+	syscall.SYS_RECVMSG, syscall.SYS_SENDTO, syscall.SYS_RECVFROM, syscall.SYS_SHUTDOWN, syscall.SYS_GETSOCKNAME,
+	syscall.SYS_GETPEERNAME, syscall.SYS_SETSOCKOPT, syscall.SYS_GETSOCKOPT, syscall.SYS_CLONE, 435, /* clone3 */
+	syscall.SYS_EXIT, syscall.SYS_EXIT_GROUP, syscall.SYS_KILL, syscall.SYS_TGKILL, syscall.SYS_TKILL,
+	syscall.SYS_UNAME, syscall.SYS_FCNTL, syscall.SYS_FUTEX, syscall.SYS_SET_ROBUST_LIST,
+	syscall.SYS_RESTART_SYSCALL, syscall.SYS_EPOLL_CREATE1, syscall.SYS_EPOLL_CTL, syscall.SYS_EPOLL_WAIT,
+	syscall.SYS_EPOLL_PWAIT, syscall.SYS_OPENAT, syscall.SYS_NEWFSTATAT, syscall.SYS_GETDENTS64,
+	syscall.SYS_READLINKAT, syscall.SYS_FACCESSAT, syscall.SYS_PIPE2, syscall.SYS_EVENTFD2, syscall.SYS_PPOLL,
+	syscall.SYS_PSELECT6, syscall.SYS_PRLIMIT64, syscall.SYS_GETRLIMIT, syscall.SYS_FSTATFS,
+	syscall.SYS_ARCH_PRCTL, syscall.SYS_SET_TID_ADDRESS, syscall.SYS_PRCTL,
+	syscall.SYS_ACCEPT4,                   // serving a Prometheus metrics sink
+	syscall.SYS_RENAMEAT,                  // replacing the --state file
+	syscall.SYS_WAIT4, syscall.SYS_WAITID, // reaping the --suppress_dimming helper
+	318 /* getrandom */, 332 /* statx */, 334 /* rseq */, 439 /* faccessat2 */, 441, /* epoll_pwait2 */
+}
+
+// seccompExecAllowlist is added to the allowlist when the daemon may re-exec itself: the successor inherits this
+// filter and has to be able to install its own sandbox on top.
+var seccompExecAllowlist = []uint32{
+	syscall.SYS_EXECVE, sysSeccomp, sysLandlockCreateRuleset, sysLandlockAddRule, sysLandlockRestrictSelf,
+}
+
+// applySeccomp installs the allowlist filter on every thread of the process.
+func applySeccomp(allowExec bool) error {
+	deny := uint32(seccompRetErrno | uint32(syscall.EPERM))
+	allowlist := seccompAllowlist
+	if allowExec {
+		allowlist = append(append([]uint32(nil), seccompAllowlist...), seccompExecAllowlist...)
+	}
+	n := len(allowlist)
+
+	prog := []sockFilter{
+		{code: bpfLdWAbs, k: offsetArch},
+		{code: bpfJeqK, jt: 1, jf: 0, k: auditArchX86_64},
+		{code: bpfRetK, k: deny},
+		{code: bpfLdWAbs, k: offsetNr},
+		{code: bpfJgeK, jt: uint8(n), jf: 0, k: x32SyscallBit},
+	}
+	for idx, nr := range allowlist {
+		// Jump over the remaining comparisons and the deny return to land on allow.
+		prog = append(prog, sockFilter{code: bpfJeqK, jt: uint8(n - idx), jf: 0, k: nr})
+	}
+	prog = append(prog,
+		sockFilter{code: bpfRetK, k: deny},
+		sockFilter{code: bpfRetK, k: seccompRetAllow},
+	)
+
+	fprog := sockFprog{len: uint16(len(prog)), filter: &prog[0]}
+	if _, _, errno := syscall.Syscall(sysSeccomp, seccompSetModeFilter, seccompFilterFlagTsync, uintptr(unsafe.Pointer(&fprog))); errno != 0 {
+		return fmt.Errorf("seccomp(SECCOMP_SET_MODE_FILTER): %v", errno)
+	}
+
+	return nil
+}
@@ -0,0 +1,9 @@
+//go:build linux && !amd64
+
+package main
+
+// applySeccomp is a no-op on architectures without a syscall allowlist.
+func applySeccomp(allowExec bool) error {
+	maybeLog("No seccomp allowlist for this architecture; skipping.\n")
+	return nil
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/coltwillcox/inhibitor/pkg/bridge"
+	"github.com/godbus/dbus/v5"
+)
+
+const (
+	login1Name = "org.freedesktop.login1"
+	login1Path = "/org/freedesktop/login1"
+	peerPing   = "org.freedesktop.DBus.Peer.Ping"
+	getConnPID = "org.freedesktop.DBus.GetConnectionUnixProcessID"
+
+	// nameTakenHint is the advice given whenever bridge.ServiceName turns out to be owned already.
+	nameTakenHint = "stop it, or start with --replace, --queue, --proxy or --takeover as appropriate"
+)
+
+// checkResult is the outcome of a single startup check.
+type checkResult struct {
+	name string // stable identifier, e.g. "bus"
+	code int    // exit code to use when the check fails
+	err  error
+	warn bool // a failure is only logged, not fatal
+}
+
+// selfCheck verifies that everything the daemon needs is in place before it starts: the bus it serves, the
+// org.freedesktop.ScreenSaver name (unless taking over from a previous instance) and logind. With lazy, logind being
+// unreachable is only a warning, as the daemon connects to it when first needed.
+func selfCheck(system bool, logindBus string, takeover, lazy bool) []checkResult {
+	var results []checkResult
+
+	connect, busName := dbus.ConnectSessionBus, "session"
+	if system {
+		connect, busName = dbus.ConnectSystemBus, "system"
+	}
+	conn, err := connect()
+	if err != nil {
+		err = fmt.Errorf("%s bus connect failed: %v", busName, err)
+	}
+	results = append(results, checkResult{name: "bus", code: exitBusUnavailable, err: err})
+
+	if conn != nil {
+		defer conn.Close()
+
+		var owner string
+		err = nil
+		if !takeover && conn.BusObject().Call("org.freedesktop.DBus.GetNameOwner", 0, bridge.ServiceName).Store(&owner) == nil {
+			err = fmt.Errorf("%s is owned by %s; %s", bridge.ServiceName, describeOwner(conn, owner), nameTakenHint)
+		}
+		results = append(results, checkResult{name: "name", code: exitNameTaken, err: err})
+	}
+
+	connect = dbus.ConnectSystemBus
+	if logindBus == "session" {
+		connect = dbus.ConnectSessionBus
+	}
+	lconn, err := connect()
+	if err == nil {
+		defer lconn.Close()
+		// Ping starts logind if it is bus-activatable but not yet running.
+		if err = lconn.Object(login1Name, login1Path).Call(peerPing, 0).Err; err != nil {
+			err = fmt.Errorf("%s not reachable on the %s bus: %v", login1Name, logindBus, err)
+		}
+	}
+	results = append(results, checkResult{name: "logind", code: exitNoLogind, err: err, warn: lazy})
+
+	return results
+}
+
+// describeOwner names the process behind the bus connection owner, e.g. "ksmserver (pid 1234, :1.23)". Parts that
+// can't be found out are left out.
+func describeOwner(conn *dbus.Conn, owner string) string {
+	var pid uint32
+	if err := conn.BusObject().Call(getConnPID, 0, owner).Store(&pid); err != nil {
+		return owner
+	}
+
+	name := ""
+	if exe, err := os.Readlink(fmt.Sprintf("/proc/%d/exe", pid)); err == nil {
+		name = filepath.Base(exe)
+	} else if comm, err := os.ReadFile(fmt.Sprintf("/proc/%d/comm", pid)); err == nil {
+		name = strings.TrimSpace(string(comm))
+	}
+	if name == "" {
+		return fmt.Sprintf("pid %d (%s)", pid, owner)
+	}
+	return fmt.Sprintf("%s (pid %d, %s)", name, pid, owner)
+}
+
+// nameConflict describes the current owner of bridge.ServiceName, for when claiming it failed after the self-check.
+func nameConflict(system bool) string {
+	connect := dbus.ConnectSessionBus
+	if system {
+		connect = dbus.ConnectSystemBus
+	}
+	conn, err := connect()
+	if err != nil {
+		return nameTakenHint
+	}
+	defer conn.Close()
+
+	var owner string
+	if err := conn.BusObject().Call("org.freedesktop.DBus.GetNameOwner", 0, bridge.ServiceName).Store(&owner); err != nil {
+		return nameTakenHint
+	}
+	return fmt.Sprintf("%s is owned by %s; %s", bridge.ServiceName, describeOwner(conn, owner), nameTakenHint)
+}
+
+// reportChecks logs every result in a machine-readable form and returns the exit code of the first failure that isn't
+// just a warning, or 0.
+// Passing checks are only logged with --verbose unless all is set.
+func reportChecks(results []checkResult, all bool) int {
+	code := 0
+	for _, r := range results {
+		if r.err == nil {
+			if all {
+				reallyLog("selfcheck: check=%s result=ok\n", r.name)
+			} else {
+				maybeLog("selfcheck: check=%s result=ok\n", r.name)
+			}
+			continue
+		}
+		if r.warn {
+			reallyLog("selfcheck: check=%s result=warn error=%q\n", r.name, r.err.Error())
+			continue
+		}
+		reallyLog("selfcheck: check=%s result=fail exit=%d error=%q\n", r.name, r.code, r.err.Error())
+		if code == 0 {
+			code = r.code
+		}
+	}
+	return code
+}
+
+// healthCheck asks the running daemon whether it can take locks: the "daemon" check fails if none answers, and the
+// "backend" check if its circuit breaker is open or it is handing out no-op locks (see --breaker_threshold and
+// --soft_fail), for monitoring and service health checks.
+func healthCheck(system bool) []checkResult {
+	var metrics map[string]uint64
+	conn, err := connectBus(system)
+	if err == nil {
+		defer conn.Close()
+		err = conn.Object(bridge.ServiceName, bridge.ControlPath).Call(bridge.ControlInterface+".Metrics", 0).Store(&metrics)
+	}
+	if err != nil {
+		return []checkResult{{name: "daemon", code: exitCode(err), err: err}}
+	}
+
+	switch breakerOpen, degraded := bridge.BackendState(metrics); {
+	case breakerOpen:
+		err = errors.New("the backend failed repeatedly; requests fail at once until a probe finds it back")
+	case degraded:
+		err = errors.New("the backend is unavailable; locks are no-ops until it is back")
+	}
+	return []checkResult{{name: "daemon"}, {name: "backend", code: exitNoLogind, err: err}}
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/coltwillcox/inhibitor/pkg/bridge"
+)
+
+// inhibitShutdown asks the running daemon to hold off shutdowns and reboots for ttl, on behalf of a job that runs
+// without us.
+func inhibitShutdown(system bool, why string, ttl time.Duration) error {
+	if ttl < time.Second {
+		return fmt.Errorf("--shutdown_ttl must be at least a second, got %s", ttl)
+	}
+	conn, err := connectBus(system)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	var cookie uint32
+	if err := conn.Object(bridge.ServiceName, bridge.ControlPath).Call(bridge.ControlInterface+".InhibitShutdown", 0, "inhibitor shutdown", why, uint32(ttl/time.Second)).Store(&cookie); err != nil {
+		return err
+	}
+
+	// Printed regardless of --verbose: it is the only way to release the lock early.
+	fmt.Printf("Shutdown inhibited for %s: peer %s, cookie %d.\n", ttl, conn.Names()[0], cookie)
+	return nil
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/coltwillcox/inhibitor/pkg/bridge"
+)
+
+// stateDir is the directory, in $XDG_RUNTIME_DIR, that holds the --state file. The file is replaced rather than
+// rewritten, which the sandbox only allows within a directory of the daemon's own.
+const stateDir = "inhibitor"
+
+// stateFile is the daemon's --state file, or nil.
+var stateFile *stateWriter
+
+// stateSnapshot is the content of the --state file.
+type stateSnapshot struct {
+	Updated time.Time `json:"updated"`
+	// Running is false once the daemon has exited, which leaves its last word behind rather than no file at all.
+	Running bool `json:"running"`
+	// Mode is what the tray icon shows: "manual" with a manual or caffeine inhibit, "auto" with only applications'
+	// locks, or else "none".
+	Mode     string        `json:"mode"`
+	Manual   bool          `json:"manual"`
+	Caffeine bool          `json:"caffeine"`
+	Locks    []bridge.Lock `json:"locks"`
+}
+
+// stateWriter keeps the --state file up to date for status bars and scripts that would rather read a file than speak
+// D-Bus. Each update is written to a temporary file that then replaces the state file, so that readers never see
+// one cut short.
+type stateWriter struct {
+	path string
+}
+
+// openStateFile creates the --state directory in $XDG_RUNTIME_DIR. It must exist before the sandbox is applied.
+func openStateFile() (*stateWriter, error) {
+	base := os.Getenv("XDG_RUNTIME_DIR")
+	if base == "" {
+		return nil, errors.New("$XDG_RUNTIME_DIR isn't set")
+	}
+	dir := filepath.Join(base, stateDir)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("creating %q: %v", dir, err)
+	}
+	return &stateWriter{path: filepath.Join(dir, "state.json")}, nil
+}
+
+// dir is the directory the state file and its temporary file live in.
+func (s *stateWriter) dir() string {
+	return filepath.Dir(s.path)
+}
+
+// write replaces the state file with st. Callers serialize writes, as the temporary file is shared.
+func (s *stateWriter) write(st stateSnapshot) {
+	st.Updated = time.Now()
+	if st.Locks == nil {
+		st.Locks = []bridge.Lock{}
+	}
+	switch {
+	case st.Manual || st.Caffeine:
+		st.Mode = "manual"
+	case len(st.Locks) > 0:
+		st.Mode = "auto"
+	default:
+		st.Mode = "none"
+	}
+	data, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		maybeLog("Error encoding --state: %v\n", err)
+		return
+	}
+	// A fixed name, rather than a random one, as the sandbox doesn't allow removing one left behind by a failure.
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0600); err != nil {
+		reallyLog("Error writing --state: %v\n", err)
+		return
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		reallyLog("Error writing --state: %v\n", err)
+	}
+}
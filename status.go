@@ -0,0 +1,283 @@
+package main
+
+import (
+	"fmt"
+	"os/user"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/coltwillcox/inhibitor/pkg/bridge"
+	"github.com/godbus/dbus/v5"
+)
+
+const login1ListInhibitors = "org.freedesktop.login1.Manager.ListInhibitors"
+
+// statusLock is a lock as returned by the control interface's ListInhibits and ListAllInhibits.
+type statusLock struct {
+	Cookie  uint32
+	Peer    string
+	Who     string
+	Why     string
+	UID     uint32
+	What    string
+	Expires int64
+	Note    string
+	Window  string
+	Held    uint64
+	ID      string
+	App     string
+}
+
+// String returns a useful textual representation of a lock.
+func (l statusLock) String() string {
+	s := fmt.Sprintf("[%s] %q / %q, what %s (%s, cookie %d), held %s", l.ID, l.Who, l.Why, l.What, l.Peer, l.Cookie, time.Duration(l.Held)*time.Second)
+	if l.Expires != 0 {
+		s += fmt.Sprintf(", until %s", time.Unix(l.Expires, 0).Format(time.RFC3339))
+	}
+	if l.Window != "" {
+		s += fmt.Sprintf(", until window %s closes", l.Window)
+	}
+	if l.Note != "" {
+		s += fmt.Sprintf(", note %q", l.Note)
+	}
+	return s
+}
+
+// logindInhibitor is an entry of logind's ListInhibitors.
+type logindInhibitor struct {
+	What, Who, Why, Mode string
+	UID, PID             uint32
+}
+
+// status prints the running daemon's locks, grouped by user and then by application, which only lists each of its
+// locks if it holds just one or expand is set. With allUsers, an admin sees every user's locks rather than just their
+// own. With logindBus set, it instead prints every inhibitor logind knows about, ours included and
+// marked as such, so that it answers what is keeping the machine awake whoever took the inhibit.
+func status(system, allUsers, expand bool, logindBus string) error {
+	conn, err := connectBus(system)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	method := "ListInhibits"
+	if allUsers {
+		method = "ListAllInhibits"
+	}
+	var locks []statusLock
+	if err := conn.Object(bridge.ServiceName, bridge.ControlPath).Call(bridge.ControlInterface+"."+method, 0).Store(&locks); err != nil {
+		return err
+	}
+	sort.Slice(locks, func(i, j int) bool {
+		if locks[i].UID != locks[j].UID {
+			return locks[i].UID < locks[j].UID
+		}
+		return locks[i].Who < locks[j].Who
+	})
+
+	if logindBus != "" {
+		var pid uint32
+		if err := conn.BusObject().Call(getConnPID, 0, bridge.ServiceName).Store(&pid); err != nil {
+			return fmt.Errorf("couldn't identify the daemon: %v", err)
+		}
+		return mergedStatus(locks, pid, logindBus)
+	}
+
+	if len(locks) == 0 {
+		fmt.Println("No locks are held.")
+		return nil
+	}
+	for start := 0; start < len(locks); {
+		end := start + 1
+		for end < len(locks) && locks[end].UID == locks[start].UID {
+			end++
+		}
+		user := locks[start:end]
+		fmt.Printf("%s: %d lock(s)\n", userName(user[0].UID), len(user))
+		groups := groupByApp(len(user), func(i int) (string, string, time.Duration) {
+			return user[i].App, user[i].Who, time.Duration(user[i].Held) * time.Second
+		})
+		for _, g := range groups {
+			if len(g.locks) == 1 {
+				fmt.Printf("  %s\n", user[g.locks[0]])
+				continue
+			}
+			fmt.Printf("  %s\n", g)
+			if expand {
+				for _, i := range g.locks {
+					fmt.Printf("    %s\n", user[i])
+				}
+			}
+		}
+		start = end
+	}
+	return nil
+}
+
+// mergedStatus prints logind's inhibitors, marking those taken by the daemon (running as pid) with the locks they
+// were taken for, followed by any of locks that logind doesn't hold an inhibit for, such as provisional and
+// just-in-time ones.
+func mergedStatus(locks []statusLock, pid uint32, logindBus string) error {
+	connect := dbus.ConnectSystemBus
+	if logindBus == "session" {
+		connect = dbus.ConnectSessionBus
+	}
+	lconn, err := connect()
+	if err != nil {
+		return &exitError{exitNoLogind, fmt.Errorf("%s bus connect failed: %v", logindBus, err)}
+	}
+	defer lconn.Close()
+
+	var inhibitors []logindInhibitor
+	if err := lconn.Object(login1Name, login1Path).Call(login1ListInhibitors, 0).Store(&inhibitors); err != nil {
+		return &exitError{exitNoLogind, fmt.Errorf("calling %q: %v", login1ListInhibitors, err)}
+	}
+	sort.Slice(inhibitors, func(i, j int) bool {
+		if inhibitors[i].What != inhibitors[j].What {
+			return inhibitors[i].What < inhibitors[j].What
+		}
+		return inhibitors[i].Who < inhibitors[j].Who
+	})
+
+	// The daemon takes its inhibits as "<who> <why>" of the lock, possibly several (or, for locks sharing one, none)
+	// per lock, so a lock is matched to every inhibit of ours with that reason.
+	matched := make([]bool, len(locks))
+	fmt.Println("logind inhibitors (* taken by inhibitor):")
+	if len(inhibitors) == 0 {
+		fmt.Println("  none")
+	}
+	for _, inh := range inhibitors {
+		if inh.PID != pid {
+			fmt.Printf("   %s, %s: %q / %q (%s, pid %d)\n", inh.What, inh.Mode, inh.Who, inh.Why, userName(inh.UID), inh.PID)
+			continue
+		}
+		fmt.Printf(" * %s, %s:\n", inh.What, inh.Mode)
+		found := false
+		for i, l := range locks {
+			if l.Who+" "+l.Why == inh.Why {
+				fmt.Printf("       %s, %s\n", userName(l.UID), l)
+				matched[i], found = true, true
+			}
+		}
+		if !found {
+			// A lock of another user, which the caller isn't allowed to see.
+			fmt.Printf("       %q\n", inh.Why)
+		}
+	}
+
+	for i, l := range locks {
+		if !matched[i] {
+			fmt.Printf("Not held by logind: %s, %s\n", userName(l.UID), l)
+		}
+	}
+	return nil
+}
+
+// userName returns the login name of uid along with the uid, or just the uid if it has no name.
+func userName(uid uint32) string {
+	id := strconv.FormatUint(uint64(uid), 10)
+	u, err := user.LookupId(id)
+	if err != nil {
+		return "uid " + id
+	}
+	return fmt.Sprintf("%s (uid %s)", u.Username, id)
+}
+
+// annotate sets the note of the lock peer holds as cookie, or removes it if note is empty.
+func annotate(system bool, peer string, cookie uint32, note string) error {
+	conn, err := connectBus(system)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	return conn.Object(bridge.ServiceName, bridge.ControlPath).Call(bridge.ControlInterface+".Annotate", 0, peer, cookie, note).Err
+}
+
+// capabilities prints what the running daemon offers, as GetCapabilities returns it, a category per line.
+func capabilities(system bool) error {
+	conn, err := connectBus(system)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	var caps map[string][]string
+	if err := conn.Object(bridge.ServiceName, bridge.ControlPath).Call(bridge.ControlInterface+".GetCapabilities", 0).Store(&caps); err != nil {
+		return err
+	}
+	for _, c := range []string{bridge.CapInterfaces, bridge.CapPaths, bridge.CapBackend, bridge.CapFeatures, bridge.CapDenied} {
+		fmt.Printf("%s: %s\n", c, strings.Join(caps[c], " "))
+		delete(caps, c)
+	}
+	// Categories added by a newer daemon than this binary.
+	var rest []string
+	for c := range caps {
+		rest = append(rest, c)
+	}
+	sort.Strings(rest)
+	for _, c := range rest {
+		fmt.Printf("%s: %s\n", c, strings.Join(caps[c], " "))
+	}
+	return nil
+}
+
+// nameStats prints how many requests arrived on each name and path the daemon can serve, most used first, so that
+// unused compat names can be turned off.
+func nameStats(system bool) error {
+	conn, err := connectBus(system)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	var metrics map[string]uint64
+	if err := conn.Object(bridge.ServiceName, bridge.ControlPath).Call(bridge.ControlInterface+".Metrics", 0).Store(&metrics); err != nil {
+		return err
+	}
+	via := bridge.RequestsVia(metrics)
+	names := make([]string, 0, len(via))
+	for name := range via {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if via[names[i]] != via[names[j]] {
+			return via[names[i]] > via[names[j]]
+		}
+		return names[i] < names[j]
+	})
+	fmt.Println("Inhibit requests since the daemon started, by the name or path they arrived on:")
+	for _, name := range names {
+		fmt.Printf("  %-18s %8d\n", name, via[name])
+	}
+	return nil
+}
+
+// topApps prints the n applications that held locks the longest over the last window, for battery-drain triage.
+func topApps(system bool, n int, window time.Duration) error {
+	conn, err := connectBus(system)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	var tops []bridge.TopInhibitor
+	if err := conn.Object(bridge.ServiceName, bridge.ControlPath).Call(bridge.ControlInterface+".GetTopInhibitors", 0, uint32(n), uint32(window/time.Second)).Store(&tops); err != nil {
+		return err
+	}
+
+	fmt.Printf("Inhibited in the last %s:\n", window)
+	if len(tops) == 0 {
+		fmt.Println("  nothing")
+	}
+	for _, t := range tops {
+		app := t.App
+		if system {
+			app += " (" + userName(t.UID) + ")"
+		}
+		fmt.Printf("  %-30s %10s  %d lock(s), %d held\n", app, time.Duration(t.Seconds)*time.Second, t.Locks, t.Held)
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/coltwillcox/inhibitor/pkg/store"
+)
+
+// openStores is every store opened by openStore, by spec, so that --store and --history can share one.
+var openStores = make(map[string]store.Store)
+
+// parseStore is store.ParseSpec for the stores the command offers, which leave out SQLite: no SQLite driver is linked
+// into it.
+func parseStore(spec string) (kind, path string, err error) {
+	kind, path, err = store.ParseSpec(spec)
+	if err == nil && kind == store.KindSQLite {
+		return "", "", fmt.Errorf("invalid store %q: SQLite stores aren't supported by this build; use %q or \"file:PATH\"", spec, store.KindMemory)
+	}
+	return kind, path, err
+}
+
+// openStore opens the store spec names (see parseStore) for flag, or returns the one already open. It must be
+// called before the sandbox is applied, which only allows writing files that exist by then. A file store that can't be
+// read back, most likely cut short by a crash, is started afresh, as what it keeps is nice to have but not worth not
+// starting over.
+func openStore(flag, spec string) (store.Store, error) {
+	if st, ok := openStores[spec]; ok {
+		return st, nil
+	}
+	if _, _, err := parseStore(spec); err != nil {
+		return nil, err
+	}
+	st, err := store.Open(spec)
+	var ce *store.CorruptError
+	if errors.As(err, &ce) {
+		reallyLog("Starting --%s afresh: %v\n", flag, err)
+	} else if err != nil {
+		return nil, err
+	}
+	openStores[spec] = st
+	return st, nil
+}
+
+// readStore opens the existing store spec names for reading, without creating a file store that isn't there.
+func readStore(spec string) (store.Store, error) {
+	kind, path, err := parseStore(spec)
+	if err != nil {
+		return nil, err
+	}
+	if kind == store.KindFile {
+		if _, err := os.Stat(path); err != nil {
+			return nil, err
+		}
+	}
+	st, err := store.Open(spec)
+	if err != nil {
+		return nil, fmt.Errorf("opening %q: %v", spec, err)
+	}
+	return st, nil
+}
+
+// closeStores closes every store openStore opened.
+func closeStores() {
+	for spec, st := range openStores {
+		if err := st.Close(); err != nil {
+			reallyLog("Error closing store %q: %v\n", spec, err)
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"time"
+
+	"github.com/coltwillcox/inhibitor/pkg/bridge"
+)
+
+// prepareSummaryFile creates the --summary_file up front, so that the sandbox can allow writing it at shutdown.
+func prepareSummaryFile(path string) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// reportSummary logs the bridge's shutdown summary and, if path is set, writes it there as JSON.
+func reportSummary(s *bridge.Summary, path string) {
+	c := s.Counters
+	reallyLog("Shutdown summary: up %s, %d locks granted, %d released, %d reaped, %d revoked, %d held at shutdown.\n",
+		s.Taken.Sub(s.Started).Round(time.Second), c["locks_granted"], c["locks_released"], c["locks_reaped"], c["locks_revoked"], len(s.Held))
+	for _, l := range s.Held {
+		if l.Note != "" {
+			reallyLog("  held for %s: %s, note %q\n", s.Taken.Sub(l.Since).Round(time.Second), l, l.Note)
+		} else {
+			reallyLog("  held for %s: %s\n", s.Taken.Sub(l.Since).Round(time.Second), l)
+		}
+	}
+	if rss, ok := c["rss_bytes"]; ok {
+		reallyLog("Last sampled usage: %.1f MiB resident, %d goroutines, %d open fds.\n", float64(rss)/(1<<20), c["goroutines"], c["open_fds"])
+	}
+
+	if path == "" {
+		return
+	}
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		reallyLog("Error encoding shutdown summary: %v\n", err)
+		return
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0600); err != nil {
+		reallyLog("Error writing shutdown summary to %q: %v\n", path, err)
+	}
+}
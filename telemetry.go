@@ -0,0 +1,37 @@
+package main
+
+import (
+	"github.com/coltwillcox/inhibitor/pkg/telemetry"
+)
+
+// metricsSink is where the bridge's metrics go besides the control interface, as the config's "metrics" named it on
+// startup (see telemetry.ParseSpec), and metricsSpec that name. Both are empty without one.
+var (
+	metricsSink telemetry.Sink
+	metricsSpec string
+)
+
+// openMetrics opens the sink spec names, unless it is empty. It must be called before the sandbox is applied, as a
+// Prometheus sink listens on its address.
+func openMetrics(spec string) error {
+	if spec == "" {
+		return nil
+	}
+	sink, err := telemetry.Open(spec)
+	if err != nil {
+		return err
+	}
+	metricsSink, metricsSpec = sink, spec
+	maybeLog("Sending metrics to %s.\n", spec)
+	return nil
+}
+
+// closeMetrics closes the sink openMetrics opened, if any.
+func closeMetrics() {
+	if metricsSink == nil {
+		return
+	}
+	if err := metricsSink.Close(); err != nil {
+		reallyLog("Error closing metrics sink %q: %v\n", metricsSpec, err)
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+)
+
+// lockTemplate is how an auto-inhibit source, one that takes locks of its own accord such as --calendar, names its
+// locks: text/template templates of the who and why, filled in with what caused the lock, so that logind's list of
+// inhibitors and notifications say more than the source's name. Either may be left empty for the source's default.
+type lockTemplate struct {
+	Who string `json:"who,omitempty"`
+	Why string `json:"why,omitempty"`
+}
+
+// autoSources are the auto-inhibit sources, with their default templates and a sample of the data their templates
+// are filled in with, for checking templates before they are used.
+var autoSources = map[string]struct {
+	defaults lockTemplate
+	sample   interface{}
+}{
+	"calendar": {lockTemplate{Who: calendarWho, Why: "{{.Summary}}"}, calendarEvent{}.templateData()},
+}
+
+// validate checks that t's templates parse and fill in for source.
+func (t lockTemplate) validate(source string) error {
+	s, ok := autoSources[source]
+	if !ok {
+		var names []string
+		for name := range autoSources {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return fmt.Errorf("template for unknown source %q; want one of %s", source, strings.Join(names, ", "))
+	}
+	c, err := t.compile(source)
+	if err != nil {
+		return err
+	}
+	if _, _, err := c.render(s.sample); err != nil {
+		return fmt.Errorf("template for %s: %v", source, err)
+	}
+	return nil
+}
+
+// compiledTemplate is a lockTemplate ready to fill in.
+type compiledTemplate struct {
+	who, why *template.Template
+}
+
+// compile parses t, taking the source's default for whatever t leaves empty.
+func (t lockTemplate) compile(source string) (*compiledTemplate, error) {
+	d := autoSources[source].defaults
+	if t.Who == "" {
+		t.Who = d.Who
+	}
+	if t.Why == "" {
+		t.Why = d.Why
+	}
+	who, err := template.New(source + " who").Option("missingkey=error").Parse(t.Who)
+	if err != nil {
+		return nil, fmt.Errorf("template for %s: %v", source, err)
+	}
+	why, err := template.New(source + " why").Option("missingkey=error").Parse(t.Why)
+	if err != nil {
+		return nil, fmt.Errorf("template for %s: %v", source, err)
+	}
+	return &compiledTemplate{who, why}, nil
+}
+
+// render fills in c with data.
+func (c *compiledTemplate) render(data interface{}) (who, why string, err error) {
+	var b strings.Builder
+	if err := c.who.Execute(&b, data); err != nil {
+		return "", "", err
+	}
+	who = b.String()
+	b.Reset()
+	if err := c.why.Execute(&b, data); err != nil {
+		return "", "", err
+	}
+	return who, b.String(), nil
+}
+
+// lockTemplates are the templates of the auto-inhibit sources in use, replaced when the config is reloaded.
+type lockTemplates struct {
+	mtx       sync.Mutex
+	templates map[string]*compiledTemplate
+}
+
+// set replaces the templates with those of the config, compiled. Sources the config leaves out get their defaults.
+func (lt *lockTemplates) set(templates map[string]lockTemplate) error {
+	compiled := make(map[string]*compiledTemplate)
+	for source := range autoSources {
+		c, err := templates[source].compile(source)
+		if err != nil {
+			return err
+		}
+		compiled[source] = c
+	}
+	lt.mtx.Lock()
+	lt.templates = compiled
+	lt.mtx.Unlock()
+	return nil
+}
+
+// render returns the who and why of a lock source takes for data. Should the template fail, the lock is named by the
+// source's defaults instead, so that it is still taken.
+func (lt *lockTemplates) render(source string, data interface{}) (who, why string) {
+	lt.mtx.Lock()
+	c := lt.templates[source]
+	lt.mtx.Unlock()
+	if c != nil {
+		who, why, err := c.render(data)
+		if err == nil {
+			return who, why
+		}
+		reallyLog("Error filling in the %s template: %v\n", source, err)
+	}
+	c, _ = lockTemplate{}.compile(source)
+	who, why, _ = c.render(data)
+	return who, why
+}
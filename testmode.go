@@ -0,0 +1,38 @@
+package main
+
+import (
+	"flag"
+	"sort"
+	"strings"
+)
+
+// testModeFlags are the flags --test_mode sets unless they are given too: what a run on a private bus, such as
+// cmd/e2e's under dbus-run-session, needs against cmd/mock-logind, with nothing that reaches outside that bus and a
+// heartbeat quick enough to see locks reaped.
+var testModeFlags = map[string]string{
+	"compat":           "true",
+	"critical_battery": "false",
+	"heartbeat":        "1s",
+	"logind_bus":       "session",
+	"notify":           "false",
+	"suspend_veto":     "1s",
+	"verbose":          "true",
+}
+
+// applyTestMode sets testModeFlags.
+func applyTestMode() {
+	given := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { given[f.Name] = true })
+	var set []string
+	for name, value := range testModeFlags {
+		if given[name] {
+			continue
+		}
+		if err := flag.Set(name, value); err != nil {
+			fatalf(exitUsage, "--test_mode can't set --%s: %v\n", name, err)
+		}
+		set = append(set, "--"+name+"="+value)
+	}
+	sort.Strings(set)
+	maybeLog("Test mode: %s.\n", strings.Join(set, " "))
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"strings"
+	"time"
+
+	"fyne.io/systray"
+	"github.com/coltwillcox/inhibitor/pkg/bridge"
+)
+
+// lockMenu is the tray's Locks submenu: an item per application holding locks, such as "Firefox: 7 locks, oldest
+// 2h0m0s", which expands into an item per lock.
+type lockMenu struct {
+	item   *systray.MenuItem
+	groups []*systray.MenuItem // the application items, each with its locks as sub-items
+	locks  []*systray.MenuItem
+	shown  string // the titles on show, to leave the menu alone while they don't change
+}
+
+func newLockMenu() *lockMenu {
+	m := &lockMenu{item: systray.AddMenuItem(tr("No locks"), tr("Locks held, by application"))}
+	m.item.Disable()
+	return m
+}
+
+// update shows held, grouped by application. Hold times are rounded down to the minute, so that the menu isn't
+// rebuilt on every change.
+func (m *lockMenu) update(held []bridge.Lock, now time.Time) {
+	heldFor := func(l bridge.Lock) time.Duration { return now.Sub(l.Since).Truncate(time.Minute) }
+	groups := groupByApp(len(held), func(i int) (string, string, time.Duration) {
+		return held[i].App, held[i].Who, heldFor(held[i])
+	})
+	var titles []string
+	for _, g := range groups {
+		titles = append(titles, groupTitle(g))
+		for _, i := range g.locks {
+			titles = append(titles, "  "+lockTitle(held[i], heldFor(held[i])))
+		}
+	}
+	shown := strings.Join(titles, "\n")
+	if shown == m.shown {
+		return
+	}
+	m.shown = shown
+
+	for _, l := range m.locks {
+		l.Remove()
+	}
+	for _, g := range m.groups {
+		g.Remove()
+	}
+	m.groups, m.locks = nil, nil
+	if len(groups) == 0 {
+		m.item.SetTitle(tr("No locks"))
+		m.item.Disable()
+		return
+	}
+	m.item.SetTitle(tr("Locks (%d)", len(held)))
+	m.item.Enable()
+	for _, g := range groups {
+		gi := m.item.AddSubMenuItem(groupTitle(g), "")
+		m.groups = append(m.groups, gi)
+		for _, i := range g.locks {
+			li := gi.AddSubMenuItem(lockTitle(held[i], heldFor(held[i])), held[i].String())
+			li.Disable()
+			m.locks = append(m.locks, li)
+		}
+	}
+}
+
+// lockTitle is how the Locks submenu lists a single lock.
+func lockTitle(l bridge.Lock, held time.Duration) string {
+	why := l.Why
+	if why == "" {
+		why = tr("no reason given")
+	}
+	return tr("%s (held %s)", why, held)
+}
+
+// groupTitle is how the Locks submenu lists the locks of an application: lockGroup.String, in the user's language.
+func groupTitle(g lockGroup) string {
+	if len(g.locks) == 1 {
+		return tr("%s: 1 lock, oldest %s", g.name, g.oldest)
+	}
+	return tr("%s: %d locks, oldest %s", g.name, len(g.locks), g.oldest)
+}
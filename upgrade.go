@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/coltwillcox/inhibitor/pkg/bridge"
+)
+
+const (
+	// handoffEnv carries the fd a successor reads its handoffState from.
+	handoffEnv = "INHIBITOR_HANDOFF_FD"
+	// upgradeWait is how long `inhibitor upgrade` waits for the successor to claim the bus name.
+	upgradeWait = 10 * time.Second
+)
+
+// handoffState is everything an instance passes to its successor across a hot upgrade.
+type handoffState struct {
+	Bridge *bridge.Handoff
+	LogFD  int // -1 when logging to stderr
+}
+
+// takeOver takes the lock table over from the running instance, if there is one, and tells it to exit. The result is
+// nil if no instance is running.
+func takeOver(system bool) (*bridge.Handoff, error) {
+	conn, err := connectBus(system)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	var owner string
+	if err := conn.BusObject().Call("org.freedesktop.DBus.GetNameOwner", 0, bridge.ServiceName).Store(&owner); err != nil {
+		maybeLog("No running instance to take over from.\n")
+		return nil, nil
+	}
+
+	h, confirm, err := bridge.RequestHandoff(conn)
+	if err != nil {
+		return nil, err
+	}
+	if err := confirm(); err != nil {
+		return nil, fmt.Errorf("couldn't confirm the handoff: %v", err)
+	}
+	return h, nil
+}
+
+// requestUpgrade asks the running daemon to upgrade itself and waits for its successor to take over the bus name.
+func requestUpgrade(system bool) error {
+	conn, err := connectBus(system)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	var owner string
+	if err := conn.BusObject().Call("org.freedesktop.DBus.GetNameOwner", 0, bridge.ServiceName).Store(&owner); err != nil {
+		return &exitError{exitNotRunning, fmt.Errorf("no running daemon found: %v", err)}
+	}
+
+	if err := conn.Object(bridge.ServiceName, bridge.ControlPath).Call(bridge.ControlInterface+".Upgrade", 0).Err; err != nil {
+		return err
+	}
+
+	for deadline := time.Now().Add(upgradeWait); time.Now().Before(deadline); time.Sleep(100 * time.Millisecond) {
+		var now string
+		if err := conn.BusObject().Call("org.freedesktop.DBus.GetNameOwner", 0, bridge.ServiceName).Store(&now); err == nil && now != owner {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("no new instance took over within %s; check the daemon's log", upgradeWait)
+}
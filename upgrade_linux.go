@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+const (
+	fSetPipeSize = 1031 // F_SETPIPE_SZ, missing from the syscall package
+)
+
+// upgrade replaces the process with a fresh copy of its executable, handing over the bridge's lock table and fds
+// (the manual inhibit among them) and the log file. The lock table stays frozen throughout, so nothing is lost or released in
+// between. It only returns on failure, in which case the daemon carries on as before.
+func (i *inhibitor) upgrade() error {
+	i.mtx.Lock()
+	defer i.mtx.Unlock()
+
+	h, thaw, err := i.bridge.Freeze()
+	if err != nil {
+		return err
+	}
+	defer thaw()
+	// The successor only counts the locks' time from when it takes them over.
+	if lockHistory != nil {
+		lockHistory.settle()
+	}
+
+	data, err := json.Marshal(handoffState{Bridge: h, LogFD: i.logFD})
+	if err != nil {
+		return fmt.Errorf("encoding handoff state: %v", err)
+	}
+
+	// The state goes through a pipe, so it has to fit in the pipe's buffer: nobody reads it until after the exec.
+	var p [2]int
+	if err := syscall.Pipe2(p[:], syscall.O_CLOEXEC); err != nil {
+		return fmt.Errorf("pipe2: %v", err)
+	}
+	r, w := p[0], p[1]
+	defer syscall.Close(r)
+	syscall.Syscall(syscall.SYS_FCNTL, uintptr(w), fSetPipeSize, uintptr(len(data)))
+	if err := syscall.SetNonblock(w, true); err != nil {
+		syscall.Close(w)
+		return fmt.Errorf("setting handoff pipe nonblocking: %v", err)
+	}
+	n, err := syscall.Write(w, data)
+	syscall.Close(w)
+	if err != nil || n != len(data) {
+		return fmt.Errorf("handoff state of %d bytes doesn't fit in a pipe (wrote %d): %v", len(data), n, err)
+	}
+
+	inherit := []int{r}
+	if i.logFD >= 0 {
+		inherit = append(inherit, i.logFD)
+	}
+	for _, l := range h.Locks {
+		inherit = append(inherit, l.FDs()...)
+	}
+	for _, fd := range inherit {
+		setCloseOnExec(fd, false)
+		defer setCloseOnExec(fd, true)
+	}
+
+	env := []string{handoffEnv + "=" + strconv.Itoa(r)}
+	for _, e := range os.Environ() {
+		if !strings.HasPrefix(e, handoffEnv+"=") {
+			env = append(env, e)
+		}
+	}
+
+	reallyLog("Upgrading: handing %d locks to a new %s.\n", len(h.Locks), i.exe)
+	if err := syscall.Exec(i.exe, os.Args, env); err != nil {
+		return fmt.Errorf("exec %q: %v", i.exe, err)
+	}
+	return nil
+}
+
+func setCloseOnExec(fd int, on bool) {
+	var flag uintptr
+	if on {
+		flag = syscall.FD_CLOEXEC
+	}
+	syscall.Syscall(syscall.SYS_FCNTL, uintptr(fd), syscall.F_SETFD, flag)
+}
+
+// readHandoff returns the state passed on by a predecessor if this process was started by a hot upgrade, or nil.
+func readHandoff() (*handoffState, error) {
+	v, ok := os.LookupEnv(handoffEnv)
+	if !ok {
+		return nil, nil
+	}
+	os.Unsetenv(handoffEnv)
+
+	fd, err := strconv.Atoi(v)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s %q", handoffEnv, v)
+	}
+	f := os.NewFile(uintptr(fd), "handoff")
+	defer f.Close()
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return nil, fmt.Errorf("reading handoff state: %v", err)
+	}
+
+	var s handoffState
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("decoding handoff state: %v", err)
+	}
+
+	// The inherited fds must not leak into anything we start ourselves.
+	if s.LogFD >= 0 {
+		setCloseOnExec(s.LogFD, true)
+	}
+	if s.Bridge != nil {
+		for _, l := range s.Bridge.Locks {
+			for _, fd := range l.FDs() {
+				setCloseOnExec(fd, true)
+			}
+		}
+	}
+
+	return &s, nil
+}
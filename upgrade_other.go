@@ -0,0 +1,15 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+// upgrade is unsupported outside of Linux.
+func (i *inhibitor) upgrade() error {
+	return errors.New("hot upgrades are only supported on Linux")
+}
+
+// readHandoff never finds a predecessor outside of Linux.
+func readHandoff() (*handoffState, error) {
+	return nil, nil
+}
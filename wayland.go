@@ -0,0 +1,260 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"unsafe"
+)
+
+// The few Wayland objects and messages needed to import xdg-foreign handles. Object ids are the client's own; the
+// imported toplevels take the ones from wlFirstImported on.
+const (
+	wlDisplayID     = 1
+	wlRegistryID    = 2
+	wlSyncID        = 3
+	wlImporterID    = 4
+	wlFirstImported = 5
+
+	wlDisplaySync        = 0 // request
+	wlDisplayGetRegistry = 1 // request
+	wlDisplayError       = 0 // event
+	wlRegistryBind       = 0 // request
+	wlRegistryGlobal     = 0 // event
+	wlCallbackDone       = 0 // event
+
+	xdgImporter               = "zxdg_importer_v2"
+	xdgImporterImportToplevel = 1 // request
+	xdgImportedDestroy        = 0 // request
+	xdgImportedDestroyed      = 0 // event
+)
+
+// wlEndian is the host's byte order, which the Wayland wire protocol uses.
+var wlEndian binary.ByteOrder = binary.LittleEndian
+
+func init() {
+	one := uint16(1)
+	if *(*byte)(unsafe.Pointer(&one)) == 0 {
+		wlEndian = binary.BigEndian
+	}
+}
+
+// waylandForeign is a Wayland connection that imports the xdg-foreign handles applications export for their
+// toplevels. The compositor tells an importer when the toplevel behind a handle goes away, or right away if there is
+// none.
+type waylandForeign struct {
+	conn net.Conn
+	// gone receives the watch ids whose toplevel went away. It is closed with the connection.
+	gone chan int
+
+	mtx      sync.Mutex
+	next     uint32         // guarded by mtx
+	imported map[uint32]int // watch ids by imported object id, guarded by mtx
+}
+
+// dialWaylandForeign connects to the compositor and binds its xdg-foreign importer.
+func dialWaylandForeign() (*waylandForeign, error) {
+	name := os.Getenv("WAYLAND_DISPLAY")
+	if name == "" {
+		return nil, errors.New("$WAYLAND_DISPLAY isn't set")
+	}
+	if !filepath.IsAbs(name) {
+		name = filepath.Join(os.Getenv("XDG_RUNTIME_DIR"), name)
+	}
+	conn, err := net.Dial("unix", name)
+	if err != nil {
+		return nil, err
+	}
+	wf := &waylandForeign{conn: conn, gone: make(chan int), next: wlFirstImported, imported: make(map[uint32]int)}
+
+	// The sync's callback comes after every global has been announced.
+	if err := wf.send(wlDisplayID, wlDisplayGetRegistry, wlArgs{}.uint(wlRegistryID)); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if err := wf.send(wlDisplayID, wlDisplaySync, wlArgs{}.uint(wlSyncID)); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	var (
+		global uint32
+		found  bool
+	)
+	for done := false; !done; {
+		obj, op, args, err := wf.receive()
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		switch {
+		case obj == wlDisplayID && op == wlDisplayError:
+			conn.Close()
+			return nil, wlError(args)
+		case obj == wlRegistryID && op == wlRegistryGlobal:
+			name := args.takeUint()
+			if args.takeString() == xdgImporter {
+				global, found = name, true
+			}
+		case obj == wlSyncID && op == wlCallbackDone:
+			done = true
+		}
+	}
+	if !found {
+		conn.Close()
+		return nil, fmt.Errorf("the compositor doesn't offer %s", xdgImporter)
+	}
+	if err := wf.send(wlRegistryID, wlRegistryBind, wlArgs{}.uint(global).string(xdgImporter).uint(1).uint(wlImporterID)); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	go wf.read()
+	return wf, nil
+}
+
+// watch imports handle for the watch id.
+func (wf *waylandForeign) watch(id int, handle string) error {
+	wf.mtx.Lock()
+	defer wf.mtx.Unlock()
+
+	obj := wf.next
+	if err := wf.send(wlImporterID, xdgImporterImportToplevel, wlArgs{}.uint(obj).string(handle)); err != nil {
+		return err
+	}
+	wf.next++
+	wf.imported[obj] = id
+	return nil
+}
+
+// unwatch drops the import of the watch id, if it is still around.
+func (wf *waylandForeign) unwatch(id int) {
+	wf.mtx.Lock()
+	defer wf.mtx.Unlock()
+
+	for obj, w := range wf.imported {
+		if w == id {
+			delete(wf.imported, obj)
+			wf.send(obj, xdgImportedDestroy, nil)
+			return
+		}
+	}
+}
+
+// watched returns the watch ids whose toplevel hasn't gone away.
+func (wf *waylandForeign) watched() []int {
+	wf.mtx.Lock()
+	defer wf.mtx.Unlock()
+
+	ids := make([]int, 0, len(wf.imported))
+	for _, id := range wf.imported {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+func (wf *waylandForeign) close() {
+	wf.conn.Close()
+}
+
+// read reports the toplevels that go away on gone until the connection ends.
+func (wf *waylandForeign) read() {
+	defer close(wf.gone)
+	for {
+		obj, op, args, err := wf.receive()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				reallyLog("Lost the Wayland connection: %v\n", err)
+			}
+			return
+		}
+		if obj == wlDisplayID && op == wlDisplayError {
+			reallyLog("Wayland error: %v\n", wlError(args))
+			wf.conn.Close()
+			return
+		}
+		if op != xdgImportedDestroyed {
+			continue
+		}
+		wf.mtx.Lock()
+		id, ok := wf.imported[obj]
+		delete(wf.imported, obj)
+		wf.mtx.Unlock()
+		if ok {
+			wf.gone <- id
+		}
+	}
+}
+
+// send sends the request op on obj.
+func (wf *waylandForeign) send(obj uint32, op uint16, args wlArgs) error {
+	msg := wlArgs{}.uint(obj).uint(uint32(8+len(args))<<16 | uint32(op))
+	_, err := wf.conn.Write(append(msg, args...))
+	return err
+}
+
+// receive reads the next event.
+func (wf *waylandForeign) receive() (obj uint32, op uint16, args wlArgs, err error) {
+	var hdr [8]byte
+	if _, err := io.ReadFull(wf.conn, hdr[:]); err != nil {
+		return 0, 0, nil, err
+	}
+	obj, word := wlEndian.Uint32(hdr[:4]), wlEndian.Uint32(hdr[4:])
+	size := int(word >> 16)
+	if size < len(hdr) {
+		return 0, 0, nil, fmt.Errorf("malformed event of %d bytes", size)
+	}
+	args = make(wlArgs, size-len(hdr))
+	if _, err := io.ReadFull(wf.conn, args); err != nil {
+		return 0, 0, nil, err
+	}
+	return obj, uint16(word), args, nil
+}
+
+// wlError describes a wl_display error event.
+func wlError(args wlArgs) error {
+	obj, code := args.takeUint(), args.takeUint()
+	return fmt.Errorf("object %d, code %d: %s", obj, code, args.takeString())
+}
+
+// wlArgs are the encoded arguments of a Wayland message.
+type wlArgs []byte
+
+func (a wlArgs) uint(v uint32) wlArgs {
+	var b [4]byte
+	wlEndian.PutUint32(b[:], v)
+	return append(a, b[:]...)
+}
+
+// string appends s with its terminating NUL, padded to 32 bits.
+func (a wlArgs) string(s string) wlArgs {
+	a = a.uint(uint32(len(s) + 1))
+	a = append(a, s...)
+	return append(a, make([]byte, 4-len(s)%4)...)
+}
+
+func (a *wlArgs) takeUint() uint32 {
+	if len(*a) < 4 {
+		*a = nil
+		return 0
+	}
+	v := wlEndian.Uint32(*a)
+	*a = (*a)[4:]
+	return v
+}
+
+func (a *wlArgs) takeString() string {
+	n := int(a.takeUint())
+	padded := (n + 3) &^ 3
+	if n == 0 || len(*a) < padded {
+		*a = nil
+		return ""
+	}
+	s := string((*a)[:n-1])
+	*a = (*a)[padded:]
+	return s
+}
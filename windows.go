@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coltwillcox/inhibitor/pkg/bridge"
+)
+
+// windowWatcher is the bridge.WindowWatcher behind --window_locks. Windows are followed by a helper process started
+// before the sandbox is applied, since that takes xprop and the X and Wayland displays. The daemon tells it what to
+// watch on its stdin and it reports the windows that closed on its stdout, one "closed ID" line each.
+type windowWatcher struct {
+	mtx     sync.Mutex
+	cmd     *exec.Cmd
+	w       io.WriteCloser
+	next    int
+	watches map[int]func() // the closed callbacks by watch id
+}
+
+// startWindowWatcher starts the window-helper verb of exe.
+func startWindowWatcher(exe string) (*windowWatcher, error) {
+	if os.Getenv("DISPLAY") == "" && os.Getenv("WAYLAND_DISPLAY") == "" {
+		return nil, fmt.Errorf("neither $DISPLAY nor $WAYLAND_DISPLAY is set")
+	}
+	cmd := exec.Command(exe, "window-helper", fmt.Sprintf("--verbose=%t", *verbose || *debug))
+	cmd.Stderr = log.Writer()
+	w, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	r, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("starting the window helper: %v", err)
+	}
+	ww := &windowWatcher{cmd: cmd, w: w, watches: make(map[int]func())}
+	go ww.read(r)
+	return ww, nil
+}
+
+// Watch implements bridge.WindowWatcher.
+func (ww *windowWatcher) Watch(window string, closed func()) (func(), error) {
+	if id := strings.TrimPrefix(window, bridge.WindowX11); id != window {
+		wid, err := parseWindow(id)
+		if err != nil {
+			return nil, err
+		}
+		window = bridge.WindowX11 + wid
+	}
+
+	ww.mtx.Lock()
+	defer ww.mtx.Unlock()
+	ww.next++
+	id := ww.next
+	if _, err := fmt.Fprintf(ww.w, "watch %d %s\n", id, window); err != nil {
+		return nil, fmt.Errorf("couldn't reach the window helper: %v", err)
+	}
+	ww.watches[id] = closed
+	return func() {
+		ww.mtx.Lock()
+		defer ww.mtx.Unlock()
+		if _, ok := ww.watches[id]; !ok {
+			return
+		}
+		delete(ww.watches, id)
+		fmt.Fprintf(ww.w, "unwatch %d\n", id)
+	}, nil
+}
+
+// read passes the helper's reports on to the watches.
+func (ww *windowWatcher) read(r io.Reader) {
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		var id int
+		if _, err := fmt.Sscanf(s.Text(), "closed %d", &id); err != nil {
+			reallyLog("Unexpected output from the window helper: %q\n", s.Text())
+			continue
+		}
+		ww.mtx.Lock()
+		closed := ww.watches[id]
+		delete(ww.watches, id)
+		ww.mtx.Unlock()
+		if closed != nil {
+			closed()
+		}
+	}
+}
+
+// stop waits for the helper to exit.
+func (ww *windowWatcher) stop() {
+	ww.mtx.Lock()
+	defer ww.mtx.Unlock()
+
+	ww.w.Close()
+	if err := ww.cmd.Wait(); err != nil {
+		reallyLog("Window helper failed: %v\n", err)
+	}
+}
+
+// windowHelper is the window-helper verb: it follows the watch and unwatch commands the daemon sends on stdin, polling
+// X windows with xprop and importing Wayland ones through the compositor's xdg-foreign protocol, and exits once stdin
+// is closed. A window it can't follow is reported closed right away, so that no lock outlives it unnoticed.
+func windowHelper() {
+	log.SetPrefix("window-helper: ")
+
+	cmds := make(chan string)
+	go func() {
+		s := bufio.NewScanner(os.Stdin)
+		for s.Scan() {
+			cmds <- s.Text()
+		}
+		close(cmds)
+	}()
+
+	var (
+		x11     = make(map[int]string) // X window ids by watch id
+		wayland *waylandForeign
+		gone    <-chan int
+	)
+	closed := func(id int) {
+		fmt.Printf("closed %d\n", id)
+	}
+	// checkX11 reports the X windows that no longer exist. Those xprop fails on for some other reason are kept.
+	checkX11 := func(ids ...int) {
+		for _, id := range ids {
+			if ok, err := windowExists(x11[id]); err != nil {
+				reallyLog("Couldn't check window %s: %v\n", x11[id], err)
+			} else if !ok {
+				maybeLog("Window %s closed.\n", x11[id])
+				delete(x11, id)
+				closed(id)
+			}
+		}
+	}
+
+	t := time.NewTicker(windowPoll)
+	defer t.Stop()
+	for {
+		select {
+		case line, ok := <-cmds:
+			if !ok {
+				if wayland != nil {
+					wayland.close()
+				}
+				return
+			}
+			f := strings.Fields(line)
+			if len(f) < 2 {
+				continue
+			}
+			id, err := strconv.Atoi(f[1])
+			if err != nil {
+				continue
+			}
+			switch {
+			case f[0] == "unwatch":
+				delete(x11, id)
+				if wayland != nil {
+					wayland.unwatch(id)
+				}
+			case f[0] == "watch" && len(f) == 3 && strings.HasPrefix(f[2], bridge.WindowX11):
+				x11[id] = strings.TrimPrefix(f[2], bridge.WindowX11)
+				checkX11(id)
+			case f[0] == "watch" && len(f) == 3 && strings.HasPrefix(f[2], bridge.WindowWayland):
+				if wayland == nil {
+					if wayland, err = dialWaylandForeign(); err != nil {
+						reallyLog("Can't follow Wayland windows: %v\n", err)
+						wayland = nil
+						closed(id)
+						continue
+					}
+					gone = wayland.gone
+				}
+				if err := wayland.watch(id, strings.TrimPrefix(f[2], bridge.WindowWayland)); err != nil {
+					reallyLog("Can't follow window %s: %v\n", f[2], err)
+					closed(id)
+				}
+			}
+		case id, ok := <-gone:
+			if !ok {
+				// The compositor went away, and every window with it.
+				for _, id := range wayland.watched() {
+					closed(id)
+				}
+				wayland, gone = nil, nil
+				continue
+			}
+			maybeLog("Wayland window of watch %d closed.\n", id)
+			closed(id)
+		case <-t.C:
+			ids := make([]int, 0, len(x11))
+			for id := range x11 {
+				ids = append(ids, id)
+			}
+			checkX11(ids...)
+		}
+	}
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/coltwillcox/inhibitor/pkg/bridge"
+	"github.com/godbus/dbus/v5"
+)
+
+const (
+	// windowIface is served by the process holding a window's lock, so that resume can end it.
+	windowIface = bridge.ControlInterface + ".Window"
+	windowPath  = bridge.ControlPath + "/Window"
+	// windowPoll is how often the holder checks that its window still exists, as xdg-screensaver itself does.
+	windowPoll = 10 * time.Second
+	// xdgWho is the who of every lock taken through the shim.
+	xdgWho = "xdg-screensaver"
+)
+
+// xdgScreensaver emulates `xdg-screensaver suspend|resume WINDOW`. suspend takes a lock that lasts until resume is
+// called for the window or the window goes away: a window lock if the daemon serves them (see --window_locks), or else
+// one held by a process started in the background, which xprop tells when the window is gone.
+func xdgScreensaver(action, window string) error {
+	wid, err := parseWindow(window)
+	if err != nil {
+		return err
+	}
+
+	if action == "resume" {
+		return resumeWindow(wid)
+	}
+
+	if ok, err := windowExists(wid); err != nil {
+		return err
+	} else if !ok {
+		return fmt.Errorf("window %s doesn't exist", wid)
+	}
+	err = inhibitWindow(wid)
+	var de dbus.Error
+	if err == nil {
+		return nil
+	} else if !errors.As(err, &de) || de.Name != bridge.ErrorNotSupported && de.Name != "org.freedesktop.DBus.Error.UnknownMethod" {
+		return err
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command(exe, "xdg-screensaver-hold", wid)
+	// Detached, so that it outlives the script that suspended the screensaver.
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("couldn't start the lock holder: %v", err)
+	}
+	// The holder reports "ok" once it holds the lock, or why it couldn't take it.
+	line, _ := bufio.NewReader(out).ReadString('\n')
+	if line = strings.TrimSpace(line); line != "ok" {
+		if line == "" {
+			line = "the lock holder exited"
+		}
+		return errors.New(line)
+	}
+	cmd.Process.Release()
+	return nil
+}
+
+// holdWindow is the background half of `xdg-screensaver suspend`: it holds a lock for wid until the window goes away
+// or resume is called for it. A window already suspended keeps its existing holder.
+func holdWindow(wid string) {
+	report := func(format string, args ...interface{}) {
+		fmt.Printf(format+"\n", args...)
+		os.Stdout.Close()
+	}
+
+	conn, err := dbus.ConnectSessionBus()
+	if err != nil {
+		report("session bus connect failed: %v", err)
+		return
+	}
+	defer conn.Close()
+
+	resumed := make(chan struct{}, 1)
+	if err := conn.ExportMethodTable(map[string]interface{}{
+		"Resume": func() *dbus.Error {
+			select {
+			case resumed <- struct{}{}:
+			default:
+			}
+			return nil
+		},
+	}, windowPath, windowIface); err != nil {
+		report("couldn't export %s: %v", windowIface, err)
+		return
+	}
+	if r, err := conn.RequestName(windowName(wid), dbus.NameFlagDoNotQueue); err != nil {
+		report("couldn't claim %s: %v", windowName(wid), err)
+		return
+	} else if r != dbus.RequestNameReplyPrimaryOwner {
+		report("ok")
+		return
+	}
+
+	ss := conn.Object(bridge.ServiceName, "/org/freedesktop/ScreenSaver")
+	var cookie uint32
+	if err := ss.Call(bridge.ServiceName+".Inhibit", 0, xdgWho, "window "+wid).Store(&cookie); err != nil {
+		report("%v", err)
+		return
+	}
+	report("ok")
+
+	t := time.NewTicker(windowPoll)
+	defer t.Stop()
+	for held := true; held; {
+		select {
+		case <-resumed:
+			held = false
+		case <-t.C:
+			// Keep the lock if xprop fails for some other reason; resume still ends it.
+			ok, err := windowExists(wid)
+			held = ok || err != nil
+		}
+	}
+	ss.Call(bridge.ServiceName+".UnInhibit", 0, cookie)
+}
+
+// inhibitWindow takes a window lock for wid through the daemon, unless wid already has one. A daemon that doesn't
+// serve window locks fails it with NotSupported, or UnknownMethod if it predates them.
+func inhibitWindow(wid string) error {
+	conn, err := dbus.ConnectSessionBus()
+	if err != nil {
+		return fmt.Errorf("session bus connect failed: %v", err)
+	}
+	defer conn.Close()
+
+	ctl := conn.Object(bridge.ServiceName, bridge.ControlPath)
+	if l, err := windowLock(ctl, wid); err == nil && l != nil {
+		return nil
+	}
+	return ctl.Call(bridge.ControlInterface+".InhibitWindow", 0, xdgWho, "window "+wid, bridge.WindowX11+wid).Err
+}
+
+// windowLock returns the caller's window lock for wid, or nil.
+func windowLock(ctl dbus.BusObject, wid string) (*statusLock, error) {
+	var locks []statusLock
+	if err := ctl.Call(bridge.ControlInterface+".ListInhibits", 0).Store(&locks); err != nil {
+		return nil, err
+	}
+	for _, l := range locks {
+		if l.Window == bridge.WindowX11+wid {
+			return &l, nil
+		}
+	}
+	return nil, nil
+}
+
+// resumeWindow ends the lock held for wid, if any, whether a holder process or the daemon's window lock.
+func resumeWindow(wid string) error {
+	conn, err := dbus.ConnectSessionBus()
+	if err != nil {
+		return fmt.Errorf("session bus connect failed: %v", err)
+	}
+	defer conn.Close()
+
+	var owner string
+	if err := conn.BusObject().Call("org.freedesktop.DBus.GetNameOwner", 0, windowName(wid)).Store(&owner); err == nil {
+		return conn.Object(owner, windowPath).Call(windowIface+".Resume", 0).Err
+	}
+	ctl := conn.Object(bridge.ServiceName, bridge.ControlPath)
+	if l, err := windowLock(ctl, wid); err == nil && l != nil {
+		return ctl.Call(bridge.ControlInterface+".Release", 0, l.Peer, l.Cookie).Err
+	}
+	maybeLog("Window %s isn't suspended.\n", wid)
+	return nil
+}
+
+// parseWindow normalizes an X window id, given in decimal or as 0x-prefixed hex like xdg-screensaver accepts.
+func parseWindow(s string) (string, error) {
+	id, err := strconv.ParseUint(s, 0, 32)
+	if err != nil || id == 0 {
+		return "", fmt.Errorf("invalid window id %q", s)
+	}
+	return fmt.Sprintf("0x%x", id), nil
+}
+
+// windowName is the bus name owned by the process holding wid's lock.
+func windowName(wid string) string {
+	return windowIface + ".w" + strings.TrimPrefix(wid, "0x")
+}
+
+// windowExists asks the X server, through xprop, whether wid still exists.
+func windowExists(wid string) (bool, error) {
+	err := exec.Command("xprop", "-id", wid, "WM_CLASS").Run()
+	var eerr *exec.ExitError
+	if errors.As(err, &eerr) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("xprop is needed to follow the window: %v", err)
+	}
+	return true, nil
+}